@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"net/url"
+	"organization_manager/pkg/api/services"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const exportCommand = "export"
+
+// repeatedFlag collects every value of a flag that can be given more than once
+type repeatedFlag []string
+
+func (f *repeatedFlag) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *repeatedFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// runExport writes the organizations matching the filters as CSV, NDJSON or JSON to a file, or to stdout when no
+// file is given. The filters are the query parameters of GET /organizations
+func runExport(envConfig EnvConfig, args []string) error {
+	flags := flag.NewFlagSet(exportCommand, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [flags]\n", os.Args[0], exportCommand)
+		flags.PrintDefaults()
+	}
+	var filters, rangeFilters repeatedFlag
+	flags.Var(&filters, "filter", "categorical filter such as name:Acme*, can be repeated")
+	flags.Var(&rangeFilters, "range-filter", "range filter such as employee_count:[10TO*], can be repeated")
+	expression := flags.String("q", "", "boolean filter expression")
+	search := flags.String("search", "", "full text search of organization names")
+	sort := flags.String("sort", "", "comma separated sort fields, prefix a field with - to sort it descending")
+	fields := flags.String("fields", "", "comma separated fields to export, every field by default")
+	includeDeleted := flags.Bool("include-deleted", false, "also export soft deleted organizations")
+	format := flags.String("format", "", "csv, ndjson or json, detected from the output file extension by default")
+	output := flags.String("o", "", "file to write, stdout by default")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return errors.Errorf("unexpected arguments %v", flags.Args())
+	}
+
+	if *format == "" {
+		*format = services.FormatJSON
+		if *output != "" {
+			*format, err = services.FormatFromExtension(*output)
+			if err != nil {
+				return errors.Wrap(err, "cannot detect the export format, use -format")
+			}
+		}
+	}
+	queryParams := url.Values{}
+	for name, values := range map[string][]string{
+		"filter":          filters,
+		"range_filter":    rangeFilters,
+		"q":               {*expression},
+		"search":          {*search},
+		"sort":            {*sort},
+		"fields":          {*fields},
+		"include_deleted": {strconv.FormatBool(*includeDeleted)},
+	} {
+		for _, value := range values {
+			if value != "" {
+				queryParams.Add(name, value)
+			}
+		}
+	}
+
+	orgRepository, _, err := initializeRepositories(envConfig)
+	if err != nil {
+		return errors.Wrap(err, "error initializing the database")
+	}
+	service := services.NewOrganizationService(orgRepository)
+	export, err := service.GetOrganizationExport(queryParams, *format)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return export.Write(context.Background(), os.Stdout)
+	}
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := export.Write(context.Background(), file); err != nil {
+		file.Close()
+		return err
+	}
+	// A failed close can mean the last writes never reached the disk
+	return errors.Wrap(file.Close(), "error closing the export file")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"organization_manager/pkg/api/services"
+	"os"
+)
+
+const importCommand = "import"
+
+// runImport imports organizations from a CSV or NDJSON file, or from stdin when the file is -. It prints an error
+// for each row that was not imported and fails when any row was not imported
+func runImport(envConfig EnvConfig, args []string) error {
+	flags := flag.NewFlagSet(importCommand, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [flags] <file>\n", os.Args[0], importCommand)
+		flags.PrintDefaults()
+	}
+	dryRun := flags.Bool("dry-run", false, "validate every row without creating organizations")
+	format := flags.String("format", "", "csv or ndjson, detected from the file extension by default")
+	reportPath := flags.String("report", "", "also write the import report as JSON to this file")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected a single file to import")
+	}
+	path := flags.Arg(0)
+
+	options := services.ImportOptions{Format: *format, DryRun: *dryRun}
+	if options.Format == "" {
+		options.Format, err = services.ImportFormat("", path)
+		if err != nil {
+			return errors.Wrap(err, "cannot detect the format of the file, use -format")
+		}
+	}
+
+	var content io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		content = file
+	}
+
+	orgRepository, _, err := initializeRepositories(envConfig)
+	if err != nil {
+		return errors.Wrap(err, "error initializing the database")
+	}
+	service := services.NewOrganizationService(orgRepository)
+	service.DuplicateCheck = duplicateCheck(envConfig)
+	err = service.DuplicateCheck.Validate()
+	if err != nil {
+		return err
+	}
+
+	report, err := service.ImportOrganizations(context.Background(), content, options)
+	if err != nil {
+		return err
+	}
+	for _, rowErr := range report.Errors {
+		fmt.Printf("row %d: %s\n", rowErr.Row, rowErr.Error)
+	}
+	if report.DryRun {
+		fmt.Printf("dry run: %d rows, %d valid, %d invalid\n", report.Rows, report.Valid, len(report.Errors))
+	} else {
+		fmt.Printf("%d rows, %d created, %d failed\n", report.Rows, report.Created, len(report.Errors))
+	}
+
+	if *reportPath != "" {
+		serialized, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(*reportPath, serialized, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	if report.Error != "" {
+		return errors.New(report.Error)
+	}
+	if len(report.Errors) > 0 {
+		return errors.Errorf("%d rows were not imported", len(report.Errors))
+	}
+	return nil
+}
@@ -4,7 +4,11 @@ import (
 	"github.com/kelseyhightower/envconfig"
 	log "github.com/sirupsen/logrus"
 	"organization_manager/pkg/api"
+	"organization_manager/pkg/api/services"
 	"organization_manager/pkg/database"
+	"organization_manager/pkg/database/repository"
+	"os"
+	"strings"
 )
 
 func main() {
@@ -14,18 +18,35 @@ func main() {
 		log.Fatalf("error loading environment variables: %v", err.Error())
 	}
 
-	err = database.Initialize(envConfig.DatabaseURL)
-	if err != nil {
-		log.Fatalf("error initializing the database: %v", err.Error())
+	// the server is run unless a subcommand is given
+	if len(os.Args) > 1 && os.Args[1] == importCommand {
+		err = runImport(envConfig, os.Args[2:])
+		if err != nil {
+			log.Fatalf("error importing organizations: %v", err.Error())
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == exportCommand {
+		err = runExport(envConfig, os.Args[2:])
+		if err != nil {
+			log.Fatalf("error exporting organizations: %v", err.Error())
+		}
+		return
 	}
 
-	// running migrations on startup
-	err = database.RunMigrations(envConfig.MigrationsPath, envConfig.DatabaseURL)
+	orgRepository, idempotencyKeys, err := initializeRepositories(envConfig)
 	if err != nil {
-		log.Fatalf("error running database migration on startup: %v", err.Error())
+		log.Fatalf("error initializing the database: %v", err.Error())
 	}
 
-	server := api.Server{}
+	server := api.Server{
+		Repository:          orgRepository,
+		IdempotencyKeys:     idempotencyKeys,
+		IdempotencyKeyTTL:   envConfig.IdempotencyKeyTTL,
+		IdempotencyKeyLease: envConfig.IdempotencyKeyLease,
+		AdminKey:            envConfig.AdminKey,
+		DuplicateCheck:      duplicateCheck(envConfig),
+	}
 	err = server.Initialize()
 	if err != nil {
 		log.Fatalf("error initializing server: %v", err.Error())
@@ -36,3 +57,33 @@ func main() {
 	server.Run(envConfig.Port)
 }
 
+func duplicateCheck(envConfig EnvConfig) services.DuplicateCheck {
+	return services.DuplicateCheck{
+		Mode:      envConfig.DuplicateCheck,
+		Threshold: envConfig.DuplicateThreshold,
+	}
+}
+
+// initializeRepositories connects to the configured database and runs its migrations. A DATABASE_URL of memory://
+// selects in-memory repositories instead, which are empty on startup and lost on shutdown
+func initializeRepositories(envConfig EnvConfig) (repository.OrganizationRepository,
+	repository.IdempotencyKeyRepository, error) {
+
+	if strings.HasPrefix(envConfig.DatabaseURL, repository.MemoryURLScheme) {
+		log.Warnf("using in-memory organization repository, data will not be persisted")
+		return repository.NewMemoryOrganizationRepository(), repository.NewMemoryIdempotencyKeyRepository(), nil
+	}
+
+	db, err := database.Initialize(envConfig.DatabaseURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// running migrations on startup
+	err = database.RunMigrations(envConfig.MigrationsPath, envConfig.DatabaseURL)
+	if err != nil {
+		log.Errorf("error running database migration on startup: %v", err.Error())
+		return nil, nil, err
+	}
+	return repository.NewPostgresOrganizationRepository(db), repository.NewPostgresIdempotencyKeyRepository(db), nil
+}
@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"bytes"
+	"github.com/google/uuid"
+	"github.com/vmihailenco/msgpack/v5"
+	"gorm.io/gorm"
+	"reflect"
+)
+
+func init() {
+	// UUIDs are encoded as strings like in JSON responses, rather than as their bytes
+	msgpack.Register(uuid.UUID{}, func(encoder *msgpack.Encoder, value reflect.Value) error {
+		return encoder.EncodeString(value.Interface().(uuid.UUID).String())
+	}, nil)
+	// soft delete timestamps are nil until organizations are deleted, like in JSON responses
+	msgpack.Register(gorm.DeletedAt{}, func(encoder *msgpack.Encoder, value reflect.Value) error {
+		deletedAt := value.Interface().(gorm.DeletedAt)
+		if !deletedAt.Valid {
+			return encoder.EncodeNil()
+		}
+		return encoder.EncodeTime(deletedAt.Time)
+	}, nil)
+}
+
+// encodeMessagePack encodes data as MessagePack. Struct fields are named by their json tags and map keys are sorted,
+// so the fields are named and ordered like in JSON responses. Numbers are encoded as the smallest integer that holds
+// them, or as a 64-bit float when they are not integers, and timestamps use the MessagePack timestamp extension type
+func encodeMessagePack(data interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := msgpack.NewEncoder(&buffer)
+	encoder.SetCustomStructTag("json")
+	encoder.SetSortMapKeys(true)
+	encoder.UseCompactInts(true)
+	encoder.UseCompactFloats(true)
+	err := encoder.Encode(data)
+	return buffer.Bytes(), err
+}
@@ -1,28 +1,212 @@
 package controllers
 
 import (
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
 	"net/http"
+	"organization_manager/pkg/api/problems"
 	"organization_manager/pkg/api/services"
 )
 
-func CreateOrganization(w http.ResponseWriter, r *http.Request) {
-	newOrg, httpRespCode, err := services.SaveNewOrganization(r.Body)
+// OrganizationController exposes the organization service over HTTP
+type OrganizationController struct {
+	service *services.OrganizationService
+}
+
+func NewOrganizationController(service *services.OrganizationService) *OrganizationController {
+	return &OrganizationController{service: service}
+}
+
+func (c *OrganizationController) CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	newOrg, duplicates, err := c.service.SaveNewOrganization(r.Context(), r.Body)
+	if err != nil {
+		RenderError(w, r, err)
+		return
+	}
+
+	// possible duplicates of a created organization are reported as warnings
+	for _, duplicate := range duplicates {
+		w.Header().Add("Warning", duplicateWarning(duplicate))
+	}
+	setETag(w, r, *newOrg, nil)
+	Render(w, r, http.StatusCreated, newOrg)
+}
+
+func (c *OrganizationController) CreateOrganizations(w http.ResponseWriter, r *http.Request) {
+	resp, err := c.service.SaveNewOrganizations(r.Context(), r.Body, r.URL.Query())
+	if err != nil {
+		RenderError(w, r, err)
+		return
+	}
+	// a best effort batch reports the result of each organization, some of which may have failed
+	if resp.Atomic {
+		Render(w, r, http.StatusCreated, resp)
+	} else {
+		Render(w, r, http.StatusMultiStatus, resp)
+	}
+}
+
+func (c *OrganizationController) ImportOrganizations(w http.ResponseWriter, r *http.Request) {
+	content, contentType, filename, err := importContent(r)
+	if err != nil {
+		RenderError(w, r, problems.InvalidBody(err))
+		return
+	}
+	options, err := services.GetImportOptions(r.URL.Query(), contentType, filename)
+	if err != nil {
+		RenderError(w, r, err)
+		return
+	}
+	report, err := c.service.ImportOrganizations(r.Context(), content, options)
 	if err != nil {
-		JsonResponse(w, httpRespCode, ErrorResponse{err.Error()})
+		RenderError(w, r, err)
 		return
 	}
+	Render(w, r, http.StatusOK, report)
+}
 
-	JsonResponse(w, httpRespCode, newOrg)
+func (c *OrganizationController) GetOrganizations(w http.ResponseWriter, r *http.Request) {
+	resp, err := c.service.GetOrganizations(r.Context(), r.URL.Query())
+	if err != nil {
+		RenderError(w, r, err)
+		return
+	} else if len(resp.Organizations) == 0 {
+		RenderError(w, r, problems.Errorf(http.StatusNotFound, problems.CodeNotFound, "No organizations found"))
+		return
+	}
+	Render(w, r, http.StatusOK, resp)
 }
 
-func GetOrganizations(w http.ResponseWriter, r *http.Request) {
-	resp, responseStatus, err := services.GetOrganizations(r.URL.Query())
+func (c *OrganizationController) SearchOrganizations(w http.ResponseWriter, r *http.Request) {
+	resp, err := c.service.SearchOrganizations(r.Context(), r.Body)
 	if err != nil {
-		JsonResponse(w, responseStatus, ErrorResponse{err.Error()})
+		RenderError(w, r, err)
 		return
 	} else if len(resp.Organizations) == 0 {
-		JsonResponse(w, http.StatusNotFound, ErrorResponse{"No organizations found"})
+		RenderError(w, r, problems.Errorf(http.StatusNotFound, problems.CodeNotFound, "No organizations found"))
+		return
+	}
+	Render(w, r, http.StatusOK, resp)
+}
+
+func (c *OrganizationController) ExportOrganizations(w http.ResponseWriter, r *http.Request) {
+	format, err := services.NegotiateFormat(r.Header.Get("Accept"), services.ExportFormats...)
+	if err != nil {
+		JsonProblem(w, problems.New(http.StatusNotAcceptable, problems.CodeNotAcceptable, err))
+		return
+	}
+	export, err := c.service.GetOrganizationExport(r.URL.Query(), format)
+	if err != nil {
+		JsonProblem(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", export.ContentType())
+	stream := &responseStream{ResponseWriter: w}
+	err = export.Write(r.Context(), stream)
+	if err != nil && !stream.started {
+		JsonProblem(w, err)
+	} else if err != nil {
+		// the status has already been sent, so the export is cut short
+		log.Errorf("error streaming organization export: %v", err)
+	}
+}
+
+func (c *OrganizationController) GetOrganization(w http.ResponseWriter, r *http.Request) {
+	org, err := c.service.GetProjectedOrganization(r.Context(), mux.Vars(r)["id"], r.URL.Query())
+	if err != nil {
+		RenderError(w, r, err)
+		return
+	}
+	etag := setETag(w, r, org.Organization, org.Fields)
+	if services.NotModified(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	Render(w, r, http.StatusOK, org)
+}
+
+func (c *OrganizationController) GetOrganizationAggregates(w http.ResponseWriter, r *http.Request) {
+	resp, err := c.service.GetOrganizationAggregates(r.Context(), r.URL.Query())
+	if err != nil {
+		RenderError(w, r, err)
+		return
+	}
+	Render(w, r, http.StatusOK, resp)
+}
+
+func (c *OrganizationController) GetDuplicateOrganizations(w http.ResponseWriter, r *http.Request) {
+	resp, err := c.service.GetDuplicateOrganizations(r.Context(), mux.Vars(r)["id"], r.URL.Query())
+	if err != nil {
+		RenderError(w, r, err)
+		return
+	}
+	Render(w, r, http.StatusOK, resp)
+}
+
+func (c *OrganizationController) GetOrganizationHistory(w http.ResponseWriter, r *http.Request) {
+	resp, err := c.service.GetOrganizationHistory(r.Context(), mux.Vars(r)["id"], r.URL.Query())
+	if err != nil {
+		RenderError(w, r, err)
+		return
+	}
+	Render(w, r, http.StatusOK, resp)
+}
+
+func (c *OrganizationController) UpdateOrganization(w http.ResponseWriter, r *http.Request) {
+	org, err := c.service.UpdateOrganization(r.Context(), mux.Vars(r)["id"], r.Header.Get("If-Match"), r.Body)
+	if err != nil {
+		RenderError(w, r, err)
+		return
+	}
+	setETag(w, r, *org, nil)
+	Render(w, r, http.StatusOK, org)
+}
+
+func (c *OrganizationController) PatchOrganization(w http.ResponseWriter, r *http.Request) {
+	org, err := c.service.PatchOrganization(r.Context(), mux.Vars(r)["id"], r.Header.Get("If-Match"), r.Body)
+	if err != nil {
+		RenderError(w, r, err)
+		return
+	}
+	setETag(w, r, *org, nil)
+	Render(w, r, http.StatusOK, org)
+}
+
+func (c *OrganizationController) MergeOrganization(w http.ResponseWriter, r *http.Request) {
+	org, err := c.service.MergeOrganization(r.Context(), mux.Vars(r)["id"], r.Body)
+	if err != nil {
+		RenderError(w, r, err)
+		return
+	}
+	setETag(w, r, *org, nil)
+	Render(w, r, http.StatusOK, org)
+}
+
+func (c *OrganizationController) DeleteOrganization(w http.ResponseWriter, r *http.Request) {
+	err := c.service.DeleteOrganization(r.Context(), mux.Vars(r)["id"], r.Header.Get("If-Match"))
+	if err != nil {
+		RenderError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *OrganizationController) RestoreOrganization(w http.ResponseWriter, r *http.Request) {
+	org, err := c.service.RestoreOrganization(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		RenderError(w, r, err)
+		return
+	}
+	setETag(w, r, *org, nil)
+	Render(w, r, http.StatusOK, org)
+}
+
+func (c *OrganizationController) PurgeOrganization(w http.ResponseWriter, r *http.Request) {
+	err := c.service.PurgeOrganization(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		RenderError(w, r, err)
 		return
 	}
-	JsonResponse(w, responseStatus, resp)
+	w.WriteHeader(http.StatusNoContent)
 }
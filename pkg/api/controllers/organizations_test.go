@@ -7,12 +7,16 @@ import (
 	"fmt"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/google/uuid"
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"organization_manager/pkg/api/problems"
 	"organization_manager/pkg/api/services"
 	"organization_manager/pkg/database"
 	"organization_manager/pkg/database/models"
+	"organization_manager/pkg/database/repository"
 	"regexp"
 	"strings"
 	"testing"
@@ -32,9 +36,9 @@ func TestSaveNewOrganization(t *testing.T) {
 								"employee_count": 10,"is_public": false}`),
 			expectedOrganization: models.Organization{
 				Name:          "Organization 1",
-				CreationDate:  time.Date(2021, 9, 26, 0, 0, 0, 0, time.UTC),
-				EmployeeCount: 10,
-				IsPublic:      false,
+				CreationDate:  timePointer(time.Date(2021, 9, 26, 0, 0, 0, 0, time.UTC)),
+				EmployeeCount: intPointer(10),
+				IsPublic:      boolPointer(false),
 			},
 			expectedRespCode: http.StatusCreated,
 		},
@@ -52,8 +56,7 @@ func TestSaveNewOrganization(t *testing.T) {
 		},
 	}
 
-	_, mock, err := database.InitializeTest()
-	assert.NoError(t, err)
+	controller, mock := newTestController(t)
 
 	for i, test := range testCases {
 		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
@@ -62,14 +65,15 @@ func TestSaveNewOrganization(t *testing.T) {
 
 			if test.expectedOrganization != emptyOrg {
 				mock.ExpectBegin()
-				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "organizations" ("id","name","creation_date","employee_count","is_public") VALUES ($1,$2,$3,$4,$5)`)).
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "organizations" ("id","name","creation_date","employee_count","is_public","deleted_at","version") VALUES ($1,$2,$3,$4,$5,$6,$7)`)).
 					WithArgs(sqlmock.AnyArg(), test.expectedOrganization.Name,
 						test.expectedOrganization.CreationDate, test.expectedOrganization.EmployeeCount,
-						test.expectedOrganization.IsPublic).WillReturnResult(sqlmock.NewResult(1, 1))
+						test.expectedOrganization.IsPublic, nil, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+				expectAuditInsert(mock, models.CreateAuditAction, 1)
 				mock.ExpectCommit()
 			}
 
-			CreateOrganization(w, req)
+			controller.CreateOrganization(w, req)
 			res := w.Result()
 			assert.Equal(t, test.expectedRespCode, res.StatusCode)
 
@@ -80,10 +84,200 @@ func TestSaveNewOrganization(t *testing.T) {
 				assert.NoError(t, err)
 				assert.NotEqual(t, uuid.Nil, respObj.ID)
 			} else if test.expectedRespCode == http.StatusBadRequest {
-				var respObj ErrorResponse
+				var respObj ProblemResponse
+				err := json.NewDecoder(res.Body).Decode(&respObj)
+				assert.NoError(t, err)
+				assert.True(t, strings.Contains(respObj.Detail, "invalid request body"))
+			}
+		})
+	}
+}
+
+func TestSaveNewOrganizations(t *testing.T) {
+	var testCases = []struct {
+		queryParams      string
+		requestBody      string
+		expectedRespCode int
+	}{
+		{
+			queryParams: "atomic=true",
+			requestBody: `[{"name": "Organization 1", "creation_date": "2021-09-26T00:00:00Z", "employee_count": 10, "is_public": false},
+				{"name": "Organization 2", "creation_date": "2021-09-27T00:00:00Z", "employee_count": 20, "is_public": true}]`,
+			expectedRespCode: http.StatusCreated,
+		},
+		{
+			// Testing an invalid organization rolls back an atomic batch, should not reach the database
+			queryParams:      "atomic=true",
+			requestBody:      `[{"name": "Organization 1"}, {"id": "1eacb0fa-d4ae-4d5e-9b69-268c1359db19", "name": "Organization 2"}]`,
+			expectedRespCode: http.StatusBadRequest,
+		},
+		{
+			requestBody:      `{"name": "Organization 1"}`,
+			expectedRespCode: http.StatusBadRequest,
+		},
+	}
+
+	controller, mock := newTestController(t)
+
+	for i, test := range testCases {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/organizations:batch?"+test.queryParams,
+				strings.NewReader(test.requestBody))
+			w := httptest.NewRecorder()
+
+			if test.expectedRespCode == http.StatusCreated {
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "organizations" ("id","name","creation_date","employee_count","is_public","deleted_at","version") VALUES ($1,$2,$3,$4,$5,$6,$7),($8,$9,$10,$11,$12,$13,$14)`)).
+					WithArgs(sqlmock.AnyArg(), "Organization 1", time.Date(2021, 9, 26, 0, 0, 0, 0, time.UTC), 10, false, nil, 1,
+						sqlmock.AnyArg(), "Organization 2", time.Date(2021, 9, 27, 0, 0, 0, 0, time.UTC), 20, true, nil, 1).
+					WillReturnResult(sqlmock.NewResult(2, 2))
+				expectAuditInsert(mock, models.CreateAuditAction, 2)
+				mock.ExpectCommit()
+			}
+
+			controller.CreateOrganizations(w, req)
+			res := w.Result()
+			assert.Equal(t, test.expectedRespCode, res.StatusCode)
+			assert.NoError(t, mock.ExpectationsWereMet())
+
+			var respObj ProblemResponse
+			err := json.NewDecoder(res.Body).Decode(&respObj)
+			assert.NoError(t, err)
+			switch {
+			case test.expectedRespCode == http.StatusCreated:
+				assert.Equal(t, 2, len(respObj.Results))
+				for _, result := range respObj.Results {
+					assert.Equal(t, http.StatusCreated, result.Status)
+					assert.NotEqual(t, uuid.Nil, result.Organization.ID)
+				}
+			case test.queryParams != "":
+				if assert.Equal(t, 2, len(respObj.Results)) {
+					assert.Equal(t, http.StatusFailedDependency, respObj.Results[0].Status)
+					assert.Equal(t, http.StatusBadRequest, respObj.Results[1].Status)
+				}
+			default:
+				assert.True(t, strings.Contains(respObj.Detail, "invalid request body"))
+			}
+		})
+	}
+}
+
+func TestImportOrganizations(t *testing.T) {
+	csvContent := "name,employee_count\nOrganization 1,10\nOrganization 2,many\n"
+	multipartBody := &bytes.Buffer{}
+	multipartWriter := multipart.NewWriter(multipartBody)
+	filePart, err := multipartWriter.CreateFormFile("file", "organizations.csv")
+	assert.NoError(t, err)
+	filePart.Write([]byte(csvContent))
+	assert.NoError(t, multipartWriter.Close())
+
+	var testCases = []struct {
+		queryParams      string
+		contentType      string
+		requestBody      []byte
+		expectedCreated  int
+		expectedRespCode int
+	}{
+		{contentType: "text/csv", requestBody: []byte(csvContent), expectedCreated: 1, expectedRespCode: http.StatusOK},
+		{
+			queryParams:      "dry_run=true",
+			contentType:      multipartWriter.FormDataContentType(),
+			requestBody:      multipartBody.Bytes(),
+			expectedRespCode: http.StatusOK,
+		},
+		{contentType: "application/json", requestBody: []byte(`[]`), expectedRespCode: http.StatusUnsupportedMediaType},
+		{contentType: "text/csv", requestBody: []byte("revenue\n10\n"), expectedRespCode: http.StatusBadRequest},
+	}
+
+	controller, mock := newTestController(t)
+
+	for i, test := range testCases {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/organizations/import?"+test.queryParams,
+				bytes.NewReader(test.requestBody))
+			req.Header.Set("Content-Type", test.contentType)
+			w := httptest.NewRecorder()
+
+			if test.expectedCreated > 0 {
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "organizations" ("id","name","creation_date","employee_count","is_public","deleted_at","version") VALUES ($1,$2,$3,$4,$5,$6,$7)`)).
+					WithArgs(sqlmock.AnyArg(), "Organization 1", nil, 10, nil, nil, 1).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectAuditInsert(mock, models.CreateAuditAction, 1)
+				mock.ExpectCommit()
+			}
+
+			controller.ImportOrganizations(w, req)
+			res := w.Result()
+			assert.Equal(t, test.expectedRespCode, res.StatusCode)
+			assert.NoError(t, mock.ExpectationsWereMet())
+
+			if test.expectedRespCode == http.StatusOK {
+				var report services.ImportReport
+				err := json.NewDecoder(res.Body).Decode(&report)
+				assert.NoError(t, err)
+				assert.Equal(t, services.ImportReport{
+					DryRun:  test.queryParams == "dry_run=true",
+					Rows:    2,
+					Valid:   1,
+					Created: test.expectedCreated,
+					Errors:  []services.ImportRowError{{Row: 2, Error: "invalid value 'many' for column 'employee_count'"}},
+				}, report)
+			}
+		})
+	}
+}
+
+func TestSaveNewOrganization_duplicateCheck(t *testing.T) {
+	duplicateID := uuid.New()
+	var testCases = []struct {
+		duplicateCheck   string
+		expectedRespCode int
+	}{
+		{duplicateCheck: services.DuplicateCheckWarn, expectedRespCode: http.StatusCreated},
+		{duplicateCheck: services.DuplicateCheckReject, expectedRespCode: http.StatusConflict},
+	}
+
+	for i, test := range testCases {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			controller, mock := newTestController(t)
+			controller.service.DuplicateCheck = services.DuplicateCheck{Mode: test.duplicateCheck, Threshold: 0.7}
+			req := httptest.NewRequest(http.MethodPost, "/organizations",
+				strings.NewReader(`{"name": "ACME, Inc.", "employee_count": 10}`))
+			w := httptest.NewRecorder()
+
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta(`SELECT set_config('pg_trgm.similarity_threshold', $1, true)`)).
+				WithArgs("0.7").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT *, similarity(name, $1) AS similarity FROM "organizations" WHERE name % $2 AND "organizations"."deleted_at" IS NULL ORDER BY similarity DESC,id LIMIT 5`)).
+				WithArgs("ACME, Inc.", "ACME, Inc.").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "name", "employee_count", "similarity"}).
+					AddRow(duplicateID, "Acme Inc", 12, 1))
+			mock.ExpectCommit()
+			if test.expectedRespCode == http.StatusCreated {
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "organizations"`)).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectAuditInsert(mock, models.CreateAuditAction, 1)
+				mock.ExpectCommit()
+			}
+
+			controller.CreateOrganization(w, req)
+			res := w.Result()
+			assert.Equal(t, test.expectedRespCode, res.StatusCode)
+			assert.NoError(t, mock.ExpectationsWereMet())
+
+			if test.expectedRespCode == http.StatusCreated {
+				assert.Equal(t, []string{fmt.Sprintf(
+					`299 - "possible duplicate of organization '%s' named 'Acme Inc' with similarity 1.00"`, duplicateID)},
+					res.Header.Values("Warning"))
+			} else {
+				var respObj ProblemResponse
 				err := json.NewDecoder(res.Body).Decode(&respObj)
 				assert.NoError(t, err)
-				assert.True(t, strings.Contains(respObj.Error, "invalid request body"))
+				assert.Equal(t, 1, len(respObj.Duplicates))
+				assert.Equal(t, duplicateID, respObj.Duplicates[0].Organization.ID)
+				assert.Empty(t, res.Header.Values("Warning"))
 			}
 		})
 	}
@@ -93,42 +287,108 @@ func TestGetOrganizations(t *testing.T) {
 	var tests = []struct {
 		queryParams              map[string][]string
 		expectedQueryConditional string
+		expectedQueryOrder       string
 		expectedQueryLimit       string
 		expectedArgs             []driver.Value
 		expectedResponseCode     int
 	}{
 		{
 			queryParams:              map[string][]string{"filter": {"name:CLEAR"}},
-			expectedQueryConditional: `WHERE name = $1`,
-			expectedQueryLimit:       `LIMIT 20`,
+			expectedQueryConditional: `WHERE name = $1 AND "organizations"."deleted_at" IS NULL`,
+			expectedQueryLimit:       `LIMIT 21`,
 			expectedArgs:             []driver.Value{"CLEAR"},
 			expectedResponseCode:     http.StatusOK,
 		},
 		{
 			queryParams:              map[string][]string{"filter": {"name:CLEAR"}, "range_filter": {"creation_date:[2002-09-22T00:00:00ZTO*]"}, "page": {"2"}},
-			expectedQueryConditional: `WHERE name = $1 AND creation_date >= $2`,
-			expectedQueryLimit:       `LIMIT 20 OFFSET 20`,
+			expectedQueryConditional: `WHERE name = $1 AND creation_date >= $2 AND "organizations"."deleted_at" IS NULL`,
+			expectedQueryLimit:       `LIMIT 21 OFFSET 20`,
 			expectedArgs:             []driver.Value{"CLEAR", "2002-09-22T00:00:00Z"},
 			expectedResponseCode:     http.StatusOK,
 		},
 		{
 			queryParams:              map[string][]string{"filter": {"name:CLEAR"}, "range_filter": {"creation_date:[2002-09-22T00:00:00ZTO*]"}, "page": {"2"}},
-			expectedQueryConditional: `WHERE name = $1 AND creation_date >= $2`,
-			expectedQueryLimit:       `LIMIT 20 OFFSET 20`,
+			expectedQueryConditional: `WHERE name = $1 AND creation_date >= $2 AND "organizations"."deleted_at" IS NULL`,
+			expectedQueryLimit:       `LIMIT 21 OFFSET 20`,
 			expectedArgs:             []driver.Value{"CLEAR", "2002-09-22T00:00:00Z"},
 			expectedResponseCode:     http.StatusNotFound,
 		},
 		{
 			queryParams:              map[string][]string{"filter": {"name:CLEAR"}, "range_filter": {"creation_date:[2002-09-22T00:00:00ZTO*]"}, "page": {"r"}},
-			expectedQueryConditional: `WHERE name = $1 AND creation_date >= $2`,
-			expectedQueryLimit:       `LIMIT 20 OFFSET 20`,
+			expectedQueryConditional: `WHERE name = $1 AND creation_date >= $2 AND "organizations"."deleted_at" IS NULL`,
+			expectedQueryLimit:       `LIMIT 21 OFFSET 20`,
 			expectedArgs:             []driver.Value{"CLEAR", "2002-09-22T00:00:00Z"},
 			expectedResponseCode:     http.StatusBadRequest,
 		},
+		{
+			// Testing soft deleted organizations can be included
+			queryParams:              map[string][]string{"filter": {"name:CLEAR"}, "include_deleted": {"true"}},
+			expectedQueryConditional: `WHERE name = $1`,
+			expectedQueryLimit:       `LIMIT 21`,
+			expectedArgs:             []driver.Value{"CLEAR"},
+			expectedResponseCode:     http.StatusOK,
+		},
+		{
+			// Testing sorting on multiple fields with id as the final tiebreaker
+			queryParams:              map[string][]string{"filter": {"name:CLEAR"}, "sort": {"-employee_count,name"}},
+			expectedQueryConditional: `WHERE name = $1 AND "organizations"."deleted_at" IS NULL`,
+			expectedQueryOrder:       `ORDER BY COALESCE(employee_count, 0) DESC,lower(name) COLLATE "C",id`,
+			expectedQueryLimit:       `LIMIT 21`,
+			expectedArgs:             []driver.Value{"CLEAR"},
+			expectedResponseCode:     http.StatusOK,
+		},
+		{
+			queryParams:              map[string][]string{"filter": {"name:CLEAR"}, "sort": {"revenue"}},
+			expectedQueryConditional: `WHERE name = $1 AND "organizations"."deleted_at" IS NULL`,
+			expectedQueryLimit:       `LIMIT 21`,
+			expectedArgs:             []driver.Value{"CLEAR"},
+			expectedResponseCode:     http.StatusBadRequest,
+		},
+		{
+			// Testing boolean filter expressions are compiled into parameterized conditions
+			queryParams:              map[string][]string{"filter": {"name:CLEAR"}, "q": {"is_public:true OR (employee_count:[1000TO*] AND NOT name:Acme*)"}},
+			expectedQueryConditional: `WHERE name = $1 AND (is_public = $2 OR (employee_count >= $3 AND NOT (name LIKE $4))) AND "organizations"."deleted_at" IS NULL`,
+			expectedQueryLimit:       `LIMIT 21`,
+			expectedArgs:             []driver.Value{"CLEAR", "true", "1000", "Acme%"},
+			expectedResponseCode:     http.StatusOK,
+		},
+		{
+			// Testing set membership and null filters
+			queryParams:              map[string][]string{"filter": {"name:in(CLEAR,Acme)", "creation_date:is_null"}},
+			expectedQueryConditional: `WHERE name IN ($1,$2) AND creation_date IS NULL AND "organizations"."deleted_at" IS NULL`,
+			expectedQueryLimit:       `LIMIT 21`,
+			expectedArgs:             []driver.Value{"CLEAR", "Acme"},
+			expectedResponseCode:     http.StatusOK,
+		},
+		{
+			queryParams:              map[string][]string{"q": {"employee_count:not_in(1,2) OR is_public:not_null"}},
+			expectedQueryConditional: `WHERE (employee_count NOT IN ($1,$2) OR is_public IS NOT NULL) AND "organizations"."deleted_at" IS NULL`,
+			expectedQueryLimit:       `LIMIT 21`,
+			expectedArgs:             []driver.Value{"1", "2"},
+			expectedResponseCode:     http.StatusOK,
+		},
+		{
+			queryParams:          map[string][]string{"filter": {"employee_count:in(1,two)"}},
+			expectedResponseCode: http.StatusBadRequest,
+		},
+		{
+			queryParams:          map[string][]string{"filter": {"name:is_null"}},
+			expectedResponseCode: http.StatusBadRequest,
+		},
+		{
+			queryParams:          map[string][]string{"q": {"is_public:true OR"}},
+			expectedResponseCode: http.StatusBadRequest,
+		},
+		{
+			queryParams:              map[string][]string{"filter": {"name:CLEAR"}, "include_deleted": {"maybe"}},
+			expectedQueryConditional: `WHERE name = $1`,
+			expectedQueryLimit:       `LIMIT 21`,
+			expectedArgs:             []driver.Value{"CLEAR"},
+			expectedResponseCode:     http.StatusBadRequest,
+		},
 	}
 
-	_, mock, err := database.InitializeTest()
-	assert.NoError(t, err)
+	controller, mock := newTestController(t)
 
 	for i, test := range tests {
 		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
@@ -143,10 +403,16 @@ func TestGetOrganizations(t *testing.T) {
 			}
 			req.URL.RawQuery = q.Encode()
 
-			mockSearchQueries(test.expectedResponseCode, mock, test.expectedArgs, test.expectedQueryLimit,
-				test.expectedQueryConditional)
+			// invalid query parameters are rejected before the database is queried
+			if test.expectedResponseCode != http.StatusBadRequest {
+				if test.expectedQueryOrder == "" {
+					test.expectedQueryOrder = "ORDER BY id"
+				}
+				mockSearchQueries(test.expectedResponseCode, mock, test.expectedArgs, test.expectedQueryLimit,
+					test.expectedQueryConditional, test.expectedQueryOrder)
+			}
 
-			GetOrganizations(w, req)
+			controller.GetOrganizations(w, req)
 			res := w.Result()
 			assert.Equal(t, test.expectedResponseCode, res.StatusCode)
 
@@ -158,35 +424,1077 @@ func TestGetOrganizations(t *testing.T) {
 				// mock db call will always return a single org
 				assert.Equal(t, 1, len(respObj.Organizations))
 			} else {
-				var respObj ErrorResponse
+				var respObj ProblemResponse
 				err := json.NewDecoder(res.Body).Decode(&respObj)
 				assert.NoError(t, err)
-				assert.NotEqual(t, "", respObj.Error)
+				assert.NotEqual(t, "", respObj.Detail)
 			}
 		})
 	}
 }
 
-func mockSearchQueries(expectedResponseCode int, mock sqlmock.Sqlmock, expectedArgs []driver.Value, expectedQueryLimit,
-	expectedQueryConditional string) {
+func TestGetOrganizations_fullTextSearch(t *testing.T) {
+	controller, mock := newTestController(t)
+	orgID := uuid.New()
 
-	var organizationColumns = []string{"id", "name", "created_date", "employee_count", "is_public"}
-	var countCol = []string{"count"}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "organizations" WHERE name ILIKE $1 AND name_tsv @@ plainto_tsquery('pg_catalog.simple', $2) AND "organizations"."deleted_at" IS NULL`)).
+		WithArgs("%corp%", "acme corp").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT *, ts_rank(name_tsv, plainto_tsquery('pg_catalog.simple', $1)) AS search_rank, ts_headline('pg_catalog.simple', name, plainto_tsquery('pg_catalog.simple', $2), $3) AS search_highlight FROM "organizations" WHERE name ILIKE $4 AND name_tsv @@ plainto_tsquery('pg_catalog.simple', $5) AND "organizations"."deleted_at" IS NULL ORDER BY search_rank DESC,id LIMIT 21`)).
+		WithArgs("acme corp", "acme corp", "StartSel=<mark>, StopSel=</mark>, HighlightAll=true", "%corp%", "acme corp").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "creation_date", "employee_count", "is_public", "name_tsv", "search_rank", "search_highlight"}).
+			AddRow(orgID, "ACME Corp", time.Date(2002, 9, 22, 0, 0, 0, 0, time.UTC), 10000, true, "'acme':1 'corp':2", 0.1, "<mark>ACME</mark> <mark>Corp</mark>"))
 
-	var returnedCount = 1
-	if expectedResponseCode == http.StatusNotFound {
-		returnedCount = 0
+	req := httptest.NewRequest(http.MethodGet, "/organizations?search=acme+corp&filter=name:ilike(*corp*)", nil)
+	w := httptest.NewRecorder()
+	controller.GetOrganizations(w, req)
+	res := w.Result()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var respObj services.PaginatedOrganizationResponse
+	err := json.NewDecoder(res.Body).Decode(&respObj)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(respObj.Organizations))
+	assert.Equal(t, orgID, respObj.Organizations[0].ID)
+	assert.Equal(t, "ACME Corp", respObj.Organizations[0].Name)
+	assert.Equal(t, map[string]string{orgID.String(): "<mark>ACME</mark> <mark>Corp</mark>"}, respObj.Highlights)
+}
+
+func TestGetOrganizations_fields(t *testing.T) {
+	controller, mock := newTestController(t)
+	orgID := uuid.New()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "organizations" WHERE "organizations"."deleted_at" IS NULL`)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id","name","employee_count" FROM "organizations" WHERE "organizations"."deleted_at" IS NULL ORDER BY COALESCE(employee_count, 0) DESC,id LIMIT 21`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "employee_count"}).AddRow(orgID, "CLEAR", 10000))
+
+	req := httptest.NewRequest(http.MethodGet, "/organizations?fields=id,name&sort=-employee_count", nil)
+	w := httptest.NewRecorder()
+	controller.GetOrganizations(w, req)
+	res := w.Result()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var respObj struct {
+		Organizations []map[string]interface{} `json:"organizations"`
 	}
-	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "organizations" ` + expectedQueryConditional)).
-		WithArgs(expectedArgs...).WillReturnRows(sqlmock.NewRows(countCol).AddRow(returnedCount))
+	err := json.NewDecoder(res.Body).Decode(&respObj)
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{{"ID": orgID.String(), "name": "CLEAR"}}, respObj.Organizations)
 
-	searchQuery := mock.ExpectQuery(regexp.QuoteMeta(
-		`SELECT * FROM "organizations" ` + expectedQueryConditional + ` ORDER BY id ` + expectedQueryLimit)).
-		WithArgs(expectedArgs...)
-	if expectedResponseCode == http.StatusNotFound {
-		searchQuery.WillReturnRows(sqlmock.NewRows(organizationColumns))
-	} else {
-		searchQuery.WillReturnRows(sqlmock.NewRows(organizationColumns).
-			AddRow(uuid.New(), "CLEAR", "2002-09-22T00:00:00Z", 10000, true))
+	// searches select the requested columns alongside the rank and highlight
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, name, ts_rank(name_tsv, plainto_tsquery('pg_catalog.simple', $1)) AS search_rank, ts_headline('pg_catalog.simple', name, plainto_tsquery('pg_catalog.simple', $2), $3) AS search_highlight FROM "organizations"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "search_rank", "search_highlight"}).
+			AddRow(orgID, "CLEAR", 0.1, "<mark>CLEAR</mark>"))
+
+	req = httptest.NewRequest(http.MethodGet, "/organizations?fields=name&search=clear&include_total=false", nil)
+	w = httptest.NewRecorder()
+	controller.GetOrganizations(w, req)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	req = httptest.NewRequest(http.MethodGet, "/organizations?fields=id,revenue", nil)
+	w = httptest.NewRecorder()
+	controller.GetOrganizations(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+}
+
+func TestSearchOrganizations(t *testing.T) {
+	var tests = []struct {
+		requestBody              string
+		expectedQueryConditional string
+		expectedQueryOrder       string
+		expectedQueryLimit       string
+		expectedArgs             []driver.Value
+		expectedResponseCode     int
+		expectedInvalidClauses   int
+	}{
+		{
+			requestBody: `{"filters": [{"field": "name", "operator": "eq", "value": "CLEAR: TO"},
+				{"field": "employee_count", "operator": "between", "values": [10, 20]}],
+				"sort": ["-employee_count"], "page": 2}`,
+			expectedQueryConditional: `WHERE name = $1 AND (employee_count >= $2 AND employee_count <= $3) AND "organizations"."deleted_at" IS NULL`,
+			expectedQueryOrder:       `ORDER BY COALESCE(employee_count, 0) DESC,id`,
+			expectedQueryLimit:       `LIMIT 21 OFFSET 20`,
+			expectedArgs:             []driver.Value{"CLEAR: TO", "10", "20"},
+			expectedResponseCode:     http.StatusOK,
+		},
+		{
+			requestBody:              `{"filters": [{"field": "is_public", "operator": "eq", "value": true}]}`,
+			expectedQueryConditional: `WHERE is_public = $1 AND "organizations"."deleted_at" IS NULL`,
+			expectedQueryOrder:       `ORDER BY id`,
+			expectedQueryLimit:       `LIMIT 21`,
+			expectedArgs:             []driver.Value{"true"},
+			expectedResponseCode:     http.StatusNotFound,
+		},
+		{
+			requestBody: `{"filters": [{"field": "revenue", "operator": "eq", "value": 10},
+				{"field": "name", "operator": "gt", "value": "CLEAR"}]}`,
+			expectedResponseCode:   http.StatusBadRequest,
+			expectedInvalidClauses: 2,
+		},
+		{
+			requestBody:          `{"filters": {"field": "name"}}`,
+			expectedResponseCode: http.StatusBadRequest,
+		},
+	}
+
+	controller, mock := newTestController(t)
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/organizations/search", strings.NewReader(test.requestBody))
+			w := httptest.NewRecorder()
+
+			if test.expectedResponseCode != http.StatusBadRequest {
+				mockSearchQueries(test.expectedResponseCode, mock, test.expectedArgs, test.expectedQueryLimit,
+					test.expectedQueryConditional, test.expectedQueryOrder)
+			}
+
+			controller.SearchOrganizations(w, req)
+			res := w.Result()
+			assert.Equal(t, test.expectedResponseCode, res.StatusCode)
+			assert.NoError(t, mock.ExpectationsWereMet())
+
+			if test.expectedResponseCode == http.StatusOK {
+				var respObj services.PaginatedOrganizationResponse
+				err := json.NewDecoder(res.Body).Decode(&respObj)
+				assert.NoError(t, err)
+				assert.Equal(t, 1, len(respObj.Organizations))
+			} else if test.expectedResponseCode == http.StatusBadRequest {
+				var respObj ProblemResponse
+				err := json.NewDecoder(res.Body).Decode(&respObj)
+				assert.NoError(t, err)
+				assert.NotEmpty(t, respObj.Detail)
+				assert.Equal(t, test.expectedInvalidClauses, len(respObj.Clauses))
+			}
+		})
+	}
+}
+
+func TestExportOrganizations(t *testing.T) {
+	orgID := uuid.New()
+	var testCases = []struct {
+		accept              string
+		queryError          error
+		expectedRespCode    int
+		expectedContentType string
+		expectedBody        string
+	}{
+		{
+			accept:              "text/csv",
+			expectedRespCode:    http.StatusOK,
+			expectedContentType: "text/csv",
+			expectedBody: "id,name,creation_date,employee_count,is_public\n" +
+				orgID.String() + ",CLEAR,2002-09-22T00:00:00Z,10000,true\n",
+		},
+		{
+			// Testing a database error before any organization is streamed is returned as an error response
+			accept:              "application/x-ndjson",
+			queryError:          fmt.Errorf("connection refused"),
+			expectedRespCode:    http.StatusInternalServerError,
+			expectedContentType: "application/problem+json",
+		},
+		{accept: "text/html", expectedRespCode: http.StatusNotAcceptable, expectedContentType: "application/problem+json"},
+	}
+
+	controller, mock := newTestController(t)
+
+	for i, test := range testCases {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/organizations/export?filter=is_public:true&sort=name", nil)
+			req.Header.Set("Accept", test.accept)
+			w := httptest.NewRecorder()
+
+			if test.expectedRespCode != http.StatusNotAcceptable {
+				expectedQuery := mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organizations" WHERE is_public = $1 AND "organizations"."deleted_at" IS NULL ORDER BY lower(name) COLLATE "C",id`)).
+					WithArgs("true")
+				if test.queryError != nil {
+					expectedQuery.WillReturnError(test.queryError)
+				} else {
+					expectedQuery.WillReturnRows(sqlmock.NewRows([]string{"id", "name", "creation_date", "employee_count", "is_public"}).
+						AddRow(orgID, "CLEAR", time.Date(2002, 9, 22, 0, 0, 0, 0, time.UTC), 10000, true))
+				}
+			}
+
+			controller.ExportOrganizations(w, req)
+			res := w.Result()
+			assert.Equal(t, test.expectedRespCode, res.StatusCode)
+			assert.Equal(t, test.expectedContentType, res.Header.Get("Content-Type"))
+			assert.NoError(t, mock.ExpectationsWereMet())
+			if test.expectedBody != "" {
+				assert.Equal(t, test.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestGetOrganization(t *testing.T) {
+	existingID := uuid.New()
+
+	var tests = []struct {
+		orgID                string
+		ifNoneMatch          string
+		expectQuery          bool
+		orgExists            bool
+		isMerged             bool
+		expectedResponseCode int
+	}{
+		{
+			orgID:                existingID.String(),
+			expectQuery:          true,
+			orgExists:            true,
+			expectedResponseCode: http.StatusOK,
+		},
+		{
+			// Testing an ID with no matching organization
+			orgID:                uuid.New().String(),
+			expectQuery:          true,
+			orgExists:            false,
+			expectedResponseCode: http.StatusNotFound,
+		},
+		{
+			// Testing the ID of an organization merged into the existing organization resolves to it
+			orgID:                uuid.New().String(),
+			expectQuery:          true,
+			orgExists:            false,
+			isMerged:             true,
+			expectedResponseCode: http.StatusOK,
+		},
+		{
+			// Testing a client holding the current version is told it has not been modified
+			orgID:                existingID.String(),
+			ifNoneMatch:          `W/"2", "3"`,
+			expectQuery:          true,
+			orgExists:            true,
+			expectedResponseCode: http.StatusNotModified,
+		},
+		{
+			// Testing a client holding an earlier version receives the current version
+			orgID:                existingID.String(),
+			ifNoneMatch:          `"2"`,
+			expectQuery:          true,
+			orgExists:            true,
+			expectedResponseCode: http.StatusOK,
+		},
+		{
+			// Testing a malformed ID, should not reach the database
+			orgID:                "not-a-uuid",
+			expectQuery:          false,
+			expectedResponseCode: http.StatusBadRequest,
+		},
+	}
+
+	controller, mock := newTestController(t)
+
+	var organizationColumns = []string{"id", "name", "creation_date", "employee_count", "is_public", "version"}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/organizations/"+test.orgID, nil)
+			req = mux.SetURLVars(req, map[string]string{"id": test.orgID})
+			req.Header.Set("If-None-Match", test.ifNoneMatch)
+			w := httptest.NewRecorder()
+
+			if test.expectQuery {
+				rows := sqlmock.NewRows(organizationColumns)
+				if test.orgExists {
+					rows.AddRow(test.orgID, "CLEAR", time.Date(2002, 9, 22, 0, 0, 0, 0, time.UTC), 10000, true, 3)
+				}
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organizations" WHERE id = $1 AND "organizations"."deleted_at" IS NULL`)).
+					WithArgs(test.orgID).WillReturnRows(rows)
+			}
+			if test.expectQuery && !test.orgExists {
+				mergeRows := sqlmock.NewRows([]string{"source_id", "target_id"})
+				if test.isMerged {
+					mergeRows.AddRow(test.orgID, existingID)
+				}
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organization_merges" WHERE source_id = $1`)).
+					WithArgs(test.orgID).WillReturnRows(mergeRows)
+			}
+			if test.isMerged {
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organization_merges" WHERE source_id = $1`)).
+					WithArgs(existingID).WillReturnRows(sqlmock.NewRows([]string{"source_id", "target_id"}))
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organizations" WHERE id = $1 AND "organizations"."deleted_at" IS NULL`)).
+					WithArgs(existingID.String()).WillReturnRows(sqlmock.NewRows(organizationColumns).
+					AddRow(existingID, "CLEAR", time.Date(2002, 9, 22, 0, 0, 0, 0, time.UTC), 10000, true, 3))
+			}
+
+			controller.GetOrganization(w, req)
+			res := w.Result()
+			assert.Equal(t, test.expectedResponseCode, res.StatusCode)
+			assert.NoError(t, mock.ExpectationsWereMet())
+
+			if test.expectedResponseCode == http.StatusOK {
+				var respObj models.Organization
+				err := json.NewDecoder(res.Body).Decode(&respObj)
+				assert.NoError(t, err)
+				assert.Equal(t, existingID, respObj.ID)
+				assert.Equal(t, `"3"`, res.Header.Get("ETag"))
+			} else if test.expectedResponseCode == http.StatusNotModified {
+				assert.Equal(t, `"3"`, res.Header.Get("ETag"))
+				assert.Equal(t, 0, w.Body.Len())
+			} else {
+				var respObj ProblemResponse
+				err := json.NewDecoder(res.Body).Decode(&respObj)
+				assert.NoError(t, err)
+				assert.NotEqual(t, "", respObj.Detail)
+			}
+		})
 	}
 }
+
+func TestGetOrganization_nullColumns(t *testing.T) {
+	orgID := uuid.New()
+	controller, mock := newTestController(t)
+
+	var organizationColumns = []string{"id", "name", "creation_date", "employee_count", "is_public", "version"}
+	req := httptest.NewRequest(http.MethodGet, "/organizations/"+orgID.String(), nil)
+	req = mux.SetURLVars(req, map[string]string{"id": orgID.String()})
+	w := httptest.NewRecorder()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organizations" WHERE id = $1 AND "organizations"."deleted_at" IS NULL`)).
+		WithArgs(orgID.String()).WillReturnRows(sqlmock.NewRows(organizationColumns).
+		AddRow(orgID, "CLEAR", nil, nil, nil, 1))
+
+	// NULL columns are rendered as null rather than as zero values
+	controller.GetOrganization(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"ID": "`+orgID.String()+`", "name": "CLEAR", "creation_date": null, "employee_count": null, `+
+		`"is_public": null, "deleted_at": null, "version": 1}`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetOrganization_responseFormat(t *testing.T) {
+	orgID := uuid.New()
+	controller, mock := newTestController(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/organizations/"+orgID.String()+"?fields=name", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": orgID.String()})
+	req.Header.Set("Accept", "application/json;q=0.5, application/yaml")
+	w := httptest.NewRecorder()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organizations" WHERE id = $1 AND "organizations"."deleted_at" IS NULL`)).
+		WithArgs(orgID.String()).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(orgID, "CLEAR"))
+
+	controller.GetOrganization(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/yaml", w.Header().Get("Content-Type"))
+	assert.Equal(t, "ID: "+orgID.String()+"\nname: CLEAR\n", w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+	etag := w.Header().Get("ETag")
+	assert.True(t, strings.HasPrefix(etag, `"0-`), etag)
+
+	// Testing the entity tag of the full JSON representation does not revalidate the projected YAML representation
+	req = httptest.NewRequest(http.MethodGet, "/organizations/"+orgID.String()+"?fields=name", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": orgID.String()})
+	req.Header.Set("Accept", "application/yaml")
+	req.Header.Set("If-None-Match", `"0"`)
+	w = httptest.NewRecorder()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organizations" WHERE id = $1 AND "organizations"."deleted_at" IS NULL`)).
+		WithArgs(orgID.String()).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(orgID, "CLEAR"))
+
+	controller.GetOrganization(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Testing problems keep their fields in CSV, with a row for each invalid parameter
+	req = httptest.NewRequest(http.MethodGet, "/organizations/invalid", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "invalid"})
+	req.Header.Set("Accept", "text/csv")
+	w = httptest.NewRecorder()
+
+	controller.GetOrganization(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(w.Body.String(), "type,title,status,detail,code,name,reason\n"))
+}
+
+func TestGetOrganization_asOf(t *testing.T) {
+	orgID := uuid.New()
+	asOf := time.Date(2021, 11, 20, 10, 0, 0, 0, time.UTC)
+	controller, mock := newTestController(t)
+
+	var organizationColumns = []string{"id", "name", "creation_date", "employee_count", "is_public", "version"}
+	req := httptest.NewRequest(http.MethodGet, "/organizations/"+orgID.String()+"?as_of=2021-11-20T11:00:00%2B01:00", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": orgID.String()})
+	w := httptest.NewRecorder()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organization_history" WHERE id = $1 AND (valid_from <= $2 AND (valid_to IS NULL OR valid_to > $3)) AND "organization_history"."deleted_at" IS NULL ORDER BY "organization_history"."id" LIMIT 1`)).
+		WithArgs(orgID.String(), asOf, asOf).WillReturnRows(sqlmock.NewRows(organizationColumns).
+		AddRow(orgID, "CLEAR", time.Date(2002, 9, 22, 0, 0, 0, 0, time.UTC), 8000, true, 2))
+
+	controller.GetOrganization(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+	var respObj models.Organization
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&respObj))
+	assert.Equal(t, intPointer(8000), respObj.EmployeeCount)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Testing an organization that did not exist at the moment, merged organizations are not resolved
+	req = httptest.NewRequest(http.MethodGet, "/organizations/"+orgID.String()+"?as_of=2021-11-20T10:00:00Z", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": orgID.String()})
+	w = httptest.NewRecorder()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organization_history" WHERE id = $1`)).
+		WithArgs(orgID.String(), asOf, asOf).WillReturnRows(sqlmock.NewRows(organizationColumns))
+	backfilledQuery := regexp.QuoteMeta(`SELECT "id" FROM "organization_history" WHERE id = $1 AND (backfilled AND valid_from > $2) LIMIT 1`)
+	mock.ExpectQuery(backfilledQuery).WithArgs(orgID.String(), asOf).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	controller.GetOrganization(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Testing an organization whose history was backfilled after the moment
+	req = httptest.NewRequest(http.MethodGet, "/organizations/"+orgID.String()+"?as_of=2021-11-20T10:00:00Z", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": orgID.String()})
+	w = httptest.NewRecorder()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organization_history" WHERE id = $1`)).
+		WithArgs(orgID.String(), asOf, asOf).WillReturnRows(sqlmock.NewRows(organizationColumns))
+	mock.ExpectQuery(backfilledQuery).WithArgs(orgID.String(), asOf).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(orgID))
+
+	controller.GetOrganization(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"history_unavailable"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Testing searching the organizations as they were at the moment
+	req = httptest.NewRequest(http.MethodGet, "/organizations?as_of=2021-11-20T10:00:00Z&filter=name:CLEAR", nil)
+	w = httptest.NewRecorder()
+	searchConditional := `WHERE (valid_from <= $1 AND (valid_to IS NULL OR valid_to > $2)) AND name = $3 AND "organization_history"."deleted_at" IS NULL`
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id" FROM "organization_history" WHERE backfilled AND valid_from > $1 LIMIT 1`)).
+		WithArgs(asOf).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "organization_history" `+searchConditional)).
+		WithArgs(asOf, asOf, "CLEAR").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organization_history" `+searchConditional+` ORDER BY id LIMIT 21`)).
+		WithArgs(asOf, asOf, "CLEAR").WillReturnRows(sqlmock.NewRows(organizationColumns).
+		AddRow(orgID, "CLEAR", time.Date(2002, 9, 22, 0, 0, 0, 0, time.UTC), 8000, true, 2))
+
+	controller.GetOrganizations(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Testing searching the organizations before their history was backfilled
+	req = httptest.NewRequest(http.MethodGet, "/organizations?as_of=2021-11-20T10:00:00Z", nil)
+	w = httptest.NewRecorder()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id" FROM "organization_history" WHERE backfilled AND valid_from > $1 LIMIT 1`)).
+		WithArgs(asOf).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(orgID))
+
+	controller.GetOrganizations(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"history_unavailable"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateOrganization(t *testing.T) {
+	existingID := uuid.New()
+	existingCreationDate := time.Date(2002, 9, 22, 0, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		method               string
+		ifMatch              string
+		requestBody          []byte
+		concurrentUpdate     bool
+		expectedOrganization models.Organization
+		expectedResponseCode int
+	}{
+		{
+			method: http.MethodPut,
+			requestBody: []byte(`{"name": "CLEAR Secure","creation_date": "2010-01-01T00:00:00Z",
+								"employee_count": 20,"is_public": false}`),
+			expectedOrganization: models.Organization{
+				ID:            existingID,
+				Name:          "CLEAR Secure",
+				CreationDate:  timePointer(time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)),
+				EmployeeCount: intPointer(20),
+				IsPublic:      boolPointer(false),
+				Version:       5,
+			},
+			expectedResponseCode: http.StatusOK,
+		},
+		{
+			// Testing the merge patch only changes the supplied fields
+			method:      http.MethodPatch,
+			ifMatch:     `"4"`,
+			requestBody: []byte(`{"employee_count": 20}`),
+			expectedOrganization: models.Organization{
+				ID:            existingID,
+				Name:          "CLEAR",
+				CreationDate:  &existingCreationDate,
+				EmployeeCount: intPointer(20),
+				IsPublic:      boolPointer(true),
+				Version:       5,
+			},
+			expectedResponseCode: http.StatusOK,
+		},
+		{
+			// Testing an update based on an earlier version is rejected, should not update the organization
+			method:               http.MethodPut,
+			ifMatch:              `"3"`,
+			requestBody:          []byte(`{"name": "CLEAR Secure"}`),
+			expectedResponseCode: http.StatusPreconditionFailed,
+		},
+		{
+			// Testing an update racing another update is rejected by the version check of the UPDATE statement
+			method:               http.MethodPatch,
+			ifMatch:              `"4"`,
+			requestBody:          []byte(`{"employee_count": 20}`),
+			concurrentUpdate:     true,
+			expectedResponseCode: http.StatusPreconditionFailed,
+		},
+		{
+			// Testing the ID of an organization cannot be changed
+			method:               http.MethodPut,
+			requestBody:          []byte(`{"id": "1eacb0fa-d4ae-4d5e-9b69-268c1359db19", "name": "CLEAR"}`),
+			expectedResponseCode: http.StatusBadRequest,
+		},
+		{
+			// Testing the ID of an organization cannot be changed through a patch
+			method:               http.MethodPatch,
+			requestBody:          []byte(`{"id": "1eacb0fa-d4ae-4d5e-9b69-268c1359db19"}`),
+			expectedResponseCode: http.StatusBadRequest,
+		},
+		{
+			// Testing a patch must be a JSON object
+			method:               http.MethodPatch,
+			requestBody:          []byte(`["employee_count"]`),
+			expectedResponseCode: http.StatusBadRequest,
+		},
+	}
+
+	controller, mock := newTestController(t)
+
+	var organizationColumns = []string{"id", "name", "creation_date", "employee_count", "is_public", "version"}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			req := httptest.NewRequest(test.method, "/organizations/"+existingID.String(),
+				bytes.NewBuffer(test.requestBody))
+			req = mux.SetURLVars(req, map[string]string{"id": existingID.String()})
+			req.Header.Set("If-Match", test.ifMatch)
+			w := httptest.NewRecorder()
+
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organizations" WHERE id = $1 AND "organizations"."deleted_at" IS NULL`)).
+				WithArgs(existingID.String()).WillReturnRows(sqlmock.NewRows(organizationColumns).
+				AddRow(existingID.String(), "CLEAR", existingCreationDate, 10000, true, 4))
+			if test.expectedResponseCode == http.StatusOK || test.concurrentUpdate {
+				// the row is locked and read again for the audit log, another update may have happened in between
+				lockedVersion := 4
+				if test.concurrentUpdate {
+					lockedVersion = 5
+				}
+				mock.ExpectBegin()
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organizations" WHERE id = $1 AND "organizations"."deleted_at" IS NULL ORDER BY "organizations"."id" LIMIT 1 FOR UPDATE`)).
+					WithArgs(existingID).WillReturnRows(sqlmock.NewRows(organizationColumns).
+					AddRow(existingID.String(), "CLEAR", existingCreationDate, 10000, true, lockedVersion))
+				if test.concurrentUpdate {
+					mock.ExpectRollback()
+				} else {
+					mock.ExpectExec(regexp.QuoteMeta(`UPDATE "organizations" SET "name"=$1,"creation_date"=$2,"employee_count"=$3,"is_public"=$4,"version"=$5 WHERE (id = $6 AND version = $7) AND "organizations"."deleted_at" IS NULL`)).
+						WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 20, sqlmock.AnyArg(), 5, existingID, 4).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					expectAuditInsert(mock, models.UpdateAuditAction, 1)
+					mock.ExpectCommit()
+				}
+			}
+
+			if test.method == http.MethodPut {
+				controller.UpdateOrganization(w, req)
+			} else {
+				controller.PatchOrganization(w, req)
+			}
+			res := w.Result()
+			assert.Equal(t, test.expectedResponseCode, res.StatusCode)
+			assert.NoError(t, mock.ExpectationsWereMet())
+
+			if test.expectedResponseCode == http.StatusOK {
+				var respObj models.Organization
+				err := json.NewDecoder(res.Body).Decode(&respObj)
+				assert.NoError(t, err)
+				assert.Equal(t, test.expectedOrganization, respObj)
+				assert.Equal(t, `"5"`, res.Header.Get("ETag"))
+			} else if test.expectedResponseCode == http.StatusPreconditionFailed {
+				var respObj ProblemResponse
+				err := json.NewDecoder(res.Body).Decode(&respObj)
+				assert.NoError(t, err)
+				assert.Equal(t, problems.CodePreconditionFailed, respObj.Code)
+			} else {
+				var respObj ProblemResponse
+				err := json.NewDecoder(res.Body).Decode(&respObj)
+				assert.NoError(t, err)
+				assert.True(t, strings.Contains(respObj.Detail, "invalid request body"))
+			}
+		})
+	}
+}
+
+func TestDeleteOrganization(t *testing.T) {
+	var tests = []struct {
+		orgID                string
+		ifMatch              string
+		orgExists            bool
+		expectedResponseCode int
+	}{
+		{
+			orgID:                uuid.New().String(),
+			orgExists:            true,
+			expectedResponseCode: http.StatusNoContent,
+		},
+		{
+			// Testing deleting an organization that does not exist or was already deleted
+			orgID:                uuid.New().String(),
+			expectedResponseCode: http.StatusNotFound,
+		},
+		{
+			orgID:                "not-a-uuid",
+			expectedResponseCode: http.StatusBadRequest,
+		},
+		{
+			// Testing a conditional delete only deletes the matching version
+			orgID:                uuid.New().String(),
+			ifMatch:              `"2"`,
+			orgExists:            true,
+			expectedResponseCode: http.StatusNoContent,
+		},
+		{
+			// Testing a conditional delete of a modified organization, should not delete it
+			orgID:                uuid.New().String(),
+			ifMatch:              `"1"`,
+			expectedResponseCode: http.StatusPreconditionFailed,
+		},
+	}
+
+	controller, mock := newTestController(t)
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/organizations/"+test.orgID, nil)
+			req = mux.SetURLVars(req, map[string]string{"id": test.orgID})
+			req.Header.Set("If-Match", test.ifMatch)
+			w := httptest.NewRecorder()
+
+			if test.ifMatch != "" {
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organizations" WHERE id = $1 AND "organizations"."deleted_at" IS NULL`)).
+					WithArgs(test.orgID).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "version"}).AddRow(test.orgID, "CLEAR", 2))
+			}
+			if test.expectedResponseCode == http.StatusNoContent || test.expectedResponseCode == http.StatusNotFound {
+				rows := sqlmock.NewRows([]string{"id", "name", "version"})
+				if test.orgExists {
+					rows.AddRow(test.orgID, "CLEAR", 2)
+				}
+				mock.ExpectBegin()
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organizations" WHERE id = $1 AND "organizations"."deleted_at" IS NULL ORDER BY "organizations"."id" LIMIT 1 FOR UPDATE`)).
+					WithArgs(test.orgID).WillReturnRows(rows)
+			}
+			if test.orgExists {
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE "organizations" SET "deleted_at"=$1,"version"=$2 WHERE id = $3 AND "organizations"."deleted_at" IS NULL`)).
+					WithArgs(sqlmock.AnyArg(), 3, test.orgID).WillReturnResult(sqlmock.NewResult(0, 1))
+				expectAuditInsert(mock, models.DeleteAuditAction, 1)
+				mock.ExpectCommit()
+			} else if test.expectedResponseCode == http.StatusNotFound {
+				mock.ExpectRollback()
+			}
+
+			controller.DeleteOrganization(w, req)
+			res := w.Result()
+			assert.Equal(t, test.expectedResponseCode, res.StatusCode)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRestoreOrganization(t *testing.T) {
+	var tests = []struct {
+		orgID                string
+		deletedAt            interface{}
+		orgExists            bool
+		expectedResponseCode int
+	}{
+		{
+			orgID:                uuid.New().String(),
+			deletedAt:            time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC),
+			orgExists:            true,
+			expectedResponseCode: http.StatusOK,
+		},
+		{
+			// Testing restoring an organization that was never deleted
+			orgID:                uuid.New().String(),
+			deletedAt:            nil,
+			orgExists:            true,
+			expectedResponseCode: http.StatusConflict,
+		},
+		{
+			orgID:                uuid.New().String(),
+			orgExists:            false,
+			expectedResponseCode: http.StatusNotFound,
+		},
+	}
+
+	controller, mock := newTestController(t)
+
+	var organizationColumns = []string{"id", "name", "creation_date", "employee_count", "is_public", "deleted_at"}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/organizations/"+test.orgID+"/restore", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": test.orgID})
+			w := httptest.NewRecorder()
+
+			rows := sqlmock.NewRows(organizationColumns)
+			if test.orgExists {
+				rows.AddRow(test.orgID, "CLEAR", time.Date(2002, 9, 22, 0, 0, 0, 0, time.UTC), 10000, true,
+					test.deletedAt)
+			}
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organizations" WHERE id = $1 ORDER BY "organizations"."id" LIMIT 1 FOR UPDATE`)).
+				WithArgs(test.orgID).WillReturnRows(rows)
+			if test.expectedResponseCode == http.StatusOK {
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE "organizations" SET "deleted_at"=$1,"version"=$2 WHERE id = $3`)).
+					WithArgs(nil, 1, test.orgID).WillReturnResult(sqlmock.NewResult(0, 1))
+				expectAuditInsert(mock, models.RestoreAuditAction, 1)
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			controller.RestoreOrganization(w, req)
+			res := w.Result()
+			assert.Equal(t, test.expectedResponseCode, res.StatusCode)
+			assert.NoError(t, mock.ExpectationsWereMet())
+
+			if test.expectedResponseCode == http.StatusOK {
+				var respObj models.Organization
+				err := json.NewDecoder(res.Body).Decode(&respObj)
+				assert.NoError(t, err)
+				assert.False(t, respObj.DeletedAt.Valid)
+			}
+		})
+	}
+}
+
+func TestGetOrganizationHistory(t *testing.T) {
+	var tests = []struct {
+		orgID                string
+		queryParams          string
+		totalCount           int
+		expectedQueryLimit   string
+		expectedResponseCode int
+	}{
+		{
+			orgID:                uuid.New().String(),
+			totalCount:           2,
+			expectedQueryLimit:   "LIMIT 20",
+			expectedResponseCode: http.StatusOK,
+		},
+		{
+			orgID:                uuid.New().String(),
+			queryParams:          "?page=2&page_size=1",
+			totalCount:           2,
+			expectedQueryLimit:   "LIMIT 1 OFFSET 1",
+			expectedResponseCode: http.StatusOK,
+		},
+		{
+			// Testing the history of an organization that never existed
+			orgID:                uuid.New().String(),
+			totalCount:           0,
+			expectedQueryLimit:   "LIMIT 20",
+			expectedResponseCode: http.StatusNotFound,
+		},
+		{
+			orgID:                "not-a-uuid",
+			expectedResponseCode: http.StatusBadRequest,
+		},
+	}
+
+	controller, mock := newTestController(t)
+
+	var auditColumns = []string{"id", "organization_id", "action", "actor", "claimed_actor", "request_id", "before", "after",
+		"changed_at"}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/organizations/"+test.orgID+"/history"+test.queryParams, nil)
+			req = mux.SetURLVars(req, map[string]string{"id": test.orgID})
+			w := httptest.NewRecorder()
+
+			if test.expectedResponseCode != http.StatusBadRequest {
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "organization_audit" WHERE organization_id = $1`)).
+					WithArgs(test.orgID).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(test.totalCount))
+				rows := sqlmock.NewRows(auditColumns)
+				if test.totalCount > 0 {
+					rows.AddRow(2, test.orgID, models.UpdateAuditAction, models.AdminActor, "jane", "request-1",
+						[]byte(`{"employee_count": 10}`), []byte(`{"employee_count": 20}`), time.Now().UTC())
+				}
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organization_audit" WHERE organization_id = $1 ORDER BY id DESC ` +
+					test.expectedQueryLimit)).WithArgs(test.orgID).WillReturnRows(rows)
+			}
+
+			controller.GetOrganizationHistory(w, req)
+			res := w.Result()
+			assert.Equal(t, test.expectedResponseCode, res.StatusCode)
+			assert.NoError(t, mock.ExpectationsWereMet())
+
+			if test.expectedResponseCode == http.StatusOK {
+				var respObj services.OrganizationHistoryResponse
+				err := json.NewDecoder(res.Body).Decode(&respObj)
+				assert.NoError(t, err)
+				assert.Equal(t, test.totalCount, respObj.TotalCount)
+				if assert.Len(t, respObj.Entries, 1) {
+					assert.Equal(t, models.UpdateAuditAction, respObj.Entries[0].Action)
+					assert.Equal(t, models.AdminActor, respObj.Entries[0].Actor)
+					assert.Equal(t, "jane", respObj.Entries[0].ClaimedActor)
+					assert.JSONEq(t, `{"employee_count": 20}`, string(respObj.Entries[0].After))
+				}
+			}
+		})
+	}
+}
+
+func TestGetDuplicateOrganizations(t *testing.T) {
+	orgID := uuid.New()
+	duplicateID := uuid.New()
+	var testCases = []struct {
+		orgID                string
+		queryParams          string
+		expectedResponseCode int
+	}{
+		{orgID: orgID.String(), queryParams: "threshold=0.5&limit=3", expectedResponseCode: http.StatusOK},
+		{orgID: orgID.String(), queryParams: "threshold=2", expectedResponseCode: http.StatusBadRequest},
+		{orgID: "invalid", expectedResponseCode: http.StatusBadRequest},
+	}
+
+	controller, mock := newTestController(t)
+
+	for i, test := range testCases {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/organizations/"+test.orgID+"/duplicates?"+test.queryParams, nil)
+			req = mux.SetURLVars(req, map[string]string{"id": test.orgID})
+			w := httptest.NewRecorder()
+
+			if test.expectedResponseCode == http.StatusOK {
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organizations" WHERE id = $1`)).
+					WithArgs(orgID).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(orgID, "Acme Inc"))
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta(`SELECT set_config('pg_trgm.similarity_threshold', $1, true)`)).
+					WithArgs("0.5").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT *, similarity(name, $1) AS similarity FROM "organizations" WHERE name % $2 AND id <> $3 AND "organizations"."deleted_at" IS NULL ORDER BY similarity DESC,id LIMIT 3`)).
+					WithArgs("Acme Inc", "Acme Inc", orgID).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name", "similarity"}).AddRow(duplicateID, "ACME, Inc.", 1))
+				mock.ExpectCommit()
+			}
+
+			controller.GetDuplicateOrganizations(w, req)
+			res := w.Result()
+			assert.Equal(t, test.expectedResponseCode, res.StatusCode)
+			assert.NoError(t, mock.ExpectationsWereMet())
+
+			if test.expectedResponseCode == http.StatusOK {
+				var respObj services.DuplicateOrganizationsResponse
+				err := json.NewDecoder(res.Body).Decode(&respObj)
+				assert.NoError(t, err)
+				assert.Equal(t, 0.5, respObj.Threshold)
+				assert.Equal(t, 1, len(respObj.Duplicates))
+				assert.Equal(t, duplicateID, respObj.Duplicates[0].Organization.ID)
+				assert.Equal(t, 1.0, respObj.Duplicates[0].Similarity)
+			}
+		})
+	}
+}
+
+func TestGetOrganizationAggregates(t *testing.T) {
+	var testCases = []struct {
+		queryParams          string
+		expectedResponseCode int
+	}{
+		{
+			queryParams:          "filter=is_public:true&facets=name&facet_limit=5&stats=employee_count&histogram=creation_date:year",
+			expectedResponseCode: http.StatusOK,
+		},
+		{queryParams: "facets=employee_count", expectedResponseCode: http.StatusBadRequest},
+		{queryParams: "histogram=employee_count:-1", expectedResponseCode: http.StatusBadRequest},
+	}
+
+	controller, mock := newTestController(t)
+
+	for i, test := range testCases {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/organizations/aggregate?"+test.queryParams, nil)
+			w := httptest.NewRecorder()
+
+			if test.expectedResponseCode == http.StatusOK {
+				mock.ExpectBegin()
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "organizations" WHERE is_public = $1 AND "organizations"."deleted_at" IS NULL`)).
+					WithArgs("true").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT name AS value, count(*) AS count FROM "organizations" WHERE is_public = $1 AND "organizations"."deleted_at" IS NULL GROUP BY "name" ORDER BY count DESC,lower(name) COLLATE "C" LIMIT 5`)).
+					WithArgs("true").WillReturnRows(sqlmock.NewRows([]string{"value", "count"}).AddRow("Acme", 1).AddRow("Globex", 1))
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT min(employee_count), max(employee_count), avg(employee_count)::float8, sum(employee_count) FROM "organizations" WHERE is_public = $1 AND "organizations"."deleted_at" IS NULL`)).
+					WithArgs("true").WillReturnRows(sqlmock.NewRows([]string{"min", "max", "avg", "sum"}).AddRow(10, 30, 20.0, 40))
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT date_trunc($1, creation_date) AS bucket, count(*) AS count FROM "organizations" WHERE is_public = $2 AND "organizations"."deleted_at" IS NULL GROUP BY "bucket" ORDER BY bucket`)).
+					WithArgs("year", "true").
+					WillReturnRows(sqlmock.NewRows([]string{"bucket", "count"}).AddRow(time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC), 2))
+				mock.ExpectCommit()
+			}
+
+			controller.GetOrganizationAggregates(w, req)
+			res := w.Result()
+			assert.Equal(t, test.expectedResponseCode, res.StatusCode)
+			assert.NoError(t, mock.ExpectationsWereMet())
+
+			if test.expectedResponseCode == http.StatusOK {
+				var respObj map[string]interface{}
+				err := json.NewDecoder(res.Body).Decode(&respObj)
+				assert.NoError(t, err)
+				assert.Equal(t, map[string]interface{}{
+					"count": 2.0,
+					"facets": map[string]interface{}{"name": []interface{}{
+						map[string]interface{}{"value": "Acme", "count": 1.0},
+						map[string]interface{}{"value": "Globex", "count": 1.0},
+					}},
+					"stats": map[string]interface{}{
+						"employee_count": map[string]interface{}{"min": 10.0, "max": 30.0, "avg": 20.0, "sum": 40.0},
+					},
+					"histograms": map[string]interface{}{"creation_date": []interface{}{
+						map[string]interface{}{"start": "2010-01-01T00:00:00Z", "end": "2011-01-01T00:00:00Z", "count": 2.0},
+					}},
+				}, respObj)
+			}
+		})
+	}
+}
+
+func TestMergeOrganization(t *testing.T) {
+	targetID := uuid.New()
+	sourceID := uuid.New()
+	var tests = []struct {
+		orgID                string
+		requestBody          string
+		expectedResponseCode int
+	}{
+		{
+			orgID:                targetID.String(),
+			requestBody:          fmt.Sprintf(`{"source_id": "%s", "rules": {"employee_count": "max"}}`, sourceID),
+			expectedResponseCode: http.StatusOK,
+		},
+		{
+			// Testing max cannot be used for categorical fields, should not reach the database
+			orgID:                targetID.String(),
+			requestBody:          fmt.Sprintf(`{"source_id": "%s", "rules": {"name": "max"}}`, sourceID),
+			expectedResponseCode: http.StatusBadRequest,
+		},
+		{
+			orgID:                "not-a-uuid",
+			requestBody:          fmt.Sprintf(`{"source_id": "%s"}`, sourceID),
+			expectedResponseCode: http.StatusBadRequest,
+		},
+	}
+
+	controller, mock := newTestController(t)
+
+	var organizationColumns = []string{"id", "name", "creation_date", "employee_count", "is_public", "version"}
+	creationDate := time.Date(2002, 9, 22, 0, 0, 0, 0, time.UTC)
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/organizations/"+test.orgID+"/merge",
+				strings.NewReader(test.requestBody))
+			req = mux.SetURLVars(req, map[string]string{"id": test.orgID})
+			w := httptest.NewRecorder()
+
+			if test.expectedResponseCode == http.StatusOK {
+				targetRow := []driver.Value{targetID, "Acme Inc", creationDate, 10, true, 2}
+				sourceRow := []driver.Value{sourceID, "ACME, Inc.", creationDate, 50, false, 1}
+				for _, row := range [][]driver.Value{targetRow, sourceRow} {
+					mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organizations" WHERE id = $1`)).
+						WithArgs(row[0]).WillReturnRows(sqlmock.NewRows(organizationColumns).AddRow(row...))
+				}
+				mock.ExpectBegin()
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organizations" WHERE id IN ($1,$2) AND "organizations"."deleted_at" IS NULL FOR UPDATE`)).
+					WithArgs(targetID, sourceID).
+					WillReturnRows(sqlmock.NewRows(organizationColumns).AddRow(sourceRow...).AddRow(targetRow...))
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE "organizations" SET "name"=$1,"creation_date"=$2,"employee_count"=$3,"is_public"=$4,"version"=$5 WHERE id = $6`)).
+					WithArgs("Acme Inc", creationDate, 50, true, 3, targetID).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "organizations" WHERE id = $1`)).
+					WithArgs(sourceID).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "organization_merges" ("source_id","target_id","rules","source","target","merged_at") VALUES ($1,$2,$3,$4,$5,$6)`)).
+					WithArgs(sourceID, targetID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				// the surviving and the merged away organizations are both audited
+				expectAuditInsert(mock, models.MergeAuditAction, 1)
+				expectAuditInsert(mock, models.MergeAuditAction, 1)
+				mock.ExpectCommit()
+			}
+
+			controller.MergeOrganization(w, req)
+			res := w.Result()
+			assert.Equal(t, test.expectedResponseCode, res.StatusCode)
+			assert.NoError(t, mock.ExpectationsWereMet())
+
+			if test.expectedResponseCode == http.StatusOK {
+				var respObj models.Organization
+				err := json.NewDecoder(res.Body).Decode(&respObj)
+				assert.NoError(t, err)
+				assert.Equal(t, targetID, respObj.ID)
+				assert.Equal(t, "Acme Inc", respObj.Name)
+				assert.Equal(t, intPointer(50), respObj.EmployeeCount)
+			}
+		})
+	}
+}
+
+// newTestController creates an OrganizationController backed by a Postgres repository using a mocked database
+func newTestController(t *testing.T) (*OrganizationController, sqlmock.Sqlmock) {
+	db, mock, err := database.InitializeTest()
+	assert.NoError(t, err)
+	service := services.NewOrganizationService(repository.NewPostgresOrganizationRepository(db))
+	return NewOrganizationController(service), mock
+}
+
+func mockSearchQueries(expectedResponseCode int, mock sqlmock.Sqlmock, expectedArgs []driver.Value, expectedQueryLimit,
+	expectedQueryConditional, expectedQueryOrder string) {
+
+	var organizationColumns = []string{"id", "name", "created_date", "employee_count", "is_public"}
+	var countCol = []string{"count"}
+
+	var returnedCount = 1
+	if expectedResponseCode == http.StatusNotFound {
+		returnedCount = 0
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "organizations" ` + expectedQueryConditional)).
+		WithArgs(expectedArgs...).WillReturnRows(sqlmock.NewRows(countCol).AddRow(returnedCount))
+
+	searchQuery := mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT * FROM "organizations" ` + expectedQueryConditional + ` ` + expectedQueryOrder + ` ` + expectedQueryLimit)).
+		WithArgs(expectedArgs...)
+	if expectedResponseCode == http.StatusNotFound {
+		searchQuery.WillReturnRows(sqlmock.NewRows(organizationColumns))
+	} else {
+		searchQuery.WillReturnRows(sqlmock.NewRows(organizationColumns).
+			AddRow(uuid.New(), "CLEAR", "2002-09-22T00:00:00Z", 10000, true))
+	}
+}
+
+// expectAuditInsert expects the audit log entries of a change to be inserted in a single statement, one for each
+// changed organization
+func expectAuditInsert(mock sqlmock.Sqlmock, action string, entries int) {
+	values := make([]string, entries)
+	var args []driver.Value
+	for i := range values {
+		n := i * 8
+		values[i] = fmt.Sprintf("($%d,$%d,$%d,$%d,$%d,$%d,$%d,$%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8)
+		args = append(args, sqlmock.AnyArg(), action, models.SystemActor, "", "", sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sqlmock.AnyArg())
+	}
+	idRows := sqlmock.NewRows([]string{"id"})
+	for i := range values {
+		idRows.AddRow(i + 1)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "organization_audit" ("organization_id","action","actor","claimed_actor","request_id","before","after","changed_at") VALUES ` +
+		strings.Join(values, ",") + ` RETURNING "id"`)).WithArgs(args...).WillReturnRows(idRows)
+}
+
+func intPointer(value int) *int {
+	return &value
+}
+
+func boolPointer(value bool) *bool {
+	return &value
+}
+
+func timePointer(value time.Time) *time.Time {
+	return &value
+}
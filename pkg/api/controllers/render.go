@@ -0,0 +1,330 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/http"
+	"organization_manager/pkg/api/services"
+	"strconv"
+	"strings"
+)
+
+// ResponseFormats are the formats responses can be rendered as, JSON is preferred
+var ResponseFormats = []string{services.FormatJSON, services.FormatCSV, services.FormatYAML, services.FormatMessagePack}
+
+// ResponseFormat negotiates the format of the response to a request from its Accept header
+func ResponseFormat(r *http.Request) (string, error) {
+	return services.NegotiateFormat(r.Header.Get("Accept"), ResponseFormats...)
+}
+
+// Render writes data in the format negotiated from the Accept header of the request. Requests accepting none of the
+// ResponseFormats are rejected before reaching the controllers, so they are answered with JSON here.
+// CSV and YAML are rendered from the JSON serialization of data and MessagePack is encoded with the JSON names of its
+// fields, so the fields are named alike in every format
+func Render(w http.ResponseWriter, r *http.Request, statusCode int, data interface{}) {
+	render(w, r, statusCode, data, "application/json")
+}
+
+// RenderError writes the problem err is or wraps in the format negotiated from the Accept header of the request,
+// JSON problems are served as application/problem+json
+func RenderError(w http.ResponseWriter, r *http.Request, err error) {
+	resp := newProblemResponse(err)
+	render(w, r, resp.Status, resp, problemMediaType)
+}
+
+func render(w http.ResponseWriter, r *http.Request, statusCode int, data interface{}, jsonMediaType string) {
+	format, err := ResponseFormat(r)
+	if err != nil || format == services.FormatJSON {
+		writeJSON(w, statusCode, data, jsonMediaType)
+		return
+	}
+
+	var body []byte
+	if format == services.FormatMessagePack {
+		body, err = encodeMessagePack(data)
+	} else {
+		body, err = json.Marshal(data)
+		if err == nil {
+			body, err = convertJSON(body, format)
+		}
+	}
+	if err != nil {
+		log.Errorf("could not render %s: %v: %v", format, err, data)
+		JsonProblem(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", services.FormatMediaType(format))
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
+
+// convertJSON converts serialized JSON to CSV or YAML
+func convertJSON(body []byte, format string) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	document, err := decodeDocument(decoder)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case services.FormatCSV:
+		return renderCSV(document)
+	case services.FormatYAML:
+		var buffer bytes.Buffer
+		encoder := yaml.NewEncoder(&buffer)
+		encoder.SetIndent(2)
+		err = encoder.Encode(yamlNode(document))
+		if err != nil {
+			return nil, err
+		}
+		err = encoder.Close()
+		return buffer.Bytes(), err
+	}
+	return nil, errors.Errorf("unsupported response format '%s'", format)
+}
+
+// documentObject is a decoded JSON object, its members keep the order they were serialized in
+type documentObject struct {
+	keys   []string
+	values []interface{}
+}
+
+// decodeDocument decodes the next JSON value of a decoder using numbers as nil, a bool, a json.Number, a string, a
+// []interface{} or a *documentObject
+func decodeDocument(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('['):
+		array := []interface{}{}
+		for decoder.More() {
+			element, err := decodeDocument(decoder)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, element)
+		}
+		_, err = decoder.Token()
+		return array, err
+	case json.Delim('{'):
+		object := &documentObject{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeDocument(decoder)
+			if err != nil {
+				return nil, err
+			}
+			object.keys = append(object.keys, key.(string))
+			object.values = append(object.values, value)
+		}
+		_, err = decoder.Token()
+		return object, err
+	}
+	return token, nil
+}
+
+// yamlNode converts a decoded JSON document to a YAML node, keeping the order of object members
+func yamlNode(document interface{}) *yaml.Node {
+	switch value := document.(type) {
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(value)}
+	case json.Number:
+		if _, err := value.Int64(); err == nil {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: value.String()}
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!float", Value: value.String()}
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	case []interface{}:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, element := range value {
+			node.Content = append(node.Content, yamlNode(element))
+		}
+		return node
+	}
+	object := document.(*documentObject)
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for i, key := range object.keys {
+		node.Content = append(node.Content, yamlNode(key), yamlNode(object.values[i]))
+	}
+	return node
+}
+
+// renderCSV renders a decoded JSON document as a CSV header followed by rows. An array is rendered as a row for each
+// element. An object containing an array of objects, such as a page of organizations, is rendered as a row for each
+// object in the array, with the other members of the object repeated on every row. Other objects are rendered as a
+// single row, so problem responses have a detail column. Nested objects are flattened to columns named by their path,
+// such as organization.name, and nested arrays are rendered as JSON
+func renderCSV(document interface{}) ([]byte, error) {
+	var rows []*csvRow
+	switch value := document.(type) {
+	case []interface{}:
+		for _, element := range value {
+			row := newCSVRow()
+			row.add("", element)
+			rows = append(rows, row)
+		}
+	case *documentObject:
+		rows = objectCSVRows(value)
+	default:
+		row := newCSVRow()
+		row.add("", value)
+		rows = append(rows, row)
+	}
+
+	var columns []string
+	seenColumns := map[string]bool{}
+	for _, row := range rows {
+		for _, column := range row.columns {
+			if !seenColumns[column] {
+				seenColumns[column] = true
+				columns = append(columns, column)
+			}
+		}
+	}
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	err := writer.Write(columns)
+	if err != nil {
+		return nil, err
+	}
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		for i, column := range columns {
+			record[i] = row.cells[column]
+		}
+		err = writer.Write(record)
+		if err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	return buffer.Bytes(), writer.Error()
+}
+
+// objectCSVRows expands the first member of an object holding an array of objects to a row for each of its objects
+func objectCSVRows(object *documentObject) []*csvRow {
+	listIndex := -1
+	for i, value := range object.values {
+		if array, isArray := value.([]interface{}); isArray && len(array) > 0 && isObjectArray(array) {
+			listIndex = i
+			break
+		}
+	}
+	if listIndex == -1 {
+		row := newCSVRow()
+		row.add("", object)
+		return []*csvRow{row}
+	}
+
+	var rows []*csvRow
+	for _, element := range object.values[listIndex].([]interface{}) {
+		row := newCSVRow()
+		for i, key := range object.keys {
+			if i == listIndex {
+				row.add("", element)
+			} else {
+				row.add(key, object.values[i])
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func isObjectArray(array []interface{}) bool {
+	for _, element := range array {
+		if _, isObject := element.(*documentObject); !isObject {
+			return false
+		}
+	}
+	return true
+}
+
+// csvRow holds the cells of a CSV row by column, along with the columns in the order they were added
+type csvRow struct {
+	columns []string
+	cells   map[string]string
+}
+
+func newCSVRow() *csvRow {
+	return &csvRow{cells: map[string]string{}}
+}
+
+// add adds the cells of a value to the row, flattening objects to a column for each of their members prefixed by
+// the column of the object. Values without a column are added to the value column
+func (row *csvRow) add(column string, value interface{}) {
+	if object, isObject := value.(*documentObject); isObject {
+		for i, key := range object.keys {
+			if column != "" {
+				key = column + "." + key
+			}
+			row.add(key, object.values[i])
+		}
+		return
+	}
+	if column == "" {
+		column = "value"
+	}
+	if _, isSet := row.cells[column]; !isSet {
+		row.columns = append(row.columns, column)
+	}
+	row.cells[column] = csvCell(value)
+}
+
+func csvCell(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case bool:
+		return strconv.FormatBool(value)
+	case json.Number:
+		return value.String()
+	case string:
+		return value
+	}
+	var builder strings.Builder
+	writeJSONDocument(&builder, value)
+	return builder.String()
+}
+
+// writeJSONDocument serializes a decoded JSON document back to compact JSON
+func writeJSONDocument(w io.Writer, document interface{}) {
+	switch value := document.(type) {
+	case []interface{}:
+		io.WriteString(w, "[")
+		for i, element := range value {
+			if i > 0 {
+				io.WriteString(w, ",")
+			}
+			writeJSONDocument(w, element)
+		}
+		io.WriteString(w, "]")
+	case *documentObject:
+		io.WriteString(w, "{")
+		for i, key := range value.keys {
+			if i > 0 {
+				io.WriteString(w, ",")
+			}
+			writeJSONDocument(w, key)
+			io.WriteString(w, ":")
+			writeJSONDocument(w, value.values[i])
+		}
+		io.WriteString(w, "}")
+	default:
+		serialized, _ := json.Marshal(value)
+		w.Write(serialized)
+	}
+}
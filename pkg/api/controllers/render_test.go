@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v5"
+	"net/http"
+	"net/http/httptest"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/api/services"
+	"organization_manager/pkg/database/models"
+	"testing"
+	"time"
+)
+
+func TestConvertJSON(t *testing.T) {
+	page := `{"organizations":[{"id":"a1","name":"Acme, Inc","tags":["x"]},{"id":"b2","name":"Globex","deleted_at":null}],` +
+		`"page":1,"page_size":2}`
+	var testCases = []struct {
+		body         string
+		format       string
+		expectedBody string
+	}{
+		{
+			body:   page,
+			format: services.FormatCSV,
+			expectedBody: "id,name,tags,page,page_size,deleted_at\n" +
+				"a1,\"Acme, Inc\",\"[\"\"x\"\"]\",1,2,\n" +
+				"b2,Globex,,1,2,\n",
+		},
+		{
+			body:         `{"detail":"not found","duplicates":[{"organization":{"name":"Acme"},"similarity":0.9}]}`,
+			format:       services.FormatCSV,
+			expectedBody: "detail,organization.name,similarity\nnot found,Acme,0.9\n",
+		},
+		{body: `{"detail":"not found","duplicates":[]}`, format: services.FormatCSV, expectedBody: "detail,duplicates\nnot found,[]\n"},
+		{body: `["a","b"]`, format: services.FormatCSV, expectedBody: "value\na\nb\n"},
+		{
+			body:   page,
+			format: services.FormatYAML,
+			expectedBody: "organizations:\n" +
+				"- id: a1\n  name: Acme, Inc\n  tags:\n  - x\n" +
+				"- id: b2\n  name: Globex\n  deleted_at: null\n" +
+				"page: 1\npage_size: 2\n",
+		},
+		{
+			body:         `{"name":"true","creation_date":"2002-09-22T00:00:00Z","employee_count":1.5}`,
+			format:       services.FormatYAML,
+			expectedBody: "name: \"true\"\ncreation_date: \"2002-09-22T00:00:00Z\"\nemployee_count: 1.5\n",
+		},
+	}
+	for _, test := range testCases {
+		body, err := convertJSON([]byte(test.body), test.format)
+		assert.NoError(t, err, test.body)
+		assert.Equal(t, test.expectedBody, string(body), test.body)
+	}
+}
+
+func TestEncodeMessagePack(t *testing.T) {
+	body, err := encodeMessagePack(map[string]interface{}{"a": []interface{}{1, -1, 200, -200, 70000, nil, true, false}})
+	assert.NoError(t, err)
+	assert.Equal(t, "\x81\xa1a\x98\x01\xff\xcc\xc8\xd1\xff\x38\xce\x00\x01\x11\x70\xc0\xc3\xc2", string(body))
+	body, err = encodeMessagePack([]float64{1.5, 2})
+	assert.NoError(t, err)
+	assert.Equal(t, "\x92\xcb\x3f\xf8\x00\x00\x00\x00\x00\x00\x02", string(body))
+
+	// values are encoded with their types and named like in JSON
+	creationDate := time.Date(2002, 9, 22, 0, 0, 0, 0, time.UTC)
+	org := models.Organization{ID: uuid.New(), Name: "Acme", CreationDate: &creationDate, EmployeeCount: intPointer(10),
+		Version: 2}
+	var testCases = []struct {
+		data     interface{}
+		expected interface{}
+	}{
+		{
+			data: org,
+			expected: map[string]interface{}{"ID": org.ID.String(), "name": "Acme", "creation_date": creationDate,
+				"employee_count": int8(10), "is_public": nil, "deleted_at": nil, "version": int8(2)},
+		},
+		{
+			data:     services.ProjectedOrganization{Organization: org, Fields: []string{"employee_count"}},
+			expected: map[string]interface{}{"ID": org.ID.String(), "employee_count": int8(10)},
+		},
+		{
+			data: models.OrganizationAuditEntry{ID: 1, OrganizationID: org.ID, Action: models.UpdateAuditAction,
+				Before: models.JSONDocument(`{"employee_count": 10}`), ChangedAt: creationDate},
+			expected: map[string]interface{}{"id": int8(1), "organization_id": org.ID.String(), "action": "update",
+				"actor": "", "claimed_actor": "", "request_id": "", "before": map[string]interface{}{"employee_count": int8(10)},
+				"after": nil, "changed_at": creationDate},
+		},
+	}
+	for _, test := range testCases {
+		body, err := encodeMessagePack(test.data)
+		assert.NoError(t, err)
+		var decoded interface{}
+		assert.NoError(t, msgpack.Unmarshal(body, &decoded))
+		if decodedMap, isMap := decoded.(map[string]interface{}); isMap {
+			// timestamps are decoded in the local time zone
+			for key, value := range decodedMap {
+				if timestamp, isTime := value.(time.Time); isTime {
+					decodedMap[key] = timestamp.UTC()
+				}
+			}
+		}
+		assert.Equal(t, test.expected, decoded)
+	}
+
+	// the organizations of pages are encoded once, in place of the organizations of the embedded response
+	body, err = encodeMessagePack(services.PaginatedOrganizationResponse{Organizations: []models.Organization{org},
+		PageSize: 20})
+	assert.NoError(t, err)
+	var page struct {
+		Organizations []map[string]interface{} `msgpack:"organizations"`
+		PageSize      int                      `msgpack:"page_size"`
+	}
+	assert.NoError(t, msgpack.Unmarshal(body, &page))
+	assert.Equal(t, byte(0x82), body[0])
+	assert.Equal(t, 20, page.PageSize)
+	assert.Len(t, page.Organizations, 1)
+	assert.Equal(t, "Acme", page.Organizations[0]["name"])
+}
+
+func TestRender(t *testing.T) {
+	data := struct {
+		Name string `json:"name"`
+	}{"Acme"}
+	var testCases = []struct {
+		accept              string
+		expectedContentType string
+		expectedBody        string
+	}{
+		{accept: "", expectedContentType: "application/json", expectedBody: `{"name":"Acme"}`},
+		{accept: "text/*", expectedContentType: "text/csv", expectedBody: "name\nAcme\n"},
+		{accept: "application/x-yaml", expectedContentType: "application/yaml", expectedBody: "name: Acme\n"},
+		{accept: "application/msgpack", expectedContentType: "application/msgpack", expectedBody: "\x81\xa4name\xa4Acme"},
+	}
+	for _, test := range testCases {
+		req := httptest.NewRequest(http.MethodGet, "/organizations", nil)
+		req.Header.Set("Accept", test.accept)
+		w := httptest.NewRecorder()
+
+		Render(w, req, http.StatusOK, data)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, test.expectedContentType, w.Header().Get("Content-Type"))
+		assert.Equal(t, test.expectedBody, w.Body.String())
+	}
+}
+
+func TestRenderError(t *testing.T) {
+	var testCases = []struct {
+		accept              string
+		err                 error
+		expectedStatus      int
+		expectedContentType string
+		expectedCode        string
+	}{
+		{
+			err:                 problems.InvalidParameter("page", errors.New("invalid page query parameter")),
+			expectedStatus:      http.StatusBadRequest,
+			expectedContentType: "application/problem+json",
+			expectedCode:        problems.CodeInvalidParameter,
+		},
+		{
+			accept:              "application/json",
+			err:                 errors.New(`pq: relation "organizations" does not exist`),
+			expectedStatus:      http.StatusInternalServerError,
+			expectedContentType: "application/problem+json",
+			expectedCode:        problems.CodeInternal,
+		},
+		{
+			accept:              "application/yaml",
+			err:                 problems.Errorf(http.StatusNotFound, problems.CodeNotFound, "not found"),
+			expectedStatus:      http.StatusNotFound,
+			expectedContentType: "application/yaml",
+			expectedCode:        problems.CodeNotFound,
+		},
+	}
+	for _, test := range testCases {
+		req := httptest.NewRequest(http.MethodGet, "/organizations", nil)
+		req.Header.Set("Accept", test.accept)
+		w := httptest.NewRecorder()
+
+		RenderError(w, req, test.err)
+		assert.Equal(t, test.expectedStatus, w.Code)
+		assert.Equal(t, test.expectedContentType, w.Header().Get("Content-Type"))
+		if test.expectedContentType != "application/problem+json" {
+			continue
+		}
+		var respObj ProblemResponse
+		err := json.NewDecoder(w.Body).Decode(&respObj)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expectedStatus, respObj.Status)
+		assert.Equal(t, test.expectedCode, respObj.Code)
+		assert.Equal(t, problems.TypePrefix+test.expectedCode, respObj.Type)
+		if test.expectedStatus == http.StatusInternalServerError {
+			// internal errors are not exposed, they can be found from their correlation ID
+			assert.NotEmpty(t, respObj.CorrelationID)
+			assert.NotContains(t, respObj.Detail, "pq")
+			assert.Contains(t, respObj.Detail, respObj.CorrelationID)
+		} else {
+			assert.Empty(t, respObj.CorrelationID)
+			assert.Equal(t, test.err.Error(), respObj.Detail)
+		}
+	}
+}
@@ -2,22 +2,150 @@ package controllers
 
 import (
 	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"io"
+	"mime"
 	"net/http"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/api/services"
+	"organization_manager/pkg/database/models"
+	"strings"
 )
 
-type ErrorResponse struct {
-	Error string
+// importFormField is the multipart form field holding the content of an import request
+const importFormField = "file"
+
+// warningTextEscaper escapes the text of a Warning header, which is a quoted string
+var warningTextEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// problemMediaType is the media type of problem details serialized as JSON
+const problemMediaType = "application/problem+json"
+
+// ProblemResponse is returned when a request fails, it describes the problem as RFC 7807 problem details. The code is
+// stable so clients can handle problems by their code, and invalid_params lists the reason each invalid parameter or
+// field was rejected. Internal errors are not described, their correlation_id can be used to find them in the logs
+type ProblemResponse struct {
+	Type          string                  `json:"type"`
+	Title         string                  `json:"title"`
+	Status        int                     `json:"status"`
+	Detail        string                  `json:"detail"`
+	Code          string                  `json:"code"`
+	InvalidParams []problems.InvalidParam `json:"invalid_params,omitempty"`
+	CorrelationID string                  `json:"correlation_id,omitempty"`
+	// Clauses lists the error for each invalid filter clause of a search request
+	Clauses []services.SearchClauseError `json:"clauses,omitempty"`
+	// Duplicates lists the existing organizations with names similar to a rejected organization
+	Duplicates []models.SimilarOrganization `json:"duplicates,omitempty"`
+	// Results lists the result of each organization in an atomic batch that was rolled back
+	Results []services.BatchItemResult `json:"results,omitempty"`
+}
+
+// newProblemResponse describes the problem err is or wraps. The detail of internal errors is replaced with a
+// correlation ID, which is logged along with the error
+func newProblemResponse(err error) ProblemResponse {
+	problem := problems.From(err)
+	var correlationID string
+	if problem.Status >= http.StatusInternalServerError {
+		correlationID = uuid.New().String()
+		log.Errorf("internal error, reference %s: %v", correlationID, err)
+		problem = problem.Redact(correlationID)
+	}
+	resp := ProblemResponse{
+		Type:          problem.Type(),
+		Title:         problem.Title(),
+		Status:        problem.Status,
+		Detail:        problem.Detail,
+		Code:          problem.Code,
+		InvalidParams: problem.InvalidParams,
+		CorrelationID: correlationID,
+	}
+
+	var validationErr *services.SearchValidationError
+	var duplicateErr *services.DuplicateOrganizationError
+	var batchErr *services.BatchCreateError
+	if errors.As(err, &validationErr) {
+		resp.Clauses = validationErr.Clauses
+	} else if errors.As(err, &duplicateErr) {
+		resp.Duplicates = duplicateErr.Duplicates
+	} else if errors.As(err, &batchErr) {
+		resp.Results = batchErr.Results
+	}
+	return resp
+}
+
+// duplicateWarning formats a possible duplicate of a created organization as a Warning header value, using the
+// miscellaneous persistent warning code 299
+func duplicateWarning(duplicate models.SimilarOrganization) string {
+	text := fmt.Sprintf("possible duplicate of organization '%s' named '%s' with similarity %.2f",
+		duplicate.Organization.ID, duplicate.Organization.Name, duplicate.Similarity)
+	return fmt.Sprintf(`299 - "%s"`, warningTextEscaper.Replace(text))
+}
+
+// setETag sets the ETag header to the entity tag of the current version of an organization in the representation
+// the request is answered with, which clients send back in If-Match headers to update it or in If-None-Match headers
+// to revalidate it. fields are the fields of a projection of the organization, or nil for every field
+func setETag(w http.ResponseWriter, r *http.Request, org models.Organization, fields []string) string {
+	// requests accepting none of the response formats are rejected before they reach the controllers
+	format, _ := ResponseFormat(r)
+	etag := services.RepresentationETag(org, services.Representation(format, fields))
+	w.Header().Set("ETag", etag)
+	return etag
+}
+
+// responseStream records whether a streamed response has started, after which its status can no longer change
+type responseStream struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (s *responseStream) Write(p []byte) (int, error) {
+	s.started = true
+	return s.ResponseWriter.Write(p)
+}
+
+// importContent returns the content of an import request along with its content type and file name. Multipart form
+// requests are streamed from their importFormField part, other requests from their body
+func importContent(r *http.Request) (io.Reader, string, string, error) {
+	contentType := r.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" {
+		return r.Body, contentType, "", nil
+	}
+
+	parts, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", "", errors.Wrap(err, "invalid multipart request body")
+	}
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			return nil, "", "", errors.Errorf("multipart request body must contain a '%s' part", importFormField)
+		} else if err != nil {
+			return nil, "", "", errors.Wrap(err, "invalid multipart request body")
+		}
+		if part.FormName() == importFormField {
+			return part, part.Header.Get("Content-Type"), part.FileName(), nil
+		}
+	}
+}
+
+// JsonProblem writes the problem err is or wraps as JSON, regardless of the formats the request accepts
+func JsonProblem(w http.ResponseWriter, err error) {
+	resp := newProblemResponse(err)
+	writeJSON(w, resp.Status, resp, problemMediaType)
 }
 
-func JsonResponse(w http.ResponseWriter, statusCode int, data interface{}) {
+func writeJSON(w http.ResponseWriter, statusCode int, data interface{}, mediaType string) {
 	body, err := json.Marshal(data)
 	if err != nil {
 		log.Errorf("could not serialize json: %v: %v", err, data)
-		statusCode = http.StatusInternalServerError
-		data = ErrorResponse{err.Error()}
+		JsonProblem(w, err)
+		return
 	}
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", mediaType)
 	w.WriteHeader(statusCode)
 	w.Write(body)
 }
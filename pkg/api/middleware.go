@@ -0,0 +1,168 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"organization_manager/pkg/api/controllers"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/api/services"
+	"organization_manager/pkg/database/models"
+	"strings"
+)
+
+const (
+	adminKeyHeader = "X-Admin-Key"
+	// requestIDHeader identifies a request in the audit log, it is generated for requests that do not supply one
+	requestIDHeader = "X-Request-ID"
+	// actorHeader names who claims to make a request. It is not authenticated, so it is recorded in the audit log as
+	// the claimed actor next to the actor authenticated by the server
+	actorHeader = "X-Actor"
+	// maxAuditHeaderLength is the maximum length of the X-Request-ID and X-Actor headers
+	maxAuditHeaderLength = 255
+)
+
+// requireAdmin rejects requests that do not supply the server's admin key in the X-Admin-Key header. Admin
+// endpoints are disabled entirely when no admin key has been configured
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.AdminKey == "" {
+			log.Warnf("rejected admin request to %s, no admin key is configured", r.URL.Path)
+			controllers.RenderError(w, r, problems.Errorf(http.StatusForbidden, problems.CodeForbidden,
+				"admin endpoints are disabled"))
+			return
+		}
+		if !s.hasAdminKey(r) {
+			controllers.RenderError(w, r, problems.Errorf(http.StatusForbidden, problems.CodeForbidden, "admin key required"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hasAdminKey tells whether a request supplies the server's admin key in the X-Admin-Key header
+func (s *Server) hasAdminKey(r *http.Request) bool {
+	return s.AdminKey != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(adminKeyHeader)), []byte(s.AdminKey)) == 1
+}
+
+// identifyRequest carries the actor and ID of each request in its context, so the changes it makes are recorded in
+// the audit log along with them. The actor is the admin for requests supplying the admin key and anonymous for the
+// others, the X-Actor header is recorded as the claimed actor. The request ID is returned in the X-Request-ID
+// response header
+func (s *Server) identifyRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := strings.TrimSpace(r.Header.Get(requestIDHeader))
+		if requestID == "" {
+			requestID = uuid.New().String()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+		claimedActor := strings.TrimSpace(r.Header.Get(actorHeader))
+		actor := models.AnonymousActor
+		if s.hasAdminKey(r) {
+			actor = models.AdminActor
+		}
+
+		for _, header := range []struct{ name, value string }{{requestIDHeader, requestID}, {actorHeader, claimedActor}} {
+			if len(header.value) > maxAuditHeaderLength {
+				controllers.RenderError(w, r, problems.InvalidParameter(header.name, errors.Errorf(
+					"%s header cannot be longer than %d characters", header.name, maxAuditHeaderLength)))
+				return
+			}
+		}
+		ctx := models.WithAuditor(r.Context(), models.Auditor{Actor: actor, ClaimedActor: claimedActor,
+			RequestID: requestID})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// negotiateResponseFormat rejects requests that accept none of the formats responses can be rendered as, before
+// they are handled
+func negotiateResponseFormat(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := controllers.ResponseFormat(r)
+		if err != nil {
+			controllers.JsonProblem(w, problems.New(http.StatusNotAcceptable, problems.CodeNotAcceptable, err))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// idempotent lets clients retry a request by sending an Idempotency-Key header. The response to the first request
+// with a key is stored and replayed for retries, so a retried POST does not create a second organization. Requests
+// without the header are handled as usual. The key is released when the handler panics or fails with a server error,
+// so the request can be retried
+func idempotent(service *services.IdempotencyService, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(services.IdempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			controllers.RenderError(w, r, problems.InvalidBody(errors.Wrap(err, "error reading request body")))
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		// requests accepting none of the response formats are rejected before they reach this handler
+		format, _ := controllers.ResponseFormat(r)
+		reservation, stored, err := service.Begin(r.Context(), key,
+			services.RequestHash(r.Method, r.URL.Path, format, body))
+		if err != nil {
+			controllers.RenderError(w, r, err)
+			return
+		} else if stored != nil {
+			for name, values := range stored.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set(services.IdempotentReplayedHeader, "true")
+			w.WriteHeader(stored.StatusCode)
+			w.Write(stored.Body)
+			return
+		}
+
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				if err := service.Release(context.Background(), reservation); err != nil {
+					log.Errorf("error releasing idempotency key '%s' of a failed request: %v", key, err)
+				}
+				panic(recovered)
+			}
+		}()
+		recorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next(recorder, r)
+		// the response is stored even when the client has gone away, as it is the response its retry expects
+		err = service.Complete(context.Background(), reservation, services.StoredResponse{
+			StatusCode: recorder.statusCode,
+			Header:     w.Header(),
+			Body:       recorder.body.Bytes(),
+		})
+		if err != nil {
+			log.Errorf("error completing request with idempotency key '%s': %v", key, err)
+		}
+	}
+}
+
+// responseRecorder keeps a copy of the status code and body of a response as it is written
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	r.statusCode = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
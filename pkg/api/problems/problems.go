@@ -0,0 +1,150 @@
+// Package problems describes why requests fail as RFC 7807 problem details. Services return a *Problem for errors
+// caused by the request, any other error is an internal error whose details are not exposed to clients
+package problems
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+// Codes identify the kind of a problem, they are stable so clients can handle problems by their code
+const (
+	CodeInvalidParameter      = "invalid_parameter"
+	CodeInvalidBody           = "invalid_body"
+	CodeValidationFailed      = "validation_failed"
+	CodeNotFound              = "not_found"
+	CodeNotDeleted            = "not_deleted"
+	CodePreconditionFailed    = "precondition_failed"
+	CodeEditConflict          = "edit_conflict"
+	CodeIdempotencyKeyReused  = "idempotency_key_reused"
+	CodeRequestInProgress     = "request_in_progress"
+	CodeDuplicateOrganization = "duplicate_organization"
+	CodeBatchFailed           = "batch_failed"
+	CodeNotAcceptable         = "not_acceptable"
+	CodeUnsupportedMediaType  = "unsupported_media_type"
+	CodeForbidden             = "forbidden"
+	CodeHistoryUnavailable    = "history_unavailable"
+	CodeInternal              = "internal_error"
+)
+
+// TypePrefix is prefixed to the code of a problem to form its type URI
+const TypePrefix = "urn:organization-manager:problem:"
+
+// titles are the short summaries of each kind of problem
+var titles = map[string]string{
+	CodeInvalidParameter:      "Invalid parameter",
+	CodeInvalidBody:           "Invalid request body",
+	CodeValidationFailed:      "Validation failed",
+	CodeNotFound:              "Organization not found",
+	CodeNotDeleted:            "Organization not deleted",
+	CodePreconditionFailed:    "Precondition failed",
+	CodeEditConflict:          "Edit conflict",
+	CodeIdempotencyKeyReused:  "Idempotency key reused",
+	CodeRequestInProgress:     "Request in progress",
+	CodeDuplicateOrganization: "Duplicate organization",
+	CodeBatchFailed:           "Batch failed",
+	CodeNotAcceptable:         "Not acceptable",
+	CodeUnsupportedMediaType:  "Unsupported media type",
+	CodeForbidden:             "Forbidden",
+	CodeHistoryUnavailable:    "History unavailable",
+	CodeInternal:              "Internal error",
+}
+
+// InvalidParam describes why a parameter or field of a request is invalid
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// Problem is an error describing why a request failed
+type Problem struct {
+	Status int
+	Code   string
+	// Detail explains this occurrence of the problem
+	Detail        string
+	InvalidParams []InvalidParam
+	// Err is the error that caused the problem, such as a *services.DuplicateOrganizationError
+	Err error
+}
+
+// New creates a problem caused by err, which is described by the message of err
+func New(status int, code string, err error) *Problem {
+	return &Problem{Status: status, Code: code, Detail: err.Error(), Err: err}
+}
+
+// Errorf creates a problem described by a formatted message
+func Errorf(status int, code, format string, args ...interface{}) *Problem {
+	return New(status, code, errors.Errorf(format, args...))
+}
+
+// InvalidParameter creates a 400 problem for an invalid query or path parameter
+func InvalidParameter(name string, err error) *Problem {
+	problem := New(http.StatusBadRequest, CodeInvalidParameter, err)
+	problem.InvalidParams = []InvalidParam{{Name: name, Reason: err.Error()}}
+	return problem
+}
+
+// InvalidBody creates a 400 problem for a request body that cannot be deserialized
+func InvalidBody(err error) *Problem {
+	return New(http.StatusBadRequest, CodeInvalidBody, err)
+}
+
+// InvalidField creates a 400 problem for an invalid field of a request body
+func InvalidField(name string, err error) *Problem {
+	problem := New(http.StatusBadRequest, CodeValidationFailed, err)
+	problem.InvalidParams = []InvalidParam{{Name: name, Reason: err.Error()}}
+	return problem
+}
+
+// From returns the problem err is or wraps, or a 500 internal problem when err is not caused by the request
+func From(err error) *Problem {
+	var problem *Problem
+	if errors.As(err, &problem) {
+		return problem
+	}
+	return New(http.StatusInternalServerError, CodeInternal, err)
+}
+
+// Status returns the status of the problem err is or wraps, or 500 when err is not caused by the request
+func Status(err error) int {
+	return From(err).Status
+}
+
+// HasCode reports whether err is or wraps a problem with the code
+func HasCode(err error, code string) bool {
+	var problem *Problem
+	return errors.As(err, &problem) && problem.Code == code
+}
+
+func (p *Problem) Error() string {
+	return p.Detail
+}
+
+func (p *Problem) Unwrap() error {
+	return p.Err
+}
+
+// Type is the URI identifying the kind of problem
+func (p *Problem) Type() string {
+	return TypePrefix + p.Code
+}
+
+// Title is the short summary of the kind of problem
+func (p *Problem) Title() string {
+	if title, hasTitle := titles[p.Code]; hasTitle {
+		return title
+	}
+	return http.StatusText(p.Status)
+}
+
+// Redact replaces the detail of an internal problem with a correlation ID, which is logged along with the cause of
+// the problem so it can be found from the response
+func (p *Problem) Redact(correlationID string) *Problem {
+	return &Problem{
+		Status: p.Status,
+		Code:   p.Code,
+		Detail: fmt.Sprintf("an internal error occurred, reference %s", correlationID),
+		Err:    p.Err,
+	}
+}
@@ -0,0 +1,36 @@
+package problems
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestFrom(t *testing.T) {
+	problem := InvalidParameter("page", errors.New("invalid page query parameter 'x'"))
+	wrapped := errors.Wrap(problem, "error fetching organizations")
+	assert.Equal(t, problem, From(wrapped))
+	assert.Equal(t, http.StatusBadRequest, Status(wrapped))
+	assert.True(t, HasCode(wrapped, CodeInvalidParameter))
+	assert.Equal(t, []InvalidParam{{Name: "page", Reason: "invalid page query parameter 'x'"}}, problem.InvalidParams)
+
+	internal := From(errors.New("pq: connection refused"))
+	assert.Equal(t, http.StatusInternalServerError, internal.Status)
+	assert.Equal(t, CodeInternal, internal.Code)
+	assert.False(t, HasCode(errors.New("pq: connection refused"), CodeInternal))
+}
+
+func TestProblem(t *testing.T) {
+	cause := errors.New("organization not found")
+	problem := New(http.StatusNotFound, CodeNotFound, cause)
+	assert.Equal(t, "organization not found", problem.Error())
+	assert.Equal(t, "urn:organization-manager:problem:not_found", problem.Type())
+	assert.Equal(t, "Organization not found", problem.Title())
+	assert.True(t, errors.Is(problem, cause))
+	assert.Equal(t, "Conflict", Errorf(http.StatusConflict, "unknown", "conflict").Title())
+
+	redacted := From(errors.New("pq: connection refused")).Redact("ref-1")
+	assert.Equal(t, "an internal error occurred, reference ref-1", redacted.Detail)
+	assert.Equal(t, CodeInternal, redacted.Code)
+}
@@ -2,11 +2,39 @@ package api
 
 import (
 	"organization_manager/pkg/api/controllers"
+	"organization_manager/pkg/api/services"
 )
 
 func (s *Server) initializeRoutes() {
-	router := s.Router.PathPrefix("/api/v1").Subrouter()
-	router.HandleFunc("/organizations", controllers.CreateOrganization).Methods("POST")
-	router.HandleFunc("/organizations", controllers.GetOrganizations).Methods("GET")
-}
+	organizationService := services.NewOrganizationService(s.Repository)
+	organizationService.DuplicateCheck = s.DuplicateCheck
+	organizations := controllers.NewOrganizationController(organizationService)
+	idempotencyService := services.NewIdempotencyService(s.IdempotencyKeys)
+	idempotencyService.TTL = s.IdempotencyKeyTTL
+	idempotencyService.Lease = s.IdempotencyKeyLease
+
+	apiRouter := s.Router.PathPrefix("/api/v1").Subrouter()
+	// exports negotiate their own formats, as they can be streamed as NDJSON
+	apiRouter.HandleFunc("/organizations/export", organizations.ExportOrganizations).Methods("GET")
 
+	router := apiRouter.NewRoute().Subrouter()
+	router.Use(negotiateResponseFormat)
+	router.HandleFunc("/organizations", idempotent(idempotencyService, organizations.CreateOrganization)).Methods("POST")
+	router.HandleFunc("/organizations", organizations.GetOrganizations).Methods("GET")
+	router.HandleFunc("/organizations:batch", organizations.CreateOrganizations).Methods("POST")
+	router.HandleFunc("/organizations/search", organizations.SearchOrganizations).Methods("POST")
+	router.HandleFunc("/organizations/aggregate", organizations.GetOrganizationAggregates).Methods("GET")
+	router.HandleFunc("/organizations/import", organizations.ImportOrganizations).Methods("POST")
+	router.HandleFunc("/organizations/{id}", organizations.GetOrganization).Methods("GET")
+	router.HandleFunc("/organizations/{id}", organizations.UpdateOrganization).Methods("PUT")
+	router.HandleFunc("/organizations/{id}", organizations.PatchOrganization).Methods("PATCH")
+	router.HandleFunc("/organizations/{id}", organizations.DeleteOrganization).Methods("DELETE")
+	router.HandleFunc("/organizations/{id}/duplicates", organizations.GetDuplicateOrganizations).Methods("GET")
+	router.HandleFunc("/organizations/{id}/history", organizations.GetOrganizationHistory).Methods("GET")
+	router.HandleFunc("/organizations/{id}/merge", organizations.MergeOrganization).Methods("POST")
+	router.HandleFunc("/organizations/{id}/restore", organizations.RestoreOrganization).Methods("POST")
+
+	adminRouter := router.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(s.requireAdmin)
+	adminRouter.HandleFunc("/organizations/{id}", organizations.PurgeOrganization).Methods("DELETE")
+}
@@ -4,22 +4,50 @@ import (
 	"fmt"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
-	"github.com/jinzhu/gorm"
 	log "github.com/sirupsen/logrus"
 	"net/http"
+	"organization_manager/pkg/api/services"
+	"organization_manager/pkg/database/repository"
+	"time"
 )
 
 type Server struct {
-	DB     *gorm.DB
-	Router *mux.Router
+	Repository repository.OrganizationRepository
+	// IdempotencyKeys stores the responses to requests made with an Idempotency-Key header
+	IdempotencyKeys repository.IdempotencyKeyRepository
+	// IdempotencyKeyTTL is how long the response to a request made with an Idempotency-Key header is kept
+	IdempotencyKeyTTL time.Duration
+	// IdempotencyKeyLease is how long a request in progress holds its Idempotency-Key before a retry can take it over
+	IdempotencyKeyLease time.Duration
+	Router              *mux.Router
+	// AdminKey must be supplied in the X-Admin-Key header to use the admin endpoints
+	AdminKey string
+	// DuplicateCheck configures how new organizations with names similar to existing organizations are handled
+	DuplicateCheck services.DuplicateCheck
 }
 
 func (s *Server) Initialize() error {
+	err := s.DuplicateCheck.Validate()
+	if err != nil {
+		return err
+	}
+	if s.IdempotencyKeyTTL <= 0 {
+		return fmt.Errorf("idempotency key TTL must be positive, got %s", s.IdempotencyKeyTTL)
+	}
+	if s.IdempotencyKeyLease <= 0 {
+		return fmt.Errorf("idempotency key lease must be positive, got %s", s.IdempotencyKeyLease)
+	}
+
 	s.Router = mux.NewRouter()
 	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"})
 
 	originsOk := handlers.AllowedOrigins([]string{"*"})
-	s.Router.Use(handlers.CORS(originsOk, methodsOk))
+	// conditional requests send entity tags back in If-Match and If-None-Match headers
+	headersOk := handlers.AllowedHeaders([]string{"If-Match", "If-None-Match", services.IdempotencyKeyHeader,
+		requestIDHeader, actorHeader})
+	exposedOk := handlers.ExposedHeaders([]string{"ETag", services.IdempotentReplayedHeader, requestIDHeader})
+	s.Router.Use(handlers.CORS(originsOk, methodsOk, headersOk, exposedOk))
+	s.Router.Use(s.identifyRequest)
 
 	s.initializeRoutes()
 	return nil
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/database/models"
+	"organization_manager/pkg/database/repository"
+	"strconv"
+	"strings"
+)
+
+// Modes of the duplicate check run when organizations are created
+const (
+	DuplicateCheckOff    = "off"
+	DuplicateCheckWarn   = "warn"
+	DuplicateCheckReject = "reject"
+)
+
+const (
+	defaultDuplicateThreshold = 0.6
+	// duplicateCheckLimit is the number of similar organizations reported when an organization is created
+	duplicateCheckLimit          = 5
+	defaultDuplicatesLimit       = 10
+	maxDuplicatesLimit           = 100
+	duplicateThresholdQueryParam = "threshold"
+	duplicateLimitQueryParam     = "limit"
+)
+
+// DuplicateCheck configures how organizations are compared against existing organizations with similar names when
+// they are created. Names are similar when their trigram similarity, which ignores case and punctuation, is at least
+// the threshold
+type DuplicateCheck struct {
+	// Mode is DuplicateCheckWarn to create the organization and report its possible duplicates, DuplicateCheckReject
+	// to refuse to create it or DuplicateCheckOff. The zero value disables the check
+	Mode string
+	// Threshold is the similarity between 0 and 1 at which names are duplicates, defaulting to 0.6 when unset
+	Threshold float64
+}
+
+// Validate checks the mode and threshold of a duplicate check
+func (c DuplicateCheck) Validate() error {
+	switch c.Mode {
+	case "", DuplicateCheckOff, DuplicateCheckWarn, DuplicateCheckReject:
+	default:
+		return errors.Errorf("invalid duplicate check mode '%s', expected %s, %s or %s", c.Mode, DuplicateCheckOff,
+			DuplicateCheckWarn, DuplicateCheckReject)
+	}
+	if c.Threshold < 0 || c.Threshold > 1 {
+		return errors.Errorf("invalid duplicate threshold %v, expected a value between 0 and 1", c.Threshold)
+	}
+	return nil
+}
+
+func (c DuplicateCheck) threshold() float64 {
+	if c.Threshold == 0 {
+		return defaultDuplicateThreshold
+	}
+	return c.Threshold
+}
+
+// DuplicateOrganizationError is returned when creating an organization is rejected because organizations with
+// similar names exist, or are created along with it
+type DuplicateOrganizationError struct {
+	Name       string
+	Duplicates []models.SimilarOrganization
+	// BatchDuplicates describes the earlier organizations of the same batch or import with similar names
+	BatchDuplicates []string
+}
+
+func (e *DuplicateOrganizationError) Error() string {
+	var similar []string
+	if len(e.Duplicates) > 0 {
+		names := make([]string, len(e.Duplicates))
+		for i, duplicate := range e.Duplicates {
+			names[i] = fmt.Sprintf("'%s' (%s)", duplicate.Organization.Name, duplicate.Organization.ID)
+		}
+		similar = append(similar, "existing organizations "+strings.Join(names, ", "))
+	}
+	if len(e.BatchDuplicates) > 0 {
+		similar = append(similar, "organizations of the same batch "+strings.Join(e.BatchDuplicates, ", "))
+	}
+	return fmt.Sprintf("organization name '%s' is similar to %s", e.Name, strings.Join(similar, " and "))
+}
+
+// batchDuplicates is the outcome of the duplicate check of an organization created along with other organizations
+type batchDuplicates struct {
+	// Existing are the existing organizations with similar names
+	Existing []models.SimilarOrganization
+	// Earlier are the earlier organizations of the batch with similar names, by their index in the batch
+	Earlier []repository.NameMatch
+	// Err is a problem caused by a DuplicateOrganizationError when the check rejects the organization
+	Err error
+}
+
+type DuplicateOrganizationsResponse struct {
+	Threshold  float64                      `json:"threshold"`
+	Duplicates []models.SimilarOrganization `json:"duplicates"`
+}
+
+// GetDuplicateOrganizations returns the organizations with names similar to the name of the organization with the ID
+// from the request path, the threshold and limit query parameters override the configured threshold and default limit
+// Will return a *problems.Problem for invalid requests
+func (s *OrganizationService) GetDuplicateOrganizations(ctx context.Context, orgID string, queryParams url.Values) (*DuplicateOrganizationsResponse, error) {
+	similarQuery := models.SimilarNameQuery{Threshold: s.DuplicateCheck.threshold(), Limit: defaultDuplicatesLimit}
+	var err error
+	if threshold := queryParams.Get(duplicateThresholdQueryParam); threshold != "" {
+		similarQuery.Threshold, err = strconv.ParseFloat(threshold, 64)
+		if err != nil || similarQuery.Threshold <= 0 || similarQuery.Threshold > 1 {
+			return nil, problems.InvalidParameter(duplicateThresholdQueryParam, errors.Errorf(
+				"%s must be greater than 0 and at most 1, got '%s'", duplicateThresholdQueryParam, threshold))
+		}
+	}
+	if limit := queryParams.Get(duplicateLimitQueryParam); limit != "" {
+		similarQuery.Limit, err = strconv.Atoi(limit)
+		if err != nil || similarQuery.Limit < 1 || similarQuery.Limit > maxDuplicatesLimit {
+			return nil, problems.InvalidParameter(duplicateLimitQueryParam, errors.Errorf(
+				"%s must be between 1 and %d, got '%s'", duplicateLimitQueryParam, maxDuplicatesLimit, limit))
+		}
+	}
+
+	org, err := s.GetOrganization(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	similarQuery.Name = org.Name
+	similarQuery.ExcludeID = org.ID
+
+	duplicates, err := s.repo.FindSimilar(ctx, similarQuery)
+	if err != nil {
+		log.Errorf("error finding duplicates of organization '%s': %v", orgID, err)
+		return nil, err
+	}
+	if duplicates == nil {
+		duplicates = []models.SimilarOrganization{}
+	}
+	return &DuplicateOrganizationsResponse{Threshold: similarQuery.Threshold, Duplicates: duplicates}, nil
+}
+
+// checkDuplicates returns the existing organizations with names similar to a new organization, or a
+// problem caused by a DuplicateOrganizationError if the duplicate check rejects them
+func (s *OrganizationService) checkDuplicates(ctx context.Context, org models.Organization) ([]models.SimilarOrganization, error) {
+	if s.DuplicateCheck.Mode != DuplicateCheckWarn && s.DuplicateCheck.Mode != DuplicateCheckReject {
+		return nil, nil
+	}
+
+	duplicates, err := s.repo.FindSimilar(ctx, models.SimilarNameQuery{
+		Name:      org.Name,
+		Threshold: s.DuplicateCheck.threshold(),
+		Limit:     duplicateCheckLimit,
+	})
+	if err != nil {
+		log.Errorf("error checking for duplicates of new organization '%s': %v", org.Name, err)
+		return nil, err
+	}
+	if len(duplicates) > 0 && s.DuplicateCheck.Mode == DuplicateCheckReject {
+		return nil, problems.New(http.StatusConflict, problems.CodeDuplicateOrganization,
+			&DuplicateOrganizationError{Name: org.Name, Duplicates: duplicates})
+	}
+	return duplicates, nil
+}
+
+// checkBatchDuplicates runs the duplicate check for organizations created together. The existing organizations with
+// names similar to any of them are found at once, and as the organizations of the batch are not stored yet each is
+// also compared with the earlier organizations of the batch. Organizations rejected by the check are not compared
+// with later organizations, as they will not be created. position describes an organization of the batch by its
+// index, such as "item 2"
+func (s *OrganizationService) checkBatchDuplicates(ctx context.Context, orgs []models.Organization,
+	position func(int) string) ([]batchDuplicates, error) {
+
+	checks := make([]batchDuplicates, len(orgs))
+	if len(orgs) == 0 || (s.DuplicateCheck.Mode != DuplicateCheckWarn && s.DuplicateCheck.Mode != DuplicateCheckReject) {
+		return checks, nil
+	}
+
+	names := make([]string, len(orgs))
+	for i, org := range orgs {
+		names[i] = org.Name
+	}
+	existing, err := s.repo.FindSimilarBatch(ctx, models.SimilarNamesQuery{
+		Names:     names,
+		Threshold: s.DuplicateCheck.threshold(),
+		Limit:     duplicateCheckLimit,
+	})
+	if err != nil {
+		log.Errorf("error checking for duplicates of a batch of %d new organizations: %v", len(orgs), err)
+		return nil, err
+	}
+	earlier := repository.MatchSimilarNames(names, s.DuplicateCheck.threshold())
+
+	for i, org := range orgs {
+		checks[i].Existing = existing[i]
+		for _, match := range earlier[i] {
+			if checks[match.Index].Err == nil {
+				checks[i].Earlier = append(checks[i].Earlier, match)
+			}
+		}
+		if s.DuplicateCheck.Mode == DuplicateCheckReject && (len(checks[i].Existing) > 0 || len(checks[i].Earlier) > 0) {
+			duplicateErr := &DuplicateOrganizationError{Name: org.Name, Duplicates: checks[i].Existing}
+			for _, match := range checks[i].Earlier {
+				duplicateErr.BatchDuplicates = append(duplicateErr.BatchDuplicates,
+					fmt.Sprintf("'%s' (%s)", orgs[match.Index].Name, position(match.Index)))
+			}
+			checks[i].Err = problems.New(http.StatusConflict, problems.CodeDuplicateOrganization, duplicateErr)
+		}
+	}
+	return checks, nil
+}
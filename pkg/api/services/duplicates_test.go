@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/database/repository"
+	"strings"
+	"testing"
+)
+
+func TestDuplicateCheck_Validate(t *testing.T) {
+	assert.NoError(t, DuplicateCheck{}.Validate())
+	assert.NoError(t, DuplicateCheck{Mode: DuplicateCheckReject, Threshold: 0.8}.Validate())
+	assert.Error(t, DuplicateCheck{Mode: "block"}.Validate())
+	assert.Error(t, DuplicateCheck{Mode: DuplicateCheckWarn, Threshold: 1.5}.Validate())
+}
+
+func TestOrganizationService_duplicateCheck(t *testing.T) {
+	ctx := context.Background()
+	service := NewOrganizationService(repository.NewMemoryOrganizationRepository())
+	acme, duplicates, err := service.SaveNewOrganization(ctx, strings.NewReader(`{"name": "Acme Inc"}`))
+	require.NoError(t, err)
+	assert.Empty(t, duplicates)
+
+	// the check is off by default
+	_, duplicates, err = service.SaveNewOrganization(ctx, strings.NewReader(`{"name": "ACME, Inc."}`))
+	require.NoError(t, err)
+	assert.Empty(t, duplicates)
+
+	service.DuplicateCheck = DuplicateCheck{Mode: DuplicateCheckWarn}
+	_, duplicates, err = service.SaveNewOrganization(ctx, strings.NewReader(`{"name": "acme inc"}`))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(duplicates))
+	assert.InDelta(t, 1, duplicates[0].Similarity, 0.0001)
+
+	service.DuplicateCheck = DuplicateCheck{Mode: DuplicateCheckReject, Threshold: 0.9}
+	_, _, err = service.SaveNewOrganization(ctx, strings.NewReader(`{"name": "Acme Inc"}`))
+	assert.Equal(t, http.StatusConflict, problems.Status(err))
+	var duplicateErr *DuplicateOrganizationError
+	require.True(t, errors.As(err, &duplicateErr))
+	assert.Equal(t, 3, len(duplicateErr.Duplicates))
+	_, _, err = service.SaveNewOrganization(ctx, strings.NewReader(`{"name": "Globex"}`))
+	require.NoError(t, err)
+
+	resp, err := service.GetDuplicateOrganizations(ctx, acme.ID.String(), url.Values{})
+	require.NoError(t, err)
+	assert.Equal(t, 0.9, resp.Threshold)
+	require.Equal(t, 2, len(resp.Duplicates))
+	for _, duplicate := range resp.Duplicates {
+		assert.NotEqual(t, acme.ID, duplicate.Organization.ID)
+	}
+
+	resp, err = service.GetDuplicateOrganizations(ctx, acme.ID.String(),
+		url.Values{duplicateLimitQueryParam: {"1"}, duplicateThresholdQueryParam: {"0.5"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(resp.Duplicates))
+
+	for _, queryParams := range []url.Values{
+		{duplicateThresholdQueryParam: {"0"}},
+		{duplicateThresholdQueryParam: {"high"}},
+		{duplicateLimitQueryParam: {"0"}},
+		{duplicateLimitQueryParam: {"101"}},
+	} {
+		_, err = service.GetDuplicateOrganizations(ctx, acme.ID.String(), queryParams)
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusBadRequest, problems.Status(err))
+	}
+	_, err = service.GetDuplicateOrganizations(ctx, "d9b2d63d-a233-4123-847a-7ac09bd3b1d3", url.Values{})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, problems.Status(err))
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v5"
+	"net/url"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/database/models"
+	"strings"
+)
+
+const (
+	fieldsQueryParam = "fields"
+	fieldSeparator   = ","
+	// idJSONField is the JSON name of the organization ID
+	idJSONField = "ID"
+)
+
+// ProjectedOrganization serializes the ID and the given fields of an organization, or every field if Fields is nil
+type ProjectedOrganization struct {
+	Organization models.Organization
+	Fields       []string
+}
+
+func (p ProjectedOrganization) MarshalJSON() ([]byte, error) {
+	if p.Fields == nil {
+		return json.Marshal(p.Organization)
+	}
+	document, err := organizationDocument(p.Organization)
+	if err != nil {
+		return nil, err
+	}
+	projected := map[string]interface{}{idJSONField: document[idJSONField]}
+	for _, field := range p.Fields {
+		projected[field] = document[field]
+	}
+	return json.Marshal(projected)
+}
+
+// EncodeMsgpack encodes the ID and the given fields of the organization like MarshalJSON, keeping the types of the
+// fields
+func (p ProjectedOrganization) EncodeMsgpack(encoder *msgpack.Encoder) error {
+	if p.Fields == nil {
+		return encoder.Encode(p.Organization)
+	}
+	projected := map[string]interface{}{idJSONField: p.Organization.ID}
+	for _, field := range p.Fields {
+		projected[field] = p.Organization.ColumnValue(field)
+	}
+	return encoder.Encode(projected)
+}
+
+// GetProjectedOrganization returns the organization with the ID from the request path, serializing only the fields
+// in the fields query parameter. The organization is returned as it was at the moment of the as_of query parameter,
+// when it is supplied
+// Will return a *problems.Problem for invalid requests
+func (s *OrganizationService) GetProjectedOrganization(ctx context.Context, orgID string, queryParams url.Values) (*ProjectedOrganization, error) {
+	fields, err := getFieldsQueryParam(queryParams)
+	if err != nil {
+		return nil, err
+	}
+	asOf, err := getAsOfQueryParam(queryParams)
+	if err != nil {
+		return nil, err
+	}
+	var org *models.Organization
+	if asOf != nil {
+		org, err = s.GetOrganizationAsOf(ctx, orgID, *asOf)
+	} else {
+		org, err = s.GetOrganization(ctx, orgID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ProjectedOrganization{Organization: *org, Fields: fields}, nil
+}
+
+// getFieldsQueryParam parses the comma separated fields query parameter, for example fields=id,name. It returns nil
+// if the parameter is not supplied
+func getFieldsQueryParam(queryParams url.Values) ([]string, error) {
+	fieldParams, isSupplied := queryParams[fieldsQueryParam]
+	if !isSupplied {
+		return nil, nil
+	}
+	var fields []string
+	for _, fieldParam := range fieldParams {
+		fields = append(fields, strings.Split(fieldParam, fieldSeparator)...)
+	}
+	parsedFields, err := parseFields(fields)
+	if err != nil {
+		return nil, problems.InvalidParameter(fieldsQueryParam, err)
+	}
+	return parsedFields, nil
+}
+
+// parseFields validates fields against the organization field registry. The ID is always included, so it is left out
+// of the returned fields
+func parseFields(fieldNames []string) ([]string, error) {
+	fields := []string{}
+	seenFields := map[string]bool{}
+	for _, field := range fieldNames {
+		field = strings.TrimSpace(field)
+		if _, isField := models.OrganizationFields[field]; !isField && field != models.IDField {
+			return nil, errors.Errorf("invalid field '%s'", field)
+		}
+		if seenFields[field] {
+			return nil, errors.Errorf("duplicate field '%s'", field)
+		}
+		seenFields[field] = true
+		if field != models.IDField {
+			fields = append(fields, field)
+		}
+	}
+	return fields, nil
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/database/repository"
+	"strings"
+	"testing"
+)
+
+func Test_getFieldsQueryParam(t *testing.T) {
+	var testCases = []struct {
+		queryParams    url.Values
+		expectedResult []string
+		shouldFail     bool
+	}{
+		{
+			queryParams:    url.Values{},
+			expectedResult: nil,
+		},
+		{
+			queryParams:    url.Values{fieldsQueryParam: {"id,name"}},
+			expectedResult: []string{"name"},
+		},
+		{
+			// Testing the ID is included when it is not requested
+			queryParams:    url.Values{fieldsQueryParam: {"employee_count, is_public", "creation_date"}},
+			expectedResult: []string{"employee_count", "is_public", "creation_date"},
+		},
+		{
+			queryParams:    url.Values{fieldsQueryParam: {"id"}},
+			expectedResult: []string{},
+		},
+		{
+			queryParams: url.Values{fieldsQueryParam: {"id,revenue"}},
+			shouldFail:  true,
+		},
+		{
+			queryParams: url.Values{fieldsQueryParam: {"name,name"}},
+			shouldFail:  true,
+		},
+		{
+			queryParams: url.Values{fieldsQueryParam: {""}},
+			shouldFail:  true,
+		},
+		{
+			queryParams: url.Values{fieldsQueryParam: {"deleted_at"}},
+			shouldFail:  true,
+		},
+	}
+
+	for i, test := range testCases {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			result, err := getFieldsQueryParam(test.queryParams)
+			if test.shouldFail {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, test.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestOrganizationService_fields(t *testing.T) {
+	ctx := context.Background()
+	service := NewOrganizationService(repository.NewMemoryOrganizationRepository())
+	org, _, err := service.SaveNewOrganization(ctx, strings.NewReader(
+		`{"name": "CLEAR", "creation_date": "2002-09-22T00:00:00Z", "employee_count": 10000, "is_public": true}`))
+	require.NoError(t, err)
+
+	// sort fields are fetched for the cursor, but only the requested fields are serialized
+	resp, err := service.GetOrganizations(ctx, url.Values{fieldsQueryParam: {"name"},
+		sortQueryParam: {"-employee_count"}})
+	require.NoError(t, err)
+	content, err := json.Marshal(resp)
+	require.NoError(t, err)
+	assert.JSONEq(t, fmt.Sprintf(`{"organizations": [{"ID": "%s", "name": "CLEAR"}], "page": 1, "page_size": 20,
+		"total_pages": 1, "total_count": 1}`, org.ID), string(content))
+
+	projectedOrg, err := service.GetProjectedOrganization(ctx, org.ID.String(),
+		url.Values{fieldsQueryParam: {"id,is_public"}})
+	require.NoError(t, err)
+	content, err = json.Marshal(projectedOrg)
+	require.NoError(t, err)
+	assert.JSONEq(t, fmt.Sprintf(`{"ID": "%s", "is_public": true}`, org.ID), string(content))
+
+	// every field is serialized without the fields query parameter
+	projectedOrg, err = service.GetProjectedOrganization(ctx, org.ID.String(), url.Values{})
+	require.NoError(t, err)
+	content, err = json.Marshal(projectedOrg)
+	require.NoError(t, err)
+	expectedContent, err := json.Marshal(org)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(expectedContent), string(content))
+
+	resp, err = service.SearchOrganizations(ctx, strings.NewReader(`{"fields": ["employee_count"]}`))
+	require.NoError(t, err)
+	content, err = json.Marshal(resp)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), fmt.Sprintf(`"organizations":[{"ID":"%s","employee_count":10000}]`, org.ID))
+
+	invalidFields := url.Values{fieldsQueryParam: {"revenue"}}
+	_, err = service.GetOrganizations(ctx, invalidFields)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, problems.Status(err))
+	_, err = service.GetProjectedOrganization(ctx, org.ID.String(), invalidFields)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, problems.Status(err))
+	_, err = service.SearchOrganizations(ctx, strings.NewReader(`{"fields": ["revenue"]}`))
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, problems.Status(err))
+}
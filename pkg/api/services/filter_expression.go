@@ -0,0 +1,297 @@
+package services
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"organization_manager/pkg/database/models"
+	"strings"
+	"unicode"
+)
+
+const (
+	andKeyword = "AND"
+	orKeyword  = "OR"
+	notKeyword = "NOT"
+)
+
+// filterExpressionParser is a recursive descent parser for the q query parameter, which combines filters with
+// boolean operators. Keywords are case sensitive and AND binds tighter than OR:
+//
+//	expression  = conjunction { "OR" conjunction }
+//	conjunction = negation { "AND" negation }
+//	negation    = "NOT" negation | "(" expression ")" | filter
+//	filter      = column ":" ( range | quoted_value | value )
+//
+// Ranges use the range_filter syntax, such as employee_count:[10TO20), and values use the filter syntax, so * is a
+// wildcard and is_null, not_null, in(...), not_in(...) and ilike(...) are operators. Values containing whitespace or parentheses
+// can be double quoted, such as name:"Acme (EU)", quoted values are never treated as operators
+type filterExpressionParser struct {
+	input string
+	pos   int
+}
+
+// parseFilterExpression parses the q query parameter into a filter expression validated against the organization
+// field registry
+func parseFilterExpression(input string) (models.FilterExpression, error) {
+	parser := filterExpressionParser{input: input}
+	expression, err := parser.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	parser.skipWhitespace()
+	if parser.pos < len(parser.input) {
+		return nil, parser.errorf("unexpected '%c'", parser.input[parser.pos])
+	}
+	return expression, nil
+}
+
+func (p *filterExpressionParser) parseExpression() (models.FilterExpression, error) {
+	var operands []models.FilterExpression
+	for {
+		operand, err := p.parseConjunction()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+		if !p.consumeKeyword(orKeyword) {
+			break
+		}
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return models.OrExpression{Operands: operands}, nil
+}
+
+func (p *filterExpressionParser) parseConjunction() (models.FilterExpression, error) {
+	var operands []models.FilterExpression
+	for {
+		operand, err := p.parseNegation()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+		if !p.consumeKeyword(andKeyword) {
+			break
+		}
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return models.AndExpression{Operands: operands}, nil
+}
+
+func (p *filterExpressionParser) parseNegation() (models.FilterExpression, error) {
+	if p.consumeKeyword(notKeyword) {
+		operand, err := p.parseNegation()
+		if err != nil {
+			return nil, err
+		}
+		return models.NotExpression{Operand: operand}, nil
+	}
+
+	p.skipWhitespace()
+	if p.pos < len(p.input) && p.input[p.pos] == '(' {
+		p.pos++
+		expression, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		p.skipWhitespace()
+		if p.pos >= len(p.input) || p.input[p.pos] != ')' {
+			return nil, p.errorf("expected ')'")
+		}
+		p.pos++
+		return expression, nil
+	}
+	return p.parseFilter()
+}
+
+// parseFilter parses a single column:value or column:range filter
+func (p *filterExpressionParser) parseFilter() (models.FilterExpression, error) {
+	start := p.pos
+	for p.pos < len(p.input) && isColumnNameChar(rune(p.input[p.pos])) {
+		p.pos++
+	}
+	column := p.input[start:p.pos]
+	if column == "" {
+		return nil, p.errorf("expected a filter")
+	}
+	if p.pos >= len(p.input) || p.input[p.pos] != ':' {
+		return nil, p.errorf("expected ':' after column '%s'", column)
+	}
+	p.pos++
+
+	if p.pos < len(p.input) && (p.input[p.pos] == '[' || p.input[p.pos] == '(') {
+		end := strings.IndexAny(p.input[p.pos:], "])")
+		if end < 0 {
+			return nil, p.errorf("unterminated range")
+		}
+		p.pos += end + 1
+		matchedGroups, err := checkFilter(rangeFilterRegex, p.input[start:p.pos], 6, true)
+		if err != nil {
+			return nil, err
+		}
+		rangeFilter, err := newRangeFilter(matchedGroups)
+		if err != nil {
+			return nil, err
+		}
+		return rangeFilter, nil
+	}
+
+	// quoted values are always matched as values, so they can contain operator names
+	valueStart := p.pos
+	isQuoted := p.pos < len(p.input) && p.input[p.pos] == '"'
+	err := p.skipValue()
+	if err != nil {
+		return nil, err
+	}
+	err = checkFilterColumn(column, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var categoryFilter models.CategoryQueryFilter
+	if isQuoted {
+		value, _, _ := unquoteFilterValue(p.input, valueStart)
+		categoryFilter, err = newValueFilter(column, value)
+	} else {
+		categoryFilter, err = newCategoryFilter(column, p.input[valueStart:p.pos])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return categoryFilter, nil
+}
+
+// skipValue skips a filter value, which is either a double quoted value, an in(...), not_in(...) or ilike(...)
+// operator or a bare value that ends at whitespace or a closing parenthesis
+func (p *filterExpressionParser) skipValue() error {
+	var err error
+	rest := p.input[p.pos:]
+	switch {
+	case strings.HasPrefix(rest, `"`):
+		_, p.pos, err = unquoteFilterValue(p.input, p.pos)
+		if err != nil {
+			return p.errorf("%s", err)
+		}
+		return nil
+	case strings.HasPrefix(rest, inFilterPrefix) || strings.HasPrefix(rest, notInFilterPrefix) ||
+		strings.HasPrefix(rest, iLikeFilterPrefix):
+		p.pos += strings.IndexByte(rest, '(') + 1
+		for p.pos < len(p.input) {
+			switch p.input[p.pos] {
+			case '"':
+				_, p.pos, err = unquoteFilterValue(p.input, p.pos)
+				if err != nil {
+					return p.errorf("%s", err)
+				}
+			case ')':
+				p.pos++
+				return nil
+			default:
+				p.pos++
+			}
+		}
+		return p.errorf("unterminated value list")
+	}
+
+	start := p.pos
+	for p.pos < len(p.input) && !unicode.IsSpace(rune(p.input[p.pos])) && p.input[p.pos] != ')' {
+		p.pos++
+	}
+	if p.pos == start {
+		return p.errorf("expected a value")
+	}
+	return nil
+}
+
+// consumeKeyword skips a keyword if it is the next token, keywords must be followed by whitespace or a parenthesis
+// so they are not mistaken for the start of a column name
+func (p *filterExpressionParser) consumeKeyword(keyword string) bool {
+	p.skipWhitespace()
+	if !strings.HasPrefix(p.input[p.pos:], keyword) {
+		return false
+	}
+	end := p.pos + len(keyword)
+	if end < len(p.input) && isColumnNameChar(rune(p.input[end])) {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func (p *filterExpressionParser) skipWhitespace() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+// errorf creates an error describing where parsing the q query parameter failed
+func (p *filterExpressionParser) errorf(format string, args ...interface{}) error {
+	return errors.Errorf("invalid %s query parameter at position %d: %s", filterExpressionQueryParam, p.pos+1,
+		fmt.Sprintf(format, args...))
+}
+
+func isColumnNameChar(char rune) bool {
+	return char == '_' || unicode.IsLetter(char) || unicode.IsDigit(char)
+}
+
+// unquoteFilterValue parses the double quoted value starting at input[start], where \" and \\ are escaped quotes and
+// backslashes. It returns the value and the position after its closing quote
+func unquoteFilterValue(input string, start int) (string, int, error) {
+	var value strings.Builder
+	for pos := start + 1; pos < len(input); pos++ {
+		switch input[pos] {
+		case '"':
+			return value.String(), pos + 1, nil
+		case '\\':
+			pos++
+			if pos >= len(input) {
+				continue
+			}
+		}
+		value.WriteByte(input[pos])
+	}
+	return "", len(input), errors.New("unterminated quoted value")
+}
+
+// parseFilterValueList parses the comma separated values of an in(...) or not_in(...) filter. Whitespace around
+// values is ignored and values containing commas can be double quoted
+func parseFilterValueList(list string) ([]string, error) {
+	var values []string
+	for pos := 0; ; pos++ {
+		for pos < len(list) && unicode.IsSpace(rune(list[pos])) {
+			pos++
+		}
+		if pos < len(list) && list[pos] == '"' {
+			value, end, err := unquoteFilterValue(list, pos)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			pos = end
+			for pos < len(list) && unicode.IsSpace(rune(list[pos])) {
+				pos++
+			}
+		} else {
+			end := strings.IndexByte(list[pos:], ',')
+			if end < 0 {
+				end = len(list) - pos
+			}
+			value := strings.TrimSpace(list[pos : pos+end])
+			if value == "" {
+				return nil, errors.New("values cannot be empty")
+			}
+			values = append(values, value)
+			pos += end
+		}
+
+		if pos >= len(list) {
+			return values, nil
+		}
+		if list[pos] != ',' {
+			return nil, errors.Errorf("expected ',' at position %d", pos+1)
+		}
+	}
+}
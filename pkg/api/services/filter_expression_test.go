@@ -0,0 +1,159 @@
+package services
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"organization_manager/pkg/database/models"
+	"testing"
+)
+
+func Test_parseFilterExpression(t *testing.T) {
+	publicFilter := models.CategoryQueryFilter{DBfield: "is_public", ExactFilter: "true"}
+	acmeFilter := models.CategoryQueryFilter{DBfield: "name", LikeFilter: "Acme%"}
+	employeeFilter := models.RangeQueryFilter{DBfield: "employee_count", StartRange: "1000", StartComparator: models.GTE,
+		EndRange: "*", EndComparator: models.LTE}
+
+	var testCases = []struct {
+		input          string
+		expectedResult models.FilterExpression
+		shouldFail     bool
+	}{
+		{
+			input:          "is_public:true",
+			expectedResult: publicFilter,
+		},
+		{
+			input: "is_public:true OR (employee_count:[1000TO*] AND NOT name:Acme*)",
+			expectedResult: models.OrExpression{Operands: []models.FilterExpression{
+				publicFilter,
+				models.AndExpression{Operands: []models.FilterExpression{
+					employeeFilter,
+					models.NotExpression{Operand: acmeFilter},
+				}},
+			}},
+		},
+		{
+			// Testing AND binds tighter than OR
+			input: "is_public:true OR employee_count:[1000TO*] AND name:Acme*",
+			expectedResult: models.OrExpression{Operands: []models.FilterExpression{
+				publicFilter,
+				models.AndExpression{Operands: []models.FilterExpression{employeeFilter, acmeFilter}},
+			}},
+		},
+		{
+			// Testing parentheses override precedence and whitespace is optional around them
+			input: "(is_public:true OR employee_count:[1000TO*])AND NOT(name:Acme*)",
+			expectedResult: models.AndExpression{Operands: []models.FilterExpression{
+				models.OrExpression{Operands: []models.FilterExpression{publicFilter, employeeFilter}},
+				models.NotExpression{Operand: acmeFilter},
+			}},
+		},
+		{
+			// Testing exclusive range bounds and timestamps containing colons
+			input: "NOT NOT creation_date:(2002-09-22T00:00:00ZTO2010-01-01T00:00:00Z)",
+			expectedResult: models.NotExpression{Operand: models.NotExpression{Operand: models.RangeQueryFilter{
+				DBfield: "creation_date", StartRange: "2002-09-22T00:00:00Z", StartComparator: models.GT,
+				EndRange: "2010-01-01T00:00:00Z", EndComparator: models.LT}}},
+		},
+		{
+			// Testing quoted values can contain whitespace, parentheses, keywords and escaped quotes
+			input:          `name:"Acme (EU) OR \"Acme\" \\ Co*"`,
+			expectedResult: models.CategoryQueryFilter{DBfield: "name", LikeFilter: `Acme (EU) OR "Acme" \ Co%`},
+		},
+		{
+			// Testing set and null operators, value lists can contain quoted parentheses
+			input: `name:in(Acme, "Globex (EU)") AND NOT employee_count:not_in(1,2) OR creation_date:is_null`,
+			expectedResult: models.OrExpression{Operands: []models.FilterExpression{
+				models.AndExpression{Operands: []models.FilterExpression{
+					models.CategoryQueryFilter{DBfield: "name", InFilter: []string{"Acme", "Globex (EU)"}},
+					models.NotExpression{Operand: models.CategoryQueryFilter{DBfield: "employee_count",
+						NotInFilter: []string{"1", "2"}}},
+				}},
+				models.CategoryQueryFilter{DBfield: "creation_date", NullFilter: models.IsNull},
+			}},
+		},
+		{
+			input:          "name:ilike(*acme*)",
+			expectedResult: models.CategoryQueryFilter{DBfield: "name", ILikeFilter: "%acme%"},
+		},
+		{
+			// Testing quoted values are never operators
+			input:          `name:"in(Acme)"`,
+			expectedResult: models.CategoryQueryFilter{DBfield: "name", ExactFilter: "in(Acme)"},
+		},
+		{
+			input:      "name:in(Acme",
+			shouldFail: true,
+		},
+		{
+			input:      "employee_count:[tenTO*]",
+			shouldFail: true,
+		},
+		{
+			// Testing keywords are case sensitive and must be separated from columns
+			input:      "is_public:true or name:Acme",
+			shouldFail: true,
+		},
+		{
+			input:      "is_public:true ORname:Acme",
+			shouldFail: true,
+		},
+		{
+			input:      "is_public:true OR",
+			shouldFail: true,
+		},
+		{
+			input:      "(is_public:true",
+			shouldFail: true,
+		},
+		{
+			input:      "is_public:true)",
+			shouldFail: true,
+		},
+		{
+			input:      "NOT",
+			shouldFail: true,
+		},
+		{
+			input:      "is_public",
+			shouldFail: true,
+		},
+		{
+			input:      "is_public:",
+			shouldFail: true,
+		},
+		{
+			input:      `name:"Acme`,
+			shouldFail: true,
+		},
+		{
+			input:      "employee_count:[1000TO*",
+			shouldFail: true,
+		},
+		{
+			// Testing columns are validated against the field registry
+			input:      "revenue:1000",
+			shouldFail: true,
+		},
+		{
+			input:      "is_public:[trueTO*]",
+			shouldFail: true,
+		},
+		{
+			input:      "employee_count:1*",
+			shouldFail: true,
+		},
+	}
+
+	for i, test := range testCases {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			result, err := parseFilterExpression(test.input)
+			if test.shouldFail {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, test.expectedResult, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"github.com/pkg/errors"
+	"mime"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Formats organizations are imported from, exported as and responses are rendered as
+const (
+	FormatCSV         = "csv"
+	FormatNDJSON      = "ndjson"
+	FormatJSON        = "json"
+	FormatYAML        = "yaml"
+	FormatMessagePack = "msgpack"
+)
+
+// ExportFormats are the formats organizations can be exported as, the first is preferred
+var ExportFormats = []string{FormatJSON, FormatCSV, FormatNDJSON}
+
+// formatMediaTypes are the media types of content in each format
+var formatMediaTypes = map[string]string{
+	FormatCSV:         "text/csv",
+	FormatNDJSON:      "application/x-ndjson",
+	FormatJSON:        "application/json",
+	FormatYAML:        "application/yaml",
+	FormatMessagePack: "application/msgpack",
+}
+
+// mediaTypeFormats maps the media types of content to their format, including common alternatives to the media
+// types in formatMediaTypes
+var mediaTypeFormats = map[string]string{
+	"text/csv":                FormatCSV,
+	"application/csv":         FormatCSV,
+	"application/x-ndjson":    FormatNDJSON,
+	"application/ndjson":      FormatNDJSON,
+	"application/jsonl":       FormatNDJSON,
+	"application/json":        FormatJSON,
+	"application/yaml":        FormatYAML,
+	"application/x-yaml":      FormatYAML,
+	"text/yaml":               FormatYAML,
+	"text/x-yaml":             FormatYAML,
+	"application/msgpack":     FormatMessagePack,
+	"application/x-msgpack":   FormatMessagePack,
+	"application/vnd.msgpack": FormatMessagePack,
+}
+
+// extensionFormats maps file extensions to the format of the file
+var extensionFormats = map[string]string{
+	".csv":    FormatCSV,
+	".ndjson": FormatNDJSON,
+	".jsonl":  FormatNDJSON,
+	".json":   FormatJSON,
+}
+
+// FormatFromExtension determines the format of a file from its extension
+func FormatFromExtension(filename string) (string, error) {
+	format, isSupported := extensionFormats[strings.ToLower(filepath.Ext(filename))]
+	if !isSupported {
+		return "", errors.Errorf("unsupported file extension of '%s', expected .csv, .ndjson, .jsonl or .json",
+			filename)
+	}
+	return format, nil
+}
+
+// FormatMediaType is the media type of content in a format
+func FormatMediaType(format string) string {
+	return formatMediaTypes[format]
+}
+
+// NegotiateFormat chooses the format with the highest quality in an Accept header among the given formats, preferring
+// the first format when the header is empty. Media types are preferred over the wildcard ranges with the same
+// quality, and a wildcard range chooses the first format it matches
+func NegotiateFormat(accept string, formats ...string) (string, error) {
+	if strings.TrimSpace(accept) == "" {
+		return formats[0], nil
+	}
+	bestFormat, bestQuality, bestIsRange := "", 0.0, false
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, hasQuality := params["q"]; hasQuality {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+		format, isMediaType := mediaTypeFormats[mediaType]
+		if !isMediaType || !containsFormat(formats, format) {
+			format = rangeFormat(mediaType, formats)
+		}
+		if format == "" || quality <= 0 {
+			continue
+		}
+		if quality > bestQuality || (quality == bestQuality && bestIsRange && isMediaType) {
+			bestFormat, bestQuality, bestIsRange = format, quality, !isMediaType
+		}
+	}
+	if bestFormat == "" {
+		mediaTypes := make([]string, len(formats))
+		for i, format := range formats {
+			mediaTypes[i] = formatMediaTypes[format]
+		}
+		return "", errors.Errorf("unsupported Accept header '%s', expected one of %s", accept,
+			strings.Join(mediaTypes, ", "))
+	}
+	return bestFormat, nil
+}
+
+// rangeFormat returns the first of the formats matching a wildcard media range such as text/*, or an empty string
+// when the media range is not a wildcard or matches none of the formats
+func rangeFormat(mediaRange string, formats []string) string {
+	if mediaRange == "*/*" {
+		return formats[0]
+	}
+	if !strings.HasSuffix(mediaRange, "/*") {
+		return ""
+	}
+	for _, format := range formats {
+		if strings.HasPrefix(formatMediaTypes[format], strings.TrimSuffix(mediaRange, "*")) {
+			return format
+		}
+	}
+	return ""
+}
+
+func containsFormat(formats []string, format string) bool {
+	for _, f := range formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	var testCases = []struct {
+		accept         string
+		expectedFormat string
+	}{
+		{accept: "", expectedFormat: FormatJSON},
+		{accept: "*/*", expectedFormat: FormatJSON},
+		{accept: "text/csv", expectedFormat: FormatCSV},
+		{accept: "text/*", expectedFormat: FormatCSV},
+		{accept: "application/x-ndjson, application/json;q=0.5", expectedFormat: FormatNDJSON},
+		{accept: "application/json;q=0.5, text/csv;q=0.9", expectedFormat: FormatCSV},
+		{accept: "*/*, application/x-ndjson", expectedFormat: FormatNDJSON},
+		{accept: "text/csv;q=0, application/json", expectedFormat: FormatJSON},
+		{accept: "application/yaml, application/*;q=0.5", expectedFormat: FormatJSON},
+		{accept: "text/html"},
+		{accept: "application/yaml"},
+		{accept: "text/csv;q=0"},
+	}
+	for _, test := range testCases {
+		format, err := NegotiateFormat(test.accept, ExportFormats...)
+		assert.Equal(t, test.expectedFormat, format, test.accept)
+		assert.Equal(t, test.expectedFormat == "", err != nil, test.accept)
+	}
+}
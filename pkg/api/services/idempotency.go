@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"net/http"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/database/models"
+	"organization_manager/pkg/database/repository"
+	"time"
+)
+
+const (
+	// IdempotencyKeyHeader holds the key a client chooses to identify a request and its retries
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on responses replayed for the retry of a completed request
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+	// DefaultIdempotencyKeyTTL is how long idempotency keys are kept when no TTL is configured
+	DefaultIdempotencyKeyTTL = 24 * time.Hour
+	// DefaultIdempotencyKeyLease is how long a request in progress holds its key when no lease is configured
+	DefaultIdempotencyKeyLease = time.Minute
+)
+
+// replayedHeaders are the response headers stored with an idempotency key, headers added by middleware such as CORS
+// headers are set again when a response is replayed
+var replayedHeaders = []string{"Content-Type", "ETag", "Warning"}
+
+// IdempotencyService lets clients safely retry requests by sending an Idempotency-Key header. The response to the
+// first request with a key is stored, and retries with the same key replay it instead of repeating the request
+type IdempotencyService struct {
+	repo repository.IdempotencyKeyRepository
+	// TTL is how long a key and its response are kept, a key can be reused for a new request once it expires
+	TTL time.Duration
+	// Lease is how long a request in progress holds its key. Retries are rejected as in progress until the lease
+	// ends, then a retry takes the key over as the request is assumed to have failed without releasing it
+	Lease time.Duration
+}
+
+func NewIdempotencyService(repo repository.IdempotencyKeyRepository) *IdempotencyService {
+	return &IdempotencyService{repo: repo, TTL: DefaultIdempotencyKeyTTL, Lease: DefaultIdempotencyKeyLease}
+}
+
+// Reservation is an idempotency key reserved by Begin for a request in progress, which must be completed with
+// Complete or released with Release
+type Reservation struct {
+	key models.IdempotencyKey
+}
+
+// StoredResponse is the response to a request made with an idempotency key
+type StoredResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// RequestHash identifies a request by its method, path, the format its response is rendered in and its body, so a
+// key reused for a different request is detected. Retries accepting another format are different requests, as the
+// stored response could not be replayed to them
+func RequestHash(method, path, format string, body []byte) string {
+	hash := sha256.New()
+	for _, part := range [][]byte{[]byte(method), []byte(path), []byte(format), body} {
+		hash.Write(part)
+		// parts are separated by a null byte so moving bytes between them changes the hash
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Begin reserves an idempotency key for a request, the request must then be completed with Complete or released with
+// Release. When the key was already used by the same request the stored response is returned to be replayed instead
+// Will return a *problems.Problem for invalid keys, keys reused for a different request and keys of requests still
+// in progress
+func (s *IdempotencyService) Begin(ctx context.Context, key, requestHash string) (*Reservation, *StoredResponse, error) {
+	if len(key) > maxIdempotencyKeyLength {
+		return nil, nil, problems.InvalidParameter(IdempotencyKeyHeader,
+			errors.Errorf("%s header cannot be longer than %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLength))
+	}
+
+	// the reservation time identifies the reservation, it is truncated to the precision Postgres stores
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	reservation := models.IdempotencyKey{
+		Key:         key,
+		RequestHash: requestHash,
+		CreatedAt:   now,
+		LockedUntil: now.Add(s.Lease),
+		ExpiresAt:   now.Add(s.TTL),
+	}
+	existing, err := s.repo.Reserve(ctx, &reservation)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error reserving idempotency key")
+	} else if existing == nil {
+		return &Reservation{key: reservation}, nil, nil
+	}
+
+	if existing.RequestHash != requestHash {
+		return nil, nil, problems.Errorf(http.StatusUnprocessableEntity, problems.CodeIdempotencyKeyReused,
+			"idempotency key '%s' was used for a different request", key)
+	}
+	if !existing.IsCompleted() {
+		return nil, nil, problems.Errorf(http.StatusConflict, problems.CodeRequestInProgress,
+			"a request with idempotency key '%s' is still in progress, retry the request later", key)
+	}
+	var header http.Header
+	err = json.Unmarshal(existing.Headers, &header)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error decoding stored response headers")
+	}
+	return nil, &StoredResponse{StatusCode: existing.StatusCode, Header: header, Body: existing.Body}, nil
+}
+
+// Release removes the key of a request that failed without a response to store, so it can be retried as a new
+// request without waiting for its lease to end
+func (s *IdempotencyService) Release(ctx context.Context, reservation *Reservation) error {
+	return errors.Wrap(s.repo.Release(ctx, &reservation.key), "error releasing idempotency key")
+}
+
+// Complete stores the response to the request of a key reserved by Begin. Responses to server errors are not stored,
+// the key is released instead so the request can be retried
+func (s *IdempotencyService) Complete(ctx context.Context, reservation *Reservation, resp StoredResponse) error {
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return s.Release(ctx, reservation)
+	}
+
+	header := http.Header{}
+	for _, name := range replayedHeaders {
+		if values := resp.Header.Values(name); len(values) > 0 {
+			header[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	headers, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	err = s.repo.Complete(ctx, &models.IdempotencyKey{
+		Key:        reservation.key.Key,
+		CreatedAt:  reservation.key.CreatedAt,
+		StatusCode: resp.StatusCode,
+		Headers:    headers,
+		Body:       resp.Body,
+	})
+	return errors.Wrap(err, "error storing idempotent response")
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/database/repository"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestHash(t *testing.T) {
+	hash := RequestHash(http.MethodPost, "/api/v1/organizations", FormatJSON, []byte(`{"name": "CLEAR"}`))
+	assert.Len(t, hash, 64)
+	assert.Equal(t, hash, RequestHash(http.MethodPost, "/api/v1/organizations", FormatJSON, []byte(`{"name": "CLEAR"}`)))
+	assert.NotEqual(t, hash, RequestHash(http.MethodPost, "/api/v1/organizations", FormatJSON, []byte(`{"name": "Acme"}`)))
+	assert.NotEqual(t, hash, RequestHash(http.MethodPost, "/api/v1/organizations", FormatCSV, []byte(`{"name": "CLEAR"}`)))
+	assert.NotEqual(t, RequestHash(http.MethodPost, "/a", "", []byte("b")), RequestHash(http.MethodPost, "/ab", "", nil))
+}
+
+func TestIdempotencyService(t *testing.T) {
+	ctx := context.Background()
+	service := NewIdempotencyService(repository.NewMemoryIdempotencyKeyRepository())
+
+	reservation, stored, err := service.Begin(ctx, "key-1", "hash")
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+	require.NotNil(t, reservation)
+
+	// retries of a request in progress are rejected
+	_, _, err = service.Begin(ctx, "key-1", "hash")
+	assert.Equal(t, http.StatusConflict, problems.Status(err))
+	assert.True(t, problems.HasCode(err, problems.CodeRequestInProgress))
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("ETag", `"1"`)
+	header.Set("Access-Control-Allow-Origin", "*")
+	require.NoError(t, service.Complete(ctx, reservation, StoredResponse{
+		StatusCode: http.StatusCreated,
+		Header:     header,
+		Body:       []byte(`{"name": "CLEAR"}`),
+	}))
+
+	reservation, stored, err = service.Begin(ctx, "key-1", "hash")
+	require.NoError(t, err)
+	assert.Nil(t, reservation)
+	require.NotNil(t, stored)
+	assert.Equal(t, http.StatusCreated, stored.StatusCode)
+	assert.Equal(t, http.Header{"Content-Type": {"application/json"}, "Etag": {`"1"`}}, stored.Header)
+	assert.Equal(t, `{"name": "CLEAR"}`, string(stored.Body))
+
+	_, _, err = service.Begin(ctx, "key-1", "other")
+	assert.Equal(t, http.StatusUnprocessableEntity, problems.Status(err))
+	assert.True(t, problems.HasCode(err, problems.CodeIdempotencyKeyReused))
+
+	_, _, err = service.Begin(ctx, strings.Repeat("k", 256), "hash")
+	assert.True(t, problems.HasCode(err, problems.CodeInvalidParameter))
+}
+
+func TestIdempotencyService_serverErrors(t *testing.T) {
+	ctx := context.Background()
+	service := NewIdempotencyService(repository.NewMemoryIdempotencyKeyRepository())
+
+	reservation, _, err := service.Begin(ctx, "key-1", "hash")
+	require.NoError(t, err)
+	require.NoError(t, service.Complete(ctx, reservation, StoredResponse{StatusCode: http.StatusInternalServerError}))
+
+	// the key is released so the request can be retried
+	reservation, stored, err := service.Begin(ctx, "key-1", "hash")
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+
+	// a request that failed without a response releases its key too
+	require.NoError(t, service.Release(ctx, reservation))
+	reservation, _, err = service.Begin(ctx, "key-1", "hash")
+	require.NoError(t, err)
+	assert.NotNil(t, reservation)
+}
+
+func TestIdempotencyService_lease(t *testing.T) {
+	ctx := context.Background()
+	service := NewIdempotencyService(repository.NewMemoryIdempotencyKeyRepository())
+	service.Lease = -time.Second
+
+	stale, _, err := service.Begin(ctx, "key-1", "hash")
+	require.NoError(t, err)
+
+	// a request that did not complete within its lease is assumed to have failed, a retry takes its key over
+	service.Lease = time.Minute
+	time.Sleep(time.Millisecond)
+	reservation, stored, err := service.Begin(ctx, "key-1", "hash")
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+	require.NotNil(t, reservation)
+	_, _, err = service.Begin(ctx, "key-1", "hash")
+	assert.True(t, problems.HasCode(err, problems.CodeRequestInProgress))
+
+	// the stale request cannot store its response over the retry's
+	err = service.Complete(ctx, stale, StoredResponse{StatusCode: http.StatusCreated, Header: http.Header{}})
+	assert.Equal(t, repository.ErrKeyNotReserved, errors.Cause(err))
+	require.NoError(t, service.Complete(ctx, reservation, StoredResponse{StatusCode: http.StatusCreated,
+		Header: http.Header{}}))
+}
+
+func TestIdempotencyService_expiry(t *testing.T) {
+	ctx := context.Background()
+	service := NewIdempotencyService(repository.NewMemoryIdempotencyKeyRepository())
+	service.TTL = -time.Second
+
+	reservation, _, err := service.Begin(ctx, "key-1", "hash")
+	require.NoError(t, err)
+	err = service.Complete(ctx, reservation, StoredResponse{StatusCode: http.StatusCreated, Header: http.Header{}})
+	assert.Equal(t, repository.ErrKeyNotReserved, errors.Cause(err))
+
+	// the expired key is handled as a new request, even when the request differs
+	_, stored, err := service.Begin(ctx, "key-1", "other")
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"net/url"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/database/models"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultFacetLimit       = 10
+	maxFacetLimit           = 100
+	facetsQueryParam        = "facets"
+	facetLimitQueryParam    = "facet_limit"
+	statsQueryParam         = "stats"
+	histogramQueryParam     = "histogram"
+	histogramParamSeparator = ":"
+)
+
+// GetOrganizationAggregates computes facet counts, statistics and histograms over the organizations matching the
+// same filter and search query parameters as GetOrganizations. Facets and stats default to every categorical and
+// continuous column when none of facets, stats or histogram are supplied
+// Will return a *problems.Problem for invalid requests
+func (s *OrganizationService) GetOrganizationAggregates(ctx context.Context, queryParams url.Values) (*models.AggregateResult, error) {
+	filter, err := getFilterQueryParams(queryParams)
+	if err != nil {
+		return nil, err
+	}
+	includeDeleted, err := getBoolQueryParam(queryParams, includeDeletedQueryParam, false)
+	if err != nil {
+		return nil, err
+	}
+
+	aggQuery := models.AggregateQuery{
+		Filter:         filter,
+		Search:         strings.TrimSpace(queryParams.Get(searchQueryParam)),
+		IncludeDeleted: includeDeleted,
+		FacetLimit:     defaultFacetLimit,
+	}
+	if limit := queryParams.Get(facetLimitQueryParam); limit != "" {
+		aggQuery.FacetLimit, err = strconv.Atoi(limit)
+		if err != nil || aggQuery.FacetLimit < 1 || aggQuery.FacetLimit > maxFacetLimit {
+			return nil, problems.InvalidParameter(facetLimitQueryParam, errors.Errorf(
+				"%s must be between 1 and %d, got '%s'", facetLimitQueryParam, maxFacetLimit, limit))
+		}
+	}
+	aggQuery.Facets, err = getAggregateColumnsQueryParam(queryParams, facetsQueryParam, false)
+	if err != nil {
+		return nil, problems.InvalidParameter(facetsQueryParam, err)
+	}
+	aggQuery.Stats, err = getAggregateColumnsQueryParam(queryParams, statsQueryParam, true)
+	if err != nil {
+		return nil, problems.InvalidParameter(statsQueryParam, err)
+	}
+	aggQuery.Histograms, err = getHistogramQueryParams(queryParams)
+	if err != nil {
+		return nil, problems.InvalidParameter(histogramQueryParam, err)
+	}
+	if aggQuery.Facets == nil && aggQuery.Stats == nil && aggQuery.Histograms == nil {
+		aggQuery.Facets, aggQuery.Stats = aggregateColumns(false), aggregateColumns(true)
+	}
+
+	result, err := s.repo.Aggregate(ctx, aggQuery)
+	if err != nil {
+		log.Errorf("error aggregating organizations: %v", err)
+		return nil, err
+	}
+	return result, nil
+}
+
+// getAggregateColumnsQueryParam parses a comma separated query parameter of columns to aggregate, which must all be
+// continuous or all be categorical. It returns nil if the parameter is not supplied
+func getAggregateColumnsQueryParam(queryParams url.Values, queryParam string, continuous bool) ([]string, error) {
+	columnParams, isSupplied := queryParams[queryParam]
+	if !isSupplied {
+		return nil, nil
+	}
+	columns := []string{}
+	seenColumns := map[string]bool{}
+	for _, columnParam := range columnParams {
+		for _, column := range strings.Split(columnParam, fieldSeparator) {
+			column = strings.TrimSpace(column)
+			isContinuous, isColumn := models.OrganizationColumnNamesContinuousMap[column]
+			if !isColumn {
+				return nil, errors.Errorf("invalid %s column '%s'", queryParam, column)
+			}
+			if isContinuous != continuous {
+				return nil, errors.Errorf("%s column '%s' must be %s", queryParam, column, columnKind(continuous))
+			}
+			if seenColumns[column] {
+				return nil, errors.Errorf("duplicate %s column '%s'", queryParam, column)
+			}
+			seenColumns[column] = true
+			columns = append(columns, column)
+		}
+	}
+	return columns, nil
+}
+
+// getHistogramQueryParams parses the repeatable histogram query parameter. Integer columns are bucketed by a width,
+// for example histogram=employee_count:100, and timestamp columns by year or month, for example
+// histogram=creation_date:year. It returns nil if the parameter is not supplied
+func getHistogramQueryParams(queryParams url.Values) ([]models.HistogramQuery, error) {
+	var histograms []models.HistogramQuery
+	seenColumns := map[string]bool{}
+	for _, histogramParam := range queryParams[histogramQueryParam] {
+		parts := strings.Split(histogramParam, histogramParamSeparator)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid histogram '%s', expected column:bucket", histogramParam)
+		}
+		histogram := models.HistogramQuery{DBfield: parts[0]}
+		field, isField := models.OrganizationFields[histogram.DBfield]
+		if !isField {
+			return nil, errors.Errorf("invalid histogram column '%s'", histogram.DBfield)
+		}
+		switch field.Type {
+		case models.IntegerField:
+			width, err := strconv.Atoi(parts[1])
+			if err != nil || width < 1 {
+				return nil, errors.Errorf("invalid histogram width '%s' for column '%s', expected a positive integer",
+					parts[1], histogram.DBfield)
+			}
+			histogram.Width = width
+		case models.TimestampField:
+			if parts[1] != models.YearInterval && parts[1] != models.MonthInterval {
+				return nil, errors.Errorf("invalid histogram interval '%s' for column '%s', expected %s or %s",
+					parts[1], histogram.DBfield, models.YearInterval, models.MonthInterval)
+			}
+			histogram.Interval = parts[1]
+		default:
+			return nil, errors.Errorf("histogram column '%s' must be continuous", histogram.DBfield)
+		}
+		if seenColumns[histogram.DBfield] {
+			return nil, errors.Errorf("duplicate histogram column '%s'", histogram.DBfield)
+		}
+		seenColumns[histogram.DBfield] = true
+		histograms = append(histograms, histogram)
+	}
+	return histograms, nil
+}
+
+// aggregateColumns returns the continuous or categorical columns in alphabetical order
+func aggregateColumns(continuous bool) []string {
+	var columns []string
+	for column, isContinuous := range models.OrganizationColumnNamesContinuousMap {
+		if isContinuous == continuous {
+			columns = append(columns, column)
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+func columnKind(continuous bool) string {
+	if continuous {
+		return "continuous"
+	}
+	return "categorical"
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/database/models"
+	"organization_manager/pkg/database/repository"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOrganizationService_GetOrganizationAggregates(t *testing.T) {
+	ctx := context.Background()
+	service := NewOrganizationService(repository.NewMemoryOrganizationRepository())
+	for _, org := range []string{
+		`{"name": "Acme", "employee_count": 10, "is_public": true, "creation_date": "2002-06-01T00:00:00Z"}`,
+		`{"name": "Acme Corp", "employee_count": 50, "is_public": false, "creation_date": "2010-03-01T00:00:00Z"}`,
+		`{"name": "Globex", "employee_count": 120, "is_public": true, "creation_date": "2010-06-01T00:00:00Z"}`,
+	} {
+		_, _, err := service.SaveNewOrganization(ctx, strings.NewReader(org))
+		require.NoError(t, err)
+	}
+
+	// every column is aggregated by default
+	result, err := service.GetOrganizationAggregates(ctx, url.Values{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), result.Count)
+	assert.Equal(t, 2, len(result.Facets))
+	assert.Equal(t, 3, len(result.Facets["name"]))
+	assert.Equal(t, []models.FacetBucket{{Value: true, Count: 2}, {Value: false, Count: 1}}, result.Facets["is_public"])
+	assert.Equal(t, 2, len(result.Stats))
+	assert.Equal(t, 120, result.Stats["employee_count"].Max)
+	assert.Empty(t, result.Histograms)
+
+	result, err = service.GetOrganizationAggregates(ctx, url.Values{
+		filterQueryParam:     {"name:Acme*"},
+		facetsQueryParam:     {"name"},
+		facetLimitQueryParam: {"1"},
+		histogramQueryParam:  {"employee_count:25", "creation_date:month"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), result.Count)
+	assert.Equal(t, []models.FacetBucket{{Value: "Acme", Count: 1}}, result.Facets["name"])
+	assert.Empty(t, result.Stats)
+	assert.Equal(t, []models.HistogramBucket{
+		{Start: 0, End: 25, Count: 1},
+		{Start: 50, End: 75, Count: 1},
+	}, result.Histograms["employee_count"])
+	assert.Equal(t, []models.HistogramBucket{
+		{Start: time.Date(2002, 6, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2002, 7, 1, 0, 0, 0, 0, time.UTC), Count: 1},
+		{Start: time.Date(2010, 3, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2010, 4, 1, 0, 0, 0, 0, time.UTC), Count: 1},
+	}, result.Histograms["creation_date"])
+
+	result, err = service.GetOrganizationAggregates(ctx, url.Values{
+		searchQueryParam: {"globex"},
+		statsQueryParam:  {"employee_count"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.Count)
+	assert.Empty(t, result.Facets)
+	assert.Equal(t, 120.0, result.Stats["employee_count"].Avg)
+
+	for _, queryParams := range []url.Values{
+		{facetsQueryParam: {"revenue"}},
+		{facetsQueryParam: {"employee_count"}},
+		{facetsQueryParam: {"name,name"}},
+		{statsQueryParam: {"is_public"}},
+		{facetLimitQueryParam: {"0"}},
+		{facetLimitQueryParam: {"101"}},
+		{histogramQueryParam: {"employee_count"}},
+		{histogramQueryParam: {"employee_count:0"}},
+		{histogramQueryParam: {"creation_date:week"}},
+		{histogramQueryParam: {"name:10"}},
+		{histogramQueryParam: {"employee_count:10", "employee_count:20"}},
+		{filterQueryParam: {"revenue:10"}},
+		{includeDeletedQueryParam: {"maybe"}},
+	} {
+		_, err = service.GetOrganizationAggregates(ctx, queryParams)
+		assert.Error(t, err, queryParams)
+		assert.Equal(t, http.StatusBadRequest, problems.Status(err))
+	}
+}
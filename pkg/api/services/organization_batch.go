@@ -0,0 +1,226 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/api/validation"
+	"organization_manager/pkg/database/models"
+)
+
+const (
+	// maxBatchItems is the number of organizations that can be created by one batch request
+	maxBatchItems       = 5000
+	atomicQueryParam    = "atomic"
+	batchNotCreatedText = "not created as another organization in the atomic batch failed"
+	// internalItemErrorText replaces the errors of organizations that could not be created because of an internal
+	// error, which are logged instead
+	internalItemErrorText = "not created because of an internal error"
+)
+
+// BatchItemResult is the outcome of creating one organization of a batch, in the order of the request. Code is the
+// problem code of an organization that was not created
+type BatchItemResult struct {
+	Index        int                          `json:"index"`
+	Status       int                          `json:"status"`
+	Organization *models.Organization         `json:"organization,omitempty"`
+	Code         string                       `json:"code,omitempty"`
+	Error        string                       `json:"error,omitempty"`
+	Duplicates   []models.SimilarOrganization `json:"duplicates,omitempty"`
+}
+
+type BatchCreateResponse struct {
+	Results []BatchItemResult `json:"results"`
+	// Atomic is set when every organization of the batch was created together
+	Atomic bool `json:"-"`
+}
+
+// BatchCreateError is returned when an atomic batch is rolled back, it holds the result of every item so the items
+// that caused the failure can be found. Err is set when the database failed rather than an item being invalid
+type BatchCreateError struct {
+	Results []BatchItemResult
+	Err     error
+}
+
+func (e *BatchCreateError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("no organizations were created: %v", e.Err)
+	}
+	for _, result := range e.Results {
+		if result.Error != "" && result.Error != batchNotCreatedText {
+			return fmt.Sprintf("no organizations were created, organization %d failed: %s", result.Index,
+				result.Error)
+		}
+	}
+	return "no organizations were created"
+}
+
+// SaveNewOrganizations deserializes a POST request containing an array of organizations and creates them in batches.
+// With the atomic query parameter every organization is created or none are, otherwise each organization is created
+// independently and the result of each is returned. An atomic batch that is rolled back returns a problem caused by
+// a BatchCreateError
+// Will return a *problems.Problem for invalid requests
+func (s *OrganizationService) SaveNewOrganizations(ctx context.Context, requestContent io.Reader, queryParams url.Values) (*BatchCreateResponse, error) {
+	atomic, err := getBoolQueryParam(queryParams, atomicQueryParam, false)
+	if err != nil {
+		return nil, err
+	}
+	var items []json.RawMessage
+	err = json.NewDecoder(requestContent).Decode(&items)
+	if err != nil {
+		log.Errorf("error deserializing batch request body: %v", err)
+		return nil, problems.InvalidBody(errors.Wrap(err, "invalid request body, expected an array of organizations"))
+	}
+	if len(items) == 0 || len(items) > maxBatchItems {
+		return nil, problems.InvalidBody(errors.Errorf("a batch must contain between 1 and %d organizations, got %d",
+			maxBatchItems, len(items)))
+	}
+
+	results := make([]BatchItemResult, len(items))
+	var decoded []models.Organization
+	var decodedIndexes []int
+	failed := false
+	for i, item := range items {
+		results[i] = BatchItemResult{Index: i, Status: http.StatusCreated}
+		org, err := decodeOrganization(bytes.NewReader(item), uuid.Nil, validation.Create)
+		if err != nil {
+			results[i].setError(err)
+			failed = true
+			continue
+		}
+		decoded = append(decoded, org)
+		decodedIndexes = append(decodedIndexes, i)
+	}
+
+	checks, err := s.checkBatchDuplicates(ctx, decoded, func(i int) string {
+		return fmt.Sprintf("item %d", decodedIndexes[i])
+	})
+	if err != nil {
+		if atomic {
+			return nil, atomicBatchFailure(results, decodedIndexes, err)
+		}
+		for _, i := range decodedIndexes {
+			results[i].setError(err)
+		}
+		return &BatchCreateResponse{Results: results}, nil
+	}
+	var orgs []models.Organization
+	var orgIndexes []int
+	for i, check := range checks {
+		result := &results[decodedIndexes[i]]
+		result.Duplicates = check.Existing
+		if check.Err != nil {
+			result.setError(check.Err)
+			failed = true
+			continue
+		}
+		orgs = append(orgs, decoded[i])
+		orgIndexes = append(orgIndexes, decodedIndexes[i])
+	}
+
+	if atomic {
+		if !failed {
+			err = s.repo.CreateBatch(ctx, orgs)
+		}
+		if failed || err != nil {
+			return nil, atomicBatchFailure(results, orgIndexes, err)
+		}
+		setBatchOrganizations(results, orgs, orgIndexes)
+		addBatchDuplicates(results, checks, decodedIndexes)
+		return &BatchCreateResponse{Results: results, Atomic: true}, nil
+	}
+
+	if len(orgs) > 0 {
+		err = s.repo.CreateBatch(ctx, orgs)
+		if err != nil {
+			// the batch was rolled back, so the organizations are created one by one to find the ones that fail
+			log.Errorf("error saving batch of new organizations, retrying individually: %v", err)
+			s.createEach(ctx, results, orgs, orgIndexes)
+		} else {
+			setBatchOrganizations(results, orgs, orgIndexes)
+		}
+		addBatchDuplicates(results, checks, decodedIndexes)
+	}
+	return &BatchCreateResponse{Results: results}, nil
+}
+
+// createEach creates the organizations of a failed batch individually, recording the outcome of each in results
+func (s *OrganizationService) createEach(ctx context.Context, results []BatchItemResult, orgs []models.Organization, orgIndexes []int) {
+	for i := range orgs {
+		result := &results[orgIndexes[i]]
+		err := s.repo.Create(ctx, &orgs[i])
+		if err != nil {
+			log.Errorf("error saving new organization %d of batch: %v", orgIndexes[i], err)
+			result.setError(err)
+			continue
+		}
+		result.Organization = &orgs[i]
+	}
+}
+
+// atomicBatchFailure records the outcome of the valid organizations of a rolled back atomic batch and returns the
+// problem of the batch, which has the status of the first invalid organization or 500 if the database failed
+func atomicBatchFailure(results []BatchItemResult, orgIndexes []int, dbErr error) error {
+	status := http.StatusInternalServerError
+	if dbErr != nil {
+		log.Errorf("error saving atomic batch of new organizations: %v", dbErr)
+	} else {
+		for _, result := range results {
+			if result.Error != "" {
+				status = result.Status
+				break
+			}
+		}
+	}
+	for _, i := range orgIndexes {
+		if dbErr != nil {
+			results[i].setError(dbErr)
+		} else {
+			results[i].Status, results[i].Code, results[i].Error = http.StatusFailedDependency, problems.CodeBatchFailed,
+				batchNotCreatedText
+		}
+	}
+	return problems.New(status, problems.CodeBatchFailed, &BatchCreateError{Results: results, Err: dbErr})
+}
+
+// setError records why an organization was not created, errors that are not problems are internal errors so they
+// are not exposed
+func (r *BatchItemResult) setError(err error) {
+	problem := problems.From(err)
+	r.Status, r.Code, r.Error = problem.Status, problem.Code, problem.Detail
+	if problem.Status == http.StatusInternalServerError {
+		r.Error = internalItemErrorText
+	}
+}
+
+func setBatchOrganizations(results []BatchItemResult, orgs []models.Organization, orgIndexes []int) {
+	for i := range orgs {
+		results[orgIndexes[i]].Organization = &orgs[i]
+	}
+}
+
+// addBatchDuplicates adds the organizations of the batch with similar names that were created to the duplicates of
+// each organization that was created, once they have IDs
+func addBatchDuplicates(results []BatchItemResult, checks []batchDuplicates, decodedIndexes []int) {
+	for i, check := range checks {
+		result := &results[decodedIndexes[i]]
+		if result.Organization == nil {
+			continue
+		}
+		for _, match := range check.Earlier {
+			earlier := results[decodedIndexes[match.Index]].Organization
+			if earlier != nil {
+				result.Duplicates = append(result.Duplicates,
+					models.SimilarOrganization{Organization: *earlier, Similarity: match.Similarity})
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/database/models"
+	"organization_manager/pkg/database/repository"
+	"strings"
+	"testing"
+)
+
+func TestOrganizationService_SaveNewOrganizations(t *testing.T) {
+	ctx := context.Background()
+	service := NewOrganizationService(repository.NewMemoryOrganizationRepository())
+	atomic := url.Values{atomicQueryParam: {"true"}}
+
+	// an invalid organization rolls back an atomic batch
+	_, err := service.SaveNewOrganizations(ctx, strings.NewReader(`[{"name": "Acme"}, {"name": 1}]`), atomic)
+	assert.Equal(t, http.StatusBadRequest, problems.Status(err))
+	var batchErr *BatchCreateError
+	require.True(t, errors.As(err, &batchErr))
+	assert.Equal(t, http.StatusFailedDependency, batchErr.Results[0].Status)
+	assert.Equal(t, http.StatusBadRequest, batchErr.Results[1].Status)
+	assert.Nil(t, batchErr.Results[0].Organization)
+	result, err := service.repo.Aggregate(ctx, models.AggregateQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), result.Count)
+
+	resp, err := service.SaveNewOrganizations(ctx, strings.NewReader(`[{"name": "Acme"}, {"name": "Globex"}]`),
+		atomic)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(resp.Results))
+	assert.Equal(t, "Globex", resp.Results[1].Organization.Name)
+
+	// without atomic each organization is created independently
+	resp, err = service.SaveNewOrganizations(ctx,
+		strings.NewReader(`[{"name": "Initech"}, {"name": 1}, {"name": "Umbrella"}]`), url.Values{})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(resp.Results))
+	for i, expectedStatus := range []int{http.StatusCreated, http.StatusBadRequest, http.StatusCreated} {
+		assert.Equal(t, i, resp.Results[i].Index)
+		assert.Equal(t, expectedStatus, resp.Results[i].Status)
+	}
+	assert.NotEmpty(t, resp.Results[1].Error)
+	org, err := service.GetOrganization(ctx, resp.Results[2].Organization.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, "Umbrella", org.Name)
+
+	// organizations rejected by the duplicate check fail individually
+	service.DuplicateCheck = DuplicateCheck{Mode: DuplicateCheckReject}
+	resp, err = service.SaveNewOrganizations(ctx, strings.NewReader(`[{"name": "ACME"}, {"name": "Hooli"}]`),
+		url.Values{})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.Results[0].Status)
+	assert.Equal(t, 1, len(resp.Results[0].Duplicates))
+	assert.Equal(t, http.StatusCreated, resp.Results[1].Status)
+
+	for _, body := range []string{`[]`, `{"name": "Acme"}`, `[{"name": "Acme"}`} {
+		_, err = service.SaveNewOrganizations(ctx, strings.NewReader(body), url.Values{})
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusBadRequest, problems.Status(err))
+	}
+	_, err = service.SaveNewOrganizations(ctx, strings.NewReader(`[{"name": "Acme"}]`),
+		url.Values{atomicQueryParam: {"yes"}})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, problems.Status(err))
+}
+
+// countingSimilarRepository counts the similarity queries of the duplicate check
+type countingSimilarRepository struct {
+	*repository.MemoryOrganizationRepository
+	queries int
+}
+
+func (r *countingSimilarRepository) FindSimilar(ctx context.Context, query models.SimilarNameQuery) ([]models.SimilarOrganization, error) {
+	r.queries++
+	return r.MemoryOrganizationRepository.FindSimilar(ctx, query)
+}
+
+func (r *countingSimilarRepository) FindSimilarBatch(ctx context.Context, query models.SimilarNamesQuery) ([][]models.SimilarOrganization, error) {
+	r.queries++
+	return r.MemoryOrganizationRepository.FindSimilarBatch(ctx, query)
+}
+
+func TestOrganizationService_SaveNewOrganizations_duplicates(t *testing.T) {
+	ctx := context.Background()
+	repo := &countingSimilarRepository{MemoryOrganizationRepository: repository.NewMemoryOrganizationRepository()}
+	service := NewOrganizationService(repo)
+	_, _, err := service.SaveNewOrganization(ctx, strings.NewReader(`{"name": "Acme"}`))
+	require.NoError(t, err)
+
+	// the whole batch is checked with one query, and later organizations are compared with earlier ones
+	service.DuplicateCheck = DuplicateCheck{Mode: DuplicateCheckWarn}
+	repo.queries = 0
+	resp, err := service.SaveNewOrganizations(ctx,
+		strings.NewReader(`[{"name": "Soylent"}, {"name": "ACME"}, {"name": 1}, {"name": "SOYLENT"}]`), url.Values{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, repo.queries)
+	assert.Empty(t, resp.Results[0].Duplicates)
+	require.Len(t, resp.Results[1].Duplicates, 1)
+	assert.Equal(t, "Acme", resp.Results[1].Duplicates[0].Organization.Name)
+	assert.Equal(t, http.StatusBadRequest, resp.Results[2].Status)
+	assert.Equal(t, http.StatusCreated, resp.Results[3].Status)
+	require.Len(t, resp.Results[3].Duplicates, 1)
+	assert.Equal(t, resp.Results[0].Organization.ID, resp.Results[3].Duplicates[0].Organization.ID)
+
+	// in reject mode an organization similar to an earlier one of the batch is rejected, but a rejected organization
+	// does not cause later ones to be rejected
+	service.DuplicateCheck = DuplicateCheck{Mode: DuplicateCheckReject}
+	resp, err = service.SaveNewOrganizations(ctx,
+		strings.NewReader(`[{"name": "Hooli"}, {"name": "HOOLI"}, {"name": "acme"}, {"name": "Initech"}]`),
+		url.Values{})
+	require.NoError(t, err)
+	for i, expectedStatus := range []int{http.StatusCreated, http.StatusConflict, http.StatusConflict,
+		http.StatusCreated} {
+		assert.Equal(t, expectedStatus, resp.Results[i].Status, i)
+	}
+	assert.Contains(t, resp.Results[1].Error, "organizations of the same batch 'Hooli' (item 0)")
+	assert.Empty(t, resp.Results[1].Duplicates)
+	assert.Contains(t, resp.Results[2].Error, "existing organizations")
+
+	// the check fails an atomic batch when it is rejected within the batch
+	_, err = service.SaveNewOrganizations(ctx, strings.NewReader(`[{"name": "Globex"}, {"name": "GLOBEX"}]`),
+		url.Values{atomicQueryParam: {"true"}})
+	assert.Equal(t, http.StatusConflict, problems.Status(err))
+	_, err = service.SaveNewOrganizations(ctx, strings.NewReader(`[{"name": "Globex"}]`), url.Values{})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/database/models"
+	"organization_manager/pkg/database/repository"
+	"strings"
+	"time"
+)
+
+// exportColumns are the CSV columns of exports that do not select fields, in the order of the Organization struct
+var exportColumns = []string{models.IDField, "name", "creation_date", "employee_count", "is_public"}
+
+// OrganizationExport streams every organization matching a query, it is created by GetOrganizationExport
+type OrganizationExport struct {
+	repo  repository.OrganizationRepository
+	query models.OrganizationQuery
+	// Format is FormatCSV, FormatNDJSON or FormatJSON
+	Format string
+}
+
+// GetOrganizationExport parses the filter, search, sort, include_deleted and fields query parameters like
+// GetOrganizations, returning an export of every matching organization in the given format
+// Will return a *problems.Problem for invalid requests
+func (s *OrganizationService) GetOrganizationExport(queryParams url.Values, format string) (*OrganizationExport, error) {
+	if !containsFormat(ExportFormats, format) {
+		return nil, problems.Errorf(http.StatusNotAcceptable, problems.CodeNotAcceptable,
+			"unsupported export format '%s'", format)
+	}
+	includeDeleted, err := getBoolQueryParam(queryParams, includeDeletedQueryParam, false)
+	if err != nil {
+		return nil, err
+	}
+	sortFields, err := getSortQueryParam(queryParams)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := getFieldsQueryParam(queryParams)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := getFilterQueryParams(queryParams)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OrganizationExport{
+		repo: s.repo,
+		query: models.OrganizationQuery{
+			Search:         strings.TrimSpace(queryParams.Get(searchQueryParam)),
+			Sort:           sortFields,
+			IncludeDeleted: includeDeleted,
+			Fields:         fields,
+			Filter:         filter,
+		},
+		Format: format,
+	}, nil
+}
+
+// ContentType is the media type of the export's format
+func (e *OrganizationExport) ContentType() string {
+	return formatMediaTypes[e.Format]
+}
+
+// Write streams the organizations to w as they are read from the repository. Output is buffered, so nothing is
+// written to w when reading the first organizations fails
+func (e *OrganizationExport) Write(ctx context.Context, w io.Writer) error {
+	buffered := bufio.NewWriter(w)
+	var err error
+	switch e.Format {
+	case FormatCSV:
+		err = e.writeCSV(ctx, buffered)
+	case FormatNDJSON:
+		encoder := json.NewEncoder(buffered)
+		err = e.repo.Stream(ctx, e.query, func(org models.Organization) error {
+			return encoder.Encode(ProjectedOrganization{Organization: org, Fields: e.query.Fields})
+		})
+	default:
+		err = e.writeJSON(ctx, buffered)
+	}
+	if err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
+
+// writeCSV writes a header of the exported columns followed by a row for each organization
+func (e *OrganizationExport) writeCSV(ctx context.Context, w io.Writer) error {
+	columns := exportColumns
+	if e.query.Fields != nil {
+		columns = append([]string{models.IDField}, e.query.Fields...)
+	}
+	writer := csv.NewWriter(w)
+	err := writer.Write(columns)
+	if err != nil {
+		return err
+	}
+	record := make([]string, len(columns))
+	err = e.repo.Stream(ctx, e.query, func(org models.Organization) error {
+		for i, column := range columns {
+			record[i] = formatCSVValue(org.ColumnValue(column))
+		}
+		return writer.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeJSON writes the organizations as the elements of a JSON array
+func (e *OrganizationExport) writeJSON(ctx context.Context, w io.Writer) error {
+	separator := "["
+	err := e.repo.Stream(ctx, e.query, func(org models.Organization) error {
+		serialized, err := json.Marshal(ProjectedOrganization{Organization: org, Fields: e.query.Fields})
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, separator)
+		if err != nil {
+			return err
+		}
+		separator = ","
+		_, err = w.Write(serialized)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if separator == "[" {
+		_, err = io.WriteString(w, "[]")
+	} else {
+		_, err = io.WriteString(w, "]")
+	}
+	return err
+}
+
+// formatCSVValue formats a column value like the JSON representation of organizations, NULL values are left empty
+// so they are imported as null
+func formatCSVValue(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/database/repository"
+	"strings"
+	"testing"
+)
+
+func TestOrganizationService_GetOrganizationExport(t *testing.T) {
+	ctx := context.Background()
+	service := NewOrganizationService(repository.NewMemoryOrganizationRepository())
+	acme, _, err := service.SaveNewOrganization(ctx, strings.NewReader(
+		`{"name": "Acme, Inc.", "employee_count": 10, "is_public": true, "creation_date": "2002-09-22T00:00:00Z"}`))
+	require.NoError(t, err)
+	globex, _, err := service.SaveNewOrganization(ctx, strings.NewReader(`{"name": "Globex", "employee_count": 120}`))
+	require.NoError(t, err)
+
+	var testCases = []struct {
+		queryParams    url.Values
+		format         string
+		expectedOutput string
+	}{
+		{
+			queryParams: url.Values{sortQueryParam: {"name"}},
+			format:      FormatCSV,
+			expectedOutput: "id,name,creation_date,employee_count,is_public\n" +
+				acme.ID.String() + ",\"Acme, Inc.\",2002-09-22T00:00:00Z,10,true\n" +
+				globex.ID.String() + ",Globex,,120,\n",
+		},
+		{
+			queryParams: url.Values{sortQueryParam: {"-employee_count"}, fieldsQueryParam: {"name"}},
+			format:      FormatNDJSON,
+			expectedOutput: `{"ID":"` + globex.ID.String() + `","name":"Globex"}` + "\n" +
+				`{"ID":"` + acme.ID.String() + `","name":"Acme, Inc."}` + "\n",
+		},
+		{
+			queryParams:    url.Values{filterQueryParam: {"name:Glob*"}, fieldsQueryParam: {"employee_count"}},
+			format:         FormatJSON,
+			expectedOutput: `[{"ID":"` + globex.ID.String() + `","employee_count":120}]`,
+		},
+		{
+			queryParams:    url.Values{filterQueryParam: {"name:Initech"}},
+			format:         FormatJSON,
+			expectedOutput: `[]`,
+		},
+		{
+			queryParams:    url.Values{filterQueryParam: {"name:Initech"}, fieldsQueryParam: {"name"}},
+			format:         FormatCSV,
+			expectedOutput: "id,name\n",
+		},
+	}
+	for _, test := range testCases {
+		export, err := service.GetOrganizationExport(test.queryParams, test.format)
+		require.NoError(t, err)
+		var output bytes.Buffer
+		require.NoError(t, export.Write(ctx, &output))
+		assert.Equal(t, test.expectedOutput, output.String())
+	}
+
+	export, err := service.GetOrganizationExport(url.Values{}, FormatNDJSON)
+	require.NoError(t, err)
+	assert.Equal(t, "application/x-ndjson", export.ContentType())
+
+	for _, queryParams := range []url.Values{
+		{filterQueryParam: {"revenue:10"}},
+		{sortQueryParam: {"revenue"}},
+		{fieldsQueryParam: {"revenue"}},
+		{includeDeletedQueryParam: {"maybe"}},
+	} {
+		_, err := service.GetOrganizationExport(queryParams, FormatCSV)
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusBadRequest, problems.Status(err))
+	}
+	_, err = service.GetOrganizationExport(url.Values{}, "xml")
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotAcceptable, problems.Status(err))
+}
+
+func TestOrganizationService_GetOrganizationExport_roundTrip(t *testing.T) {
+	ctx := context.Background()
+	service := NewOrganizationService(repository.NewMemoryOrganizationRepository())
+	for _, content := range []string{
+		`{"name": "Acme, Inc.", "employee_count": 10, "is_public": true, "creation_date": "2002-09-22T00:00:00Z"}`,
+		`{"name": "Globex", "employee_count": 120}`,
+	} {
+		_, _, err := service.SaveNewOrganization(ctx, strings.NewReader(content))
+		require.NoError(t, err)
+	}
+	exported, err := service.GetOrganizations(ctx, url.Values{sortQueryParam: {"name"}})
+	require.NoError(t, err)
+
+	// exports import as new organizations with the same fields, their IDs are ignored
+	for _, format := range []string{FormatCSV, FormatNDJSON} {
+		export, err := service.GetOrganizationExport(url.Values{}, format)
+		require.NoError(t, err)
+		var output bytes.Buffer
+		require.NoError(t, export.Write(ctx, &output))
+
+		importService := NewOrganizationService(repository.NewMemoryOrganizationRepository())
+		report, err := importService.ImportOrganizations(ctx, &output, ImportOptions{Format: format})
+		require.NoError(t, err)
+		assert.Equal(t, ImportReport{Rows: 2, Valid: 2, Created: 2, Errors: []ImportRowError{}}, *report)
+		imported, err := importService.GetOrganizations(ctx, url.Values{sortQueryParam: {"name"}})
+		require.NoError(t, err)
+		require.Len(t, imported.Organizations, 2)
+		for i, org := range imported.Organizations {
+			assert.NotEqual(t, exported.Organizations[i].ID, org.ID)
+			org.ID = exported.Organizations[i].ID
+			assert.Equal(t, exported.Organizations[i], org)
+		}
+	}
+}
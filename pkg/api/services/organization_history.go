@@ -0,0 +1,56 @@
+package services
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"net/url"
+	"organization_manager/pkg/database/models"
+)
+
+// OrganizationHistoryResponse is a page of the audit log of an organization, newest entries first
+type OrganizationHistoryResponse struct {
+	Entries    []models.OrganizationAuditEntry `json:"entries"`
+	Page       int                             `json:"page"`
+	PageSize   int                             `json:"page_size"`
+	TotalPages int                             `json:"total_pages"`
+	TotalCount int                             `json:"total_count"`
+}
+
+// GetOrganizationHistory returns a page of the audit log of an organization, which is kept after the organization is
+// deleted, merged or purged. The ID of a merged organization returns its own history, not the history of the
+// organization it was merged into
+// Will return a *problems.Problem for invalid requests and organizations without any history
+func (s *OrganizationService) GetOrganizationHistory(ctx context.Context, orgID string,
+	queryParams url.Values) (*OrganizationHistoryResponse, error) {
+
+	id, err := parseOrganizationID(orgID)
+	if err != nil {
+		return nil, err
+	}
+	page, pageSize, err := getPaginationQueryParams(queryParams)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.repo.History(ctx, models.AuditQuery{OrganizationID: id, Page: page, PageSize: pageSize})
+	if err != nil {
+		log.Errorf("error fetching the history of organization '%s': %v", orgID, err)
+		return nil, err
+	}
+	// every organization has at least the entry of its creation, so an empty history means it never existed
+	if result.TotalCount == 0 {
+		return nil, organizationNotFound(orgID)
+	}
+	entries := result.Entries
+	if entries == nil {
+		entries = []models.OrganizationAuditEntry{}
+	}
+	return &OrganizationHistoryResponse{
+		Entries:    entries,
+		Page:       page,
+		PageSize:   pageSize,
+		TotalPages: int(math.Ceil(float64(result.TotalCount) / float64(pageSize))),
+		TotalCount: int(result.TotalCount),
+	}, nil
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/database/models"
+	"organization_manager/pkg/database/repository"
+	"strings"
+	"testing"
+)
+
+func TestOrganizationService_GetOrganizationHistory(t *testing.T) {
+	ctx := models.WithAuditor(context.Background(), models.Auditor{Actor: models.AdminActor, ClaimedActor: "jane",
+		RequestID: "request-1"})
+	service := NewOrganizationService(repository.NewMemoryOrganizationRepository())
+	org, _, err := service.SaveNewOrganization(ctx, strings.NewReader(`{"name": "CLEAR", "employee_count": 10}`))
+	require.NoError(t, err)
+	_, err = service.PatchOrganization(ctx, org.ID.String(), "", strings.NewReader(`{"employee_count": 20}`))
+	require.NoError(t, err)
+	require.NoError(t, service.PurgeOrganization(ctx, org.ID.String()))
+
+	// the history is kept after the organization is purged
+	history, err := service.GetOrganizationHistory(ctx, org.ID.String(), url.Values{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, history.TotalCount)
+	assert.Equal(t, 1, history.TotalPages)
+	require.Len(t, history.Entries, 3)
+	assert.Equal(t, models.PurgeAuditAction, history.Entries[0].Action)
+	assert.Equal(t, models.UpdateAuditAction, history.Entries[1].Action)
+	assert.Equal(t, models.AdminActor, history.Entries[1].Actor)
+	assert.Equal(t, "jane", history.Entries[1].ClaimedActor)
+	assert.Equal(t, "request-1", history.Entries[1].RequestID)
+	assert.JSONEq(t, `{"employee_count": 20, "version": 2}`, string(history.Entries[1].After))
+	assert.Equal(t, models.CreateAuditAction, history.Entries[2].Action)
+
+	history, err = service.GetOrganizationHistory(ctx, org.ID.String(), url.Values{"page": {"2"}, "page_size": {"2"}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, history.Page)
+	assert.Equal(t, 2, history.TotalPages)
+	require.Len(t, history.Entries, 1)
+	assert.Equal(t, models.CreateAuditAction, history.Entries[0].Action)
+
+	history, err = service.GetOrganizationHistory(ctx, org.ID.String(), url.Values{"page": {"3"}})
+	require.NoError(t, err)
+	assert.Empty(t, history.Entries)
+
+	for _, test := range []struct {
+		orgID       string
+		queryParams url.Values
+		status      int
+	}{
+		{"not-a-uuid", url.Values{}, http.StatusBadRequest},
+		{org.ID.String(), url.Values{"page": {"0"}}, http.StatusBadRequest},
+		{"d9b2d63d-a233-4123-847a-7ac09bd3b1d3", url.Values{}, http.StatusNotFound},
+	} {
+		_, err := service.GetOrganizationHistory(ctx, test.orgID, test.queryParams)
+		assert.Error(t, err, test.orgID)
+		assert.Equal(t, test.status, problems.Status(err), test.orgID)
+	}
+}
@@ -0,0 +1,375 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/api/validation"
+	"organization_manager/pkg/database/models"
+	"sort"
+	"strings"
+)
+
+const (
+	// importBatchSize is the number of valid rows inserted together while importing
+	importBatchSize  = 500
+	dryRunQueryParam = "dry_run"
+	// maxImportLineSize is the longest NDJSON line that can be imported
+	maxImportLineSize = 1 << 20
+	// utf8BOM is stripped from the start of CSV headers, as spreadsheet applications often export it
+	utf8BOM = "\ufeff"
+)
+
+// csvHeaderReplacer normalizes CSV headers such as "Employee Count" to the JSON name of the organization field
+var csvHeaderReplacer = strings.NewReplacer(" ", "_", "-", "_")
+
+// ImportOptions configures how organizations are imported
+type ImportOptions struct {
+	// Format is FormatCSV or FormatNDJSON
+	Format string
+	// DryRun validates every row without creating any organizations
+	DryRun bool
+}
+
+// ImportRowError is the reason a row was not imported. Rows are numbered from 1, CSV rows exclude the header and
+// NDJSON rows are line numbers
+type ImportRowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// ImportReport summarizes an import. Rows that are valid but fail to be inserted are reported as errors too
+type ImportReport struct {
+	DryRun  bool             `json:"dry_run"`
+	Rows    int              `json:"rows"`
+	Valid   int              `json:"valid"`
+	Created int              `json:"created"`
+	Errors  []ImportRowError `json:"errors"`
+	// Error is set when the import stopped before the end of the content, the row it stopped at is reported in
+	// Errors and the rows after it were not read. Organizations created before it stopped are kept
+	Error string `json:"error,omitempty"`
+}
+
+// ImportFormat determines the format of import content from its media type, falling back to the extension of its
+// file name when the media type is missing or generic. Organizations can be imported from CSV or NDJSON
+func ImportFormat(contentType, filename string) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	format, isSupported := mediaTypeFormats[mediaType]
+	if err != nil || contentType == "" || mediaType == "application/octet-stream" || mediaType == "text/plain" {
+		format, err = FormatFromExtension(filename)
+		isSupported = err == nil
+	}
+	if !isSupported || (format != FormatCSV && format != FormatNDJSON) {
+		return "", errors.Errorf("unsupported import content type '%s', expected %s or %s", contentType,
+			formatMediaTypes[FormatCSV], formatMediaTypes[FormatNDJSON])
+	}
+	return format, nil
+}
+
+// GetImportOptions parses the dry_run query parameter and determines the format of import content
+// Will return a *problems.Problem for invalid requests
+func GetImportOptions(queryParams url.Values, contentType, filename string) (ImportOptions, error) {
+	var options ImportOptions
+	var err error
+	options.DryRun, err = getBoolQueryParam(queryParams, dryRunQueryParam, false)
+	if err != nil {
+		return options, err
+	}
+	options.Format, err = ImportFormat(contentType, filename)
+	if err != nil {
+		return options, problems.New(http.StatusUnsupportedMediaType, problems.CodeUnsupportedMediaType, err)
+	}
+	return options, nil
+}
+
+// ImportOrganizations streams CSV or NDJSON rows, validating each with the rules for creating an organization and
+// inserting the valid rows in batches. Invalid rows are reported without stopping the import, and batches that were
+// inserted are kept when a later row fails. Content that cannot be read further and internal errors stop the import,
+// the valid rows read until then are still inserted and the report of the rows read is returned
+// Will return a *problems.Problem for invalid requests
+func (s *OrganizationService) ImportOrganizations(ctx context.Context, content io.Reader, options ImportOptions) (*ImportReport, error) {
+	var rows importRowReader
+	var err error
+	switch options.Format {
+	case FormatCSV:
+		rows, err = newCSVRowReader(content)
+	case FormatNDJSON:
+		rows = newNDJSONRowReader(content)
+	default:
+		return nil, problems.Errorf(http.StatusUnsupportedMediaType, problems.CodeUnsupportedMediaType,
+			"unsupported import format '%s'", options.Format)
+	}
+	if err != nil {
+		return nil, problems.InvalidBody(err)
+	}
+
+	batch := importBatch{service: s, report: &ImportReport{DryRun: options.DryRun, Errors: []ImportRowError{}}}
+	for {
+		row, document, err := rows.next()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *importRowError
+		if errors.As(err, &rowErr) {
+			batch.report.Rows++
+			batch.addError(row, rowErr.err)
+			continue
+		} else if err != nil {
+			log.Errorf("error reading import content: %v", err)
+			batch.report.Rows++
+			batch.stop(row, errors.Wrap(err, "error reading content"))
+			break
+		}
+
+		batch.report.Rows++
+		// the duplicate check of the rows runs when their batch is flushed
+		org, err := decodeOrganization(bytes.NewReader(document), uuid.Nil, validation.Create)
+		if err != nil {
+			batch.addError(row, err)
+			continue
+		}
+		batch.report.Valid++
+		err = batch.add(ctx, row, org)
+		if err != nil {
+			break
+		}
+	}
+	_ = batch.flush(ctx)
+
+	sort.SliceStable(batch.report.Errors, func(i, j int) bool {
+		return batch.report.Errors[i].Row < batch.report.Errors[j].Row
+	})
+	return batch.report, nil
+}
+
+// importBatch collects valid rows of an import until they are inserted together
+type importBatch struct {
+	service *OrganizationService
+	report  *ImportReport
+	rows    []int
+	orgs    []models.Organization
+}
+
+// add collects a valid row, flushing the batch once it is full. It returns the error of the flush, which stops the
+// import
+func (b *importBatch) add(ctx context.Context, row int, org models.Organization) error {
+	b.rows = append(b.rows, row)
+	b.orgs = append(b.orgs, org)
+	if len(b.orgs) == importBatchSize {
+		return b.flush(ctx)
+	}
+	return nil
+}
+
+// flush runs the duplicate check of the collected rows together, then inserts the rows that pass it. When the batch
+// fails the rows are inserted one by one to find the ones that fail. An error of the duplicate check stops the import
+func (b *importBatch) flush(ctx context.Context) error {
+	defer func() {
+		b.rows, b.orgs = nil, nil
+	}()
+	if len(b.orgs) == 0 {
+		return nil
+	}
+	checks, err := b.service.checkBatchDuplicates(ctx, b.orgs, func(i int) string {
+		return fmt.Sprintf("row %d", b.rows[i])
+	})
+	if err != nil {
+		b.report.Valid -= len(b.rows)
+		for _, row := range b.rows[:len(b.rows)-1] {
+			b.addError(row, errors.New(internalItemErrorText))
+		}
+		b.stop(b.rows[len(b.rows)-1], errors.New(internalItemErrorText))
+		return err
+	}
+	var rows []int
+	var orgs []models.Organization
+	for i, check := range checks {
+		if check.Err != nil {
+			b.report.Valid--
+			b.addError(b.rows[i], check.Err)
+			continue
+		}
+		rows = append(rows, b.rows[i])
+		orgs = append(orgs, b.orgs[i])
+	}
+	if b.report.DryRun || len(orgs) == 0 {
+		return nil
+	}
+
+	err = b.service.repo.CreateBatch(ctx, orgs)
+	if err == nil {
+		b.report.Created += len(orgs)
+		return nil
+	}
+	log.Errorf("error importing batch of organizations, retrying individually: %v", err)
+	for i := range orgs {
+		err = b.service.repo.Create(ctx, &orgs[i])
+		if err != nil {
+			log.Errorf("error importing organization of row %d: %v", rows[i], err)
+			b.addError(rows[i], errors.New(internalItemErrorText))
+			continue
+		}
+		b.report.Created++
+	}
+	return nil
+}
+
+// stop records the error a row failed with as the reason the import stopped
+func (b *importBatch) stop(row int, err error) {
+	b.addError(row, err)
+	b.report.Error = errors.Wrapf(err, "import stopped at row %d", row).Error()
+}
+
+func (b *importBatch) addError(row int, err error) {
+	b.report.Errors = append(b.report.Errors, ImportRowError{Row: row, Error: err.Error()})
+}
+
+// importRowReader reads the rows of import content as serialized organizations
+type importRowReader interface {
+	// next returns the number and serialized organization of the next row. It returns io.EOF after the last row and
+	// an *importRowError when a row is invalid but the following rows can still be read
+	next() (int, []byte, error)
+}
+
+// importRowError is returned for a row that cannot be read as an organization
+type importRowError struct {
+	err error
+}
+
+func (e *importRowError) Error() string {
+	return e.err.Error()
+}
+
+// csvRowReader reads CSV rows, mapping each column to the organization field named by its header. Empty values are
+// left unset, and the id column of exports is ignored as imported organizations are assigned new IDs
+type csvRowReader struct {
+	reader  *csv.Reader
+	columns []string
+	row     int
+}
+
+// newCSVRowReader reads the header of CSV content and validates it names organization fields or the ID, including the
+// name
+func newCSVRowReader(content io.Reader) (*csvRowReader, error) {
+	reader := csv.NewReader(content)
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("invalid CSV content, expected a header row")
+	} else if err != nil {
+		return nil, errors.Wrap(err, "invalid CSV header")
+	}
+
+	columns := make([]string, len(header))
+	seenColumns := map[string]bool{}
+	for i, heading := range header {
+		column := csvHeaderReplacer.Replace(strings.ToLower(strings.TrimSpace(strings.TrimPrefix(heading, utf8BOM))))
+		if _, isField := models.OrganizationFields[column]; !isField && column != models.IDField {
+			return nil, errors.Errorf("invalid CSV header '%s', expected an organization field", heading)
+		}
+		if seenColumns[column] {
+			return nil, errors.Errorf("duplicate CSV header '%s'", heading)
+		}
+		seenColumns[column] = true
+		columns[i] = column
+	}
+	if !seenColumns["name"] {
+		return nil, errors.New("CSV header must include name")
+	}
+	return &csvRowReader{reader: reader, columns: columns}, nil
+}
+
+func (r *csvRowReader) next() (int, []byte, error) {
+	record, err := r.reader.Read()
+	if err == io.EOF {
+		return 0, nil, err
+	}
+	r.row++
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return r.row, nil, &importRowError{err: parseErr.Err}
+	} else if err != nil {
+		return r.row, nil, err
+	}
+
+	document := map[string]interface{}{}
+	for i, value := range record {
+		column := r.columns[i]
+		if strings.TrimSpace(value) == "" || column == models.IDField {
+			continue
+		}
+		parsedValue, err := models.OrganizationFields[column].ParseValue(value)
+		if err != nil {
+			return r.row, nil, &importRowError{err: errors.Errorf("invalid value '%s' for column '%s'", value, column)}
+		}
+		document[column] = parsedValue
+	}
+	serialized, err := json.Marshal(document)
+	if err != nil {
+		return r.row, nil, &importRowError{err: err}
+	}
+	return r.row, serialized, nil
+}
+
+// ndjsonRowReader reads one serialized organization from each line, skipping blank lines. The IDs of exported
+// organizations are removed, as imported organizations are assigned new IDs
+type ndjsonRowReader struct {
+	scanner *bufio.Scanner
+	row     int
+}
+
+func newNDJSONRowReader(content io.Reader) *ndjsonRowReader {
+	scanner := bufio.NewScanner(content)
+	scanner.Buffer(nil, maxImportLineSize)
+	return &ndjsonRowReader{scanner: scanner}
+}
+
+func (r *ndjsonRowReader) next() (int, []byte, error) {
+	for r.scanner.Scan() {
+		r.row++
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		return r.row, withoutID(line), nil
+	}
+	if r.scanner.Err() != nil {
+		return r.row + 1, nil, r.scanner.Err()
+	}
+	return 0, nil, io.EOF
+}
+
+// withoutID removes the ID from a serialized organization, whose members are matched to fields regardless of their
+// case. Content that is not a JSON object is returned unchanged, so decoding it reports why it is invalid
+func withoutID(document []byte) []byte {
+	var members map[string]json.RawMessage
+	if json.Unmarshal(document, &members) != nil {
+		return document
+	}
+	hasID := false
+	for name := range members {
+		if strings.EqualFold(name, models.IDField) {
+			delete(members, name)
+			hasID = true
+		}
+	}
+	if !hasID {
+		return document
+	}
+	serialized, err := json.Marshal(members)
+	if err != nil {
+		return document
+	}
+	return serialized
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/database/models"
+	"organization_manager/pkg/database/repository"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportFormat(t *testing.T) {
+	var testCases = []struct {
+		contentType    string
+		filename       string
+		expectedFormat string
+	}{
+		{contentType: "text/csv; charset=utf-8", expectedFormat: FormatCSV},
+		{contentType: "application/x-ndjson", filename: "orgs.csv", expectedFormat: FormatNDJSON},
+		{contentType: "application/octet-stream", filename: "orgs.JSONL", expectedFormat: FormatNDJSON},
+		{filename: "orgs.csv", expectedFormat: FormatCSV},
+		{contentType: "application/json", filename: "orgs.csv"},
+		{filename: "orgs.json"},
+		{filename: "orgs.xlsx"},
+	}
+	for _, test := range testCases {
+		format, err := ImportFormat(test.contentType, test.filename)
+		assert.Equal(t, test.expectedFormat, format)
+		assert.Equal(t, test.expectedFormat == "", err != nil)
+	}
+}
+
+func TestOrganizationService_ImportOrganizations(t *testing.T) {
+	ctx := context.Background()
+	service := NewOrganizationService(repository.NewMemoryOrganizationRepository())
+	csvContent := utf8BOM + "Name,Employee Count,IS_PUBLIC,creation_date\n" +
+		"Acme,10,true,2002-09-22\n" +
+		"Globex,lots,false,\n" +
+		"Initech,5\n" +
+		"Umbrella,,,2010-01-01T12:00:00Z\n"
+
+	report, err := service.ImportOrganizations(ctx, strings.NewReader(csvContent),
+		ImportOptions{Format: FormatCSV, DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, ImportReport{DryRun: true, Rows: 4, Valid: 2, Errors: []ImportRowError{
+		{Row: 2, Error: "invalid value 'lots' for column 'employee_count'"},
+		{Row: 3, Error: "wrong number of fields"},
+	}}, *report)
+	result, err := service.repo.Aggregate(ctx, models.AggregateQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), result.Count)
+
+	report, err = service.ImportOrganizations(ctx, strings.NewReader(csvContent),
+		ImportOptions{Format: FormatCSV})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Created)
+	assert.Equal(t, 2, len(report.Errors))
+	resp, err := service.GetOrganizations(ctx, url.Values{sortQueryParam: {"name"}})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(resp.Organizations))
+	assert.Equal(t, models.Organization{ID: resp.Organizations[0].ID, Name: "Acme", EmployeeCount: intPointer(10),
+		IsPublic: boolPointer(true), CreationDate: timePointer(time.Date(2002, 9, 22, 0, 0, 0, 0, time.UTC)), Version: 1},
+		resp.Organizations[0])
+	assert.Equal(t, timePointer(time.Date(2010, 1, 1, 12, 0, 0, 0, time.UTC)), resp.Organizations[1].CreationDate)
+
+	// NDJSON rows are numbered by line and rejected duplicates, of existing organizations or earlier rows, are
+	// reported as row errors
+	service.DuplicateCheck = DuplicateCheck{Mode: DuplicateCheckReject}
+	report, err = service.ImportOrganizations(ctx,
+		strings.NewReader("{\"name\": \"Hooli\"}\n\n{\"name\": \"ACME\"}\n{\"id\": \"1eacb0fa-d4ae-4d5e-9b69-268c1359db19\"}\n"+
+			"{\"name\": \"HOOLI\"}\n"),
+		ImportOptions{Format: FormatNDJSON})
+	require.NoError(t, err)
+	assert.Equal(t, 4, report.Rows)
+	assert.Equal(t, 1, report.Valid)
+	assert.Equal(t, 1, report.Created)
+	require.Equal(t, 3, len(report.Errors))
+	assert.Equal(t, 3, report.Errors[0].Row)
+	assert.Contains(t, report.Errors[0].Error, "similar to existing organizations")
+	assert.Equal(t, 4, report.Errors[1].Row)
+	assert.Equal(t, 5, report.Errors[2].Row)
+	assert.Contains(t, report.Errors[2].Error, "organizations of the same batch 'Hooli' (row 1)")
+
+	for _, content := range []string{"", "name,revenue\n", "name,Name\n", "employee_count\n"} {
+		_, err = service.ImportOrganizations(ctx, strings.NewReader(content), ImportOptions{Format: FormatCSV})
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusBadRequest, problems.Status(err))
+	}
+	_, err = service.ImportOrganizations(ctx, strings.NewReader(`{"name": "Hooli"}`), ImportOptions{})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusUnsupportedMediaType, problems.Status(err))
+}
+
+// failingSimilarRepository fails every duplicate check, as a database that stops responding would
+type failingSimilarRepository struct {
+	*repository.MemoryOrganizationRepository
+}
+
+func (r failingSimilarRepository) FindSimilarBatch(context.Context, models.SimilarNamesQuery) ([][]models.SimilarOrganization, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestOrganizationService_ImportOrganizations_stopped(t *testing.T) {
+	ctx := context.Background()
+	service := NewOrganizationService(repository.NewMemoryOrganizationRepository())
+
+	// rows read before content that cannot be read are still created and reported
+	content := "{\"name\": \"Hooli\"}\n{\"name\": \"" + strings.Repeat("x", maxImportLineSize) + "\"}\n{\"name\": \"Acme\"}\n"
+	report, err := service.ImportOrganizations(ctx, strings.NewReader(content), ImportOptions{Format: FormatNDJSON})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Rows)
+	assert.Equal(t, 1, report.Valid)
+	assert.Equal(t, 1, report.Created)
+	require.Equal(t, 1, len(report.Errors))
+	assert.Equal(t, 2, report.Errors[0].Row)
+	assert.Contains(t, report.Error, "import stopped at row 2")
+	resp, err := service.GetOrganizations(ctx, url.Values{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(resp.Organizations))
+
+	// internal errors stop the import after the batch of rows they failed, without describing the error
+	service = NewOrganizationService(failingSimilarRepository{repository.NewMemoryOrganizationRepository()})
+	service.DuplicateCheck = DuplicateCheck{Mode: DuplicateCheckWarn}
+	content = "name\n" + strings.Repeat("Hooli\n", importBatchSize+1)
+	report, err = service.ImportOrganizations(ctx, strings.NewReader(content), ImportOptions{Format: FormatCSV})
+	require.NoError(t, err)
+	assert.Equal(t, importBatchSize, report.Rows)
+	assert.Equal(t, 0, report.Valid)
+	assert.Equal(t, 0, report.Created)
+	require.Equal(t, importBatchSize, len(report.Errors))
+	assert.Equal(t, ImportRowError{Row: 1, Error: internalItemErrorText}, report.Errors[0])
+	assert.Contains(t, report.Error, fmt.Sprintf("import stopped at row %d", importBatchSize))
+	assert.NotContains(t, report.Error, "connection refused")
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/api/validation"
+	"organization_manager/pkg/database/models"
+	"organization_manager/pkg/database/repository"
+	"time"
+)
+
+// Fields of a merge request named by the problems of invalid merge requests
+const (
+	sourceIDField = "source_id"
+	rulesField    = "rules"
+)
+
+// MergeOrganizationRequest is the body of a POST /organizations/{id}/merge request, which merges the source
+// organization into the organization with the ID from the request path
+type MergeOrganizationRequest struct {
+	SourceID uuid.UUID `json:"source_id"`
+	// Rules maps organization fields to keep_target, keep_source, max or min. Fields without a rule keep the value of
+	// the target and max and min can only be used for continuous fields
+	Rules map[string]string `json:"rules"`
+}
+
+// MergeOrganization deserializes a merge request and merges its source organization into the organization with the ID
+// from the request path. The source organization is removed and its ID resolves to the returned surviving organization
+// Will return a *problems.Problem for invalid requests
+func (s *OrganizationService) MergeOrganization(ctx context.Context, orgID string, requestContent io.Reader) (*models.Organization, error) {
+	targetID, err := parseOrganizationID(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	var mergeRequest MergeOrganizationRequest
+	decoder := json.NewDecoder(requestContent)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&mergeRequest)
+	if err != nil {
+		log.Errorf("error deserializing organization merge request body: %v", err)
+		return nil, problems.InvalidBody(errors.Wrap(err, "invalid request body"))
+	}
+	if mergeRequest.SourceID == uuid.Nil {
+		return nil, problems.InvalidField(sourceIDField, errors.New("invalid request body: source_id is required"))
+	}
+	if mergeRequest.SourceID == targetID {
+		return nil, problems.InvalidField(sourceIDField, errors.New("an organization cannot be merged into itself"))
+	}
+	rules, err := newMergeRules(mergeRequest.Rules)
+	if err != nil {
+		return nil, problems.InvalidField(rulesField, err)
+	}
+
+	// both organizations are looked up first so a missing organization can be reported by its ID
+	for _, id := range []uuid.UUID{targetID, mergeRequest.SourceID} {
+		_, err := s.getOrganization(ctx, id.String())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	merge := models.OrganizationMerge{SourceID: mergeRequest.SourceID, TargetID: targetID}
+	merge.Rules, err = json.Marshal(rules)
+	if err != nil {
+		return nil, err
+	}
+	org, err := s.repo.Merge(ctx, &merge, func(target, source models.Organization) (models.Organization, error) {
+		return applyMergeRules(target, source, rules)
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, problems.Errorf(http.StatusNotFound, problems.CodeNotFound, "organization '%s' or '%s' not found",
+			targetID, mergeRequest.SourceID)
+	} else if err != nil {
+		log.Errorf("error merging organization '%s' into '%s': %v", mergeRequest.SourceID, targetID, err)
+		return nil, err
+	}
+
+	return org, nil
+}
+
+// newMergeRules validates the rules of a merge request against the organization field registry and returns the rule
+// for every field
+func newMergeRules(requestRules map[string]string) (map[string]string, error) {
+	for field, rule := range requestRules {
+		organizationField, isField := models.OrganizationFields[field]
+		if !isField {
+			return nil, errors.Errorf("invalid merge rule field '%s'", field)
+		}
+		switch rule {
+		case models.KeepTargetMergeRule, models.KeepSourceMergeRule:
+		case models.MaxMergeRule, models.MinMergeRule:
+			if !organizationField.Continuous {
+				return nil, errors.Errorf("cannot use merge rule '%s' for categorical field '%s'", rule, field)
+			}
+		default:
+			return nil, errors.Errorf("invalid merge rule '%s' for field '%s'", rule, field)
+		}
+	}
+
+	rules := make(map[string]string, len(models.OrganizationFields))
+	for field := range models.OrganizationFields {
+		rules[field] = models.KeepTargetMergeRule
+		if rule, hasRule := requestRules[field]; hasRule {
+			rules[field] = rule
+		}
+	}
+	return rules, nil
+}
+
+// applyMergeRules resolves every field of the target organization from the target or source organization. The merged
+// organization is built from their JSON representations and decoded with the same validation as updates
+func applyMergeRules(target, source models.Organization, rules map[string]string) (models.Organization, error) {
+	targetDocument, err := organizationDocument(target)
+	if err != nil {
+		return models.Organization{}, err
+	}
+	sourceDocument, err := organizationDocument(source)
+	if err != nil {
+		return models.Organization{}, err
+	}
+
+	for field, rule := range rules {
+		if useSourceValue(target.ColumnValue(field), source.ColumnValue(field), rule) {
+			targetDocument[field] = sourceDocument[field]
+		}
+	}
+
+	mergedContent, err := json.Marshal(targetDocument)
+	if err != nil {
+		return models.Organization{}, err
+	}
+	return decodeOrganization(bytes.NewReader(mergedContent), target.ID, validation.Create)
+}
+
+// organizationDocument returns the JSON representation of an organization as a deserialized JSON object
+func organizationDocument(org models.Organization) (map[string]interface{}, error) {
+	content, err := json.Marshal(org)
+	if err != nil {
+		return nil, err
+	}
+	var document map[string]interface{}
+	err = json.Unmarshal(content, &document)
+	return document, err
+}
+
+// useSourceValue determines if a merge rule resolves a field to the value of the source organization. Like the
+// Postgres max and min functions, the max and min rules ignore NULL values
+func useSourceValue(targetValue, sourceValue interface{}, rule string) bool {
+	switch rule {
+	case models.KeepSourceMergeRule:
+		return true
+	case models.MaxMergeRule, models.MinMergeRule:
+		if sourceValue == nil || targetValue == nil {
+			return targetValue == nil
+		}
+		var isGreater, isLess bool
+		switch targetValue := targetValue.(type) {
+		case int:
+			isGreater, isLess = sourceValue.(int) > targetValue, sourceValue.(int) < targetValue
+		case time.Time:
+			isGreater, isLess = sourceValue.(time.Time).After(targetValue), sourceValue.(time.Time).Before(targetValue)
+		}
+		if rule == models.MaxMergeRule {
+			return isGreater
+		}
+		return isLess
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/database/models"
+	"organization_manager/pkg/database/repository"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_applyMergeRules(t *testing.T) {
+	target := models.Organization{Name: "Acme Inc",
+		CreationDate: timePointer(time.Date(2002, 9, 22, 0, 0, 0, 0, time.UTC)), EmployeeCount: intPointer(10),
+		IsPublic: boolPointer(true)}
+	source := models.Organization{Name: "ACME, Inc.",
+		CreationDate: timePointer(time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)), EmployeeCount: intPointer(50),
+		IsPublic: boolPointer(false)}
+	nullSource := models.Organization{Name: "Acme"}
+
+	var testCases = []struct {
+		source         models.Organization
+		rules          map[string]string
+		expectedResult models.Organization
+	}{
+		{
+			source:         source,
+			rules:          map[string]string{},
+			expectedResult: target,
+		},
+		{
+			source: source,
+			rules: map[string]string{"name": "keep_source", "creation_date": "min", "employee_count": "max",
+				"is_public": "keep_target"},
+			expectedResult: models.Organization{Name: "ACME, Inc.", CreationDate: source.CreationDate,
+				EmployeeCount: intPointer(50), IsPublic: boolPointer(true)},
+		},
+		{
+			source: source,
+			rules:  map[string]string{"creation_date": "max", "employee_count": "min", "is_public": "keep_source"},
+			expectedResult: models.Organization{Name: "Acme Inc", CreationDate: target.CreationDate,
+				EmployeeCount: intPointer(10), IsPublic: boolPointer(false)},
+		},
+		{
+			// Testing max and min ignore null values, while keep_source copies them
+			source: nullSource,
+			rules:  map[string]string{"creation_date": "min", "employee_count": "max", "is_public": "keep_source"},
+			expectedResult: models.Organization{Name: "Acme Inc", CreationDate: target.CreationDate,
+				EmployeeCount: intPointer(10)},
+		},
+	}
+
+	for i, test := range testCases {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			rules, err := newMergeRules(test.rules)
+			require.NoError(t, err)
+			result, err := applyMergeRules(target, test.source, rules)
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedResult, result)
+		})
+	}
+
+	for _, rules := range []map[string]string{
+		{"revenue": "max"},
+		{"id": "keep_source"},
+		{"name": "max"},
+		{"is_public": "min"},
+		{"employee_count": "sum"},
+	} {
+		_, err := newMergeRules(rules)
+		assert.Error(t, err, rules)
+	}
+}
+
+func TestOrganizationService_MergeOrganization(t *testing.T) {
+	ctx := context.Background()
+	service := NewOrganizationService(repository.NewMemoryOrganizationRepository())
+	target, _, err := service.SaveNewOrganization(ctx, strings.NewReader(`{"name": "Acme Inc", "employee_count": 10}`))
+	require.NoError(t, err)
+	source, _, err := service.SaveNewOrganization(ctx, strings.NewReader(`{"name": "ACME, Inc.", "employee_count": 50}`))
+	require.NoError(t, err)
+
+	for _, body := range []string{
+		`{}`,
+		fmt.Sprintf(`{"source_id": "%s"}`, target.ID),
+		fmt.Sprintf(`{"source_id": "%s", "rules": {"name": "max"}}`, source.ID),
+		fmt.Sprintf(`{"source_id": "%s", "strategy": "max"}`, source.ID),
+	} {
+		_, err := service.MergeOrganization(ctx, target.ID.String(), strings.NewReader(body))
+		assert.Error(t, err, body)
+		assert.Equal(t, http.StatusBadRequest, problems.Status(err), body)
+	}
+	_, err = service.MergeOrganization(ctx, target.ID.String(),
+		strings.NewReader(`{"source_id": "d9b2d63d-a233-4123-847a-7ac09bd3b1d3"}`))
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, problems.Status(err))
+
+	merged, err := service.MergeOrganization(ctx, target.ID.String(), strings.NewReader(
+		fmt.Sprintf(`{"source_id": "%s", "rules": {"employee_count": "max"}}`, source.ID)))
+	require.NoError(t, err)
+	assert.Equal(t, target.ID, merged.ID)
+	assert.Equal(t, "Acme Inc", merged.Name)
+	assert.Equal(t, intPointer(50), merged.EmployeeCount)
+
+	// the source ID resolves to the survivor when fetched, but cannot be updated or merged again
+	org, err := service.GetOrganization(ctx, source.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, *merged, *org)
+	_, err = service.PatchOrganization(ctx, source.ID.String(), "", strings.NewReader(`{"employee_count": 1}`))
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, problems.Status(err))
+	_, err = service.MergeOrganization(ctx, target.ID.String(),
+		strings.NewReader(fmt.Sprintf(`{"source_id": "%s"}`, source.ID)))
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, problems.Status(err))
+	// once the survivor is purged, the source ID is reported as not found rather than the survivor's
+	require.NoError(t, service.PurgeOrganization(ctx, target.ID.String()))
+	_, err = service.GetOrganization(ctx, source.ID.String())
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, problems.Status(err))
+	assert.Contains(t, problems.From(err).Detail, source.ID.String())
+	assert.NotContains(t, problems.From(err).Detail, target.ID.String())
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/database/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Operators supported by the filter clauses of a search request
+const (
+	eqOperator      = "eq"
+	likeOperator    = "like"
+	iLikeOperator   = "ilike"
+	gtOperator      = "gt"
+	gteOperator     = "gte"
+	ltOperator      = "lt"
+	lteOperator     = "lte"
+	betweenOperator = "between"
+	inOperator      = "in"
+	notInOperator   = "not_in"
+	isNullOperator  = models.IsNull
+	notNullOperator = models.NotNull
+)
+
+// OrganizationSearchRequest is the body of a POST /organizations/search request. It supports the same sorting and
+// pagination as the GET /organizations query parameters, with every filter clause ANDed together
+type OrganizationSearchRequest struct {
+	Filters []SearchFilterClause `json:"filters"`
+	// Search is a full text search of organization names, matching results are ordered by relevance
+	Search string `json:"search"`
+	// Sort holds sort fields in the format of the sort query parameter, such as ["-employee_count", "name"]
+	Sort           []string `json:"sort"`
+	Page           *int     `json:"page"`
+	PageSize       *int     `json:"page_size"`
+	Cursor         string   `json:"cursor"`
+	IncludeDeleted bool     `json:"include_deleted"`
+	IncludeTotal   *bool    `json:"include_total"`
+	// Fields limits the fields of the returned organizations in the format of the fields query parameter, such as
+	// ["id", "name"]. The ID is always returned
+	Fields []string `json:"fields"`
+}
+
+// SearchFilterClause filters organizations on a single field. The between operator takes an inclusive [start, end]
+// pair in Values, in and not_in take a non empty list of Values, is_null and not_null take no value and every other
+// operator takes a single Value of the field's JSON type
+type SearchFilterClause struct {
+	Field    string        `json:"field"`
+	Operator string        `json:"operator"`
+	Value    interface{}   `json:"value"`
+	Values   []interface{} `json:"values"`
+}
+
+// SearchClauseError describes why a filter clause of a search request is invalid
+type SearchClauseError struct {
+	// Clause is the index of the clause in the filters array
+	Clause   int    `json:"clause"`
+	Field    string `json:"field"`
+	Operator string `json:"operator"`
+	Error    string `json:"error"`
+}
+
+// SearchValidationError is returned when one or more filter clauses of a search request are invalid
+type SearchValidationError struct {
+	Clauses []SearchClauseError
+}
+
+func (e *SearchValidationError) Error() string {
+	messages := make([]string, len(e.Clauses))
+	for i, clauseError := range e.Clauses {
+		messages[i] = fmt.Sprintf("filters[%d]: %s", clauseError.Clause, clauseError.Error)
+	}
+	return "invalid search filters: " + strings.Join(messages, "; ")
+}
+
+// SearchOrganizations deserializes a POST search request and returns the matching page of organizations. Every
+// invalid filter clause is reported in the invalid params of a problem caused by a SearchValidationError
+// Will return a *problems.Problem for invalid requests
+func (s *OrganizationService) SearchOrganizations(ctx context.Context, requestContent io.Reader) (*PaginatedOrganizationResponse, error) {
+	var searchRequest OrganizationSearchRequest
+	decoder := json.NewDecoder(requestContent)
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&searchRequest)
+	if err != nil {
+		log.Errorf("error deserializing organization search request body: %v", err)
+		return nil, problems.InvalidBody(errors.Wrap(err, "invalid request body"))
+	}
+
+	orgQuery := models.OrganizationQuery{
+		Search:         strings.TrimSpace(searchRequest.Search),
+		Page:           defaultPage,
+		PageSize:       defaultPageSize,
+		OmitTotalCount: searchRequest.IncludeTotal != nil && !*searchRequest.IncludeTotal,
+		IncludeDeleted: searchRequest.IncludeDeleted,
+	}
+	if searchRequest.Page != nil {
+		if *searchRequest.Page < 1 {
+			return nil, problems.InvalidField(pageQueryParam, errors.Errorf("page must be at least 1, got %d",
+				*searchRequest.Page))
+		}
+		orgQuery.Page = *searchRequest.Page
+	}
+	if searchRequest.PageSize != nil {
+		if *searchRequest.PageSize < 1 {
+			return nil, problems.InvalidField(pageSizeQueryParam, errors.Errorf("page_size must be at least 1, got %d",
+				*searchRequest.PageSize))
+		}
+		orgQuery.PageSize = *searchRequest.PageSize
+	}
+
+	orgQuery.Sort, err = parseSortFields(searchRequest.Sort)
+	if err != nil {
+		return nil, problems.InvalidField(sortQueryParam, err)
+	}
+	if searchRequest.Fields != nil {
+		orgQuery.Fields, err = parseFields(searchRequest.Fields)
+		if err != nil {
+			return nil, problems.InvalidField(fieldsQueryParam, err)
+		}
+	}
+
+	// a cursor switches to keyset pagination, continuing after the last organization of the previous page
+	if searchRequest.Cursor != "" {
+		if searchRequest.Page != nil {
+			return nil, problems.InvalidField(cursorQueryParam, errors.New("cursor and page cannot be combined"))
+		}
+		if strings.TrimSpace(searchRequest.Search) != "" {
+			return nil, problems.InvalidField(cursorQueryParam, errors.New("cursor and search cannot be combined"))
+		}
+		orgQuery.After, err = decodeCursor(searchRequest.Cursor, orgQuery.Sort)
+		if err != nil {
+			return nil, problems.InvalidField(cursorQueryParam, err)
+		}
+		orgQuery.Page = 0
+	}
+
+	var filters []models.FilterExpression
+	var validationErr SearchValidationError
+	for i, clause := range searchRequest.Filters {
+		filter, err := newSearchFilter(clause)
+		if err != nil {
+			validationErr.Clauses = append(validationErr.Clauses, SearchClauseError{
+				Clause:   i,
+				Field:    clause.Field,
+				Operator: clause.Operator,
+				Error:    err.Error(),
+			})
+			continue
+		}
+		filters = append(filters, filter)
+	}
+	if len(validationErr.Clauses) > 0 {
+		problem := problems.New(http.StatusBadRequest, problems.CodeValidationFailed, &validationErr)
+		for _, clauseError := range validationErr.Clauses {
+			problem.InvalidParams = append(problem.InvalidParams, problems.InvalidParam{
+				Name:   fmt.Sprintf("filters[%d]", clauseError.Clause),
+				Reason: clauseError.Error,
+			})
+		}
+		return nil, problem
+	}
+	if len(filters) > 0 {
+		orgQuery.Filter = models.AndExpression{Operands: filters}
+	}
+
+	return s.searchOrganizations(ctx, orgQuery)
+}
+
+// newSearchFilter validates a filter clause of a search request against the organization field registry and
+// converts it into a categorical or range filter
+func newSearchFilter(clause SearchFilterClause) (models.FilterExpression, error) {
+	field, isField := models.OrganizationFields[clause.Field]
+	if !isField {
+		return nil, errors.Errorf("invalid column name '%s'", clause.Field)
+	}
+
+	switch clause.Operator {
+	case eqOperator, likeOperator, iLikeOperator, gtOperator, gteOperator, ltOperator, lteOperator:
+		if clause.Values != nil {
+			return nil, errors.Errorf("operator '%s' takes a single value", clause.Operator)
+		}
+		value, err := searchFilterValue(clause.Field, clause.Value)
+		if err != nil {
+			return nil, err
+		}
+		return newSingleValueSearchFilter(clause, field, value)
+	case betweenOperator:
+		if clause.Value != nil || len(clause.Values) != 2 {
+			return nil, errors.Errorf("operator '%s' takes a pair of values", clause.Operator)
+		}
+		if !field.Continuous {
+			return nil, errors.Errorf("cannot supply range filter for categorical column '%s'", clause.Field)
+		}
+		start, err := searchFilterValue(clause.Field, clause.Values[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := searchFilterValue(clause.Field, clause.Values[1])
+		if err != nil {
+			return nil, err
+		}
+		return models.RangeQueryFilter{DBfield: clause.Field, StartRange: start, StartComparator: models.GTE,
+			EndRange: end, EndComparator: models.LTE}, nil
+	case inOperator, notInOperator:
+		if clause.Value != nil || len(clause.Values) == 0 {
+			return nil, errors.Errorf("operator '%s' takes a non empty list of values", clause.Operator)
+		}
+		values := make([]string, len(clause.Values))
+		for i, value := range clause.Values {
+			var err error
+			values[i], err = searchFilterValue(clause.Field, value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if clause.Operator == inOperator {
+			return models.CategoryQueryFilter{DBfield: clause.Field, InFilter: values}, nil
+		}
+		return models.CategoryQueryFilter{DBfield: clause.Field, NotInFilter: values}, nil
+	case isNullOperator, notNullOperator:
+		if clause.Value != nil || clause.Values != nil {
+			return nil, errors.Errorf("operator '%s' does not take a value", clause.Operator)
+		}
+		if !field.Nullable {
+			return nil, errors.Errorf("cannot supply %s filter for non nullable column '%s'", clause.Operator,
+				clause.Field)
+		}
+		return models.CategoryQueryFilter{DBfield: clause.Field, NullFilter: clause.Operator}, nil
+	}
+	return nil, errors.Errorf("invalid operator '%s'", clause.Operator)
+}
+
+// newSingleValueSearchFilter converts a filter clause with an operator that takes a single value, comparisons are
+// converted into range filters with an open bound
+func newSingleValueSearchFilter(clause SearchFilterClause, field models.OrganizationField, value string) (models.FilterExpression, error) {
+	switch clause.Operator {
+	case eqOperator:
+		return models.CategoryQueryFilter{DBfield: clause.Field, ExactFilter: value}, nil
+	case likeOperator:
+		if field.Type != models.TextField {
+			return nil, errors.Errorf("cannot use wildcards with non text column '%s'", clause.Field)
+		}
+		return models.CategoryQueryFilter{DBfield: clause.Field, LikeFilter: strings.ReplaceAll(value, "*", "%")}, nil
+	case iLikeOperator:
+		if field.Type != models.TextField {
+			return nil, errors.Errorf("cannot use wildcards with non text column '%s'", clause.Field)
+		}
+		return models.CategoryQueryFilter{DBfield: clause.Field, ILikeFilter: strings.ReplaceAll(value, "*", "%")}, nil
+	}
+
+	if !field.Continuous {
+		return nil, errors.Errorf("cannot supply range filter for categorical column '%s'", clause.Field)
+	}
+	rangeFilter := models.RangeQueryFilter{
+		DBfield:         clause.Field,
+		StartRange:      models.OpenRangeDelimiter,
+		StartComparator: models.GTE,
+		EndRange:        models.OpenRangeDelimiter,
+		EndComparator:   models.LTE,
+	}
+	switch clause.Operator {
+	case gtOperator, gteOperator:
+		rangeFilter.StartRange = value
+		if clause.Operator == gtOperator {
+			rangeFilter.StartComparator = models.GT
+		}
+	default:
+		rangeFilter.EndRange = value
+		if clause.Operator == ltOperator {
+			rangeFilter.EndComparator = models.LT
+		}
+	}
+	return rangeFilter, nil
+}
+
+// searchFilterValue checks a JSON filter value has the type of the column it filters and converts it into the string
+// form used by the query filters. Integers are checked like the values of filter query parameters
+func searchFilterValue(column string, value interface{}) (string, error) {
+	switch models.OrganizationFields[column].Type {
+	case models.TextField:
+		if text, isString := value.(string); isString {
+			return text, nil
+		}
+		return "", errors.New("expected a string value")
+	case models.IntegerField:
+		if number, isNumber := value.(json.Number); isNumber {
+			return number.String(), checkFilterValue(column, number.String())
+		}
+		return "", errors.New("expected an integer value")
+	case models.TimestampField:
+		if text, isString := value.(string); isString {
+			if _, err := time.Parse(time.RFC3339Nano, text); err == nil {
+				return text, nil
+			}
+		}
+		return "", errors.New("expected an RFC 3339 date-time value")
+	case models.BooleanField:
+		if boolean, isBool := value.(bool); isBool {
+			return strconv.FormatBool(boolean), nil
+		}
+		return "", errors.New("expected a boolean value")
+	}
+	return "", errors.New("unsupported field type")
+}
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/database/models"
+	"organization_manager/pkg/database/repository"
+	"strings"
+	"testing"
+)
+
+func Test_newSearchFilter(t *testing.T) {
+	var testCases = []struct {
+		clause         SearchFilterClause
+		expectedResult models.FilterExpression
+		shouldFail     bool
+	}{
+		{
+			// Testing values containing the range and filter delimiters
+			clause:         SearchFilterClause{Field: "name", Operator: "eq", Value: "Acme: TO the moon"},
+			expectedResult: models.CategoryQueryFilter{DBfield: "name", ExactFilter: "Acme: TO the moon"},
+		},
+		{
+			clause:         SearchFilterClause{Field: "name", Operator: "like", Value: "Acme*"},
+			expectedResult: models.CategoryQueryFilter{DBfield: "name", LikeFilter: "Acme%"},
+		},
+		{
+			clause:         SearchFilterClause{Field: "name", Operator: "ilike", Value: "*acme*"},
+			expectedResult: models.CategoryQueryFilter{DBfield: "name", ILikeFilter: "%acme%"},
+		},
+		{
+			clause:         SearchFilterClause{Field: "is_public", Operator: "eq", Value: false},
+			expectedResult: models.CategoryQueryFilter{DBfield: "is_public", ExactFilter: "false"},
+		},
+		{
+			clause: SearchFilterClause{Field: "employee_count", Operator: "gt", Value: json.Number("10")},
+			expectedResult: models.RangeQueryFilter{DBfield: "employee_count", StartRange: "10",
+				StartComparator: models.GT, EndRange: "*", EndComparator: models.LTE},
+		},
+		{
+			clause: SearchFilterClause{Field: "creation_date", Operator: "lte", Value: "2002-09-22T00:00:00Z"},
+			expectedResult: models.RangeQueryFilter{DBfield: "creation_date", StartRange: "*",
+				StartComparator: models.GTE, EndRange: "2002-09-22T00:00:00Z", EndComparator: models.LTE},
+		},
+		{
+			clause: SearchFilterClause{Field: "employee_count", Operator: "between",
+				Values: []interface{}{json.Number("10"), json.Number("20")}},
+			expectedResult: models.RangeQueryFilter{DBfield: "employee_count", StartRange: "10",
+				StartComparator: models.GTE, EndRange: "20", EndComparator: models.LTE},
+		},
+		{
+			clause: SearchFilterClause{Field: "employee_count", Operator: "in",
+				Values: []interface{}{json.Number("10"), json.Number("20")}},
+			expectedResult: models.CategoryQueryFilter{DBfield: "employee_count", InFilter: []string{"10", "20"}},
+		},
+		{
+			clause:         SearchFilterClause{Field: "name", Operator: "not_in", Values: []interface{}{"Acme, Inc"}},
+			expectedResult: models.CategoryQueryFilter{DBfield: "name", NotInFilter: []string{"Acme, Inc"}},
+		},
+		{
+			clause:         SearchFilterClause{Field: "creation_date", Operator: "not_null"},
+			expectedResult: models.CategoryQueryFilter{DBfield: "creation_date", NullFilter: models.NotNull},
+		},
+		{
+			clause:     SearchFilterClause{Field: "name", Operator: "is_null"},
+			shouldFail: true,
+		},
+		{
+			clause:     SearchFilterClause{Field: "is_public", Operator: "is_null", Value: true},
+			shouldFail: true,
+		},
+		{
+			clause:     SearchFilterClause{Field: "name", Operator: "in", Values: []interface{}{}},
+			shouldFail: true,
+		},
+		{
+			clause:     SearchFilterClause{Field: "is_public", Operator: "in", Values: []interface{}{true, "false"}},
+			shouldFail: true,
+		},
+		{
+			clause:     SearchFilterClause{Field: "revenue", Operator: "eq", Value: "10"},
+			shouldFail: true,
+		},
+		{
+			clause:     SearchFilterClause{Field: "name", Operator: "contains", Value: "Acme"},
+			shouldFail: true,
+		},
+		{
+			// Testing values must have the field's JSON type
+			clause:     SearchFilterClause{Field: "employee_count", Operator: "eq", Value: "10"},
+			shouldFail: true,
+		},
+		{
+			clause:     SearchFilterClause{Field: "employee_count", Operator: "eq", Value: json.Number("10.5")},
+			shouldFail: true,
+		},
+		{
+			clause:     SearchFilterClause{Field: "creation_date", Operator: "eq", Value: "yesterday"},
+			shouldFail: true,
+		},
+		{
+			clause:     SearchFilterClause{Field: "name", Operator: "eq"},
+			shouldFail: true,
+		},
+		{
+			clause:     SearchFilterClause{Field: "employee_count", Operator: "like", Value: json.Number("1")},
+			shouldFail: true,
+		},
+		{
+			// Testing comparisons are only valid for continuous fields
+			clause:     SearchFilterClause{Field: "name", Operator: "gt", Value: "Acme"},
+			shouldFail: true,
+		},
+		{
+			clause: SearchFilterClause{Field: "employee_count", Operator: "between",
+				Values: []interface{}{json.Number("10")}},
+			shouldFail: true,
+		},
+		{
+			clause:     SearchFilterClause{Field: "employee_count", Operator: "eq", Value: json.Number("1.5")},
+			shouldFail: true,
+		},
+		{
+			clause: SearchFilterClause{Field: "employee_count", Operator: "gt", Value: json.Number("10"),
+				Values: []interface{}{json.Number("10")}},
+			shouldFail: true,
+		},
+	}
+
+	for i, test := range testCases {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			result, err := newSearchFilter(test.clause)
+			if test.shouldFail {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, test.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestOrganizationService_SearchOrganizations(t *testing.T) {
+	ctx := context.Background()
+	service := NewOrganizationService(repository.NewMemoryOrganizationRepository())
+	for _, body := range []string{
+		`{"name": "Acme: TO the moon", "employee_count": 10, "is_public": true}`,
+		`{"name": "Acme Labs", "employee_count": 50}`,
+		`{"name": "Globex", "employee_count": 500, "is_public": true}`,
+	} {
+		_, _, err := service.SaveNewOrganization(ctx, strings.NewReader(body))
+		require.NoError(t, err)
+	}
+
+	resp, err := service.SearchOrganizations(ctx, strings.NewReader(`{
+		"filters": [
+			{"field": "name", "operator": "like", "value": "Acme*"},
+			{"field": "employee_count", "operator": "between", "values": [10, 100]}
+		],
+		"sort": ["-employee_count"],
+		"page_size": 1
+	}`))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(resp.Organizations))
+	assert.Equal(t, "Acme Labs", resp.Organizations[0].Name)
+	assert.Equal(t, 2, *resp.TotalCount)
+	require.NotEmpty(t, resp.NextCursor)
+
+	resp, err = service.SearchOrganizations(ctx, strings.NewReader(fmt.Sprintf(`{
+		"filters": [
+			{"field": "name", "operator": "like", "value": "Acme*"},
+			{"field": "employee_count", "operator": "between", "values": [10, 100]}
+		],
+		"sort": ["-employee_count"],
+		"page_size": 1,
+		"cursor": "%s",
+		"include_total": false
+	}`, resp.NextCursor)))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(resp.Organizations))
+	assert.Equal(t, "Acme: TO the moon", resp.Organizations[0].Name)
+	assert.Nil(t, resp.TotalCount)
+	assert.Empty(t, resp.NextCursor)
+
+	// every invalid clause is reported
+	_, err = service.SearchOrganizations(ctx, strings.NewReader(`{
+		"filters": [
+			{"field": "revenue", "operator": "eq", "value": 10},
+			{"field": "name", "operator": "eq", "value": "Globex"},
+			{"field": "employee_count", "operator": "gte", "value": "10"}
+		]
+	}`))
+	assert.Equal(t, http.StatusBadRequest, problems.Status(err))
+	var validationErr *SearchValidationError
+	require.True(t, errors.As(err, &validationErr))
+	require.Equal(t, 2, len(validationErr.Clauses))
+	assert.Equal(t, 0, validationErr.Clauses[0].Clause)
+	assert.Equal(t, "revenue", validationErr.Clauses[0].Field)
+	assert.Equal(t, 2, validationErr.Clauses[1].Clause)
+	assert.Equal(t, "gte", validationErr.Clauses[1].Operator)
+
+	// integer values are rejected like the values of filter query parameters when they do not fit the column
+	_, err = service.SearchOrganizations(ctx, strings.NewReader(`{
+		"filters": [
+			{"field": "employee_count", "operator": "eq", "value": 3000000000},
+			{"field": "employee_count", "operator": "in", "values": [10, 3000000000]},
+			{"field": "employee_count", "operator": "between", "values": [10, 3000000000]}
+		]
+	}`))
+	assert.Equal(t, http.StatusBadRequest, problems.Status(err))
+	require.True(t, errors.As(err, &validationErr))
+	require.Equal(t, 3, len(validationErr.Clauses))
+	for _, clauseError := range validationErr.Clauses {
+		assert.Equal(t, checkFilterValue("employee_count", "3000000000").Error(), clauseError.Error)
+	}
+
+	for _, body := range []string{
+		`{"filters": [{"field": "name", "operator": "eq", "value": "Globex"}], "limit": 10}`,
+		`{"page": 0}`,
+		`{"page_size": -1}`,
+		`{"sort": ["revenue"]}`,
+		`{"page": 1, "cursor": "abc"}`,
+		`{"search": "acme", "cursor": "abc"}`,
+		`[]`,
+	} {
+		_, err = service.SearchOrganizations(ctx, strings.NewReader(body))
+		assert.Error(t, err, body)
+		assert.Equal(t, http.StatusBadRequest, problems.Status(err), body)
+	}
+}
@@ -1,18 +1,29 @@
 package services
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"github.com/vmihailenco/msgpack/v5"
+	"gorm.io/gorm"
 	"io"
+	"io/ioutil"
 	"math"
 	"net/http"
 	"net/url"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/api/validation"
 	"organization_manager/pkg/database/models"
+	"organization_manager/pkg/database/repository"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -25,108 +36,582 @@ const (
 	startInclusiveRangeDelimiter = "["
 	endInclusiveRangeDelimiter   = "]"
 	rangeFilterRegex             = `(.*):(\(|\[)(.*)TO(.*)(\)|\])`
-	categoryFilterRegex          = `(.*):(.*)`
+	categoryFilterRegex          = `([^:]*):(.*)`
+	inFilterPrefix               = "in("
+	notInFilterPrefix            = "not_in("
+	iLikeFilterPrefix            = "ilike("
+	searchQueryParam             = "search"
+	setFilterSuffix              = ")"
+	filterExpressionQueryParam   = "q"
+	includeDeletedQueryParam     = "include_deleted"
+	includeTotalQueryParam       = "include_total"
+	cursorQueryParam             = "cursor"
+	asOfQueryParam               = "as_of"
+	sortQueryParam               = "sort"
+	sortFieldSeparator           = ","
+	descendingSortPrefix         = "-"
+	unknownFieldErrorPrefix      = "json: unknown field "
 )
 
+// OrganizationService implements the organization endpoints on top of an OrganizationRepository
+type OrganizationService struct {
+	repo repository.OrganizationRepository
+	// DuplicateCheck configures the check for organizations with similar names run when organizations are created
+	DuplicateCheck DuplicateCheck
+}
+
+func NewOrganizationService(repo repository.OrganizationRepository) *OrganizationService {
+	return &OrganizationService{repo: repo}
+}
+
 type PaginatedOrganizationResponse struct {
 	Organizations []models.Organization `json:"organizations"`
-	Page          int                   `json:"page"`
+	Page          int                   `json:"page,omitempty"`
 	PageSize      int                   `json:"page_size"`
-	TotalPages    int                   `json:"total_pages"`
-	TotalCount    int                   `json:"total_count"`
+	TotalPages    *int                  `json:"total_pages,omitempty"`
+	TotalCount    *int                  `json:"total_count,omitempty"`
+	// NextCursor can be supplied as the cursor query parameter to fetch the organizations after this page
+	NextCursor string `json:"next_cursor,omitempty"`
+	// Highlights maps the ID of each organization returned by a full text search to its name, with the words
+	// matching the search wrapped in <mark> tags
+	Highlights map[string]string `json:"highlights,omitempty"`
+	// fields are the organization fields serialized along with the ID, or nil to serialize every field
+	fields []string
 }
 
-// SaveNewOrganization deserializes POST request and saves and returns a new organization object
-// Will return an error and associated http response code as well
-func SaveNewOrganization(requestContent io.ReadCloser) (*models.Organization, int, error) {
-	var orgRequestObject models.Organization
-	err := json.NewDecoder(requestContent).Decode(&orgRequestObject)
-	if orgRequestObject.ID != uuid.Nil {
-		log.Error("organization request content already contained ID value")
-		return nil, http.StatusBadRequest, errors.New("invalid request body")
+// MarshalJSON serializes the response, limiting the fields of its organizations to the requested fields
+func (r PaginatedOrganizationResponse) MarshalJSON() ([]byte, error) {
+	type response PaginatedOrganizationResponse
+	return json.Marshal(struct {
+		response
+		Organizations []ProjectedOrganization `json:"organizations"`
+	}{response(r), r.projectedOrganizations()})
+}
+
+// EncodeMsgpack encodes the response like MarshalJSON. The organizations precede the inlined response, as the encoder
+// only leaves out the fields of inlined structs that are shadowed by earlier fields
+func (r PaginatedOrganizationResponse) EncodeMsgpack(encoder *msgpack.Encoder) error {
+	type response PaginatedOrganizationResponse
+	return encoder.Encode(struct {
+		Organizations []ProjectedOrganization `json:"organizations"`
+		response      `msgpack:",inline"`
+	}{r.projectedOrganizations(), response(r)})
+}
+
+// projectedOrganizations limits the fields of the organizations of the response to the requested fields
+func (r PaginatedOrganizationResponse) projectedOrganizations() []ProjectedOrganization {
+	var projectedOrgs []ProjectedOrganization
+	for _, org := range r.Organizations {
+		projectedOrgs = append(projectedOrgs, ProjectedOrganization{Organization: org, Fields: r.fields})
 	}
+	return projectedOrgs
+}
+
+// cursorToken is the decoded form of the opaque cursors used for keyset pagination, it holds the last organization
+// of a page along with the sort it was returned for
+type cursorToken struct {
+	Sort     string              `json:"sort"`
+	Position models.Organization `json:"position"`
+}
+
+// SaveNewOrganization deserializes POST request and saves and returns a new organization object, along with the
+// existing organizations with similar names when the duplicate check warns about them
+// Will return a *problems.Problem for invalid requests
+func (s *OrganizationService) SaveNewOrganization(ctx context.Context, requestContent io.Reader) (*models.Organization, []models.SimilarOrganization, error) {
+	orgRequestObject, duplicates, err := s.validateNewOrganization(ctx, requestContent)
 	if err != nil {
-		log.Errorf("error deserializing organization POST request body: %v", err)
-		return nil, http.StatusBadRequest, errors.Wrap(err, "invalid request body")
+		return nil, nil, err
 	}
 
-	err = orgRequestObject.Save()
+	err = s.repo.Create(ctx, &orgRequestObject)
 	if err != nil {
 		log.Errorf("error saving new organization: %v", err)
-		return nil, http.StatusInternalServerError, err
+		return nil, nil, err
 	}
 
-	return &orgRequestObject, http.StatusCreated, nil
+	return &orgRequestObject, duplicates, nil
 }
 
-// GetOrganizations parses query parameters from GET request to create database query and returns paginated result
-// Will return an error and associated http response code as well
-func GetOrganizations(queryParams url.Values) (*PaginatedOrganizationResponse, int, error) {
-	categoryQueryFilters, _ := queryParams[filterQueryParam]
-	rangeQueryFilters, _ := queryParams[rangeFilterQueryParam]
+// validateNewOrganization applies the rules for creating an organization to a serialized organization, it returns the
+// deserialized organization along with the existing organizations with similar names when the duplicate check warns
+// about them
+// Will return a *problems.Problem for invalid organizations
+func (s *OrganizationService) validateNewOrganization(ctx context.Context, requestContent io.Reader) (models.Organization, []models.SimilarOrganization, error) {
+	org, err := decodeOrganization(requestContent, uuid.Nil, validation.Create)
+	if err != nil {
+		return org, nil, err
+	}
+	duplicates, err := s.checkDuplicates(ctx, org)
+	return org, duplicates, err
+}
 
-	page, pageSize, err := getPaginationQueryParams(queryParams)
+// UpdateOrganization deserializes PUT request and replaces the existing organization with the request content. The
+// update is only applied if the organization matches the If-Match header, when one is supplied
+// Will return a *problems.Problem for invalid requests
+func (s *OrganizationService) UpdateOrganization(ctx context.Context, orgID, ifMatch string, requestContent io.Reader) (*models.Organization, error) {
+	existingOrg, err := s.getOrganization(ctx, orgID)
 	if err != nil {
-		return nil, http.StatusBadRequest, err
+		return nil, err
+	}
+	err = checkIfMatch(ifMatch, *existingOrg)
+	if err != nil {
+		return nil, err
 	}
 
-	// Process categorical filters from query parameters
-	var categoryDBFilters = make([]models.CategoryQueryFilter, len(categoryQueryFilters))
-	for i, filter := range categoryQueryFilters {
-		matchedGroups, err := checkFilter(categoryFilterRegex, filter, 3, false)
-		if err != nil {
-			return nil, http.StatusBadRequest, err
-		}
+	orgRequestObject, err := decodeOrganization(requestContent, existingOrg.ID, validation.Create)
+	if err != nil {
+		return nil, err
+	}
 
-		categoryDBFilters[i].DBfield = matchedGroups[1]
-		// determines if the filtering value has a wildcard character
-		if strings.Contains(matchedGroups[2], "*") {
-			queryMatcher := strings.ReplaceAll(matchedGroups[2], "*", "%")
-			categoryDBFilters[i].LikeFilter = queryMatcher
-		} else {
-			categoryDBFilters[i].ExactFilter = matchedGroups[2]
-		}
+	orgRequestObject.ID = existingOrg.ID
+	orgRequestObject.Version = existingOrg.Version
+	return s.saveOrganizationUpdate(ctx, &orgRequestObject, ifMatch != "")
+}
+
+// PatchOrganization applies the JSON Merge Patch (RFC 7396) in the PATCH request to the existing organization. The
+// patch is only applied if the organization matches the If-Match header, when one is supplied
+// Will return a *problems.Problem for invalid requests
+func (s *OrganizationService) PatchOrganization(ctx context.Context, orgID, ifMatch string, requestContent io.Reader) (*models.Organization, error) {
+	existingOrg, err := s.getOrganization(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	err = checkIfMatch(ifMatch, *existingOrg)
+	if err != nil {
+		return nil, err
 	}
 
-	// Process range filters from query parameters
-	var rangeDBFilters = make([]models.RangeQueryFilter, len(rangeQueryFilters))
-	for i, filter := range rangeQueryFilters {
-		matchedGroups, err := checkFilter(rangeFilterRegex, filter, 6, true)
+	patchContent, err := ioutil.ReadAll(requestContent)
+	if err != nil {
+		log.Errorf("error reading organization PATCH request body: %v", err)
+		return nil, problems.InvalidBody(errors.Wrap(err, "invalid request body"))
+	}
+	var patch interface{}
+	err = json.Unmarshal(patchContent, &patch)
+	if err != nil {
+		log.Errorf("error deserializing organization PATCH request body: %v", err)
+		return nil, problems.InvalidBody(errors.Wrap(err, "invalid request body"))
+	}
+	if _, isObject := patch.(map[string]interface{}); !isObject {
+		return nil, problems.InvalidBody(errors.New("invalid request body: merge patch must be a JSON object"))
+	}
+	// only the fields of the patch are validated, so organizations saved before a validation rule was introduced
+	// can still be patched
+	_, err = decodeOrganization(bytes.NewReader(patchContent), existingOrg.ID, validation.Patch)
+	if err != nil {
+		return nil, err
+	}
+
+	// the existing organization is round tripped through its JSON representation so the patch can be applied
+	// with the same field names clients use
+	existingContent, err := json.Marshal(existingOrg)
+	if err != nil {
+		return nil, err
+	}
+	var existingDocument interface{}
+	err = json.Unmarshal(existingContent, &existingDocument)
+	if err != nil {
+		return nil, err
+	}
+	patchedContent, err := json.Marshal(mergePatch(existingDocument, patch))
+	if err != nil {
+		return nil, err
+	}
+
+	var orgRequestObject models.Organization
+	err = json.Unmarshal(patchedContent, &orgRequestObject)
+	if err != nil {
+		return nil, err
+	}
+
+	orgRequestObject.ID = existingOrg.ID
+	orgRequestObject.Version = existingOrg.Version
+	orgRequestObject.DeletedAt = gorm.DeletedAt{}
+	return s.saveOrganizationUpdate(ctx, &orgRequestObject, ifMatch != "")
+}
+
+// saveOrganizationUpdate writes an updated organization to the database, as long as it has not been updated since
+// the version the update is based on was read
+func (s *OrganizationService) saveOrganizationUpdate(ctx context.Context, org *models.Organization, isConditional bool) (*models.Organization, error) {
+	err := s.repo.Update(ctx, org)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, organizationNotFound(org.ID.String())
+	} else if errors.Is(err, repository.ErrVersionMismatch) {
+		return nil, versionMismatch(org.ID.String(), isConditional)
+	} else if err != nil {
+		log.Errorf("error updating organization '%s': %v", org.ID, err)
+		return nil, err
+	}
+	return org, nil
+}
+
+// GetOrganization parses the organization ID from the request path and returns the matching organization. The IDs
+// of organizations that were merged into another organization return the surviving organization
+// Will return a *problems.Problem for invalid requests
+func (s *OrganizationService) GetOrganization(ctx context.Context, orgID string) (*models.Organization, error) {
+	org, err := s.getOrganization(ctx, orgID)
+	if !problems.HasCode(err, problems.CodeNotFound) {
+		return org, err
+	}
+
+	id, parseErr := parseOrganizationID(orgID)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	survivorID, resolveErr := s.repo.ResolveMerge(ctx, id)
+	if errors.Is(resolveErr, repository.ErrNotFound) {
+		return nil, err
+	} else if resolveErr != nil {
+		log.Errorf("error resolving merged organization '%s': %v", orgID, resolveErr)
+		return nil, resolveErr
+	}
+	// a survivor that was purged after the merge is reported as the requested organization not being found
+	survivor, survivorErr := s.getOrganization(ctx, survivorID.String())
+	if problems.HasCode(survivorErr, problems.CodeNotFound) {
+		return nil, err
+	}
+	return survivor, survivorErr
+}
+
+// getOrganization returns the organization with the ID from the request path without resolving merged organizations
+// Will return a *problems.Problem for invalid requests
+func (s *OrganizationService) getOrganization(ctx context.Context, orgID string) (*models.Organization, error) {
+	id, err := parseOrganizationID(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	org, err := s.repo.Get(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, organizationNotFound(orgID)
+	} else if err != nil {
+		log.Errorf("error fetching organization '%s': %v", orgID, err)
+		return nil, err
+	}
+
+	return org, nil
+}
+
+// GetOrganizationAsOf returns the organization with the ID from the request path as it was at a past moment. Merged
+// organizations are returned as they were before their merge rather than resolved to the surviving organization
+// Will return a *problems.Problem for invalid requests
+func (s *OrganizationService) GetOrganizationAsOf(ctx context.Context, orgID string,
+	asOf time.Time) (*models.Organization, error) {
+
+	id, err := parseOrganizationID(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	org, err := s.repo.GetAsOf(ctx, id, asOf)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, problems.Errorf(http.StatusNotFound, problems.CodeNotFound,
+			"organization '%s' did not exist as of %s", orgID, asOf.Format(time.RFC3339Nano))
+	} else if errors.Is(err, repository.ErrHistoryUnavailable) {
+		return nil, historyUnavailable(asOf)
+	} else if err != nil {
+		log.Errorf("error fetching organization '%s' as of %s: %v", orgID, asOf, err)
+		return nil, err
+	}
+
+	return org, nil
+}
+
+// DeleteOrganization soft deletes the organization with the ID from the request path. The organization is only
+// deleted if it matches the If-Match header, when one is supplied
+// Will return a *problems.Problem for invalid requests
+func (s *OrganizationService) DeleteOrganization(ctx context.Context, orgID, ifMatch string) error {
+	id, err := parseOrganizationID(orgID)
+	if err != nil {
+		return err
+	}
+
+	// unconditional deletes do not depend on the version of the organization
+	version := 0
+	if ifMatch != "" {
+		existingOrg, err := s.getOrganization(ctx, orgID)
+		if err != nil {
+			return err
+		}
+		err = checkIfMatch(ifMatch, *existingOrg)
 		if err != nil {
-			return nil, http.StatusBadRequest, err
+			return err
 		}
+		version = existingOrg.Version
+	}
 
-		rangeDBFilters[i].DBfield = matchedGroups[1]
-		rangeDBFilters[i].StartRange = matchedGroups[3]
-		rangeDBFilters[i].EndRange = matchedGroups[4]
-		if matchedGroups[2] == startInclusiveRangeDelimiter {
-			rangeDBFilters[i].StartComparator = models.GTE
-		} else {
-			rangeDBFilters[i].StartComparator = models.GT
+	err = s.repo.Delete(ctx, id, version)
+	if errors.Is(err, repository.ErrNotFound) {
+		return organizationNotFound(orgID)
+	} else if errors.Is(err, repository.ErrVersionMismatch) {
+		return versionMismatch(orgID, true)
+	} else if err != nil {
+		log.Errorf("error deleting organization '%s': %v", orgID, err)
+		return err
+	}
+
+	return nil
+}
+
+// RestoreOrganization restores the soft deleted organization with the ID from the request path
+// Will return a *problems.Problem for invalid requests
+func (s *OrganizationService) RestoreOrganization(ctx context.Context, orgID string) (*models.Organization, error) {
+	id, err := parseOrganizationID(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	org, err := s.repo.Restore(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, organizationNotFound(orgID)
+	} else if errors.Is(err, repository.ErrNotDeleted) {
+		return nil, problems.Errorf(http.StatusConflict, problems.CodeNotDeleted, "organization '%s' has not been deleted",
+			orgID)
+	} else if err != nil {
+		log.Errorf("error restoring organization '%s': %v", orgID, err)
+		return nil, err
+	}
+
+	return org, nil
+}
+
+// PurgeOrganization permanently removes the organization with the ID from the request path
+// Will return a *problems.Problem for invalid requests
+func (s *OrganizationService) PurgeOrganization(ctx context.Context, orgID string) error {
+	id, err := parseOrganizationID(orgID)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.Purge(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return organizationNotFound(orgID)
+	} else if err != nil {
+		log.Errorf("error purging organization '%s': %v", orgID, err)
+		return err
+	}
+
+	return nil
+}
+
+// GetOrganizations parses query parameters from GET request to create database query and returns paginated result
+// Will return a *problems.Problem for invalid requests
+func (s *OrganizationService) GetOrganizations(ctx context.Context, queryParams url.Values) (*PaginatedOrganizationResponse, error) {
+	page, pageSize, err := getPaginationQueryParams(queryParams)
+	if err != nil {
+		return nil, err
+	}
+
+	includeDeleted, err := getBoolQueryParam(queryParams, includeDeletedQueryParam, false)
+	if err != nil {
+		return nil, err
+	}
+	includeTotal, err := getBoolQueryParam(queryParams, includeTotalQueryParam, true)
+	if err != nil {
+		return nil, err
+	}
+
+	sortFields, err := getSortQueryParam(queryParams)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := getFieldsQueryParam(queryParams)
+	if err != nil {
+		return nil, err
+	}
+
+	search := strings.TrimSpace(queryParams.Get(searchQueryParam))
+
+	// a cursor switches to keyset pagination, continuing after the last organization of the previous page
+	var after *models.Organization
+	if cursor := queryParams.Get(cursorQueryParam); cursor != "" {
+		if queryParams.Get(pageQueryParam) != "" {
+			return nil, problems.InvalidParameter(cursorQueryParam, errors.New("the cursor and page query parameters cannot be combined"))
 		}
-		if matchedGroups[5] == endInclusiveRangeDelimiter {
-			rangeDBFilters[i].EndComparator = models.LTE
-		} else {
-			rangeDBFilters[i].EndComparator = models.LT
+		if search != "" {
+			return nil, problems.InvalidParameter(cursorQueryParam, errors.New("the cursor and search query parameters cannot be combined"))
+		}
+		after, err = decodeCursor(cursor, sortFields)
+		if err != nil {
+			return nil, problems.InvalidParameter(cursorQueryParam, err)
 		}
+		page = 0
+	}
+
+	filter, err := getFilterQueryParams(queryParams)
+	if err != nil {
+		return nil, err
+	}
+	asOf, err := getAsOfQueryParam(queryParams)
+	if err != nil {
+		return nil, err
 	}
 
 	//sends parsed query params from request to query the database
-	orgs, totalCount, err := models.SearchForOrganizations(categoryDBFilters, rangeDBFilters, page, pageSize)
-	totalPages := int(math.Ceil(float64(totalCount) / float64(pageSize)))
+	orgQuery := models.OrganizationQuery{
+		Search:         search,
+		Sort:           sortFields,
+		Page:           page,
+		PageSize:       pageSize,
+		After:          after,
+		OmitTotalCount: !includeTotal,
+		IncludeDeleted: includeDeleted,
+		Fields:         fields,
+		Filter:         filter,
+		AsOf:           asOf,
+	}
+	return s.searchOrganizations(ctx, orgQuery)
+}
+
+// searchOrganizations runs a search for organizations and creates the paginated response for it
+// Will return a *problems.Problem for invalid requests
+func (s *OrganizationService) searchOrganizations(ctx context.Context, orgQuery models.OrganizationQuery) (*PaginatedOrganizationResponse, error) {
+	result, err := s.repo.Search(ctx, orgQuery)
+	if errors.Is(err, repository.ErrHistoryUnavailable) {
+		return nil, historyUnavailable(*orgQuery.AsOf)
+	} else if err != nil {
+		// database query errors are internal errors
+		return nil, err
+	}
 	respObj := PaginatedOrganizationResponse{
-		Organizations: orgs,
-		Page:          page,
-		PageSize:      pageSize,
-		TotalPages:    totalPages,
-		TotalCount:    int(totalCount),
+		Organizations: result.Organizations,
+		Page:          orgQuery.Page,
+		PageSize:      orgQuery.PageSize,
+		fields:        orgQuery.Fields,
+	}
+	if !orgQuery.OmitTotalCount {
+		totalPages := int(math.Ceil(float64(result.TotalCount) / float64(orgQuery.PageSize)))
+		totalCount := int(result.TotalCount)
+		respObj.TotalPages = &totalPages
+		respObj.TotalCount = &totalCount
+	}
+	// the order of search results depends on their relevance, so they can only be paginated by page number
+	if result.HasMore && orgQuery.Search == "" {
+		respObj.NextCursor, err = encodeCursor(orgQuery.Sort, result.Organizations[len(result.Organizations)-1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	if result.Highlights != nil {
+		respObj.Highlights = make(map[string]string, len(result.Highlights))
+		for id, highlight := range result.Highlights {
+			respObj.Highlights[id.String()] = highlight
+		}
+	}
+	return &respObj, nil
+}
+
+// decodeOrganization deserializes and validates an organization request body. Unknown fields are rejected and the
+// fields are checked by the validation rules of the mode. IDs are assigned by the server, so the body may only
+// contain an ID if it matches orgID, which is uuid.Nil for organizations that have not been created yet
+func decodeOrganization(requestContent io.Reader, orgID uuid.UUID, mode validation.Mode) (models.Organization, error) {
+	var orgRequestObject models.Organization
+	content, err := ioutil.ReadAll(requestContent)
+	if err != nil {
+		log.Errorf("error reading organization request body: %v", err)
+		return orgRequestObject, problems.InvalidBody(errors.Wrap(err, "invalid request body"))
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&orgRequestObject)
+	if err != nil {
+		log.Errorf("error deserializing organization request body: %v", err)
+		return orgRequestObject, decodeProblem(err)
+	}
+	if orgRequestObject.ID != uuid.Nil && orgRequestObject.ID != orgID {
+		log.Error("organization request content contained an ID value that cannot be set")
+		return orgRequestObject, problems.InvalidField(models.IDField,
+			errors.New("invalid request body: the id of an organization cannot be set"))
+	}
+
+	// the members of the body are needed to tell omitted fields from fields set to their zero value
+	var members map[string]json.RawMessage
+	err = json.Unmarshal(content, &members)
+	if err != nil {
+		return orgRequestObject, problems.InvalidBody(errors.Wrap(err, "invalid request body"))
+	}
+	present := make(map[string]bool, len(members))
+	for name, value := range members {
+		present[name] = string(value) != "null"
+	}
+	err = validation.Validate(&orgRequestObject, present, mode)
+	var fieldErrs validation.Errors
+	if errors.As(err, &fieldErrs) {
+		return orgRequestObject, validationProblem(fieldErrs)
+	}
+
+	// organizations are only deleted and restored through their dedicated endpoints, and versions are assigned by
+	// the repository
+	orgRequestObject.DeletedAt = gorm.DeletedAt{}
+	orgRequestObject.Version = 0
+	return orgRequestObject, nil
+}
+
+// decodeProblem describes why a request body could not be deserialized, naming the field at fault when possible
+func decodeProblem(err error) *problems.Problem {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		problem := problems.InvalidBody(errors.Wrap(err, "invalid request body"))
+		problem.InvalidParams = []problems.InvalidParam{{Name: typeErr.Field,
+			Reason: fmt.Sprintf("expected a %s value", typeErr.Type)}}
+		return problem
+	}
+	// encoding/json does not export the error for unknown fields, so the field is parsed from its message
+	if field := strings.TrimPrefix(err.Error(), unknownFieldErrorPrefix); field != err.Error() {
+		if unquoted, unquoteErr := strconv.Unquote(field); unquoteErr == nil {
+			field = unquoted
+		}
+		return validationProblem(validation.Errors{{Field: field, Reason: "is not a field of an organization"}})
 	}
-	var returnHTTPStatus = http.StatusOK
+	return problems.InvalidBody(errors.Wrap(err, "invalid request body"))
+}
+
+// validationProblem is the problem for a request body with invalid fields, which are listed in its invalid params
+func validationProblem(fieldErrs validation.Errors) *problems.Problem {
+	problem := problems.New(http.StatusBadRequest, problems.CodeValidationFailed,
+		errors.Wrap(fieldErrs, "invalid request body"))
+	for _, fieldErr := range fieldErrs {
+		problem.InvalidParams = append(problem.InvalidParams, problems.InvalidParam{Name: fieldErr.Field,
+			Reason: fieldErr.Reason})
+	}
+	return problem
+}
+
+// parseOrganizationID parses an organization ID supplied in a request path
+func parseOrganizationID(orgID string) (uuid.UUID, error) {
+	id, err := uuid.Parse(orgID)
 	if err != nil {
-		// database query errors should be returned as an internal server error
-		returnHTTPStatus = http.StatusInternalServerError
+		log.Errorf("error parsing organization id '%s': %v", orgID, err)
+		return uuid.Nil, problems.InvalidParameter(models.IDField, errors.Errorf("invalid organization id '%s'", orgID))
 	}
-	return &respObj, returnHTTPStatus, err
+	return id, nil
+}
+
+// organizationNotFound is the problem for an organization ID without a matching organization
+func organizationNotFound(orgID string) error {
+	return problems.Errorf(http.StatusNotFound, problems.CodeNotFound, "organization '%s' not found", orgID)
+}
+
+// mergePatch applies a JSON Merge Patch (RFC 7396) to a deserialized JSON document and returns the result. Null
+// values in the patch remove the matching member from the target
+func mergePatch(target, patch interface{}) interface{} {
+	patchObject, isObject := patch.(map[string]interface{})
+	if !isObject {
+		return patch
+	}
+
+	targetObject, isObject := target.(map[string]interface{})
+	if !isObject {
+		targetObject = map[string]interface{}{}
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+		} else {
+			targetObject[key] = mergePatch(targetObject[key], value)
+		}
+	}
+	return targetObject
 }
 
 // getPaginationQueryParams parses request query parameters for pagination values
@@ -139,7 +624,11 @@ func getPaginationQueryParams(queryParams url.Values) (int, int, error) {
 		page, err = strconv.Atoi(p)
 		if err != nil {
 			log.Errorf("error parsing page query param: %v", err)
-			return -1, -1, errors.Errorf("invalid page query parameter '%s'", p)
+			return -1, -1, problems.InvalidParameter(pageQueryParam, errors.Errorf("invalid page query parameter '%s'", p))
+		}
+		if page < 1 {
+			return -1, -1, problems.InvalidParameter(pageQueryParam,
+				errors.Errorf("page query parameter must be at least 1, got '%s'", p))
 		}
 	}
 
@@ -149,12 +638,277 @@ func getPaginationQueryParams(queryParams url.Values) (int, int, error) {
 		pageSize, err = strconv.Atoi(ps)
 		if err != nil {
 			log.Errorf("error parsing page_size query param: %v", err)
-			return -1, -1, errors.Errorf("invalid page_size query parameter '%s'", ps)
+			return -1, -1, problems.InvalidParameter(pageSizeQueryParam,
+				errors.Errorf("invalid page_size query parameter '%s'", ps))
+		}
+		if pageSize < 1 {
+			return -1, -1, problems.InvalidParameter(pageSizeQueryParam,
+				errors.Errorf("page_size query parameter must be at least 1, got '%s'", ps))
 		}
 	}
 	return page, pageSize, nil
 }
 
+// getBoolQueryParam parses a boolean query parameter, returning defaultValue if it was not supplied
+func getBoolQueryParam(queryParams url.Values, queryParam string, defaultValue bool) (bool, error) {
+	value := queryParams.Get(queryParam)
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsedValue, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, problems.InvalidParameter(queryParam,
+			errors.Errorf("invalid %s query parameter '%s'", queryParam, value))
+	}
+	return parsedValue, nil
+}
+
+// historyUnavailable is the problem of reading organizations as of a moment before their history was recorded, which
+// is distinct from the organizations not existing at that moment
+func historyUnavailable(asOf time.Time) *problems.Problem {
+	return problems.Errorf(http.StatusUnprocessableEntity, problems.CodeHistoryUnavailable,
+		"the history of organizations is not available as of %s", asOf.Format(time.RFC3339Nano))
+}
+
+// getAsOfQueryParam parses the as_of query parameter, an RFC 3339 timestamp of the past moment to read organizations
+// as of. It returns nil if the parameter is not supplied
+func getAsOfQueryParam(queryParams url.Values) (*time.Time, error) {
+	value := queryParams.Get(asOfQueryParam)
+	if value == "" {
+		return nil, nil
+	}
+	asOf, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return nil, problems.InvalidParameter(asOfQueryParam,
+			errors.Errorf("invalid %s query parameter '%s', expected an RFC 3339 timestamp", asOfQueryParam, value))
+	}
+	if asOf.After(time.Now()) {
+		return nil, problems.InvalidParameter(asOfQueryParam,
+			errors.Errorf("%s query parameter cannot be in the future, got '%s'", asOfQueryParam, value))
+	}
+	asOf = asOf.UTC()
+	return &asOf, nil
+}
+
+// encodeCursor creates the opaque cursor pointing after the given organization for the given sort
+func encodeCursor(sortFields []models.SortField, org models.Organization) (string, error) {
+	org.DeletedAt = gorm.DeletedAt{}
+	token, err := json.Marshal(cursorToken{Sort: formatSortFields(sortFields), Position: org})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// decodeCursor parses an opaque cursor, cursors can only be used with the sort they were created for
+func decodeCursor(cursor string, sortFields []models.SortField) (*models.Organization, error) {
+	var token cursorToken
+	decodedCursor, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(decodedCursor, &token)
+	}
+	if err != nil || token.Position.ID == uuid.Nil {
+		log.Errorf("error decoding cursor '%s': %v", cursor, err)
+		return nil, errors.Errorf("invalid cursor query parameter '%s'", cursor)
+	}
+	if token.Sort != formatSortFields(sortFields) {
+		return nil, errors.New("cursor was created for a different sort")
+	}
+	return &token.Position, nil
+}
+
+// formatSortFields converts sort fields back into the format of the sort query parameter
+func formatSortFields(sortFields []models.SortField) string {
+	sortKeys := make([]string, len(sortFields))
+	for i, sortField := range sortFields {
+		sortKeys[i] = sortField.DBfield
+		if sortField.Descending {
+			sortKeys[i] = descendingSortPrefix + sortField.DBfield
+		}
+	}
+	return strings.Join(sortKeys, sortFieldSeparator)
+}
+
+// getFilterQueryParams parses the filter, range_filter and q query parameters into a single filter expression, which
+// ANDs them together. It returns nil if none are supplied
+func getFilterQueryParams(queryParams url.Values) (models.FilterExpression, error) {
+	var filters []models.FilterExpression
+	for _, filter := range queryParams[filterQueryParam] {
+		matchedGroups, err := checkFilter(categoryFilterRegex, filter, 3, false)
+		if err != nil {
+			return nil, problems.InvalidParameter(filterQueryParam, err)
+		}
+		categoryFilter, err := newCategoryFilter(matchedGroups[1], matchedGroups[2])
+		if err != nil {
+			return nil, problems.InvalidParameter(filterQueryParam, err)
+		}
+		filters = append(filters, categoryFilter)
+	}
+	for _, filter := range queryParams[rangeFilterQueryParam] {
+		matchedGroups, err := checkFilter(rangeFilterRegex, filter, 6, true)
+		if err != nil {
+			return nil, problems.InvalidParameter(rangeFilterQueryParam, err)
+		}
+		rangeFilter, err := newRangeFilter(matchedGroups)
+		if err != nil {
+			return nil, problems.InvalidParameter(rangeFilterQueryParam, err)
+		}
+		filters = append(filters, rangeFilter)
+	}
+	if q := queryParams.Get(filterExpressionQueryParam); q != "" {
+		filter, err := parseFilterExpression(q)
+		if err != nil {
+			return nil, problems.InvalidParameter(filterExpressionQueryParam, err)
+		}
+		filters = append(filters, filter)
+	}
+
+	if len(filters) == 0 {
+		return nil, nil
+	}
+	return models.AndExpression{Operands: filters}, nil
+}
+
+// getSortQueryParam parses the comma separated sort query parameter, fields prefixed with - are sorted in
+// descending order. For example sort=-employee_count,name
+func getSortQueryParam(queryParams url.Values) ([]models.SortField, error) {
+	sortFields, err := parseSortFields(queryParams[sortQueryParam])
+	if err != nil {
+		return nil, problems.InvalidParameter(sortQueryParam, err)
+	}
+	return sortFields, nil
+}
+
+// parseSortFields parses comma separated lists of sort fields, fields are validated against the organization field
+// registry and can only be sorted on once
+func parseSortFields(sortParams []string) ([]models.SortField, error) {
+	var sortFields []models.SortField
+	seenFields := map[string]bool{}
+	for _, sortParam := range sortParams {
+		for _, sortKey := range strings.Split(sortParam, sortFieldSeparator) {
+			sortField := models.SortField{DBfield: strings.TrimPrefix(sortKey, descendingSortPrefix)}
+			sortField.Descending = sortField.DBfield != sortKey
+
+			_, isField := models.OrganizationFields[sortField.DBfield]
+			if !isField && sortField.DBfield != models.IDField {
+				return nil, errors.Errorf("invalid sort field '%s'", sortKey)
+			}
+			if seenFields[sortField.DBfield] {
+				return nil, errors.Errorf("duplicate sort field '%s'", sortField.DBfield)
+			}
+			seenFields[sortField.DBfield] = true
+			sortFields = append(sortFields, sortField)
+		}
+	}
+	return sortFields, nil
+}
+
+// newCategoryFilter creates a categorical filter for a column from a filter value, which is either one of the
+// is_null, not_null, in(...), not_in(...) or ilike(...) operators or a value to match. Values are validated against the column type
+func newCategoryFilter(column, value string) (models.CategoryQueryFilter, error) {
+	field := models.OrganizationFields[column]
+	categoryFilter := models.CategoryQueryFilter{DBfield: column}
+	var err error
+	switch {
+	case value == models.IsNull || value == models.NotNull:
+		if !field.Nullable {
+			return categoryFilter, errors.Errorf("cannot supply %s filter for non nullable column '%s'", value, column)
+		}
+		categoryFilter.NullFilter = value
+	case strings.HasPrefix(value, inFilterPrefix) && strings.HasSuffix(value, setFilterSuffix):
+		categoryFilter.InFilter, err = newSetFilterValues(column,
+			strings.TrimSuffix(strings.TrimPrefix(value, inFilterPrefix), setFilterSuffix))
+	case strings.HasPrefix(value, notInFilterPrefix) && strings.HasSuffix(value, setFilterSuffix):
+		categoryFilter.NotInFilter, err = newSetFilterValues(column,
+			strings.TrimSuffix(strings.TrimPrefix(value, notInFilterPrefix), setFilterSuffix))
+	case strings.HasPrefix(value, iLikeFilterPrefix) && strings.HasSuffix(value, setFilterSuffix):
+		return newILikeFilter(column, strings.TrimSuffix(strings.TrimPrefix(value, iLikeFilterPrefix), setFilterSuffix))
+	default:
+		return newValueFilter(column, value)
+	}
+	return categoryFilter, err
+}
+
+// newValueFilter creates a categorical filter matching a single value, values containing the * wildcard character
+// are matched with LIKE
+func newValueFilter(column, value string) (models.CategoryQueryFilter, error) {
+	categoryFilter := models.CategoryQueryFilter{DBfield: column}
+	if strings.Contains(value, "*") {
+		if models.OrganizationFields[column].Type != models.TextField {
+			return categoryFilter, errors.Errorf("cannot use wildcards with non text column '%s'", column)
+		}
+		categoryFilter.LikeFilter = strings.ReplaceAll(value, "*", "%")
+		return categoryFilter, nil
+	}
+	categoryFilter.ExactFilter = value
+	return categoryFilter, checkFilterValue(column, value)
+}
+
+// newILikeFilter creates a case insensitive categorical filter from the pattern of an ilike(...) filter, where * is a
+// wildcard. Patterns containing commas or parentheses can be double quoted
+func newILikeFilter(column, pattern string) (models.CategoryQueryFilter, error) {
+	categoryFilter := models.CategoryQueryFilter{DBfield: column}
+	if models.OrganizationFields[column].Type != models.TextField {
+		return categoryFilter, errors.Errorf("cannot supply ilike filter for non text column '%s'", column)
+	}
+	values, err := parseFilterValueList(pattern)
+	if err != nil || len(values) != 1 {
+		return categoryFilter, errors.Errorf("invalid ilike filter pattern '%s' for column '%s'", pattern, column)
+	}
+	categoryFilter.ILikeFilter = strings.ReplaceAll(values[0], "*", "%")
+	return categoryFilter, nil
+}
+
+// newSetFilterValues parses and validates the comma separated values of an in(...) or not_in(...) filter
+func newSetFilterValues(column, list string) ([]string, error) {
+	values, err := parseFilterValueList(list)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid value list for column '%s'", column)
+	}
+	for _, value := range values {
+		err = checkFilterValue(column, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// newRangeFilter creates a range filter from the groups matched by rangeFilterRegex
+func newRangeFilter(matchedGroups []string) (models.RangeQueryFilter, error) {
+	rangeFilter := models.RangeQueryFilter{
+		DBfield:         matchedGroups[1],
+		StartRange:      matchedGroups[3],
+		StartComparator: models.GT,
+		EndRange:        matchedGroups[4],
+		EndComparator:   models.LT,
+	}
+	if matchedGroups[2] == startInclusiveRangeDelimiter {
+		rangeFilter.StartComparator = models.GTE
+	}
+	if matchedGroups[5] == endInclusiveRangeDelimiter {
+		rangeFilter.EndComparator = models.LTE
+	}
+	for _, bound := range []string{rangeFilter.StartRange, rangeFilter.EndRange} {
+		if bound != models.OpenRangeDelimiter {
+			err := checkFilterValue(rangeFilter.DBfield, bound)
+			if err != nil {
+				return rangeFilter, err
+			}
+		}
+	}
+	return rangeFilter, nil
+}
+
+// checkFilterValue validates a filter value can be cast to the type of the column it filters
+func checkFilterValue(column, value string) error {
+	_, err := models.OrganizationFields[column].ParseValue(value)
+	if err != nil {
+		return errors.Errorf("invalid value '%s' for column '%s'", value, column)
+	}
+	return nil
+}
+
 // checkFilter parses and validates the value from a filter or filter_range query, will return components of the parsed
 // filter or an error if the query parameter was not provided correctly
 func checkFilter(regexStrMatcher, filter string, expectedGroupLength int, continuousFilter bool) ([]string, error) {
@@ -172,15 +926,23 @@ func checkFilter(regexStrMatcher, filter string, expectedGroupLength int, contin
 		return nil, errors.Errorf("invalid filter '%s'", filter)
 	}
 
+	err = checkFilterColumn(groups[1], continuousFilter)
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// checkFilterColumn validates the column of a filter against the organization field registry
+func checkFilterColumn(column string, continuousFilter bool) error {
 	// Checks to ensure a continuous attribute was provided with a range_filter, categorical attributes cannot be
 	// used in range filters
-	isAttrContinuous, colExists := models.OrganizationColumnNamesContinuousMap[groups[1]]
+	isAttrContinuous, colExists := models.OrganizationColumnNamesContinuousMap[column]
 	if !colExists {
-		return nil, errors.Errorf("invalid column name '%s'", groups[1])
+		return errors.Errorf("invalid column name '%s'", column)
 	}
 	if continuousFilter && !isAttrContinuous {
-		return nil, errors.Errorf("cannot supply range filter for categorical column '%s'", groups[1])
+		return errors.Errorf("cannot supply range filter for categorical column '%s'", column)
 	}
-
-	return groups, nil
+	return nil
 }
@@ -1,10 +1,19 @@
 package services
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
 	"net/url"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/database/models"
+	"organization_manager/pkg/database/repository"
+	"strings"
 	"testing"
+	"time"
 )
 
 func Test_checkFilter(t *testing.T) {
@@ -118,6 +127,15 @@ func Test_checkFilter(t *testing.T) {
 			expectedResult: nil,
 			shouldFail: true,
 		},
+		{
+			// Testing filter values can contain colons
+			regex: categoryFilterRegex,
+			inputFilter: "creation_date:2002-09-22T00:00:00Z",
+			expectedGroupLen: 3,
+			continuousFilter: false,
+			expectedResult: []string{"creation_date:2002-09-22T00:00:00Z", "creation_date", "2002-09-22T00:00:00Z"},
+			shouldFail: false,
+		},
 		{
 			// Testing creating a valid filter with an invalid column name
 			regex: categoryFilterRegex,
@@ -151,6 +169,130 @@ func Test_checkFilter(t *testing.T) {
 	}
 }
 
+func Test_newCategoryFilter(t *testing.T) {
+	var testCases = []struct {
+		column         string
+		value          string
+		expectedResult models.CategoryQueryFilter
+		shouldFail     bool
+	}{
+		{
+			column:         "name",
+			value:          "CLEAR",
+			expectedResult: models.CategoryQueryFilter{DBfield: "name", ExactFilter: "CLEAR"},
+		},
+		{
+			column:         "name",
+			value:          "CL*",
+			expectedResult: models.CategoryQueryFilter{DBfield: "name", LikeFilter: "CL%"},
+		},
+		{
+			column:         "name",
+			value:          `in(CLEAR, "Acme, Inc" ,Globex)`,
+			expectedResult: models.CategoryQueryFilter{DBfield: "name", InFilter: []string{"CLEAR", "Acme, Inc", "Globex"}},
+		},
+		{
+			column:         "employee_count",
+			value:          "not_in(10,20)",
+			expectedResult: models.CategoryQueryFilter{DBfield: "employee_count", NotInFilter: []string{"10", "20"}},
+		},
+		{
+			column:         "creation_date",
+			value:          "in(2002-09-22T00:00:00Z)",
+			expectedResult: models.CategoryQueryFilter{DBfield: "creation_date", InFilter: []string{"2002-09-22T00:00:00Z"}},
+		},
+		{
+			column:         "creation_date",
+			value:          "is_null",
+			expectedResult: models.CategoryQueryFilter{DBfield: "creation_date", NullFilter: models.IsNull},
+		},
+		{
+			column:         "is_public",
+			value:          "not_null",
+			expectedResult: models.CategoryQueryFilter{DBfield: "is_public", NullFilter: models.NotNull},
+		},
+		{
+			column:         "name",
+			value:          "ilike(*acme*)",
+			expectedResult: models.CategoryQueryFilter{DBfield: "name", ILikeFilter: "%acme%"},
+		},
+		{
+			column:         "name",
+			value:          `ilike("acme, (eu)*")`,
+			expectedResult: models.CategoryQueryFilter{DBfield: "name", ILikeFilter: "acme, (eu)%"},
+		},
+		{
+			column:     "name",
+			value:      "ilike(acme,globex)",
+			shouldFail: true,
+		},
+		{
+			column:     "employee_count",
+			value:      "ilike(1*)",
+			shouldFail: true,
+		},
+		{
+			// Testing name cannot be null
+			column:     "name",
+			value:      "is_null",
+			shouldFail: true,
+		},
+		{
+			// Testing values are validated against the column type
+			column:     "employee_count",
+			value:      "not_in(10,twenty)",
+			shouldFail: true,
+		},
+		{
+			column:     "employee_count",
+			value:      "ten",
+			shouldFail: true,
+		},
+		{
+			column:     "is_public",
+			value:      "in(true,maybe)",
+			shouldFail: true,
+		},
+		{
+			column:     "employee_count",
+			value:      "1*",
+			shouldFail: true,
+		},
+		{
+			column:     "name",
+			value:      "in()",
+			shouldFail: true,
+		},
+		{
+			column:     "name",
+			value:      "in(CLEAR,,Acme)",
+			shouldFail: true,
+		},
+		{
+			column:     "name",
+			value:      `in("CLEAR" Acme)`,
+			shouldFail: true,
+		},
+		{
+			column:     "name",
+			value:      `in("CLEAR)`,
+			shouldFail: true,
+		},
+	}
+
+	for i, test := range testCases {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			result, err := newCategoryFilter(test.column, test.value)
+			if test.shouldFail {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, test.expectedResult, result)
+			}
+		})
+	}
+}
+
 func Test_getPaginationQueryParams(t *testing.T) {
 	var testCases = []struct {
 		queryParams url.Values
@@ -198,6 +340,16 @@ func Test_getPaginationQueryParams(t *testing.T) {
 			expectedPageSize: 15,
 			shouldFail: true,
 		},
+		{
+			// Testing pages start at 1
+			queryParams: map[string][]string{pageQueryParam: {"0"}, pageSizeQueryParam: {"15"}},
+			shouldFail:  true,
+		},
+		{
+			// Testing pages cannot be empty
+			queryParams: map[string][]string{pageQueryParam: {"1"}, pageSizeQueryParam: {"0"}},
+			shouldFail:  true,
+		},
 		{
 			// Testing invalid page param values
 			queryParams: map[string][]string{pageQueryParam: {"1"}, pageSizeQueryParam: {"not a number"}},
@@ -220,3 +372,344 @@ func Test_getPaginationQueryParams(t *testing.T) {
 		})
 	}
 }
+
+func Test_getSortQueryParam(t *testing.T) {
+	var testCases = []struct {
+		queryParams    url.Values
+		expectedResult []models.SortField
+		shouldFail     bool
+	}{
+		{
+			queryParams:    map[string][]string{},
+			expectedResult: nil,
+		},
+		{
+			queryParams: map[string][]string{sortQueryParam: {"-employee_count,name"}},
+			expectedResult: []models.SortField{
+				{DBfield: "employee_count", Descending: true},
+				{DBfield: "name", Descending: false},
+			},
+		},
+		{
+			// Testing repeated sort params are combined in order
+			queryParams: map[string][]string{sortQueryParam: {"creation_date", "-id"}},
+			expectedResult: []models.SortField{
+				{DBfield: "creation_date", Descending: false},
+				{DBfield: "id", Descending: true},
+			},
+		},
+		{
+			// Testing an unknown field
+			queryParams: map[string][]string{sortQueryParam: {"-revenue"}},
+			shouldFail:  true,
+		},
+		{
+			// Testing an empty sort key
+			queryParams: map[string][]string{sortQueryParam: {"name,"}},
+			shouldFail:  true,
+		},
+		{
+			// Testing a field cannot be sorted on twice
+			queryParams: map[string][]string{sortQueryParam: {"name,-name"}},
+			shouldFail:  true,
+		},
+	}
+
+	for i, test := range testCases {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			res, err := getSortQueryParam(test.queryParams)
+			if test.shouldFail {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, test.expectedResult, res)
+			}
+		})
+	}
+}
+
+func Test_mergePatch(t *testing.T) {
+	var testCases = []struct {
+		target         string
+		patch          string
+		expectedResult string
+	}{
+		{
+			// Testing replacing a single member
+			target:         `{"name": "CLEAR", "employee_count": 10}`,
+			patch:          `{"employee_count": 20}`,
+			expectedResult: `{"name": "CLEAR", "employee_count": 20}`,
+		},
+		{
+			// Testing null values remove the member
+			target:         `{"name": "CLEAR", "employee_count": 10}`,
+			patch:          `{"employee_count": null}`,
+			expectedResult: `{"name": "CLEAR"}`,
+		},
+		{
+			// Testing adding a new member
+			target:         `{"name": "CLEAR"}`,
+			patch:          `{"is_public": true}`,
+			expectedResult: `{"name": "CLEAR", "is_public": true}`,
+		},
+		{
+			// Testing nested objects are merged recursively
+			target:         `{"a": {"b": "c", "d": "e"}}`,
+			patch:          `{"a": {"d": null, "f": "g"}}`,
+			expectedResult: `{"a": {"b": "c", "f": "g"}}`,
+		},
+		{
+			// Testing non object patches replace the target
+			target:         `{"a": ["b"]}`,
+			patch:          `{"a": ["c", "d"]}`,
+			expectedResult: `{"a": ["c", "d"]}`,
+		},
+	}
+
+	for i, test := range testCases {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			var target, patch, expectedResult interface{}
+			assert.NoError(t, json.Unmarshal([]byte(test.target), &target))
+			assert.NoError(t, json.Unmarshal([]byte(test.patch), &patch))
+			assert.NoError(t, json.Unmarshal([]byte(test.expectedResult), &expectedResult))
+			assert.Equal(t, expectedResult, mergePatch(target, patch))
+		})
+	}
+}
+
+func TestOrganizationService_lifecycle(t *testing.T) {
+	ctx := context.Background()
+	service := NewOrganizationService(repository.NewMemoryOrganizationRepository())
+
+	org, _, err := service.SaveNewOrganization(ctx, strings.NewReader(
+		`{"name": "CLEAR", "creation_date": "2002-09-22T00:00:00Z", "employee_count": 10000, "is_public": true}`))
+	require.NoError(t, err)
+
+	patchedOrg, err := service.PatchOrganization(ctx, org.ID.String(), "", strings.NewReader(
+		`{"employee_count": 12000}`))
+	require.NoError(t, err)
+	assert.Equal(t, "CLEAR", patchedOrg.Name)
+	assert.Equal(t, intPointer(12000), patchedOrg.EmployeeCount)
+	// fields set to null are stored as NULL rather than as their zero value
+	patchedOrg, err = service.PatchOrganization(ctx, org.ID.String(), "", strings.NewReader(
+		`{"employee_count": null}`))
+	require.NoError(t, err)
+	assert.Nil(t, patchedOrg.EmployeeCount)
+	foundOrg, err := service.GetOrganization(ctx, org.ID.String())
+	require.NoError(t, err)
+	assert.Nil(t, foundOrg.EmployeeCount)
+	assert.Equal(t, boolPointer(true), foundOrg.IsPublic)
+
+	resp, err := service.GetOrganizations(ctx, url.Values{filterQueryParam: {"name:CL*"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, *resp.TotalCount)
+
+	err = service.DeleteOrganization(ctx, org.ID.String(), "")
+	require.NoError(t, err)
+	_, err = service.GetOrganization(ctx, org.ID.String())
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, problems.Status(err))
+
+	resp, err = service.GetOrganizations(ctx, url.Values{includeDeletedQueryParam: {"true"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, *resp.TotalCount)
+}
+
+func TestOrganizationService_validation(t *testing.T) {
+	ctx := context.Background()
+	service := NewOrganizationService(repository.NewMemoryOrganizationRepository())
+	future := time.Now().AddDate(1, 0, 0).Format(time.RFC3339)
+
+	var testCases = []struct {
+		body           string
+		expectedParams []problems.InvalidParam
+	}{
+		{body: `{}`, expectedParams: []problems.InvalidParam{{Name: "name", Reason: "is required"}}},
+		{body: `{"name": null}`, expectedParams: []problems.InvalidParam{{Name: "name", Reason: "cannot be null"}}},
+		{body: `{"name": " "}`, expectedParams: []problems.InvalidParam{{Name: "name", Reason: "cannot be blank"}}},
+		{
+			body:           fmt.Sprintf(`{"name": "%s"}`, strings.Repeat("a", 256)),
+			expectedParams: []problems.InvalidParam{{Name: "name", Reason: "must be at most 255 characters long"}},
+		},
+		{
+			body: fmt.Sprintf(`{"name": "Acme", "creation_date": "%s", "employee_count": -1}`, future),
+			expectedParams: []problems.InvalidParam{{Name: "creation_date", Reason: "cannot be in the future"},
+				{Name: "employee_count", Reason: "must be at least 0"}},
+		},
+		{
+			body:           `{"name": "Acme", "employee_count": 2147483648}`,
+			expectedParams: []problems.InvalidParam{{Name: "employee_count", Reason: "must be at most 2147483647"}},
+		},
+		{
+			body:           `{"name": "Acme", "revenue": 10}`,
+			expectedParams: []problems.InvalidParam{{Name: "revenue", Reason: "is not a field of an organization"}},
+		},
+	}
+	for _, test := range testCases {
+		_, _, err := service.SaveNewOrganization(ctx, strings.NewReader(test.body))
+		assert.Equal(t, http.StatusBadRequest, problems.Status(err), test.body)
+		assert.True(t, problems.HasCode(err, problems.CodeValidationFailed), test.body)
+		assert.Equal(t, test.expectedParams, problems.From(err).InvalidParams, test.body)
+	}
+
+	// updates must be complete organizations, patches are only validated for the fields they set
+	org, _, err := service.SaveNewOrganization(ctx, strings.NewReader(`{"name": "Acme", "employee_count": 10}`))
+	require.NoError(t, err)
+	_, err = service.UpdateOrganization(ctx, org.ID.String(), "", strings.NewReader(`{"employee_count": 20}`))
+	assert.True(t, problems.HasCode(err, problems.CodeValidationFailed))
+	_, err = service.PatchOrganization(ctx, org.ID.String(), "", strings.NewReader(`{"name": null}`))
+	assert.True(t, problems.HasCode(err, problems.CodeValidationFailed))
+	_, err = service.PatchOrganization(ctx, org.ID.String(), "", strings.NewReader(`{"employee_count": -5}`))
+	assert.True(t, problems.HasCode(err, problems.CodeValidationFailed))
+	_, err = service.PatchOrganization(ctx, org.ID.String(), "", strings.NewReader(`{"employee_count": 3000000000}`))
+	assert.True(t, problems.HasCode(err, problems.CodeValidationFailed))
+	_, err = service.PatchOrganization(ctx, org.ID.String(), "", strings.NewReader(`{"revenue": 10}`))
+	assert.True(t, problems.HasCode(err, problems.CodeValidationFailed))
+
+	// organizations saved before the rules were introduced can still be patched
+	legacyOrg := models.Organization{Name: " ", EmployeeCount: intPointer(-1)}
+	require.NoError(t, service.repo.Create(ctx, &legacyOrg))
+	patchedOrg, err := service.PatchOrganization(ctx, legacyOrg.ID.String(), "", strings.NewReader(`{"is_public": true}`))
+	require.NoError(t, err)
+	assert.Equal(t, boolPointer(true), patchedOrg.IsPublic)
+
+	// imports share the rules of created organizations
+	report, err := service.ImportOrganizations(ctx, strings.NewReader("{\"name\": \"Hooli\", \"employee_count\": -1}\n"),
+		ImportOptions{Format: FormatNDJSON, DryRun: true})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(report.Errors))
+	assert.Equal(t, "invalid request body: employee_count must be at least 0", report.Errors[0].Error)
+}
+
+func TestOrganizationService_filterValueRange(t *testing.T) {
+	ctx := context.Background()
+	service := NewOrganizationService(repository.NewMemoryOrganizationRepository())
+
+	// integer filter values must fit the integer columns they are compared to
+	for _, queryParams := range []url.Values{
+		{filterQueryParam: {"employee_count:3000000000"}},
+		{filterQueryParam: {"employee_count:in(10,3000000000)"}},
+		{filterQueryParam: {"employee_count:not_in(-3000000000)"}},
+		{rangeFilterQueryParam: {"employee_count:[10TO3000000000]"}},
+		{filterExpressionQueryParam: {"employee_count:3000000000 OR is_public:true"}},
+	} {
+		_, err := service.GetOrganizations(ctx, queryParams)
+		assert.Equal(t, http.StatusBadRequest, problems.Status(err), queryParams)
+		assert.True(t, problems.HasCode(err, problems.CodeInvalidParameter), queryParams)
+	}
+	_, err := service.GetOrganizations(ctx, url.Values{filterQueryParam: {"employee_count:2147483647"}})
+	assert.NoError(t, err)
+}
+
+func TestOrganizationService_cursorPagination(t *testing.T) {
+	ctx := context.Background()
+	service := NewOrganizationService(repository.NewMemoryOrganizationRepository())
+	for i := 0; i < 7; i++ {
+		_, _, err := service.SaveNewOrganization(ctx, strings.NewReader(
+			fmt.Sprintf(`{"name": "Organization %d", "employee_count": %d}`, i, i%3)))
+		require.NoError(t, err)
+	}
+
+	pagedResp, err := service.GetOrganizations(ctx, url.Values{sortQueryParam: {"-employee_count"},
+		pageSizeQueryParam: {"7"}})
+	require.NoError(t, err)
+
+	// walking every page with cursors returns the same organizations as a single page
+	var cursorOrgs []models.Organization
+	queryParams := url.Values{sortQueryParam: {"-employee_count"}, pageSizeQueryParam: {"3"},
+		includeTotalQueryParam: {"false"}}
+	for pages := 0; pages < 3; pages++ {
+		resp, err := service.GetOrganizations(ctx, queryParams)
+		require.NoError(t, err)
+		assert.Nil(t, resp.TotalCount)
+		cursorOrgs = append(cursorOrgs, resp.Organizations...)
+		if pages < 2 {
+			require.NotEmpty(t, resp.NextCursor)
+		} else {
+			assert.Empty(t, resp.NextCursor)
+		}
+		queryParams.Set(cursorQueryParam, resp.NextCursor)
+	}
+	assert.Equal(t, pagedResp.Organizations, cursorOrgs)
+
+	// cursors cannot be reused with a different sort or combined with page numbers
+	resp, err := service.GetOrganizations(ctx, url.Values{sortQueryParam: {"-employee_count"},
+		pageSizeQueryParam: {"3"}})
+	require.NoError(t, err)
+	_, err = service.GetOrganizations(ctx, url.Values{cursorQueryParam: {resp.NextCursor}})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, problems.Status(err))
+	_, err = service.GetOrganizations(ctx, url.Values{sortQueryParam: {"-employee_count"},
+		cursorQueryParam: {resp.NextCursor}, pageQueryParam: {"2"}})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, problems.Status(err))
+	_, err = service.GetOrganizations(ctx, url.Values{cursorQueryParam: {"not-a-cursor"}})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, problems.Status(err))
+	_, err = service.GetOrganizations(ctx, url.Values{sortQueryParam: {"-employee_count"},
+		cursorQueryParam: {resp.NextCursor}, searchQueryParam: {"organization"}})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, problems.Status(err))
+
+	// searches can only be paginated by page number
+	resp, err = service.GetOrganizations(ctx, url.Values{searchQueryParam: {"organization"},
+		pageSizeQueryParam: {"3"}})
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(resp.Organizations))
+	assert.Equal(t, 7, *resp.TotalCount)
+	assert.Empty(t, resp.NextCursor)
+	assert.Equal(t, 3, len(resp.Highlights))
+}
+
+func TestOrganizationService_asOf(t *testing.T) {
+	ctx := context.Background()
+	service := NewOrganizationService(repository.NewMemoryOrganizationRepository())
+	beforeCreate := time.Now().UTC().Format(time.RFC3339Nano)
+	org, _, err := service.SaveNewOrganization(ctx, strings.NewReader(`{"name": "CLEAR", "employee_count": 10}`))
+	require.NoError(t, err)
+	created := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err = service.PatchOrganization(ctx, org.ID.String(), "", strings.NewReader(`{"employee_count": 20}`))
+	require.NoError(t, err)
+
+	projectedOrg, err := service.GetProjectedOrganization(ctx, org.ID.String(), url.Values{asOfQueryParam: {created}})
+	require.NoError(t, err)
+	assert.Equal(t, intPointer(10), projectedOrg.Organization.EmployeeCount)
+	assert.Equal(t, 1, projectedOrg.Organization.Version)
+	projectedOrg, err = service.GetProjectedOrganization(ctx, org.ID.String(), url.Values{})
+	require.NoError(t, err)
+	assert.Equal(t, intPointer(20), projectedOrg.Organization.EmployeeCount)
+	_, err = service.GetProjectedOrganization(ctx, org.ID.String(), url.Values{asOfQueryParam: {beforeCreate}})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, problems.Status(err))
+
+	resp, err := service.GetOrganizations(ctx, url.Values{asOfQueryParam: {created},
+		rangeFilterQueryParam: {"employee_count:[15TO*]"}})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Organizations)
+	resp, err = service.GetOrganizations(ctx, url.Values{asOfQueryParam: {created}})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(resp.Organizations))
+	assert.Equal(t, intPointer(10), resp.Organizations[0].EmployeeCount)
+	resp, err = service.GetOrganizations(ctx, url.Values{asOfQueryParam: {beforeCreate}})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Organizations)
+
+	for _, asOf := range []string{"yesterday", "2021-11-20", time.Now().Add(time.Hour).Format(time.RFC3339)} {
+		_, err = service.GetOrganizations(ctx, url.Values{asOfQueryParam: {asOf}})
+		assert.True(t, problems.HasCode(err, problems.CodeInvalidParameter), asOf)
+		_, err = service.GetProjectedOrganization(ctx, org.ID.String(), url.Values{asOfQueryParam: {asOf}})
+		assert.True(t, problems.HasCode(err, problems.CodeInvalidParameter), asOf)
+	}
+}
+
+func intPointer(value int) *int {
+	return &value
+}
+
+func boolPointer(value bool) *bool {
+	return &value
+}
+
+func timePointer(value time.Time) *time.Time {
+	return &value
+}
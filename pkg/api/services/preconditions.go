@@ -0,0 +1,119 @@
+package services
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/database/models"
+	"sort"
+	"strings"
+)
+
+const (
+	anyETag        = "*"
+	weakETagPrefix = "W/"
+	etagSeparator  = ","
+	// representationSeparator separates the version from the representation in the entity tags of representations
+	// other than the full JSON representation
+	representationSeparator = "-"
+)
+
+// ETag returns the entity tag of the current version of an organization in its full JSON representation. It is a
+// strong entity tag so it can be used in If-Match headers
+func ETag(org models.Organization) string {
+	return RepresentationETag(org, "")
+}
+
+// RepresentationETag returns the entity tag of a representation of the current version of an organization, as named
+// by Representation. Every representation of a version has its own entity tag, so If-None-Match cannot revalidate a
+// cached representation with the entity tag of another, while If-Match accepts the entity tag of any representation
+// of the current version
+func RepresentationETag(org models.Organization, representation string) string {
+	if representation == "" {
+		return fmt.Sprintf(`"%d"`, org.Version)
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(representation))
+	return fmt.Sprintf(`"%d%s%08x"`, org.Version, representationSeparator, hash.Sum32())
+}
+
+// Representation names the representation of an organization rendered in a format with only the given fields, or
+// every field if fields is nil. The full JSON representation is named by the empty string
+func Representation(format string, fields []string) string {
+	var parts []string
+	if format != FormatJSON {
+		parts = append(parts, format)
+	}
+	if fields != nil {
+		// the fields of a projection are serialized in the same order whatever order they were requested in
+		sortedFields := append([]string{}, fields...)
+		sort.Strings(sortedFields)
+		parts = append(parts, fieldsQueryParam+"="+strings.Join(sortedFields, fieldSeparator))
+	}
+	return strings.Join(parts, ";")
+}
+
+// NotModified reports whether an If-None-Match header matches the entity tag of the representation being returned,
+// in which case a GET request is answered with 304 Not Modified
+func NotModified(ifNoneMatch, etag string) bool {
+	return ifNoneMatch != "" && matchesETag(ifNoneMatch, etag, true)
+}
+
+// checkIfMatch rejects a conditional request whose If-Match header does not match the current version of an
+// organization. Requests without an If-Match header are unconditional
+// Will return a *problems.Problem for mismatched versions
+func checkIfMatch(ifMatch string, org models.Organization) error {
+	if ifMatch == "" || matchesETag(versionETags(ifMatch), ETag(org), false) {
+		return nil
+	}
+	return versionMismatch(org.ID.String(), true)
+}
+
+// versionETags replaces the entity tags of representations in a conditional header with the entity tags of their
+// versions, as a write applies to the organization whatever representation it was read in
+func versionETags(header string) string {
+	candidates := strings.Split(header, etagSeparator)
+	for i, candidate := range candidates {
+		candidate = strings.TrimSpace(candidate)
+		if separator := strings.Index(candidate, representationSeparator); separator != -1 &&
+			strings.HasSuffix(candidate, `"`) {
+			candidate = candidate[:separator] + `"`
+		}
+		candidates[i] = candidate
+	}
+	return strings.Join(candidates, etagSeparator)
+}
+
+// versionMismatch is the problem for an organization that changed since the version a write was based on. Conditional
+// requests fail their precondition, while unconditional requests raced another update and can be retried
+func versionMismatch(orgID string, isConditional bool) error {
+	if isConditional {
+		return problems.Errorf(http.StatusPreconditionFailed, problems.CodePreconditionFailed,
+			"organization '%s' does not match the If-Match header, it has been modified", orgID)
+	}
+	return problems.Errorf(http.StatusConflict, problems.CodeEditConflict,
+		"organization '%s' was modified by another request, retry the request", orgID)
+}
+
+// matchesETag reports whether a conditional header, either * or a comma separated list of entity tags, matches an
+// entity tag. If-Match compares entity tags strongly so weak entity tags never match, while If-None-Match compares
+// them weakly and ignores the W/ prefix
+func matchesETag(header, etag string, weak bool) bool {
+	if strings.TrimSpace(header) == anyETag {
+		return true
+	}
+	for _, candidate := range strings.Split(header, etagSeparator) {
+		candidate = strings.TrimSpace(candidate)
+		if strings.HasPrefix(candidate, weakETagPrefix) {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, weakETagPrefix)
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"organization_manager/pkg/api/problems"
+	"organization_manager/pkg/database/models"
+	"organization_manager/pkg/database/repository"
+	"strings"
+	"testing"
+)
+
+func Test_matchesETag(t *testing.T) {
+	var testCases = []struct {
+		header        string
+		weak          bool
+		expectedMatch bool
+	}{
+		{header: `"2"`, expectedMatch: true},
+		{header: `"1", "2"`, expectedMatch: true},
+		{header: `*`, expectedMatch: true},
+		{header: `"1"`, expectedMatch: false},
+		{header: `W/"2"`, expectedMatch: false},
+		{header: `W/"2"`, weak: true, expectedMatch: true},
+		{header: `2`, weak: true, expectedMatch: false},
+	}
+	for _, test := range testCases {
+		assert.Equal(t, test.expectedMatch, matchesETag(test.header, `"2"`, test.weak), test.header)
+	}
+	assert.Equal(t, `"3"`, ETag(models.Organization{Version: 3}))
+	assert.False(t, NotModified("", `"3"`))
+}
+
+func TestRepresentationETag(t *testing.T) {
+	org := models.Organization{Version: 3}
+	assert.Equal(t, "", Representation(FormatJSON, nil))
+	assert.Equal(t, ETag(org), RepresentationETag(org, Representation(FormatJSON, nil)))
+
+	// every representation of a version has its own entity tag, so they cannot revalidate each other
+	etags := map[string]bool{ETag(org): true}
+	for _, representation := range []string{
+		Representation(FormatCSV, nil),
+		Representation(FormatMessagePack, nil),
+		Representation(FormatJSON, []string{"name"}),
+		Representation(FormatYAML, []string{"name"}),
+		Representation(FormatJSON, []string{}),
+	} {
+		etag := RepresentationETag(org, representation)
+		assert.False(t, etags[etag], representation)
+		etags[etag] = true
+		assert.True(t, strings.HasPrefix(etag, `"3-`), etag)
+		assert.False(t, NotModified(ETag(org), etag), representation)
+		assert.True(t, NotModified("W/"+etag, etag), representation)
+	}
+	assert.Equal(t, Representation(FormatJSON, []string{"name", "is_public"}),
+		Representation(FormatJSON, []string{"is_public", "name"}))
+
+	// writes can be conditional on the entity tag of any representation of the current version
+	assert.NoError(t, checkIfMatch(RepresentationETag(org, Representation(FormatCSV, nil)), org))
+	assert.NoError(t, checkIfMatch(`"1", `+RepresentationETag(org, Representation(FormatJSON, []string{"name"})), org))
+	err := checkIfMatch(RepresentationETag(models.Organization{Version: 2}, Representation(FormatCSV, nil)), org)
+	assert.Equal(t, http.StatusPreconditionFailed, problems.Status(err))
+	err = checkIfMatch("W/"+RepresentationETag(org, Representation(FormatCSV, nil)), org)
+	assert.Equal(t, http.StatusPreconditionFailed, problems.Status(err))
+}
+
+func TestOrganizationService_preconditions(t *testing.T) {
+	ctx := context.Background()
+	service := NewOrganizationService(repository.NewMemoryOrganizationRepository())
+	org, _, err := service.SaveNewOrganization(ctx, strings.NewReader(`{"name": "CLEAR"}`))
+	require.NoError(t, err)
+	assert.Equal(t, 1, org.Version)
+
+	updatedOrg, err := service.PatchOrganization(ctx, org.ID.String(), `"1"`, strings.NewReader(`{"employee_count": 10}`))
+	require.NoError(t, err)
+	assert.Equal(t, 2, updatedOrg.Version)
+
+	// writes based on the first version are rejected now that the organization has been updated
+	_, err = service.UpdateOrganization(ctx, org.ID.String(), `"1"`, strings.NewReader(`{"name": "CLEAR Secure"}`))
+	assert.Equal(t, http.StatusPreconditionFailed, problems.Status(err))
+	_, err = service.PatchOrganization(ctx, org.ID.String(), `"1"`, strings.NewReader(`{"name": "CLEAR Secure"}`))
+	assert.Equal(t, http.StatusPreconditionFailed, problems.Status(err))
+	err = service.DeleteOrganization(ctx, org.ID.String(), `"1"`)
+	assert.Equal(t, http.StatusPreconditionFailed, problems.Status(err))
+
+	// the version in a request body is ignored
+	updatedOrg, err = service.UpdateOrganization(ctx, org.ID.String(), "*",
+		strings.NewReader(`{"name": "CLEAR Secure", "version": 7}`))
+	require.NoError(t, err)
+	assert.Equal(t, 3, updatedOrg.Version)
+	fetchedOrg, err := service.GetOrganization(ctx, org.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, "CLEAR Secure", fetchedOrg.Name)
+	assert.True(t, NotModified(`W/"1", "3"`, ETag(*fetchedOrg)))
+
+	require.NoError(t, service.DeleteOrganization(ctx, org.ID.String(), `"3"`))
+	_, err = service.GetOrganization(ctx, org.ID.String())
+	assert.Equal(t, http.StatusNotFound, problems.Status(err))
+
+	// deleting and restoring the organization are changes too, so they change its entity tag
+	restoredOrg, err := service.RestoreOrganization(ctx, org.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, `"5"`, ETag(*restoredOrg))
+	err = service.DeleteOrganization(ctx, org.ID.String(), `"3"`)
+	assert.Equal(t, http.StatusPreconditionFailed, problems.Status(err))
+}
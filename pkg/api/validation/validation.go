@@ -0,0 +1,212 @@
+// Package validation validates request payloads with the rules declared in the validate tags of their struct fields,
+// such as `validate:"required,max=255"`. Rules are looked up by name in a registry, and fields are reported by their
+// JSON names so clients can match errors to the fields they sent
+package validation
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// requiredRule marks fields that must be set, it depends on the mode rather than the value of the field
+const requiredRule = "required"
+
+// Mode determines which fields of a payload are validated
+type Mode int
+
+const (
+	// Create validates every field of a complete payload, such as the body of a POST or PUT request. Required fields
+	// must be present and cannot be null
+	Create Mode = iota
+	// Patch validates only the fields present in a partial payload, such as a merge patch. Required fields can be
+	// omitted but cannot be removed by setting them to null
+	Patch
+)
+
+// Rule checks the value of a field, param is the text after the = of the rule in the tag, such as 255 for max=255
+type Rule func(value reflect.Value, param string) error
+
+// rules holds the rules that can be used in validate tags by name
+var rules = map[string]Rule{
+	"notblank": notBlank,
+	"min":      minimum,
+	"max":      maximum,
+	"past":     past,
+}
+
+// Register adds a rule that can be used in validate tags, replacing any rule with the same name
+func Register(name string, rule Rule) {
+	rules[name] = rule
+}
+
+// FieldError describes why a single field is invalid
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s %s", e.Field, e.Reason)
+}
+
+// Errors lists every invalid field of a payload, in the order the fields are declared
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldErr := range e {
+		messages[i] = fieldErr.Error()
+	}
+	return strings.Join(messages, ", ")
+}
+
+// Validate checks the fields of the struct value points to against their validate tags. present holds the JSON name
+// of each field set in the payload, mapped to false when the field was set to null. The returned error is Errors when
+// fields are invalid
+func Validate(value interface{}, present map[string]bool, mode Mode) error {
+	structValue := reflect.Indirect(reflect.ValueOf(value))
+	structType := structValue.Type()
+	var fieldErrs Errors
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		tag, hasTag := field.Tag.Lookup("validate")
+		if !hasTag {
+			continue
+		}
+		name := jsonName(field)
+		isSet, isPresent := lookupPresent(present, name)
+		if mode == Patch && !isPresent {
+			continue
+		}
+
+		// the rules of optional fields check the value they point to, and are not checked when they are null
+		fieldValue := structValue.Field(i)
+		isNull := false
+		if fieldValue.Kind() == reflect.Ptr {
+			isNull = fieldValue.IsNil()
+			fieldValue = fieldValue.Elem()
+		}
+		for _, ruleTag := range strings.Split(tag, ",") {
+			ruleName, param := splitRule(ruleTag)
+			var err error
+			if ruleName == requiredRule {
+				err = required(isSet, isPresent, mode)
+			} else if rule, hasRule := rules[ruleName]; hasRule {
+				if !isNull {
+					err = rule(fieldValue, param)
+				}
+			} else {
+				panic(fmt.Sprintf("unknown validation rule '%s' for field %s", ruleName, field.Name))
+			}
+			if err != nil {
+				fieldErrs = append(fieldErrs, FieldError{Field: name, Reason: err.Error()})
+				// the remaining rules of a field are not checked, one reason per field is enough to fix it
+				break
+			}
+		}
+	}
+	if len(fieldErrs) > 0 {
+		return fieldErrs
+	}
+	return nil
+}
+
+// jsonName returns the name of a field in JSON payloads
+func jsonName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// lookupPresent finds whether a field was present in a payload and whether it was set to a value other than null.
+// Names are matched case insensitively as a fallback, like encoding/json matches members to fields
+func lookupPresent(present map[string]bool, name string) (bool, bool) {
+	if isSet, isPresent := present[name]; isPresent {
+		return isSet, true
+	}
+	for presentName, isSet := range present {
+		if strings.EqualFold(presentName, name) {
+			return isSet, true
+		}
+	}
+	return false, false
+}
+
+func splitRule(ruleTag string) (string, string) {
+	parts := strings.SplitN(strings.TrimSpace(ruleTag), "=", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+func required(isSet, isPresent bool, mode Mode) error {
+	if isPresent && !isSet {
+		return errors.New("cannot be null")
+	} else if !isPresent && mode == Create {
+		return errors.New("is required")
+	}
+	return nil
+}
+
+func notBlank(value reflect.Value, _ string) error {
+	if value.Kind() == reflect.String && strings.TrimSpace(value.String()) == "" {
+		return errors.New("cannot be blank")
+	}
+	return nil
+}
+
+// minimum checks numbers are at least param, and strings are at least param characters long
+func minimum(value reflect.Value, param string) error {
+	limit := parseLimit(param)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if value.Int() < limit {
+			return errors.Errorf("must be at least %d", limit)
+		}
+	case reflect.String:
+		if int64(utf8.RuneCountInString(value.String())) < limit {
+			return errors.Errorf("must be at least %d characters long", limit)
+		}
+	}
+	return nil
+}
+
+// maximum checks numbers are at most param, and strings are at most param characters long
+func maximum(value reflect.Value, param string) error {
+	limit := parseLimit(param)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if value.Int() > limit {
+			return errors.Errorf("must be at most %d", limit)
+		}
+	case reflect.String:
+		if int64(utf8.RuneCountInString(value.String())) > limit {
+			return errors.Errorf("must be at most %d characters long", limit)
+		}
+	}
+	return nil
+}
+
+func parseLimit(param string) int64 {
+	limit, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		panic(fmt.Sprintf("invalid validation rule limit '%s'", param))
+	}
+	return limit
+}
+
+// past checks times are not in the future
+func past(value reflect.Value, _ string) error {
+	if t, isTime := value.Interface().(time.Time); isTime && t.After(time.Now()) {
+		return errors.New("cannot be in the future")
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package validation
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testPayload struct {
+	Name     string    `json:"name" validate:"required,notblank,max=5"`
+	Count    int       `json:"count,omitempty" validate:"min=0,max=10"`
+	Date     time.Time `json:"date" validate:"past"`
+	Code     string    `validate:"uppercase"`
+	Untagged string    `json:"untagged"`
+	Limit    *int      `json:"limit" validate:"min=1"`
+}
+
+func TestValidate(t *testing.T) {
+	Register("uppercase", func(value reflect.Value, _ string) error {
+		if value.String() != strings.ToUpper(value.String()) {
+			return errors.New("must be uppercase")
+		}
+		return nil
+	})
+	future := time.Now().Add(time.Hour)
+	zero := 0
+
+	var testCases = []struct {
+		payload        testPayload
+		present        map[string]bool
+		mode           Mode
+		expectedErrors Errors
+	}{
+		{payload: testPayload{Name: "Acme"}, present: map[string]bool{"name": true}, mode: Create},
+		{
+			payload:        testPayload{Count: -1, Code: "a"},
+			present:        map[string]bool{"count": true},
+			mode:           Create,
+			expectedErrors: Errors{{"name", "is required"}, {"count", "must be at least 0"}, {"Code", "must be uppercase"}},
+		},
+		{
+			payload:        testPayload{Name: "Acme Inc", Count: 11, Date: future},
+			present:        map[string]bool{"NAME": true, "count": true, "date": true},
+			mode:           Create,
+			expectedErrors: Errors{{"name", "must be at most 5 characters long"}, {"count", "must be at most 10"}, {"date", "cannot be in the future"}},
+		},
+		{payload: testPayload{Name: "Ünïcø"}, present: map[string]bool{"name": true}, mode: Create},
+		{
+			payload:        testPayload{Name: "  "},
+			present:        map[string]bool{"name": true},
+			mode:           Create,
+			expectedErrors: Errors{{"name", "cannot be blank"}},
+		},
+		// Testing patches only validate the fields they set
+		{payload: testPayload{Count: -1, Code: "a"}, present: map[string]bool{"untagged": true}, mode: Patch},
+		{
+			payload:        testPayload{Count: -1},
+			present:        map[string]bool{"name": false, "count": true},
+			mode:           Patch,
+			expectedErrors: Errors{{"name", "cannot be null"}, {"count", "must be at least 0"}},
+		},
+		// Testing the rules of optional fields check the value they point to, unless they are null
+		{payload: testPayload{Name: "Acme"}, present: map[string]bool{"name": true, "limit": false}, mode: Create},
+		{
+			payload:        testPayload{Limit: &zero},
+			present:        map[string]bool{"limit": true},
+			mode:           Patch,
+			expectedErrors: Errors{{"limit", "must be at least 1"}},
+		},
+	}
+	for _, test := range testCases {
+		err := Validate(&test.payload, test.present, test.mode)
+		if test.expectedErrors == nil {
+			assert.NoError(t, err, test.payload)
+			continue
+		}
+		var fieldErrs Errors
+		if assert.True(t, errors.As(err, &fieldErrs), test.payload) {
+			assert.Equal(t, test.expectedErrors, fieldErrs)
+		}
+	}
+	assert.Equal(t, "name is required, count must be at least 0",
+		Errors{{"name", "is required"}, {"count", "must be at least 0"}}.Error())
+}
@@ -1,7 +1,6 @@
 package database
 
 import (
-	"database/sql"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
@@ -11,17 +10,14 @@ import (
 	"gorm.io/gorm"
 )
 
-var DB *gorm.DB
-
-func Initialize(dbURI string) error {
+func Initialize(dbURI string) (*gorm.DB, error) {
 	log.Infof("Attempting to connect to database: %v", dbURI)
-	var err error
-	DB, err = gorm.Open(postgres.Open(dbURI), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(dbURI), &gorm.Config{})
 	if err != nil {
 		log.Errorf("Unable to connect to postgres: %v", err)
-		return err
+		return nil, err
 	}
-	return nil
+	return db, nil
 }
 
 func RunMigrations(migrationsPath, dbUri string) error {
@@ -36,13 +32,13 @@ func RunMigrations(migrationsPath, dbUri string) error {
 	return nil
 }
 
-func InitializeTest() (*sql.DB, sqlmock.Sqlmock, error) {
-	db, mock, err := sqlmock.New()
+func InitializeTest() (*gorm.DB, sqlmock.Sqlmock, error) {
+	sqlDB, mock, err := sqlmock.New()
 	if err != nil {
 		return nil, nil, err
 	}
-	DB, err = gorm.Open(postgres.New(
-		postgres.Config{Conn: db}),
+	db, err := gorm.Open(postgres.New(
+		postgres.Config{Conn: sqlDB}),
 		&gorm.Config{})
 	if err != nil {
 		return nil, nil, err
@@ -0,0 +1,2 @@
+DROP INDEX idx_organizations_deleted_at;
+ALTER TABLE organizations DROP COLUMN deleted_at;
@@ -0,0 +1,2 @@
+ALTER TABLE organizations ADD COLUMN deleted_at TIMESTAMP;
+CREATE INDEX idx_organizations_deleted_at ON organizations (deleted_at);
@@ -0,0 +1,3 @@
+DROP INDEX idx_organizations_name_tsv;
+DROP TRIGGER organizations_name_tsv_update ON organizations;
+ALTER TABLE organizations DROP COLUMN name_tsv;
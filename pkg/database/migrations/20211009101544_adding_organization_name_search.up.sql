@@ -0,0 +1,5 @@
+ALTER TABLE organizations ADD COLUMN name_tsv tsvector;
+UPDATE organizations SET name_tsv = to_tsvector('pg_catalog.simple', name);
+CREATE TRIGGER organizations_name_tsv_update BEFORE INSERT OR UPDATE ON organizations
+    FOR EACH ROW EXECUTE PROCEDURE tsvector_update_trigger(name_tsv, 'pg_catalog.simple', name);
+CREATE INDEX idx_organizations_name_tsv ON organizations USING GIN (name_tsv);
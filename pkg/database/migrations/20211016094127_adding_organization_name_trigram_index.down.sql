@@ -0,0 +1 @@
+DROP INDEX idx_organizations_name_trgm;
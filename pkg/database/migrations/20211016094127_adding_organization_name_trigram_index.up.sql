@@ -0,0 +1,2 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX idx_organizations_name_trgm ON organizations USING GIN (name gin_trgm_ops);
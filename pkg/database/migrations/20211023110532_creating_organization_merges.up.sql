@@ -0,0 +1,10 @@
+CREATE TABLE organization_merges
+(
+    source_id uuid PRIMARY KEY,
+    target_id uuid NOT NULL,
+    rules JSONB NOT NULL,
+    source JSONB NOT NULL,
+    target JSONB NOT NULL,
+    merged_at TIMESTAMP NOT NULL
+);
+CREATE INDEX idx_organization_merges_target_id ON organization_merges (target_id);
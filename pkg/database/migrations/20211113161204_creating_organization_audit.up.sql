@@ -0,0 +1,13 @@
+CREATE TABLE organization_audit
+(
+    id BIGSERIAL PRIMARY KEY,
+    organization_id uuid NOT NULL,
+    action TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    claimed_actor TEXT NOT NULL,
+    request_id TEXT NOT NULL,
+    before JSONB,
+    after JSONB,
+    changed_at TIMESTAMP NOT NULL
+);
+CREATE INDEX idx_organization_audit_organization_id ON organization_audit (organization_id, id);
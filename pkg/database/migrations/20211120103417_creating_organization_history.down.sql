@@ -0,0 +1,3 @@
+DROP TRIGGER organizations_history ON organizations;
+DROP FUNCTION record_organization_history();
+DROP TABLE organization_history;
@@ -0,0 +1,53 @@
+CREATE TABLE organization_history
+(
+    history_id BIGSERIAL PRIMARY KEY,
+    id uuid NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    creation_date TIMESTAMP,
+    employee_count INTEGER,
+    is_public BOOLEAN,
+    deleted_at TIMESTAMP,
+    version INTEGER NOT NULL,
+    name_tsv tsvector,
+    valid_from TIMESTAMP NOT NULL,
+    valid_to TIMESTAMP,
+    -- backfilled versions were recorded when the history was introduced, the organization is unknown before them
+    backfilled BOOLEAN NOT NULL DEFAULT false
+);
+CREATE INDEX idx_organization_history_id ON organization_history (id, valid_from);
+CREATE INDEX idx_organization_history_validity ON organization_history (valid_from, valid_to);
+CREATE INDEX idx_organization_history_name_tsv ON organization_history USING GIN (name_tsv);
+CREATE INDEX idx_organization_history_backfilled ON organization_history (valid_from) WHERE backfilled;
+
+-- the current version of existing organizations is valid from their last recorded change, or from now when they have
+-- not changed since the audit log was introduced. Their earlier versions are unknown, so their history is unavailable
+-- before it
+INSERT INTO organization_history (id, name, creation_date, employee_count, is_public, deleted_at, version, name_tsv,
+                                  valid_from, backfilled)
+SELECT o.id, o.name, o.creation_date, o.employee_count, o.is_public, o.deleted_at, o.version, o.name_tsv,
+       COALESCE((SELECT max(a.changed_at) FROM organization_audit a WHERE a.organization_id = o.id),
+                now() AT TIME ZONE 'UTC'),
+       true
+FROM organizations o;
+
+-- every version of an organization is valid from the transaction that wrote it until the transaction that replaced or
+-- removed it, so every change made by a transaction takes effect at the same moment
+CREATE FUNCTION record_organization_history() RETURNS trigger AS $$
+DECLARE
+    changed_at TIMESTAMP := now() AT TIME ZONE 'UTC';
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        UPDATE organization_history SET valid_to = changed_at WHERE id = OLD.id AND valid_to IS NULL;
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        INSERT INTO organization_history (id, name, creation_date, employee_count, is_public, deleted_at, version,
+                                          name_tsv, valid_from)
+        VALUES (NEW.id, NEW.name, NEW.creation_date, NEW.employee_count, NEW.is_public, NEW.deleted_at, NEW.version,
+                NEW.name_tsv, changed_at);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER organizations_history AFTER INSERT OR UPDATE OR DELETE ON organizations
+    FOR EACH ROW EXECUTE PROCEDURE record_organization_history();
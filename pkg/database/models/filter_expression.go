@@ -0,0 +1,30 @@
+package models
+
+// FilterExpression is a node of a boolean filter expression over organization columns. CategoryQueryFilter and
+// RangeQueryFilter are the leaves of the expression, which are combined with AndExpression, OrExpression and
+// NotExpression
+type FilterExpression interface {
+	filterExpression()
+}
+
+// AndExpression matches organizations that match every operand, it matches every organization if it has no operands
+type AndExpression struct {
+	Operands []FilterExpression
+}
+
+// OrExpression matches organizations that match at least one operand, it matches no organizations if it has no
+// operands
+type OrExpression struct {
+	Operands []FilterExpression
+}
+
+// NotExpression matches organizations that do not match its operand
+type NotExpression struct {
+	Operand FilterExpression
+}
+
+func (CategoryQueryFilter) filterExpression() {}
+func (RangeQueryFilter) filterExpression()    {}
+func (AndExpression) filterExpression()       {}
+func (OrExpression) filterExpression()        {}
+func (NotExpression) filterExpression()       {}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// IdempotencyKey records a request made with an Idempotency-Key header along with its response, so retries of the
+// request replay the response instead of repeating the request
+type IdempotencyKey struct {
+	Key string `gorm:"primary_key;column:key"`
+	// RequestHash identifies the method, path, response format and body of the request, retries must send the same
+	// request
+	RequestHash string `gorm:"column:request_hash"`
+	// StatusCode is 0 while the request is in progress, it is set along with Headers and Body once it completes
+	StatusCode int          `gorm:"column:status_code"`
+	Headers    JSONDocument `gorm:"column:headers"`
+	Body       []byte       `gorm:"column:body"`
+	// CreatedAt is when the key was reserved, it identifies the reservation of the request that may complete it
+	CreatedAt time.Time `gorm:"column:created_at"`
+	// LockedUntil is when the lease of a request in progress ends. A request that has not completed by then is
+	// assumed to have failed, and a retry takes the key over
+	LockedUntil time.Time `gorm:"column:locked_until"`
+	// ExpiresAt is when the key is forgotten, a request reusing the key afterwards is handled as a new request
+	ExpiresAt time.Time `gorm:"column:expires_at"`
+}
+
+// IsCompleted reports whether the response of the request has been stored
+func (k IdempotencyKey) IsCompleted() bool {
+	return k.StatusCode != 0
+}
@@ -1,19 +1,29 @@
 package models
 
 import (
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
-	log "github.com/sirupsen/logrus"
-	"organization_manager/pkg/database"
+	"gorm.io/gorm"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Organization struct {
-	ID            uuid.UUID `gorm:"primary_key;column:id"`
-	Name          string    `gorm:"column:name" json:"name"`
-	CreationDate  time.Time `gorm:"column:creation_date" json:"creation_date"`
-	EmployeeCount int       `gorm:"employee_count" json:"employee_count"`
-	IsPublic      bool      `gorm:"column:is_public" json:"is_public"`
+	ID   uuid.UUID `gorm:"primary_key;column:id"`
+	Name string    `gorm:"column:name" json:"name" validate:"required,notblank,max=255"`
+	// CreationDate, EmployeeCount and IsPublic are nil when their column is NULL, such as when they were never set or
+	// were set to null
+	CreationDate  *time.Time `gorm:"column:creation_date" json:"creation_date" validate:"past"`
+	EmployeeCount *int       `gorm:"employee_count" json:"employee_count" validate:"min=0,max=2147483647"`
+	IsPublic      *bool      `gorm:"column:is_public" json:"is_public"`
+	// DeletedAt is set when an organization is soft deleted, soft deleted organizations are excluded from queries
+	// unless they are explicitly unscoped
+	DeletedAt gorm.DeletedAt `gorm:"column:deleted_at" json:"deleted_at"`
+	// Version starts at 1 and is incremented by every update, updates only succeed when they are based on the
+	// current version so concurrent updates cannot overwrite each other
+	Version int `gorm:"column:version" json:"version"`
 }
 
 type RangeQueryFilter struct {
@@ -28,6 +38,105 @@ type CategoryQueryFilter struct {
 	DBfield     string
 	LikeFilter  string
 	ExactFilter string
+	// ILikeFilter is matched like LikeFilter, but case insensitively
+	ILikeFilter string
+	// InFilter matches organizations whose column equals any of the values, NotInFilter matches organizations whose
+	// column equals none of them
+	InFilter    []string
+	NotInFilter []string
+	// NullFilter is either IsNull or NotNull to match organizations on whether the column is NULL
+	NullFilter string
+}
+
+// SortField orders search results by a single organization column
+type SortField struct {
+	DBfield    string
+	Descending bool
+}
+
+// OrganizationQuery holds the filters and pagination parameters used to search for organizations
+type OrganizationQuery struct {
+	// Filter restricts the results to organizations matching the expression, every organization matches a nil Filter
+	Filter FilterExpression
+	// Search restricts the results to organizations whose name contains every word of the full text search, ordered
+	// by relevance before the sort fields. Keyset pagination is not supported for searches
+	Search string
+	// Sort orders the results by each field in turn, id is always used as the final tiebreaker
+	Sort     []SortField
+	Page     int
+	PageSize int
+	// After switches the search to keyset pagination, only organizations ordered after it are returned and Page is
+	// ignored. Only its ID and sort fields need to be set
+	After *Organization
+	// OmitTotalCount skips counting every matching organization
+	OmitTotalCount bool
+	// IncludeDeleted determines if soft deleted organizations are returned
+	IncludeDeleted bool
+	// AsOf searches the organizations as they were at a past moment instead of the current organizations, when set
+	AsOf *time.Time
+	// Fields limits the columns selected for the returned organizations to their ID, the fields and the sort fields,
+	// every column is selected if it is nil
+	Fields []string
+}
+
+// SelectedColumns returns the columns selected for the query's organizations, or nil if every column is selected
+func (q OrganizationQuery) SelectedColumns() []string {
+	if q.Fields == nil {
+		return nil
+	}
+	columns := []string{IDField}
+	isSelected := map[string]bool{IDField: true}
+	for _, column := range q.Fields {
+		if !isSelected[column] {
+			columns = append(columns, column)
+			isSelected[column] = true
+		}
+	}
+	for _, sortField := range q.Sort {
+		if !isSelected[sortField.DBfield] {
+			columns = append(columns, sortField.DBfield)
+			isSelected[sortField.DBfield] = true
+		}
+	}
+	return columns
+}
+
+// OrganizationSearchResult holds a single page of organizations matching an OrganizationQuery
+type OrganizationSearchResult struct {
+	Organizations []Organization
+	// TotalCount is the number of organizations matching the query across every page, it is not set if the query
+	// omitted the total count
+	TotalCount int64
+	// HasMore is set when more organizations match the query after this page
+	HasMore bool
+	// Highlights holds the name of each organization with the words matching the query's full text search wrapped
+	// in HighlightStart and HighlightStop, it is only set for searches
+	Highlights map[uuid.UUID]string
+}
+
+// SimilarNameQuery finds organizations with names similar to Name, where similarity is the fraction of trigrams the
+// names share, as computed by the Postgres pg_trgm extension
+type SimilarNameQuery struct {
+	Name string
+	// Threshold is the minimum similarity between 0 and 1 of the returned organizations
+	Threshold float64
+	// ExcludeID excludes an organization from the results, typically the organization Name belongs to
+	ExcludeID uuid.UUID
+	Limit     int
+}
+
+// SimilarNamesQuery finds the organizations with names similar to each of Names at once, with the same similarity as
+// a SimilarNameQuery. Limit applies to the organizations of each name
+type SimilarNamesQuery struct {
+	Names     []string
+	Threshold float64
+	Limit     int
+}
+
+// SimilarOrganization is an organization returned by a SimilarNameQuery
+type SimilarOrganization struct {
+	Organization Organization `json:"organization"`
+	Similarity   float64      `json:"similarity"`
 }
 
 const (
@@ -36,55 +145,151 @@ const (
 	LTE                = "<="
 	LT                 = "<"
 	OpenRangeDelimiter = "*"
+	IsNull             = "is_null"
+	NotNull            = "not_null"
+	HighlightStart     = "<mark>"
+	HighlightStop      = "</mark>"
 )
 
-// OrganizationColumnNamesContinuousMap is a map of organization column name to boolean value determining
-// if the field is continuous or not
-var OrganizationColumnNamesContinuousMap = map[string]bool{
-	"name":           false,
-	"creation_date":  true,
-	"employee_count": true,
-	"is_public":      false,
+// IDField is the organization primary key, it can be sorted on but is not part of OrganizationFields
+const IDField = "id"
+
+// FieldType is the type of an organization column, used to parse filter values
+type FieldType int
+
+const (
+	TextField FieldType = iota
+	IntegerField
+	TimestampField
+	BooleanField
+)
+
+// OrganizationField describes an organization column that can be filtered and sorted on
+type OrganizationField struct {
+	Type       FieldType
+	Continuous bool
+	// Nullable is set for columns that can be NULL in the organizations table
+	Nullable bool
+	// SortExpression is a template for the SQL expression used to order by the field, %s is replaced by the column
+	// or by a placeholder for keyset pagination values. Names are ordered case insensitively by their lower cased
+	// bytes so every repository implementation can agree on the order. NULL values of nullable columns are ordered as
+	// the zero value of the column, so keyset pagination can continue after an organization with a NULL column
+	// without skipping or repeating organizations
+	SortExpression string
 }
 
-func (o *Organization) Save() error {
-	o.ID = uuid.New()
-	return database.DB.Create(o).Error
+// OrganizationFields is the registry of organization columns that can be filtered and sorted on
+var OrganizationFields = map[string]OrganizationField{
+	"name":           {Type: TextField, Continuous: false, Nullable: false, SortExpression: `lower(%s) COLLATE "C"`},
+	"creation_date":  {Type: TimestampField, Continuous: true, Nullable: true, SortExpression: "COALESCE(%s, '0001-01-01'::timestamp)"},
+	"employee_count": {Type: IntegerField, Continuous: true, Nullable: true, SortExpression: "COALESCE(%s, 0)"},
+	"is_public":      {Type: BooleanField, Continuous: false, Nullable: true, SortExpression: "COALESCE(%s, false)"},
 }
 
-// SearchForOrganizations takes in categorical and range filters and creates and executes a query to
-// the organizations database table
-func SearchForOrganizations(categoryFilters []CategoryQueryFilter, rangeFilters []RangeQueryFilter, page,
-	pageSize int) ([]Organization, int64, error) {
+// timestampLayouts are the formats accepted for timestamp filter values, mirroring the formats Postgres accepts for
+// the ISO8601 dates used by the API
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
 
-	query := database.DB
-	for _, categoryFilter := range categoryFilters {
-		if categoryFilter.LikeFilter != "" {
-			query = query.Where(fmt.Sprintf("%s LIKE ?", categoryFilter.DBfield), categoryFilter.LikeFilter)
-		} else {
-			query = query.Where(fmt.Sprintf("%s = ?", categoryFilter.DBfield), categoryFilter.ExactFilter)
+// ParseValue parses a filter value for the field into a string, int, time.Time or bool depending on the field type.
+// The errors match the ones Postgres returns when it fails to cast a filter value
+func (f OrganizationField) ParseValue(value string) (interface{}, error) {
+	switch f.Type {
+	case IntegerField:
+		// integer columns are 32 bit in Postgres, larger values cannot be compared to them
+		parsedValue, err := strconv.ParseInt(strings.TrimSpace(value), 10, 32)
+		if errors.Is(err, strconv.ErrRange) {
+			return nil, fmt.Errorf("value %q is out of range for type integer", value)
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid input syntax for type integer: %q", value)
+		}
+		return int(parsedValue), nil
+	case TimestampField:
+		for _, layout := range timestampLayouts {
+			if parsedValue, err := time.Parse(layout, strings.TrimSpace(value)); err == nil {
+				return parsedValue, nil
+			}
 		}
+		return nil, fmt.Errorf("invalid input syntax for type timestamp: %q", value)
+	case BooleanField:
+		parsedValue, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid input syntax for type boolean: %q", value)
+		}
+		return parsedValue, nil
 	}
+	return value, nil
+}
+
+// OrganizationColumnNamesContinuousMap is a map of organization column name to boolean value determining
+// if the field is continuous or not
+var OrganizationColumnNamesContinuousMap = continuousColumns()
 
-	for _, rangeFilter := range rangeFilters {
-		if rangeFilter.StartRange != OpenRangeDelimiter {
-			query = query.Where(fmt.Sprintf("%s %s ?", rangeFilter.DBfield, rangeFilter.StartComparator),
-				rangeFilter.StartRange)
+func continuousColumns() map[string]bool {
+	columns := make(map[string]bool, len(OrganizationFields))
+	for name, field := range OrganizationFields {
+		columns[name] = field.Continuous
+	}
+	return columns
+}
+
+// ColumnValue returns the value of an organization column, or nil if the column is NULL or the organization has no
+// such column
+func (o Organization) ColumnValue(column string) interface{} {
+	switch column {
+	case IDField:
+		return o.ID
+	case "name":
+		return o.Name
+	case "creation_date":
+		if o.CreationDate != nil {
+			return *o.CreationDate
 		}
-		if rangeFilter.EndRange != OpenRangeDelimiter {
-			query = query.Where(fmt.Sprintf("%s %s ?", rangeFilter.DBfield, rangeFilter.EndComparator),
-				rangeFilter.EndRange)
+	case "employee_count":
+		if o.EmployeeCount != nil {
+			return *o.EmployeeCount
+		}
+	case "is_public":
+		if o.IsPublic != nil {
+			return *o.IsPublic
 		}
 	}
+	return nil
+}
 
-	// Setting pagination parameters on query
-	offset := (page - 1) * pageSize
-	query = query.Limit(pageSize)
-	query = query.Offset(offset)
+// Project returns a copy of the organization with only the given columns set, leaving every other column zero
+func (o Organization) Project(columns []string) Organization {
+	var projected Organization
+	for _, column := range columns {
+		switch column {
+		case IDField:
+			projected.ID = o.ID
+		case "name":
+			projected.Name = o.Name
+		case "creation_date":
+			projected.CreationDate = o.CreationDate
+		case "employee_count":
+			projected.EmployeeCount = o.EmployeeCount
+		case "is_public":
+			projected.IsPublic = o.IsPublic
+		}
+	}
+	return projected
+}
 
-	var totalCount int64
-	var organizationsFound []Organization
-	err := query.Model(&Organization{}).Debug().Order("id").Count(&totalCount).Find(&organizationsFound).Error
-	log.Infof("Count: %d", totalCount)
-	return organizationsFound, totalCount, err
+// WithIDTiebreaker returns the sort fields followed by id, so organizations with equal sort fields are always
+// returned in the same order. The fields are returned unchanged if they already sort by id
+func WithIDTiebreaker(sortFields []SortField) []SortField {
+	keys := make([]SortField, 0, len(sortFields)+1)
+	for _, sortField := range sortFields {
+		keys = append(keys, sortField)
+		if sortField.DBfield == IDField {
+			return keys
+		}
+	}
+	return append(keys, SortField{DBfield: IDField})
 }
@@ -0,0 +1,77 @@
+package models
+
+import "time"
+
+// Intervals of timestamp histograms
+const (
+	YearInterval  = "year"
+	MonthInterval = "month"
+)
+
+// AggregateQuery computes statistics over the organizations matching its filter and full text search
+type AggregateQuery struct {
+	Filter         FilterExpression
+	Search         string
+	IncludeDeleted bool
+	// Facets are categorical columns to count the organizations of each value for, the FacetLimit most common values
+	// of each column are returned
+	Facets     []string
+	FacetLimit int
+	// Stats are continuous columns to compute the minimum, maximum, average and sum of
+	Stats      []string
+	Histograms []HistogramQuery
+}
+
+// HistogramQuery buckets the values of a continuous column. Integer columns are bucketed by Width and timestamp
+// columns by the calendar Interval, either YearInterval or MonthInterval
+type HistogramQuery struct {
+	DBfield  string
+	Width    int
+	Interval string
+}
+
+// AggregateResult holds the statistics computed for an AggregateQuery, keyed by column
+type AggregateResult struct {
+	Count      int64                        `json:"count"`
+	Facets     map[string][]FacetBucket     `json:"facets,omitempty"`
+	Stats      map[string]FieldStats        `json:"stats,omitempty"`
+	Histograms map[string][]HistogramBucket `json:"histograms,omitempty"`
+}
+
+// FacetBucket counts the organizations with a value of a categorical column, Value is nil for NULL values
+type FacetBucket struct {
+	Value interface{} `json:"value"`
+	Count int64       `json:"count"`
+}
+
+// FieldStats holds the statistics of the non NULL values of a continuous column, which are all nil if it has no
+// values. Min, Max and Avg have the type of the column, except integer averages which are float64. Sum is only
+// computed for integer columns
+type FieldStats struct {
+	Min interface{} `json:"min"`
+	Max interface{} `json:"max"`
+	Avg interface{} `json:"avg"`
+	Sum *int64      `json:"sum,omitempty"`
+}
+
+// HistogramBucket counts the organizations with values from Start up to but excluding End, Start and End are nil for
+// the bucket of NULL values, which is ordered last
+type HistogramBucket struct {
+	Start interface{} `json:"start"`
+	End   interface{} `json:"end"`
+	Count int64       `json:"count"`
+}
+
+// BucketEnd returns the end of the histogram bucket starting at start
+func (h HistogramQuery) BucketEnd(start interface{}) interface{} {
+	switch start := start.(type) {
+	case int:
+		return start + h.Width
+	case time.Time:
+		if h.Interval == YearInterval {
+			return start.AddDate(1, 0, 0)
+		}
+		return start.AddDate(0, 1, 0)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"time"
+)
+
+// Actions recorded in the audit log of an organization
+const (
+	CreateAuditAction  = "create"
+	UpdateAuditAction  = "update"
+	DeleteAuditAction  = "delete"
+	RestoreAuditAction = "restore"
+	MergeAuditAction   = "merge"
+	PurgeAuditAction   = "purge"
+)
+
+// OrganizationAuditEntry records a single change of an organization, who made it and what it changed. The Actor is
+// the authenticated identity making the change, while the ClaimedActor is the unverified actor named by its request
+type OrganizationAuditEntry struct {
+	ID             int64     `gorm:"primary_key;column:id" json:"id"`
+	OrganizationID uuid.UUID `gorm:"column:organization_id" json:"organization_id"`
+	Action         string    `gorm:"column:action" json:"action"`
+	Actor          string    `gorm:"column:actor" json:"actor"`
+	ClaimedActor   string    `gorm:"column:claimed_actor" json:"claimed_actor"`
+	RequestID      string    `gorm:"column:request_id" json:"request_id"`
+	// Before and After hold the organization fields changed by the action, before and after it. Before is null for
+	// created organizations and After is null for merged away and purged organizations
+	Before    JSONDocument `gorm:"column:before" json:"before"`
+	After     JSONDocument `gorm:"column:after" json:"after"`
+	ChangedAt time.Time    `gorm:"column:changed_at" json:"changed_at"`
+}
+
+func (OrganizationAuditEntry) TableName() string {
+	return "organization_audit"
+}
+
+// AuditQuery pages through the audit log of an organization, newest entries first
+type AuditQuery struct {
+	OrganizationID uuid.UUID
+	Page           int
+	PageSize       int
+}
+
+type AuditResult struct {
+	Entries    []OrganizationAuditEntry
+	TotalCount int64
+}
+
+// Auditor identifies who is making changes, it is carried by the context of each change so the change can be
+// recorded in the audit log
+type Auditor struct {
+	Actor        string
+	ClaimedActor string
+	RequestID    string
+}
+
+// auditorKey is the context key of the Auditor
+type auditorKey struct{}
+
+// WithAuditor returns a context carrying the auditor of the changes made with it
+func WithAuditor(ctx context.Context, auditor Auditor) context.Context {
+	return context.WithValue(ctx, auditorKey{}, auditor)
+}
+
+// AuditorFrom returns the auditor carried by a context, changes made without one are recorded as made by the system
+func AuditorFrom(ctx context.Context) Auditor {
+	auditor, hasAuditor := ctx.Value(auditorKey{}).(Auditor)
+	if !hasAuditor {
+		return Auditor{Actor: SystemActor}
+	}
+	return auditor
+}
+
+// Actors recorded in the audit log
+const (
+	// SystemActor is the actor of changes that are not made by a request, such as imports from the command line
+	SystemActor = "system"
+	// AdminActor is the actor of requests authenticated with the admin key
+	AdminActor = "admin"
+	// AnonymousActor is the actor of requests that are not authenticated
+	AnonymousActor = "anonymous"
+)
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/vmihailenco/msgpack/v5"
+	"time"
+)
+
+// Rules resolving the value of a field when an organization is merged into another
+const (
+	KeepTargetMergeRule = "keep_target"
+	KeepSourceMergeRule = "keep_source"
+	MaxMergeRule        = "max"
+	MinMergeRule        = "min"
+)
+
+// OrganizationMerge is the audit entry of a source organization merged into a target organization. The source is
+// removed by the merge and its ID resolves to the target, so the entry doubles as a redirect tombstone
+type OrganizationMerge struct {
+	SourceID uuid.UUID `gorm:"primary_key;column:source_id" json:"source_id"`
+	TargetID uuid.UUID `gorm:"column:target_id" json:"target_id"`
+	// Rules maps every organization field to the merge rule that resolved it
+	Rules JSONDocument `gorm:"column:rules" json:"rules"`
+	// Source and Target are snapshots of both organizations as they were before the merge
+	Source   JSONDocument `gorm:"column:source" json:"source"`
+	Target   JSONDocument `gorm:"column:target" json:"target"`
+	MergedAt time.Time    `gorm:"column:merged_at" json:"merged_at"`
+}
+
+// MergeFunc combines a source organization into a target organization and returns the surviving organization
+type MergeFunc func(target, source Organization) (Organization, error)
+
+// JSONDocument is a raw JSON value stored in a JSONB column, it is embedded as is when marshaled to JSON
+type JSONDocument []byte
+
+func (d JSONDocument) Value() (driver.Value, error) {
+	if d == nil {
+		return nil, nil
+	}
+	return string(d), nil
+}
+
+func (d *JSONDocument) Scan(value interface{}) error {
+	switch value := value.(type) {
+	case nil:
+		*d = nil
+	case []byte:
+		*d = append(JSONDocument{}, value...)
+	case string:
+		*d = JSONDocument(value)
+	default:
+		return fmt.Errorf("cannot scan %T into a JSON document", value)
+	}
+	return nil
+}
+
+func (d JSONDocument) MarshalJSON() ([]byte, error) {
+	if d == nil {
+		return []byte("null"), nil
+	}
+	return d, nil
+}
+
+// EncodeMsgpack encodes the value of the document rather than its serialized bytes
+func (d JSONDocument) EncodeMsgpack(encoder *msgpack.Encoder) error {
+	if d == nil {
+		return encoder.EncodeNil()
+	}
+	var value interface{}
+	err := json.Unmarshal(d, &value)
+	if err != nil {
+		return err
+	}
+	return encoder.Encode(value)
+}
+
+func (d *JSONDocument) UnmarshalJSON(data []byte) error {
+	*d = append(JSONDocument{}, data...)
+	return nil
+}
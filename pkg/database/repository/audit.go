@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"organization_manager/pkg/database/models"
+	"reflect"
+	"time"
+)
+
+// idJSONField is the name of the organization ID in serialized organizations
+const idJSONField = "ID"
+
+// newAuditEntry records an action changing an organization from before to after, either of which is nil for actions
+// creating or removing an organization. Only the fields that changed are recorded, the ID identifies the entry's
+// organization instead
+func newAuditEntry(ctx context.Context, action string, before, after *models.Organization) (models.OrganizationAuditEntry, error) {
+	auditor := models.AuditorFrom(ctx)
+	entry := models.OrganizationAuditEntry{
+		Action:       action,
+		Actor:        auditor.Actor,
+		ClaimedActor: auditor.ClaimedActor,
+		RequestID:    auditor.RequestID,
+		ChangedAt:    time.Now().UTC(),
+	}
+	if before != nil {
+		entry.OrganizationID = before.ID
+	} else {
+		entry.OrganizationID = after.ID
+	}
+
+	beforeFields, err := auditFields(before)
+	if err != nil {
+		return entry, err
+	}
+	afterFields, err := auditFields(after)
+	if err != nil {
+		return entry, err
+	}
+	if beforeFields != nil && afterFields != nil {
+		for field, value := range beforeFields {
+			if reflect.DeepEqual(value, afterFields[field]) {
+				delete(beforeFields, field)
+				delete(afterFields, field)
+			}
+		}
+	}
+
+	entry.Before, err = marshalAuditFields(beforeFields)
+	if err != nil {
+		return entry, err
+	}
+	entry.After, err = marshalAuditFields(afterFields)
+	return entry, err
+}
+
+// auditFields returns the fields of an organization as they are serialized in API responses, without its ID
+func auditFields(org *models.Organization) (map[string]interface{}, error) {
+	if org == nil {
+		return nil, nil
+	}
+	document, err := json.Marshal(org)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	err = json.Unmarshal(document, &fields)
+	if err != nil {
+		return nil, err
+	}
+	delete(fields, idJSONField)
+	return fields, nil
+}
+
+func marshalAuditFields(fields map[string]interface{}) (models.JSONDocument, error) {
+	if fields == nil {
+		return nil, nil
+	}
+	return json.Marshal(fields)
+}
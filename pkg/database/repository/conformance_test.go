@@ -0,0 +1,1192 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"organization_manager/pkg/database"
+	"organization_manager/pkg/database/models"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+// testDatabaseURLEnv is the Postgres database the conformance suite runs against, the Postgres run is skipped
+// when it is unset. Every table the suite touches is truncated, so it should never point at real data
+const testDatabaseURLEnv = "TEST_DATABASE_URL"
+
+func TestMemoryOrganizationRepository(t *testing.T) {
+	runConformanceTests(t, func(t *testing.T) OrganizationRepository {
+		return NewMemoryOrganizationRepository()
+	}, func(t *testing.T, repo OrganizationRepository, id uuid.UUID) {
+		memoryRepo := repo.(*MemoryOrganizationRepository)
+		org := memoryRepo.organizations[id]
+		org.CreationDate, org.EmployeeCount, org.IsPublic = nil, nil, nil
+		memoryRepo.organizations[id] = org
+		for i := range memoryRepo.versions {
+			if memoryRepo.versions[i].ID == id && memoryRepo.versions[i].ValidTo.IsZero() {
+				memoryRepo.versions[i].Organization = org
+			}
+		}
+	}, func(t *testing.T, repo OrganizationRepository, id uuid.UUID) {
+		memoryRepo := repo.(*MemoryOrganizationRepository)
+		for i := range memoryRepo.versions {
+			if memoryRepo.versions[i].ID == id {
+				memoryRepo.versions[i].ValidFrom, memoryRepo.versions[i].Backfilled = time.Now().UTC(), true
+			}
+		}
+	})
+}
+
+func TestPostgresOrganizationRepository(t *testing.T) {
+	dbURL := os.Getenv(testDatabaseURLEnv)
+	if dbURL == "" {
+		t.Skipf("%s is not set", testDatabaseURLEnv)
+	}
+	require.NoError(t, database.RunMigrations("file://../migrations", dbURL))
+	db, err := database.Initialize(dbURL)
+	require.NoError(t, err)
+
+	runConformanceTests(t, func(t *testing.T) OrganizationRepository {
+		require.NoError(t, db.Exec("TRUNCATE organizations, organization_merges, organization_audit, organization_history").Error)
+		return NewPostgresOrganizationRepository(db)
+	}, func(t *testing.T, repo OrganizationRepository, id uuid.UUID) {
+		require.NoError(t, db.Exec("UPDATE organizations SET creation_date = NULL, employee_count = NULL, is_public = NULL WHERE id = ?", id).Error)
+	}, func(t *testing.T, repo OrganizationRepository, id uuid.UUID) {
+		require.NoError(t, db.Exec("UPDATE organization_history SET valid_from = now() AT TIME ZONE 'UTC', backfilled = true WHERE id = ?", id).Error)
+	})
+}
+
+// runConformanceTests checks an OrganizationRepository implementation against the behavior every implementation
+// must share. newRepo must return an empty repository, nullColumns sets every nullable column of an organization to
+// NULL behind the repository's back, like rows written before the API, and backfill makes the only version of an
+// organization a version backfilled now
+func runConformanceTests(t *testing.T, newRepo func(t *testing.T) OrganizationRepository,
+	nullColumns func(t *testing.T, repo OrganizationRepository, id uuid.UUID),
+	backfill func(t *testing.T, repo OrganizationRepository, id uuid.UUID)) {
+	ctx := context.Background()
+
+	t.Run("create_and_get", func(t *testing.T) {
+		repo := newRepo(t)
+		org := newTestOrganization("CLEAR", 10000, true, 2002)
+		require.NoError(t, repo.Create(ctx, &org))
+		assert.NotEqual(t, uuid.Nil, org.ID)
+
+		found, err := repo.Get(ctx, org.ID)
+		require.NoError(t, err)
+		assertSameOrganization(t, org, *found)
+
+		_, err = repo.Get(ctx, uuid.New())
+		assert.Equal(t, ErrNotFound, err)
+	})
+
+	t.Run("create_batch", func(t *testing.T) {
+		repo := newRepo(t)
+		orgs := []models.Organization{
+			newTestOrganization("Acme", 10, true, 2002),
+			newTestOrganization("Globex", 120, false, 2010),
+		}
+		require.NoError(t, repo.CreateBatch(ctx, orgs))
+		assert.NotEqual(t, uuid.Nil, orgs[0].ID)
+		assert.NotEqual(t, orgs[0].ID, orgs[1].ID)
+
+		for _, org := range orgs {
+			found, err := repo.Get(ctx, org.ID)
+			require.NoError(t, err)
+			assertSameOrganization(t, org, *found)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		repo := newRepo(t)
+		org := newTestOrganization("CLEAR", 10000, true, 2002)
+		require.NoError(t, repo.Create(ctx, &org))
+
+		assert.Equal(t, 1, org.Version)
+		org.Name = "CLEAR Secure"
+		org.EmployeeCount = intPointer(0)
+		org.IsPublic = nil
+		require.NoError(t, repo.Update(ctx, &org))
+		assert.Equal(t, 2, org.Version)
+		found, err := repo.Get(ctx, org.ID)
+		require.NoError(t, err)
+		assertSameOrganization(t, org, *found)
+
+		// updates based on an earlier version are rejected
+		staleOrg := org
+		staleOrg.Version = 1
+		staleOrg.Name = "Stale"
+		assert.Equal(t, ErrVersionMismatch, repo.Update(ctx, &staleOrg))
+		assert.Equal(t, 1, staleOrg.Version)
+		found, err = repo.Get(ctx, org.ID)
+		require.NoError(t, err)
+		assertSameOrganization(t, org, *found)
+
+		missingOrg := newTestOrganization("Missing", 1, false, 2000)
+		missingOrg.ID = uuid.New()
+		assert.Equal(t, ErrNotFound, repo.Update(ctx, &missingOrg))
+	})
+
+	t.Run("delete_restore_and_purge", func(t *testing.T) {
+		repo := newRepo(t)
+		org := newTestOrganization("CLEAR", 10000, true, 2002)
+		require.NoError(t, repo.Create(ctx, &org))
+
+		_, err := repo.Restore(ctx, org.ID)
+		assert.Equal(t, ErrNotDeleted, err)
+
+		assert.Equal(t, ErrVersionMismatch, repo.Delete(ctx, org.ID, org.Version+1))
+		require.NoError(t, repo.Delete(ctx, org.ID, org.Version))
+		assert.Equal(t, ErrNotFound, repo.Delete(ctx, org.ID, 0))
+		_, err = repo.Get(ctx, org.ID)
+		assert.Equal(t, ErrNotFound, err)
+		assert.Equal(t, ErrNotFound, repo.Update(ctx, &org))
+
+		result, err := repo.Search(ctx, models.OrganizationQuery{Page: 1, PageSize: 20})
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), result.TotalCount)
+		result, err = repo.Search(ctx, models.OrganizationQuery{Page: 1, PageSize: 20, IncludeDeleted: true})
+		require.NoError(t, err)
+		require.Equal(t, 1, len(result.Organizations))
+		assert.True(t, result.Organizations[0].DeletedAt.Valid)
+		// deleting and restoring change the organization, so they increment its version
+		assert.Equal(t, 2, result.Organizations[0].Version)
+
+		_, err = repo.Restore(ctx, org.ID)
+		require.NoError(t, err)
+		assert.Equal(t, ErrVersionMismatch, repo.Delete(ctx, org.ID, 2))
+		restored, err := repo.Get(ctx, org.ID)
+		require.NoError(t, err)
+		assert.False(t, restored.DeletedAt.Valid)
+		assert.Equal(t, 3, restored.Version)
+
+		require.NoError(t, repo.Delete(ctx, org.ID, 0))
+		require.NoError(t, repo.Purge(ctx, org.ID))
+		assert.Equal(t, ErrNotFound, repo.Purge(ctx, org.ID))
+		_, err = repo.Restore(ctx, org.ID)
+		assert.Equal(t, ErrNotFound, err)
+	})
+
+	t.Run("audit", func(t *testing.T) {
+		repo := newRepo(t)
+		auditCtx := models.WithAuditor(ctx, models.Auditor{Actor: models.AdminActor, ClaimedActor: "jane",
+			RequestID: "request-1"})
+		org := newTestOrganization("CLEAR", 10000, true, 2002)
+		require.NoError(t, repo.Create(auditCtx, &org))
+		batch := []models.Organization{newTestOrganization("CLEAR Secure", 20, false, 2010)}
+		require.NoError(t, repo.CreateBatch(ctx, batch))
+		other := batch[0]
+		org.EmployeeCount = intPointer(12000)
+		require.NoError(t, repo.Update(auditCtx, &org))
+		// failed changes are not audited
+		assert.Equal(t, ErrVersionMismatch, repo.Delete(auditCtx, org.ID, org.Version+1))
+		require.NoError(t, repo.Delete(auditCtx, org.ID, 0))
+		_, err := repo.Restore(auditCtx, org.ID)
+		require.NoError(t, err)
+		require.NoError(t, repo.Purge(ctx, org.ID))
+
+		history, err := repo.History(ctx, models.AuditQuery{OrganizationID: org.ID, Page: 1, PageSize: 20})
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), history.TotalCount)
+		var actions []string
+		for _, entry := range history.Entries {
+			assert.Equal(t, org.ID, entry.OrganizationID)
+			assert.False(t, entry.ChangedAt.IsZero())
+			actions = append(actions, entry.Action)
+		}
+		assert.Equal(t, []string{models.PurgeAuditAction, models.RestoreAuditAction, models.DeleteAuditAction,
+			models.UpdateAuditAction, models.CreateAuditAction}, actions)
+
+		purge, update, create := history.Entries[0], history.Entries[3], history.Entries[4]
+		assert.Equal(t, models.SystemActor, purge.Actor)
+		assert.Nil(t, purge.After)
+		assert.Equal(t, "", purge.ClaimedActor)
+		assert.Equal(t, models.AdminActor, update.Actor)
+		assert.Equal(t, "jane", update.ClaimedActor)
+		assert.Equal(t, "request-1", update.RequestID)
+		assert.JSONEq(t, `{"employee_count": 10000, "version": 1}`, string(update.Before))
+		assert.JSONEq(t, `{"employee_count": 12000, "version": 2}`, string(update.After))
+		assert.Nil(t, create.Before)
+		assert.JSONEq(t, `{"name": "CLEAR", "creation_date": "2002-06-01T00:00:00Z", "employee_count": 10000,
+			"is_public": true, "deleted_at": null, "version": 1}`, string(create.After))
+
+		page, err := repo.History(ctx, models.AuditQuery{OrganizationID: org.ID, Page: 2, PageSize: 2})
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), page.TotalCount)
+		require.Equal(t, 2, len(page.Entries))
+		assert.Equal(t, history.Entries[2].ID, page.Entries[0].ID)
+
+		otherHistory, err := repo.History(ctx, models.AuditQuery{OrganizationID: other.ID, Page: 1, PageSize: 20})
+		require.NoError(t, err)
+		require.Equal(t, 1, len(otherHistory.Entries))
+		assert.Equal(t, models.CreateAuditAction, otherHistory.Entries[0].Action)
+		empty, err := repo.History(ctx, models.AuditQuery{OrganizationID: uuid.New(), Page: 1, PageSize: 20})
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), empty.TotalCount)
+		assert.Empty(t, empty.Entries)
+	})
+
+	t.Run("as_of", func(t *testing.T) {
+		repo := newRepo(t)
+		// moments are taken apart from the changes around them, as Postgres timestamps the changes itself
+		moment := func() time.Time {
+			time.Sleep(10 * time.Millisecond)
+			now := time.Now().UTC()
+			time.Sleep(10 * time.Millisecond)
+			return now
+		}
+		asOfIDs := func(asOf time.Time, orgQuery models.OrganizationQuery) []uuid.UUID {
+			orgQuery.AsOf, orgQuery.Page, orgQuery.PageSize = &asOf, 1, 10
+			result, err := repo.Search(ctx, orgQuery)
+			require.NoError(t, err)
+			ids := []uuid.UUID{}
+			for _, org := range result.Organizations {
+				ids = append(ids, org.ID)
+			}
+			assert.Equal(t, int64(len(ids)), result.TotalCount)
+			return ids
+		}
+
+		beforeCreate := moment()
+		org := newTestOrganization("CLEAR", 10000, true, 2002)
+		other := newTestOrganization("Acme", 20, false, 2010)
+		for _, created := range []*models.Organization{&org, &other} {
+			require.NoError(t, repo.Create(ctx, created))
+		}
+		created := moment()
+		org.EmployeeCount = intPointer(12000)
+		require.NoError(t, repo.Update(ctx, &org))
+		updated := moment()
+		require.NoError(t, repo.Delete(ctx, org.ID, 0))
+		deleted := moment()
+		require.NoError(t, repo.Purge(ctx, other.ID))
+		purged := moment()
+		_, err := repo.Restore(ctx, org.ID)
+		require.NoError(t, err)
+
+		_, err = repo.GetAsOf(ctx, org.ID, beforeCreate)
+		assert.Equal(t, ErrNotFound, err)
+		found, err := repo.GetAsOf(ctx, org.ID, created)
+		require.NoError(t, err)
+		assert.Equal(t, intPointer(10000), found.EmployeeCount)
+		assert.Equal(t, 1, found.Version)
+		found, err = repo.GetAsOf(ctx, org.ID, updated)
+		require.NoError(t, err)
+		assert.Equal(t, intPointer(12000), found.EmployeeCount)
+		assert.Equal(t, 2, found.Version)
+		// soft deleted organizations are not found as of the moments they were removed, and purged organizations are
+		// not found as of any moment
+		_, err = repo.GetAsOf(ctx, org.ID, deleted)
+		assert.Equal(t, ErrNotFound, err)
+		for _, asOf := range []time.Time{created, deleted, purged} {
+			_, err = repo.GetAsOf(ctx, other.ID, asOf)
+			assert.Equal(t, ErrNotFound, err)
+		}
+		found, err = repo.GetAsOf(ctx, org.ID, time.Now().UTC())
+		require.NoError(t, err)
+		assert.False(t, found.DeletedAt.Valid)
+
+		largeOrgs := models.OrganizationQuery{Filter: newRangeFilter("employee_count", "11000", models.GTE, "*", models.LTE)}
+		assert.Empty(t, asOfIDs(beforeCreate, models.OrganizationQuery{}))
+		assert.Equal(t, []uuid.UUID{org.ID}, asOfIDs(created, models.OrganizationQuery{}))
+		assert.Empty(t, asOfIDs(created, largeOrgs))
+		assert.Equal(t, []uuid.UUID{org.ID}, asOfIDs(updated, largeOrgs))
+		assert.Empty(t, asOfIDs(deleted, models.OrganizationQuery{}))
+		assert.Equal(t, []uuid.UUID{org.ID}, asOfIDs(deleted, models.OrganizationQuery{IncludeDeleted: true}))
+		assert.Equal(t, []uuid.UUID{org.ID}, asOfIDs(purged, models.OrganizationQuery{IncludeDeleted: true}))
+		assert.Empty(t, asOfIDs(created, models.OrganizationQuery{Search: "acme"}))
+		assert.Equal(t, []uuid.UUID{org.ID}, asOfIDs(created, models.OrganizationQuery{Search: "clear"}))
+	})
+
+	t.Run("as_of_backfilled", func(t *testing.T) {
+		repo := newRepo(t)
+		org := newTestOrganization("CLEAR", 10000, true, 2002)
+		require.NoError(t, repo.Create(ctx, &org))
+		other := newTestOrganization("Acme", 20, false, 2010)
+		require.NoError(t, repo.Create(ctx, &other))
+		time.Sleep(10 * time.Millisecond)
+		beforeBackfill := time.Now().UTC()
+		time.Sleep(10 * time.Millisecond)
+		backfill(t, repo, org.ID)
+		time.Sleep(10 * time.Millisecond)
+		backfilled := time.Now().UTC()
+
+		// the organization is unknown before its backfilled version, while the history of others is complete
+		_, err := repo.GetAsOf(ctx, org.ID, beforeBackfill)
+		assert.Equal(t, ErrHistoryUnavailable, err)
+		found, err := repo.GetAsOf(ctx, other.ID, beforeBackfill)
+		require.NoError(t, err)
+		assert.Equal(t, "Acme", found.Name)
+		found, err = repo.GetAsOf(ctx, org.ID, backfilled)
+		require.NoError(t, err)
+		assert.Equal(t, "CLEAR", found.Name)
+
+		_, err = repo.Search(ctx, models.OrganizationQuery{AsOf: &beforeBackfill, Page: 1, PageSize: 10})
+		assert.Equal(t, ErrHistoryUnavailable, err)
+		err = repo.Stream(ctx, models.OrganizationQuery{AsOf: &beforeBackfill}, func(models.Organization) error {
+			return nil
+		})
+		assert.Equal(t, ErrHistoryUnavailable, err)
+		result, err := repo.Search(ctx, models.OrganizationQuery{AsOf: &backfilled, Page: 1, PageSize: 10})
+		require.NoError(t, err)
+		assert.Len(t, result.Organizations, 2)
+
+		// purging the organization removes its backfilled version along with the rest of its history
+		require.NoError(t, repo.Purge(ctx, org.ID))
+		_, err = repo.GetAsOf(ctx, org.ID, beforeBackfill)
+		assert.Equal(t, ErrNotFound, err)
+		result, err = repo.Search(ctx, models.OrganizationQuery{AsOf: &beforeBackfill, Page: 1, PageSize: 10})
+		require.NoError(t, err)
+		require.Len(t, result.Organizations, 1)
+		assert.Equal(t, other.ID, result.Organizations[0].ID)
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		repo := newRepo(t)
+		target := newTestOrganization("Acme Inc", 10, true, 2002)
+		source := newTestOrganization("ACME, Inc.", 50, false, 1990)
+		other := newTestOrganization("Acme Holdings", 20, false, 2010)
+		for _, org := range []*models.Organization{&target, &source, &other} {
+			require.NoError(t, repo.Create(ctx, org))
+		}
+
+		merge := models.OrganizationMerge{SourceID: source.ID, TargetID: target.ID, Rules: []byte(`{}`)}
+		merged, err := repo.Merge(ctx, &merge, func(mergeTarget, mergeSource models.Organization) (models.Organization, error) {
+			assertSameOrganization(t, target, mergeTarget)
+			assertSameOrganization(t, source, mergeSource)
+			mergeTarget.EmployeeCount = mergeSource.EmployeeCount
+			return mergeTarget, nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, target.ID, merged.ID)
+		assert.Equal(t, intPointer(50), merged.EmployeeCount)
+		assert.Equal(t, target.Version+1, merged.Version)
+		assert.False(t, merge.MergedAt.IsZero())
+		var sourceSnapshot models.Organization
+		require.NoError(t, json.Unmarshal(merge.Source, &sourceSnapshot))
+		assertSameOrganization(t, source, sourceSnapshot)
+
+		fetched, err := repo.Get(ctx, target.ID)
+		require.NoError(t, err)
+		assertSameOrganization(t, *merged, *fetched)
+		// the source is removed rather than soft deleted, so it cannot be restored
+		_, err = repo.Get(ctx, source.ID)
+		assert.Equal(t, ErrNotFound, err)
+		_, err = repo.Restore(ctx, source.ID)
+		assert.Equal(t, ErrNotFound, err)
+
+		resolvedID, err := repo.ResolveMerge(ctx, source.ID)
+		require.NoError(t, err)
+		assert.Equal(t, target.ID, resolvedID)
+		_, err = repo.ResolveMerge(ctx, target.ID)
+		assert.Equal(t, ErrNotFound, err)
+
+		// merges of the survivor are followed when resolving
+		merge = models.OrganizationMerge{SourceID: target.ID, TargetID: other.ID, Rules: []byte(`{}`)}
+		_, err = repo.Merge(ctx, &merge, func(mergeTarget, _ models.Organization) (models.Organization, error) {
+			return mergeTarget, nil
+		})
+		require.NoError(t, err)
+		resolvedID, err = repo.ResolveMerge(ctx, source.ID)
+		require.NoError(t, err)
+		assert.Equal(t, other.ID, resolvedID)
+
+		// nothing changes when either organization is missing or the merge function fails
+		merge = models.OrganizationMerge{SourceID: source.ID, TargetID: other.ID, Rules: []byte(`{}`)}
+		_, err = repo.Merge(ctx, &merge, func(mergeTarget, _ models.Organization) (models.Organization, error) {
+			return mergeTarget, nil
+		})
+		assert.Equal(t, ErrNotFound, err)
+		sourceHistory, err := repo.History(ctx, models.AuditQuery{OrganizationID: source.ID, Page: 1, PageSize: 20})
+		require.NoError(t, err)
+		require.Equal(t, 2, len(sourceHistory.Entries))
+		assert.Equal(t, models.MergeAuditAction, sourceHistory.Entries[0].Action)
+		assert.Nil(t, sourceHistory.Entries[0].After)
+		// the target was merged into and then merged away
+		targetHistory, err := repo.History(ctx, models.AuditQuery{OrganizationID: target.ID, Page: 1, PageSize: 20})
+		require.NoError(t, err)
+		require.Equal(t, 3, len(targetHistory.Entries))
+		assert.Nil(t, targetHistory.Entries[0].After)
+		assert.JSONEq(t, `{"employee_count": 10, "version": 1}`, string(targetHistory.Entries[1].Before))
+		assert.JSONEq(t, `{"employee_count": 50, "version": 2}`, string(targetHistory.Entries[1].After))
+		extra := newTestOrganization("Acme Ltd", 5, false, 2015)
+		require.NoError(t, repo.Create(ctx, &extra))
+		mergeErr := fmt.Errorf("merge failed")
+		merge = models.OrganizationMerge{SourceID: extra.ID, TargetID: other.ID, Rules: []byte(`{}`)}
+		_, err = repo.Merge(ctx, &merge, func(models.Organization, models.Organization) (models.Organization, error) {
+			return models.Organization{}, mergeErr
+		})
+		assert.Equal(t, mergeErr, err)
+		_, err = repo.Get(ctx, extra.ID)
+		assert.NoError(t, err)
+		_, err = repo.ResolveMerge(ctx, extra.ID)
+		assert.Equal(t, ErrNotFound, err)
+	})
+
+	t.Run("search", func(t *testing.T) {
+		repo := newRepo(t)
+		orgs := []models.Organization{
+			newTestOrganization("CLEAR", 10000, true, 2002),
+			newTestOrganization("Clearwater", 50, false, 2010),
+			newTestOrganization("Acme", 1000, true, 1990),
+			newTestOrganization("Acme_Labs", 5, false, 2015),
+			newTestOrganization("Globex", 0, false, 2020),
+		}
+		namesByID := map[uuid.UUID]string{}
+		for i := range orgs {
+			require.NoError(t, repo.Create(ctx, &orgs[i]))
+			namesByID[orgs[i].ID] = orgs[i].Name
+		}
+
+		var testCases = []struct {
+			query         models.OrganizationQuery
+			expectedNames []string
+		}{
+			{
+				query:         models.OrganizationQuery{},
+				expectedNames: []string{"CLEAR", "Clearwater", "Acme", "Acme_Labs", "Globex"},
+			},
+			{
+				// Testing exact matches are case sensitive
+				query:         models.OrganizationQuery{Filter: models.CategoryQueryFilter{DBfield: "name", ExactFilter: "CLEAR"}},
+				expectedNames: []string{"CLEAR"},
+			},
+			{
+				query:         models.OrganizationQuery{Filter: models.CategoryQueryFilter{DBfield: "name", LikeFilter: "Cl%"}},
+				expectedNames: []string{"Clearwater"},
+			},
+			{
+				query:         models.OrganizationQuery{Filter: models.CategoryQueryFilter{DBfield: "name", LikeFilter: "%e%"}},
+				expectedNames: []string{"Clearwater", "Acme", "Acme_Labs", "Globex"},
+			},
+			{
+				// Testing ILIKE matches case insensitively
+				query: models.OrganizationQuery{Filter: models.CategoryQueryFilter{DBfield: "name",
+					ILikeFilter: "%clear%"}},
+				expectedNames: []string{"CLEAR", "Clearwater"},
+			},
+			{
+				// Testing _ matches any single character
+				query:         models.OrganizationQuery{Filter: models.CategoryQueryFilter{DBfield: "name", LikeFilter: "Acm_"}},
+				expectedNames: []string{"Acme"},
+			},
+			{
+				query:         models.OrganizationQuery{Filter: models.CategoryQueryFilter{DBfield: "is_public", ExactFilter: "true"}},
+				expectedNames: []string{"CLEAR", "Acme"},
+			},
+			{
+				query:         models.OrganizationQuery{Filter: models.CategoryQueryFilter{DBfield: "employee_count", ExactFilter: "1000"}},
+				expectedNames: []string{"Acme"},
+			},
+			{
+				query: models.OrganizationQuery{Filter: models.CategoryQueryFilter{DBfield: "name",
+					InFilter: []string{"CLEAR", "Globex", "Initech"}}},
+				expectedNames: []string{"CLEAR", "Globex"},
+			},
+			{
+				query: models.OrganizationQuery{Filter: models.CategoryQueryFilter{DBfield: "employee_count",
+					NotInFilter: []string{"0", "5", "50"}}},
+				expectedNames: []string{"CLEAR", "Acme"},
+			},
+			{
+				query: models.OrganizationQuery{Filter: models.CategoryQueryFilter{DBfield: "creation_date",
+					InFilter: []string{"1990-06-01T00:00:00Z", "2020-06-01T00:00:00Z"}}},
+				expectedNames: []string{"Acme", "Globex"},
+			},
+			{
+				query: models.OrganizationQuery{Filter: models.CategoryQueryFilter{DBfield: "creation_date",
+					NullFilter: models.NotNull}},
+				expectedNames: []string{"CLEAR", "Clearwater", "Acme", "Acme_Labs", "Globex"},
+			},
+			{
+				// Testing a closed inclusive range
+				query:         models.OrganizationQuery{Filter: newRangeFilter("employee_count", "5", models.GTE, "1000", models.LTE)},
+				expectedNames: []string{"Clearwater", "Acme", "Acme_Labs"},
+			},
+			{
+				// Testing a closed exclusive range
+				query:         models.OrganizationQuery{Filter: newRangeFilter("employee_count", "5", models.GT, "1000", models.LT)},
+				expectedNames: []string{"Clearwater"},
+			},
+			{
+				// Testing an open upper bound
+				query:         models.OrganizationQuery{Filter: newRangeFilter("creation_date", "2010-01-01T00:00:00Z", models.GTE, "*", models.LTE)},
+				expectedNames: []string{"Clearwater", "Acme_Labs", "Globex"},
+			},
+			{
+				// Testing an open lower bound
+				query:         models.OrganizationQuery{Filter: newRangeFilter("creation_date", "*", models.GT, "2003-01-01T00:00:00Z", models.LTE)},
+				expectedNames: []string{"CLEAR", "Acme"},
+			},
+			{
+				// Testing categorical and range filters are combined
+				query: models.OrganizationQuery{Filter: models.AndExpression{Operands: []models.FilterExpression{
+					models.CategoryQueryFilter{DBfield: "is_public", ExactFilter: "false"},
+					newRangeFilter("employee_count", "1", models.GTE, "*", models.LTE),
+				}}},
+				expectedNames: []string{"Clearwater", "Acme_Labs"},
+			},
+			{
+				// Testing a range filter with both bounds can be nested and negated
+				query: models.OrganizationQuery{Filter: models.OrExpression{Operands: []models.FilterExpression{
+					models.CategoryQueryFilter{DBfield: "is_public", ExactFilter: "true"},
+					models.NotExpression{Operand: newRangeFilter("employee_count", "1", models.GTE, "1000",
+						models.LTE)},
+				}}},
+				expectedNames: []string{"CLEAR", "Acme", "Globex"},
+			},
+			{
+				// Testing AND, OR and NOT can be nested
+				query: models.OrganizationQuery{Filter: models.AndExpression{Operands: []models.FilterExpression{
+					models.NotExpression{Operand: models.CategoryQueryFilter{DBfield: "name", LikeFilter: "Acme%"}},
+					models.OrExpression{Operands: []models.FilterExpression{
+						models.CategoryQueryFilter{DBfield: "is_public", ExactFilter: "true"},
+						newRangeFilter("creation_date", "2015-01-01T00:00:00Z", models.GTE, "*", models.LTE),
+					}},
+				}}},
+				expectedNames: []string{"CLEAR", "Globex"},
+			},
+			{
+				// Testing empty expressions
+				query: models.OrganizationQuery{Filter: models.OrExpression{Operands: []models.FilterExpression{
+					models.AndExpression{},
+					models.OrExpression{},
+				}}},
+				expectedNames: []string{"CLEAR", "Clearwater", "Acme", "Acme_Labs", "Globex"},
+			},
+			{
+				query:         models.OrganizationQuery{Filter: models.OrExpression{}},
+				expectedNames: []string{},
+			},
+		}
+
+		for i, test := range testCases {
+			t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+				test.query.Page = 1
+				test.query.PageSize = 20
+				result, err := repo.Search(ctx, test.query)
+				require.NoError(t, err)
+				assert.Equal(t, int64(len(test.expectedNames)), result.TotalCount)
+
+				var names []string
+				for _, org := range result.Organizations {
+					names = append(names, namesByID[org.ID])
+				}
+				assert.ElementsMatch(t, test.expectedNames, names)
+				assertOrderedByID(t, result.Organizations)
+			})
+		}
+	})
+
+	t.Run("search_null_columns", func(t *testing.T) {
+		repo := newRepo(t)
+		orgs := []models.Organization{
+			newTestOrganization("CLEAR", 10000, true, 2002),
+			newTestOrganization("Globex", 0, false, 2020),
+			newTestOrganization("Legacy", 0, false, 1),
+			{Name: "Initech"},
+		}
+		namesByID := map[uuid.UUID]string{}
+		for i := range orgs {
+			require.NoError(t, repo.Create(ctx, &orgs[i]))
+			namesByID[orgs[i].ID] = orgs[i].Name
+		}
+		nullColumns(t, repo, orgs[2].ID)
+
+		// NULL columns are read back as nil rather than as zero values
+		for _, org := range orgs[2:] {
+			found, err := repo.Get(ctx, org.ID)
+			require.NoError(t, err)
+			assert.Nil(t, found.CreationDate)
+			assert.Nil(t, found.EmployeeCount)
+			assert.Nil(t, found.IsPublic)
+		}
+
+		var testCases = []struct {
+			filter        models.FilterExpression
+			expectedNames []string
+		}{
+			{
+				filter:        models.CategoryQueryFilter{DBfield: "employee_count", NullFilter: models.IsNull},
+				expectedNames: []string{"Legacy", "Initech"},
+			},
+			{
+				filter:        models.CategoryQueryFilter{DBfield: "creation_date", NullFilter: models.NotNull},
+				expectedNames: []string{"CLEAR", "Globex"},
+			},
+			{
+				// Testing NULL columns are not equal to the zero value
+				filter:        models.CategoryQueryFilter{DBfield: "employee_count", ExactFilter: "0"},
+				expectedNames: []string{"Globex"},
+			},
+			{
+				filter:        models.CategoryQueryFilter{DBfield: "is_public", InFilter: []string{"false"}},
+				expectedNames: []string{"Globex"},
+			},
+			{
+				// Testing NOT IN does not match NULL columns
+				filter:        models.CategoryQueryFilter{DBfield: "employee_count", NotInFilter: []string{"0"}},
+				expectedNames: []string{"CLEAR"},
+			},
+			{
+				// Testing NOT does not match NULL columns
+				filter: models.NotExpression{Operand: newRangeFilter("employee_count", "1", models.GTE, "20000",
+					models.LTE)},
+				expectedNames: []string{"Globex"},
+			},
+			{
+				filter:        models.NotExpression{Operand: models.CategoryQueryFilter{DBfield: "is_public", ExactFilter: "true"}},
+				expectedNames: []string{"Globex"},
+			},
+			{
+				filter: models.NotExpression{Operand: newRangeFilter("creation_date", "2000-01-01T00:00:00Z",
+					models.GTE, "*", models.LTE)},
+				expectedNames: []string{},
+			},
+			{
+				// Testing OR matches NULL columns when another operand is true
+				filter: models.OrExpression{Operands: []models.FilterExpression{
+					models.CategoryQueryFilter{DBfield: "employee_count", NullFilter: models.IsNull},
+					newRangeFilter("employee_count", "1", models.GTE, "*", models.LTE),
+				}},
+				expectedNames: []string{"CLEAR", "Legacy", "Initech"},
+			},
+			{
+				filter: models.NotExpression{Operand: models.AndExpression{Operands: []models.FilterExpression{
+					models.CategoryQueryFilter{DBfield: "name", LikeFilter: "%e%"},
+					models.CategoryQueryFilter{DBfield: "is_public", ExactFilter: "true"},
+				}}},
+				expectedNames: []string{"CLEAR", "Globex"},
+			},
+		}
+
+		for i, test := range testCases {
+			t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+				result, err := repo.Search(ctx, models.OrganizationQuery{Filter: test.filter, Page: 1, PageSize: 20})
+				require.NoError(t, err)
+				assert.Equal(t, int64(len(test.expectedNames)), result.TotalCount)
+
+				var names []string
+				for _, org := range result.Organizations {
+					names = append(names, namesByID[org.ID])
+				}
+				assert.ElementsMatch(t, test.expectedNames, names)
+			})
+		}
+
+		// NULL values are counted in facets and histograms and ignored by stats
+		result, err := repo.Aggregate(ctx, models.AggregateQuery{
+			Facets:     []string{"is_public"},
+			FacetLimit: 5,
+			Stats:      []string{"employee_count"},
+			Histograms: []models.HistogramQuery{{DBfield: "employee_count", Width: 100}},
+		})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []models.FacetBucket{{Value: nil, Count: 2}, {Value: true, Count: 1},
+			{Value: false, Count: 1}}, result.Facets["is_public"])
+		sum := int64(10000)
+		assert.Equal(t, models.FieldStats{Min: 0, Max: 10000, Avg: 5000.0, Sum: &sum}, result.Stats["employee_count"])
+		assert.Equal(t, []models.HistogramBucket{
+			{Start: 0, End: 100, Count: 1},
+			{Start: 10000, End: 10100, Count: 1},
+			{Start: nil, End: nil, Count: 2},
+		}, result.Histograms["employee_count"])
+	})
+
+	t.Run("search_full_text", func(t *testing.T) {
+		repo := newRepo(t)
+		orgs := []models.Organization{
+			newTestOrganization("Acme", 10, true, 2002),
+			newTestOrganization("ACME Corp", 50, false, 2010),
+			newTestOrganization("Acme Acme Holdings", 10, true, 1990),
+			newTestOrganization("Acmeville Corp", 50, false, 2015),
+			newTestOrganization("Globex", 10, false, 2020),
+		}
+		for i := range orgs {
+			require.NoError(t, repo.Create(ctx, &orgs[i]))
+		}
+
+		result, err := repo.Search(ctx, models.OrganizationQuery{Search: "ACME", Page: 1, PageSize: 20})
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), result.TotalCount)
+		require.Equal(t, 3, len(result.Organizations))
+		// names repeating the search terms are the most relevant, other matches are ordered by the sort fields
+		assert.Equal(t, orgs[2].ID, result.Organizations[0].ID)
+		assertOrderedByID(t, result.Organizations[1:])
+		assert.Equal(t, map[uuid.UUID]string{
+			orgs[0].ID: "<mark>Acme</mark>",
+			orgs[1].ID: "<mark>ACME</mark> Corp",
+			orgs[2].ID: "<mark>Acme</mark> <mark>Acme</mark> Holdings",
+		}, result.Highlights)
+
+		// every search term must match and the search can be combined with filters
+		result, err = repo.Search(ctx, models.OrganizationQuery{Search: "corp acme", Page: 1, PageSize: 20})
+		require.NoError(t, err)
+		require.Equal(t, 1, len(result.Organizations))
+		assert.Equal(t, orgs[1].ID, result.Organizations[0].ID)
+		result, err = repo.Search(ctx, models.OrganizationQuery{Search: "acme",
+			Filter: models.CategoryQueryFilter{DBfield: "is_public", ExactFilter: "false"}, Page: 1, PageSize: 20})
+		require.NoError(t, err)
+		require.Equal(t, 1, len(result.Organizations))
+		assert.Equal(t, orgs[1].ID, result.Organizations[0].ID)
+
+		result, err = repo.Search(ctx, models.OrganizationQuery{Page: 1, PageSize: 20})
+		require.NoError(t, err)
+		assert.Nil(t, result.Highlights)
+
+		_, err = repo.Search(ctx, models.OrganizationQuery{Search: "acme", PageSize: 20, After: &orgs[0]})
+		assert.Equal(t, ErrSearchKeyset, err)
+	})
+
+	t.Run("find_similar", func(t *testing.T) {
+		repo := newRepo(t)
+		orgs := []models.Organization{
+			newTestOrganization("Acme Corp", 10, true, 2002),
+			newTestOrganization("ACME Corp.", 50, false, 2010),
+			newTestOrganization("Acme Corporation", 10, true, 1990),
+			newTestOrganization("Globex", 50, false, 2015),
+			newTestOrganization("Acme Corp", 10, false, 2020),
+		}
+		for i := range orgs {
+			require.NoError(t, repo.Create(ctx, &orgs[i]))
+		}
+		require.NoError(t, repo.Delete(ctx, orgs[4].ID, 0))
+
+		result, err := repo.FindSimilar(ctx, models.SimilarNameQuery{Name: "Acme Corp", Threshold: 0.5,
+			ExcludeID: orgs[0].ID, Limit: 10})
+		require.NoError(t, err)
+		require.Equal(t, 2, len(result))
+		assertSameOrganization(t, orgs[1], result[0].Organization)
+		assert.InDelta(t, 1, result[0].Similarity, 0.0001)
+		assertSameOrganization(t, orgs[2], result[1].Organization)
+		assert.InDelta(t, 0.5, result[1].Similarity, 0.0001)
+
+		result, err = repo.FindSimilar(ctx, models.SimilarNameQuery{Name: "acme corp", Threshold: 0.6, Limit: 1})
+		require.NoError(t, err)
+		require.Equal(t, 1, len(result))
+		assert.InDelta(t, 1, result[0].Similarity, 0.0001)
+
+		result, err = repo.FindSimilar(ctx, models.SimilarNameQuery{Name: "Initech", Threshold: 0.3, Limit: 10})
+		require.NoError(t, err)
+		assert.Empty(t, result)
+
+		// batches find the organizations similar to each name like FindSimilar
+		batchResult, err := repo.FindSimilarBatch(ctx, models.SimilarNamesQuery{
+			Names: []string{"Initech", "Acme Corp", "globex", "Acme Corp"}, Threshold: 0.5, Limit: 2})
+		require.NoError(t, err)
+		require.Equal(t, 4, len(batchResult))
+		assert.Empty(t, batchResult[0])
+		for _, i := range []int{1, 3} {
+			require.Equal(t, 2, len(batchResult[i]))
+			assert.InDelta(t, 1, batchResult[i][0].Similarity, 0.0001)
+			assert.InDelta(t, 1, batchResult[i][1].Similarity, 0.0001)
+			assert.ElementsMatch(t, []uuid.UUID{orgs[0].ID, orgs[1].ID},
+				[]uuid.UUID{batchResult[i][0].Organization.ID, batchResult[i][1].Organization.ID})
+		}
+		require.Equal(t, 1, len(batchResult[2]))
+		assertSameOrganization(t, orgs[3], batchResult[2][0].Organization)
+		batchResult, err = repo.FindSimilarBatch(ctx, models.SimilarNamesQuery{Threshold: 0.5, Limit: 2})
+		require.NoError(t, err)
+		assert.Empty(t, batchResult)
+	})
+
+	t.Run("search_sort", func(t *testing.T) {
+		repo := newRepo(t)
+		orgs := []models.Organization{
+			newTestOrganization("acme", 10, true, 2002),
+			newTestOrganization("Globex", 50, false, 2010),
+			newTestOrganization("Initech", 10, true, 1990),
+			newTestOrganization("Umbrella", 50, false, 2015),
+			newTestOrganization("ACME", 10, false, 2020),
+		}
+		namesByID := map[uuid.UUID]string{}
+		for i := range orgs {
+			require.NoError(t, repo.Create(ctx, &orgs[i]))
+			namesByID[orgs[i].ID] = orgs[i].Name
+		}
+
+		var testCases = []struct {
+			sort          []models.SortField
+			expectedNames []string
+		}{
+			{
+				sort:          []models.SortField{{DBfield: "creation_date", Descending: true}},
+				expectedNames: []string{"ACME", "Umbrella", "Globex", "acme", "Initech"},
+			},
+			{
+				sort: []models.SortField{
+					{DBfield: "employee_count", Descending: true},
+					{DBfield: "creation_date"},
+				},
+				expectedNames: []string{"Globex", "Umbrella", "Initech", "acme", "ACME"},
+			},
+			{
+				sort: []models.SortField{
+					{DBfield: "is_public"},
+					{DBfield: "employee_count"},
+					{DBfield: "creation_date", Descending: true},
+				},
+				expectedNames: []string{"ACME", "Umbrella", "Globex", "acme", "Initech"},
+			},
+		}
+
+		for i, test := range testCases {
+			t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+				result, err := repo.Search(ctx, models.OrganizationQuery{Sort: test.sort, Page: 1, PageSize: 20})
+				require.NoError(t, err)
+				var names []string
+				for _, org := range result.Organizations {
+					names = append(names, namesByID[org.ID])
+				}
+				assert.Equal(t, test.expectedNames, names)
+			})
+		}
+
+		// names are sorted case insensitively with ties broken by id
+		result, err := repo.Search(ctx, models.OrganizationQuery{
+			Sort: []models.SortField{{DBfield: "name"}}, Page: 1, PageSize: 20})
+		require.NoError(t, err)
+		require.Equal(t, 5, len(result.Organizations))
+		assert.ElementsMatch(t, []string{"acme", "ACME"},
+			[]string{result.Organizations[0].Name, result.Organizations[1].Name})
+		assert.Less(t, result.Organizations[0].ID.String(), result.Organizations[1].ID.String())
+		assert.Equal(t, "Globex", result.Organizations[2].Name)
+		assert.Equal(t, "Umbrella", result.Organizations[4].Name)
+	})
+
+	t.Run("search_pagination", func(t *testing.T) {
+		repo := newRepo(t)
+		var ids []string
+		for i := 0; i < 5; i++ {
+			org := newTestOrganization(fmt.Sprintf("Organization %d", i), i, false, 2000+i)
+			require.NoError(t, repo.Create(ctx, &org))
+			ids = append(ids, org.ID.String())
+		}
+		sort.Strings(ids)
+
+		var pagedIDs []string
+		for page := 1; page <= 3; page++ {
+			result, err := repo.Search(ctx, models.OrganizationQuery{Page: page, PageSize: 2})
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), result.TotalCount)
+			for _, org := range result.Organizations {
+				pagedIDs = append(pagedIDs, org.ID.String())
+			}
+		}
+		assert.Equal(t, ids, pagedIDs)
+
+		result, err := repo.Search(ctx, models.OrganizationQuery{Page: 4, PageSize: 2})
+		require.NoError(t, err)
+		assert.Empty(t, result.Organizations)
+		assert.Equal(t, int64(5), result.TotalCount)
+		assert.False(t, result.HasMore)
+	})
+
+	t.Run("search_fields", func(t *testing.T) {
+		repo := newRepo(t)
+		orgs := []models.Organization{
+			newTestOrganization("Acme", 10, true, 2002),
+			newTestOrganization("Acme Corp", 50, false, 2010),
+		}
+		for i := range orgs {
+			require.NoError(t, repo.Create(ctx, &orgs[i]))
+		}
+
+		// the ID and sort fields are always selected
+		result, err := repo.Search(ctx, models.OrganizationQuery{Fields: []string{"name"},
+			Sort: []models.SortField{{DBfield: "employee_count", Descending: true}}, Page: 1, PageSize: 20})
+		require.NoError(t, err)
+		assert.Equal(t, []models.Organization{
+			{ID: orgs[1].ID, Name: "Acme Corp", EmployeeCount: intPointer(50)},
+			{ID: orgs[0].ID, Name: "Acme", EmployeeCount: intPointer(10)},
+		}, result.Organizations)
+
+		result, err = repo.Search(ctx, models.OrganizationQuery{Fields: []string{}, Search: "acme", Page: 1,
+			PageSize: 20})
+		require.NoError(t, err)
+		require.Equal(t, 2, len(result.Organizations))
+		for _, org := range result.Organizations {
+			assert.Equal(t, models.Organization{ID: org.ID}, org)
+		}
+		assert.Equal(t, "<mark>Acme</mark> Corp", result.Highlights[orgs[1].ID])
+
+		_, err = repo.Search(ctx, models.OrganizationQuery{Fields: []string{"revenue"}, Page: 1, PageSize: 20})
+		assert.Error(t, err)
+	})
+
+	t.Run("aggregate", func(t *testing.T) {
+		repo := newRepo(t)
+		orgs := []models.Organization{
+			newTestOrganization("Acme", 10, true, 2002),
+			newTestOrganization("Acme Corp", 50, false, 2010),
+			newTestOrganization("Globex", 120, true, 2010),
+			newTestOrganization("Initech", 130, true, 2011),
+		}
+		for i := range orgs {
+			require.NoError(t, repo.Create(ctx, &orgs[i]))
+		}
+		deleted := newTestOrganization("Umbrella", 900, false, 1990)
+		require.NoError(t, repo.Create(ctx, &deleted))
+		require.NoError(t, repo.Delete(ctx, deleted.ID, 0))
+
+		result, err := repo.Aggregate(ctx, models.AggregateQuery{
+			Facets:     []string{"is_public", "name"},
+			FacetLimit: 2,
+			Stats:      []string{"employee_count", "creation_date"},
+			Histograms: []models.HistogramQuery{
+				{DBfield: "employee_count", Width: 100},
+				{DBfield: "creation_date", Interval: models.YearInterval},
+			},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), result.Count)
+		assert.Equal(t, []models.FacetBucket{{Value: true, Count: 3}, {Value: false, Count: 1}},
+			result.Facets["is_public"])
+		// ties are ordered like the column is sorted
+		assert.Equal(t, []models.FacetBucket{{Value: "Acme", Count: 1}, {Value: "Acme Corp", Count: 1}},
+			result.Facets["name"])
+
+		sum := int64(310)
+		assert.Equal(t, models.FieldStats{Min: 10, Max: 130, Avg: 77.5, Sum: &sum}, result.Stats["employee_count"])
+		var epochSum int64
+		for _, org := range orgs {
+			epochSum += org.CreationDate.Unix()
+		}
+		assert.Equal(t, models.FieldStats{
+			Min: *orgs[0].CreationDate,
+			Max: *orgs[3].CreationDate,
+			Avg: time.Unix(epochSum/int64(len(orgs)), 0).UTC(),
+		}, result.Stats["creation_date"])
+
+		assert.Equal(t, []models.HistogramBucket{
+			{Start: 0, End: 100, Count: 2},
+			{Start: 100, End: 200, Count: 2},
+		}, result.Histograms["employee_count"])
+		assert.Equal(t, []models.HistogramBucket{
+			{Start: time.Date(2002, 1, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2003, 1, 1, 0, 0, 0, 0, time.UTC), Count: 1},
+			{Start: time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC), Count: 2},
+			{Start: time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2012, 1, 1, 0, 0, 0, 0, time.UTC), Count: 1},
+		}, result.Histograms["creation_date"])
+
+		// aggregations only cover the organizations matching the filter and search
+		result, err = repo.Aggregate(ctx, models.AggregateQuery{
+			Filter:     models.CategoryQueryFilter{DBfield: "is_public", ExactFilter: "true"},
+			Search:     "acme",
+			Facets:     []string{"name"},
+			FacetLimit: 10,
+			Stats:      []string{"employee_count"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), result.Count)
+		assert.Equal(t, []models.FacetBucket{{Value: "Acme", Count: 1}}, result.Facets["name"])
+		sum = 10
+		assert.Equal(t, models.FieldStats{Min: 10, Max: 10, Avg: 10.0, Sum: &sum}, result.Stats["employee_count"])
+
+		result, err = repo.Aggregate(ctx, models.AggregateQuery{IncludeDeleted: true, Stats: []string{"employee_count"}})
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), result.Count)
+		assert.Equal(t, 900, result.Stats["employee_count"].Max)
+
+		// no matches leaves every statistic empty
+		result, err = repo.Aggregate(ctx, models.AggregateQuery{Search: "hooli", Facets: []string{"is_public"},
+			FacetLimit: 10, Stats: []string{"employee_count"}})
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), result.Count)
+		assert.Equal(t, []models.FacetBucket{}, result.Facets["is_public"])
+		assert.Equal(t, models.FieldStats{}, result.Stats["employee_count"])
+
+		_, err = repo.Aggregate(ctx, models.AggregateQuery{Facets: []string{"revenue"}, FacetLimit: 10})
+		assert.Error(t, err)
+		_, err = repo.Aggregate(ctx, models.AggregateQuery{Stats: []string{"name"}})
+		assert.Error(t, err)
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		repo := newRepo(t)
+		orgs := []models.Organization{
+			newTestOrganization("Acme", 10, true, 2002),
+			newTestOrganization("Acme Corp", 50, false, 2010),
+			newTestOrganization("Globex", 120, true, 2010),
+		}
+		for i := range orgs {
+			require.NoError(t, repo.Create(ctx, &orgs[i]))
+		}
+		require.NoError(t, repo.Delete(ctx, orgs[1].ID, 0))
+
+		var streamed []models.Organization
+		collect := func(org models.Organization) error {
+			streamed = append(streamed, org)
+			return nil
+		}
+		require.NoError(t, repo.Stream(ctx, models.OrganizationQuery{
+			Sort: []models.SortField{{DBfield: "employee_count", Descending: true}}, Page: 1, PageSize: 1}, collect))
+		require.Equal(t, 2, len(streamed))
+		assertSameOrganization(t, orgs[2], streamed[0])
+		assertSameOrganization(t, orgs[0], streamed[1])
+
+		streamed = nil
+		require.NoError(t, repo.Stream(ctx, models.OrganizationQuery{Search: "acme", IncludeDeleted: true,
+			Filter: models.CategoryQueryFilter{DBfield: "is_public", ExactFilter: "false"}, Fields: []string{"name"}},
+			collect))
+		assert.Equal(t, []models.Organization{{ID: orgs[1].ID, Name: "Acme Corp"}}, streamed)
+
+		// an error from the callback stops the stream
+		calls := 0
+		stop := errors.New("stop")
+		err := repo.Stream(ctx, models.OrganizationQuery{}, func(models.Organization) error {
+			calls++
+			return stop
+		})
+		assert.Equal(t, stop, err)
+		assert.Equal(t, 1, calls)
+
+		err = repo.Stream(ctx, models.OrganizationQuery{Fields: []string{"revenue"}}, collect)
+		assert.Error(t, err)
+	})
+
+	t.Run("search_keyset_pagination", func(t *testing.T) {
+		repo := newRepo(t)
+		for i := 0; i < 7; i++ {
+			// repeated names and employee counts exercise the tiebreakers
+			org := newTestOrganization(fmt.Sprintf("Organization %d", i%2), i%3, i%2 == 0, 2000+i)
+			require.NoError(t, repo.Create(ctx, &org))
+		}
+
+		for _, sortFields := range [][]models.SortField{
+			nil,
+			{{DBfield: "employee_count", Descending: true}},
+			{{DBfield: "name"}, {DBfield: "employee_count", Descending: true}},
+			{{DBfield: "is_public", Descending: true}, {DBfield: "creation_date"}},
+		} {
+			t.Run(fmt.Sprintf("%v", sortFields), func(t *testing.T) {
+				expected, err := repo.Search(ctx, models.OrganizationQuery{Sort: sortFields, Page: 1, PageSize: 7})
+				require.NoError(t, err)
+				assert.False(t, expected.HasMore)
+
+				var paged []models.Organization
+				query := models.OrganizationQuery{Sort: sortFields, PageSize: 3, OmitTotalCount: true}
+				for {
+					result, err := repo.Search(ctx, query)
+					require.NoError(t, err)
+					assert.Equal(t, int64(0), result.TotalCount)
+					paged = append(paged, result.Organizations...)
+					if !result.HasMore {
+						break
+					}
+					require.Equal(t, 3, len(result.Organizations))
+					query.After = &result.Organizations[len(result.Organizations)-1]
+				}
+
+				require.Equal(t, len(expected.Organizations), len(paged))
+				for i := range paged {
+					assert.Equal(t, expected.Organizations[i].ID, paged[i].ID)
+				}
+			})
+		}
+	})
+
+	t.Run("search_keyset_pagination_null", func(t *testing.T) {
+		repo := newRepo(t)
+		for i := 0; i < 8; i++ {
+			// the NULL columns are ordered as zero values, so they tie with the organizations created with zero values
+			org := newTestOrganization(fmt.Sprintf("Organization %d", i), 0, false, 1)
+			org.CreationDate = timePointer(time.Time{})
+			if i%3 == 2 {
+				org = newTestOrganization(fmt.Sprintf("Organization %d", i), i, true, 2000+i)
+			}
+			require.NoError(t, repo.Create(ctx, &org))
+			if i%3 == 0 {
+				nullColumns(t, repo, org.ID)
+			}
+		}
+
+		for _, sortFields := range [][]models.SortField{
+			{{DBfield: "employee_count"}},
+			{{DBfield: "employee_count", Descending: true}},
+			{{DBfield: "creation_date"}, {DBfield: "name", Descending: true}},
+			{{DBfield: "is_public", Descending: true}, {DBfield: "creation_date", Descending: true}},
+		} {
+			t.Run(fmt.Sprintf("%v", sortFields), func(t *testing.T) {
+				expected, err := repo.Search(ctx, models.OrganizationQuery{Sort: sortFields, Page: 1, PageSize: 8})
+				require.NoError(t, err)
+				require.Equal(t, 8, len(expected.Organizations))
+
+				var paged []models.Organization
+				query := models.OrganizationQuery{Sort: sortFields, PageSize: 2, OmitTotalCount: true}
+				for {
+					result, err := repo.Search(ctx, query)
+					require.NoError(t, err)
+					paged = append(paged, result.Organizations...)
+					if !result.HasMore {
+						break
+					}
+					query.After = &result.Organizations[len(result.Organizations)-1]
+				}
+
+				require.Equal(t, len(expected.Organizations), len(paged))
+				for i := range paged {
+					assert.Equal(t, expected.Organizations[i].ID, paged[i].ID)
+				}
+			})
+		}
+	})
+}
+
+func newTestOrganization(name string, employeeCount int, isPublic bool, creationYear int) models.Organization {
+	return models.Organization{
+		Name:          name,
+		CreationDate:  timePointer(time.Date(creationYear, 6, 1, 0, 0, 0, 0, time.UTC)),
+		EmployeeCount: intPointer(employeeCount),
+		IsPublic:      boolPointer(isPublic),
+	}
+}
+
+func intPointer(value int) *int {
+	return &value
+}
+
+func boolPointer(value bool) *bool {
+	return &value
+}
+
+func timePointer(value time.Time) *time.Time {
+	return &value
+}
+
+func newRangeFilter(field, start, startComparator, end, endComparator string) models.RangeQueryFilter {
+	return models.RangeQueryFilter{
+		DBfield:         field,
+		StartRange:      start,
+		StartComparator: startComparator,
+		EndRange:        end,
+		EndComparator:   endComparator,
+	}
+}
+
+// assertSameOrganization compares organizations field by field since timestamps read back from Postgres carry a
+// different location than the ones written
+func assertSameOrganization(t *testing.T, expected, actual models.Organization) {
+	assert.Equal(t, expected.ID, actual.ID)
+	assert.Equal(t, expected.Name, actual.Name)
+	if expected.CreationDate == nil || actual.CreationDate == nil {
+		assert.Equal(t, expected.CreationDate, actual.CreationDate)
+	} else {
+		assert.True(t, expected.CreationDate.Equal(*actual.CreationDate), "expected creation date %v, got %v",
+			*expected.CreationDate, *actual.CreationDate)
+	}
+	assert.Equal(t, expected.EmployeeCount, actual.EmployeeCount)
+	assert.Equal(t, expected.IsPublic, actual.IsPublic)
+	assert.Equal(t, expected.DeletedAt.Valid, actual.DeletedAt.Valid)
+	assert.Equal(t, expected.Version, actual.Version)
+}
+
+func assertOrderedByID(t *testing.T, orgs []models.Organization) {
+	for i := 1; i < len(orgs); i++ {
+		assert.Less(t, orgs[i-1].ID.String(), orgs[i].ID.String())
+	}
+}
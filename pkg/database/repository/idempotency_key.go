@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"organization_manager/pkg/database/models"
+	"sync"
+	"time"
+)
+
+// ErrKeyNotReserved is returned when completing an idempotency key that is not reserved, such as an expired key or a
+// key taken over by a retry
+var ErrKeyNotReserved = errors.New("idempotency key is not reserved")
+
+// IdempotencyKeyRepository stores the idempotency keys of requests and their responses. Expired keys are treated as
+// if they did not exist. A reservation is identified by its Key and CreatedAt, so a request whose key was taken over
+// cannot complete or release the reservation of the retry that took it over
+type IdempotencyKeyRepository interface {
+	// Reserve stores a new idempotency key for a request in progress and returns nil, unless an unexpired key with
+	// the same Key exists in which case the existing key is returned and nothing is stored. A key whose request is
+	// still in progress after its lease ended is replaced like an expired key
+	Reserve(ctx context.Context, key *models.IdempotencyKey) (*models.IdempotencyKey, error)
+	// Complete stores the status code, headers and body of the response to the request of a reserved key, or returns
+	// ErrKeyNotReserved
+	Complete(ctx context.Context, key *models.IdempotencyKey) error
+	// Release removes a reserved key whose request is in progress, so the request can be retried as a new request
+	Release(ctx context.Context, key *models.IdempotencyKey) error
+}
+
+// MemoryIdempotencyKeyRepository is a thread safe IdempotencyKeyRepository that keeps idempotency keys in memory
+type MemoryIdempotencyKeyRepository struct {
+	mu   sync.Mutex
+	keys map[string]models.IdempotencyKey
+}
+
+func NewMemoryIdempotencyKeyRepository() *MemoryIdempotencyKeyRepository {
+	return &MemoryIdempotencyKeyRepository{keys: map[string]models.IdempotencyKey{}}
+}
+
+func (r *MemoryIdempotencyKeyRepository) Reserve(_ context.Context,
+	key *models.IdempotencyKey) (*models.IdempotencyKey, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if existing, exists := r.keys[key.Key]; exists && existing.ExpiresAt.After(now) &&
+		(existing.IsCompleted() || existing.LockedUntil.After(now)) {
+		return &existing, nil
+	}
+	r.keys[key.Key] = *key
+	return nil, nil
+}
+
+func (r *MemoryIdempotencyKeyRepository) Complete(_ context.Context, key *models.IdempotencyKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.keys[key.Key]
+	if !exists || !existing.ExpiresAt.After(time.Now()) || !isReservation(existing, key) {
+		return ErrKeyNotReserved
+	}
+	existing.StatusCode = key.StatusCode
+	existing.Headers = key.Headers
+	existing.Body = key.Body
+	r.keys[key.Key] = existing
+	return nil
+}
+
+func (r *MemoryIdempotencyKeyRepository) Release(_ context.Context, key *models.IdempotencyKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, exists := r.keys[key.Key]; exists && isReservation(existing, key) {
+		delete(r.keys, key.Key)
+	}
+	return nil
+}
+
+// isReservation reports whether an existing key is the reservation of key's request and is still in progress
+func isReservation(existing models.IdempotencyKey, key *models.IdempotencyKey) bool {
+	return !existing.IsCompleted() && existing.CreatedAt.Equal(key.CreatedAt)
+}
+
+// PostgresIdempotencyKeyRepository is an IdempotencyKeyRepository backed by the idempotency_keys table
+type PostgresIdempotencyKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewPostgresIdempotencyKeyRepository(db *gorm.DB) *PostgresIdempotencyKeyRepository {
+	return &PostgresIdempotencyKeyRepository{db: db}
+}
+
+// reserveAttempts is how many times Reserve inserts a key whose existing key is removed before it can be read
+const reserveAttempts = 3
+
+// Reserve removes every expired key before inserting the key, which keeps the table from growing and lets an expired
+// key be reused. The insert only replaces an existing key when its lease ended before it completed, so concurrent
+// requests cannot both reserve a key. The existing key can be released or swept as expired by another request between
+// the insert and the read, in which case the insert is retried
+func (r *PostgresIdempotencyKeyRepository) Reserve(ctx context.Context,
+	key *models.IdempotencyKey) (*models.IdempotencyKey, error) {
+
+	db := r.db.WithContext(ctx)
+	now := time.Now().UTC()
+	err := db.Where("expires_at <= ?", now).Delete(&models.IdempotencyKey{}).Error
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 1; ; attempt++ {
+		result := db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"request_hash", "created_at", "locked_until", "expires_at"}),
+			Where: clause.Where{Exprs: []clause.Expression{clause.Expr{
+				SQL:  "idempotency_keys.status_code = 0 AND idempotency_keys.locked_until <= ?",
+				Vars: []interface{}{now},
+			}}},
+		}).Create(key)
+		if result.Error != nil {
+			return nil, result.Error
+		} else if result.RowsAffected == 1 {
+			return nil, nil
+		}
+
+		var existing models.IdempotencyKey
+		err = db.Where("key = ?", key.Key).First(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) && attempt < reserveAttempts {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return &existing, nil
+	}
+}
+
+func (r *PostgresIdempotencyKeyRepository) Complete(ctx context.Context, key *models.IdempotencyKey) error {
+	result := r.db.WithContext(ctx).Model(&models.IdempotencyKey{}).
+		Where("key = ? AND created_at = ? AND status_code = 0 AND expires_at > ?", key.Key, key.CreatedAt,
+			time.Now().UTC()).
+		Updates(map[string]interface{}{"status_code": key.StatusCode, "headers": key.Headers, "body": key.Body})
+	if result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected == 0 {
+		return ErrKeyNotReserved
+	}
+	return nil
+}
+
+// Release only removes keys whose request is in progress, the responses of completed requests are kept until they
+// expire
+func (r *PostgresIdempotencyKeyRepository) Release(ctx context.Context, key *models.IdempotencyKey) error {
+	return r.db.WithContext(ctx).Where("key = ? AND created_at = ? AND status_code = 0", key.Key, key.CreatedAt).
+		Delete(&models.IdempotencyKey{}).Error
+}
@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"organization_manager/pkg/database"
+	"organization_manager/pkg/database/models"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMemoryIdempotencyKeyRepository(t *testing.T) {
+	runIdempotencyKeyConformanceTests(t, func(t *testing.T) IdempotencyKeyRepository {
+		return NewMemoryIdempotencyKeyRepository()
+	})
+}
+
+func TestPostgresIdempotencyKeyRepository(t *testing.T) {
+	dbURL := os.Getenv(testDatabaseURLEnv)
+	if dbURL == "" {
+		t.Skipf("%s is not set", testDatabaseURLEnv)
+	}
+	require.NoError(t, database.RunMigrations("file://../migrations", dbURL))
+	db, err := database.Initialize(dbURL)
+	require.NoError(t, err)
+
+	runIdempotencyKeyConformanceTests(t, func(t *testing.T) IdempotencyKeyRepository {
+		require.NoError(t, db.Exec("TRUNCATE idempotency_keys").Error)
+		return NewPostgresIdempotencyKeyRepository(db)
+	})
+}
+
+// runIdempotencyKeyConformanceTests checks an IdempotencyKeyRepository implementation against the behavior every
+// implementation must share. newRepo must return an empty repository
+func runIdempotencyKeyConformanceTests(t *testing.T, newRepo func(t *testing.T) IdempotencyKeyRepository) {
+	ctx := context.Background()
+
+	t.Run("reserve_and_complete", func(t *testing.T) {
+		repo := newRepo(t)
+		key := newTestIdempotencyKey("key-1", time.Hour)
+		existing, err := repo.Reserve(ctx, &key)
+		require.NoError(t, err)
+		assert.Nil(t, existing)
+
+		retry := newTestIdempotencyKey("key-1", time.Hour)
+		retry.RequestHash = "other"
+		existing, err = repo.Reserve(ctx, &retry)
+		require.NoError(t, err)
+		require.NotNil(t, existing)
+		assert.Equal(t, "hash", existing.RequestHash)
+		assert.False(t, existing.IsCompleted())
+
+		key.StatusCode = 201
+		key.Headers = models.JSONDocument(`{"Content-Type": ["application/json"]}`)
+		key.Body = []byte(`{"name": "CLEAR"}`)
+		require.NoError(t, repo.Complete(ctx, &key))
+		existing, err = repo.Reserve(ctx, &retry)
+		require.NoError(t, err)
+		require.NotNil(t, existing)
+		assert.Equal(t, 201, existing.StatusCode)
+		assert.JSONEq(t, `{"Content-Type": ["application/json"]}`, string(existing.Headers))
+		assert.Equal(t, key.Body, existing.Body)
+
+		// completed keys are kept until they expire
+		require.NoError(t, repo.Release(ctx, &key))
+		existing, err = repo.Reserve(ctx, &retry)
+		require.NoError(t, err)
+		assert.NotNil(t, existing)
+
+		missing := newTestIdempotencyKey("key-2", time.Hour)
+		assert.Equal(t, ErrKeyNotReserved, repo.Complete(ctx, &missing))
+	})
+
+	t.Run("release", func(t *testing.T) {
+		repo := newRepo(t)
+		key := newTestIdempotencyKey("key-1", time.Hour)
+		_, err := repo.Reserve(ctx, &key)
+		require.NoError(t, err)
+		require.NoError(t, repo.Release(ctx, &key))
+
+		existing, err := repo.Reserve(ctx, &key)
+		require.NoError(t, err)
+		assert.Nil(t, existing)
+		missing := newTestIdempotencyKey("key-2", time.Hour)
+		require.NoError(t, repo.Release(ctx, &missing))
+	})
+
+	t.Run("lease", func(t *testing.T) {
+		repo := newRepo(t)
+		key := newTestIdempotencyKey("key-1", time.Hour)
+		key.LockedUntil = key.CreatedAt.Add(-time.Second)
+		_, err := repo.Reserve(ctx, &key)
+		require.NoError(t, err)
+
+		// a request in progress after its lease ended is taken over by a retry
+		retry := newTestIdempotencyKey("key-1", time.Hour)
+		retry.CreatedAt = retry.CreatedAt.Add(time.Millisecond)
+		existing, err := repo.Reserve(ctx, &retry)
+		require.NoError(t, err)
+		assert.Nil(t, existing)
+		other := newTestIdempotencyKey("key-1", time.Hour)
+		existing, err = repo.Reserve(ctx, &other)
+		require.NoError(t, err)
+		require.NotNil(t, existing)
+		assert.True(t, retry.CreatedAt.Equal(existing.CreatedAt))
+
+		// the request that lost its key can neither release nor complete the reservation of the retry
+		require.NoError(t, repo.Release(ctx, &key))
+		key.StatusCode = 201
+		assert.Equal(t, ErrKeyNotReserved, repo.Complete(ctx, &key))
+		retry.StatusCode = 201
+		require.NoError(t, repo.Complete(ctx, &retry))
+
+		// completed keys are not taken over once their lease ended
+		existing, err = repo.Reserve(ctx, &other)
+		require.NoError(t, err)
+		require.NotNil(t, existing)
+		assert.Equal(t, 201, existing.StatusCode)
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		repo := newRepo(t)
+		key := newTestIdempotencyKey("key-1", -time.Minute)
+		_, err := repo.Reserve(ctx, &key)
+		require.NoError(t, err)
+		key.StatusCode = 201
+		assert.Equal(t, ErrKeyNotReserved, repo.Complete(ctx, &key))
+
+		// expired keys are replaced by new reservations
+		renewed := newTestIdempotencyKey("key-1", time.Hour)
+		existing, err := repo.Reserve(ctx, &renewed)
+		require.NoError(t, err)
+		assert.Nil(t, existing)
+		renewed.StatusCode = 201
+		assert.NoError(t, repo.Complete(ctx, &renewed))
+	})
+}
+
+func newTestIdempotencyKey(key string, ttl time.Duration) models.IdempotencyKey {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	return models.IdempotencyKey{Key: key, RequestHash: "hash", CreatedAt: now, LockedUntil: now.Add(time.Minute),
+		ExpiresAt: now.Add(ttl)}
+}
@@ -0,0 +1,1060 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"math"
+	"organization_manager/pkg/database/models"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// MemoryURLScheme selects the in-memory repository when used as the DATABASE_URL
+const MemoryURLScheme = "memory://"
+
+// MemoryOrganizationRepository is a thread safe OrganizationRepository that keeps organizations in memory. It
+// applies the same filter semantics as PostgresOrganizationRepository and is intended for tests and local demos
+type MemoryOrganizationRepository struct {
+	mu            sync.RWMutex
+	organizations map[uuid.UUID]models.Organization
+	// merges holds the merge of each removed source organization by its ID
+	merges map[uuid.UUID]models.OrganizationMerge
+	// audit holds the audit log entries of every organization in the order they were recorded
+	audit []models.OrganizationAuditEntry
+	// versions holds every version of every organization in the order they were written
+	versions []organizationVersion
+}
+
+// organizationVersion is a version of an organization and the period it was valid for, ValidTo is zero while it is
+// the current version. Backfilled versions were recorded after the fact, the organization is unknown before them
+type organizationVersion struct {
+	models.Organization
+	ValidFrom  time.Time
+	ValidTo    time.Time
+	Backfilled bool
+}
+
+// isValidAt determines if the version was the current version of its organization at a moment
+func (v organizationVersion) isValidAt(asOf time.Time) bool {
+	return !v.ValidFrom.After(asOf) && (v.ValidTo.IsZero() || v.ValidTo.After(asOf))
+}
+
+func NewMemoryOrganizationRepository() *MemoryOrganizationRepository {
+	return &MemoryOrganizationRepository{
+		organizations: map[uuid.UUID]models.Organization{},
+		merges:        map[uuid.UUID]models.OrganizationMerge{},
+	}
+}
+
+func (r *MemoryOrganizationRepository) Create(ctx context.Context, org *models.Organization) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	org.ID = uuid.New()
+	org.Version = 1
+	entry, err := newAuditEntry(ctx, models.CreateAuditAction, nil, org)
+	if err != nil {
+		return err
+	}
+	r.organizations[org.ID] = *org
+	r.recordAudit(entry)
+	r.recordVersion(*org, entry.ChangedAt)
+	return nil
+}
+
+func (r *MemoryOrganizationRepository) CreateBatch(ctx context.Context, orgs []models.Organization) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries := make([]models.OrganizationAuditEntry, len(orgs))
+	for i := range orgs {
+		orgs[i].ID = uuid.New()
+		orgs[i].Version = 1
+		var err error
+		entries[i], err = newAuditEntry(ctx, models.CreateAuditAction, nil, &orgs[i])
+		if err != nil {
+			return err
+		}
+	}
+	for i := range orgs {
+		r.organizations[orgs[i].ID] = orgs[i]
+		r.recordAudit(entries[i])
+		r.recordVersion(orgs[i], entries[i].ChangedAt)
+	}
+	return nil
+}
+
+func (r *MemoryOrganizationRepository) Get(_ context.Context, id uuid.UUID) (*models.Organization, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	org, exists := r.organizations[id]
+	if !exists || org.DeletedAt.Valid {
+		return nil, ErrNotFound
+	}
+	return &org, nil
+}
+
+func (r *MemoryOrganizationRepository) GetAsOf(_ context.Context, id uuid.UUID,
+	asOf time.Time) (*models.Organization, error) {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	org, exists := r.organizationsAsOf(&asOf)[id]
+	if !exists {
+		err := r.checkHistoryAvailable(asOf, &id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !exists || org.DeletedAt.Valid {
+		return nil, ErrNotFound
+	}
+	return &org, nil
+}
+
+// Search filters every stored organization in memory, orders the matches and returns the requested page
+func (r *MemoryOrganizationRepository) Search(_ context.Context,
+	orgQuery models.OrganizationQuery) (*models.OrganizationSearchResult, error) {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if orgQuery.Search != "" && orgQuery.After != nil {
+		return nil, ErrSearchKeyset
+	}
+	searchTerms := searchWords(orgQuery.Search)
+	columns := orgQuery.SelectedColumns()
+	err := checkColumns(columns)
+	if err != nil {
+		return nil, err
+	}
+
+	matches, ranks, err := r.matchingOrganizations(orgQuery.Filter, orgQuery.Search, orgQuery.IncludeDeleted,
+		orgQuery.AsOf)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if ranks[matches[i].ID] != ranks[matches[j].ID] {
+			return ranks[matches[i].ID] > ranks[matches[j].ID]
+		}
+		return compareOrganizations(matches[i], matches[j], orgQuery.Sort) < 0
+	})
+
+	var result models.OrganizationSearchResult
+	if !orgQuery.OmitTotalCount {
+		result.TotalCount = int64(len(matches))
+	}
+
+	// keyset pagination starts after the first organization ordered after the given organization, while page
+	// based pagination skips the previous pages
+	offset := 0
+	if orgQuery.After != nil {
+		offset = sort.Search(len(matches), func(i int) bool {
+			return compareOrganizations(matches[i], *orgQuery.After, orgQuery.Sort) > 0
+		})
+	} else if orgQuery.Page > 1 {
+		offset = (orgQuery.Page - 1) * orgQuery.PageSize
+	}
+	if offset < len(matches) {
+		end := offset + orgQuery.PageSize
+		if end < len(matches) {
+			result.HasMore = true
+		} else {
+			end = len(matches)
+		}
+		result.Organizations = matches[offset:end]
+	}
+	if orgQuery.Search != "" {
+		result.Highlights = make(map[uuid.UUID]string, len(result.Organizations))
+		for _, org := range result.Organizations {
+			result.Highlights[org.ID] = highlightWords(org.Name, searchTerms)
+		}
+	}
+	if columns != nil {
+		projectedOrgs := make([]models.Organization, len(result.Organizations))
+		for i, org := range result.Organizations {
+			projectedOrgs[i] = org.Project(columns)
+		}
+		result.Organizations = projectedOrgs
+	}
+	return &result, nil
+}
+
+// Stream orders the matching organizations in memory and calls fn without holding the lock, so fn sees a snapshot of
+// the organizations taken when the stream started
+func (r *MemoryOrganizationRepository) Stream(_ context.Context, orgQuery models.OrganizationQuery,
+	fn func(models.Organization) error) error {
+
+	columns := orgQuery.SelectedColumns()
+	err := checkColumns(columns)
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	matches, _, err := r.matchingOrganizations(orgQuery.Filter, orgQuery.Search, orgQuery.IncludeDeleted,
+		orgQuery.AsOf)
+	r.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return compareOrganizations(matches[i], matches[j], orgQuery.Sort) < 0
+	})
+
+	for _, org := range matches {
+		if columns != nil {
+			org = org.Project(columns)
+		}
+		err = fn(org)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchingOrganizations returns the organizations matching a filter expression and the full text search terms, as
+// they were at the asOf moment when it is not nil, along with the search rank of each match. The caller must hold the
+// lock
+func (r *MemoryOrganizationRepository) matchingOrganizations(filter models.FilterExpression, search string,
+	includeDeleted bool, asOf *time.Time) ([]models.Organization, map[uuid.UUID]int, error) {
+
+	if asOf != nil {
+		err := r.checkHistoryAvailable(*asOf, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	searchTerms := searchWords(search)
+	var matches []models.Organization
+	ranks := map[uuid.UUID]int{}
+	for _, org := range r.organizationsAsOf(asOf) {
+		if org.DeletedAt.Valid && !includeDeleted {
+			continue
+		}
+		isMatch, err := matchesFilter(org, filter)
+		if err != nil {
+			return nil, nil, err
+		}
+		if isMatch && search != "" {
+			ranks[org.ID] = searchRank(org.Name, searchTerms)
+			isMatch = ranks[org.ID] > 0
+		}
+		if isMatch {
+			matches = append(matches, org)
+		}
+	}
+	return matches, ranks, nil
+}
+
+// Aggregate computes every aggregation over the matching organizations in memory. Like Postgres, NULL values are
+// counted in facets and histograms but ignored by stats
+func (r *MemoryOrganizationRepository) Aggregate(_ context.Context,
+	aggQuery models.AggregateQuery) (*models.AggregateResult, error) {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	matches, _, err := r.matchingOrganizations(aggQuery.Filter, aggQuery.Search, aggQuery.IncludeDeleted, nil)
+	if err != nil {
+		return nil, err
+	}
+	result := models.AggregateResult{
+		Count:      int64(len(matches)),
+		Facets:     map[string][]models.FacetBucket{},
+		Stats:      map[string]models.FieldStats{},
+		Histograms: map[string][]models.HistogramBucket{},
+	}
+
+	for _, column := range aggQuery.Facets {
+		if _, isField := models.OrganizationFields[column]; !isField {
+			return nil, fmt.Errorf("column %q does not exist", column)
+		}
+		// buckets keep an organization with their value so they can be ordered like organizations
+		counts := map[interface{}]int64{}
+		var samples []models.Organization
+		for _, org := range matches {
+			value := org.ColumnValue(column)
+			if counts[value] == 0 {
+				samples = append(samples, org)
+			}
+			counts[value]++
+		}
+		sort.Slice(samples, func(i, j int) bool {
+			countI, countJ := counts[samples[i].ColumnValue(column)], counts[samples[j].ColumnValue(column)]
+			if countI != countJ {
+				return countI > countJ
+			}
+			return compareOrganizationColumn(samples[i], samples[j], column) < 0
+		})
+		if len(samples) > aggQuery.FacetLimit {
+			samples = samples[:aggQuery.FacetLimit]
+		}
+		buckets := make([]models.FacetBucket, len(samples))
+		for i, sample := range samples {
+			buckets[i] = models.FacetBucket{Value: sample.ColumnValue(column), Count: counts[sample.ColumnValue(column)]}
+		}
+		result.Facets[column] = buckets
+	}
+
+	for _, column := range aggQuery.Stats {
+		field, isField := models.OrganizationFields[column]
+		if !isField {
+			return nil, fmt.Errorf("column %q does not exist", column)
+		}
+		if !field.Continuous {
+			return nil, fmt.Errorf("cannot compute stats of categorical column %q", column)
+		}
+		result.Stats[column] = fieldStats(matches, column, field)
+	}
+
+	for _, histogram := range aggQuery.Histograms {
+		field, isField := models.OrganizationFields[histogram.DBfield]
+		if !isField {
+			return nil, fmt.Errorf("column %q does not exist", histogram.DBfield)
+		}
+		if !field.Continuous {
+			return nil, fmt.Errorf("cannot compute histogram of categorical column %q", histogram.DBfield)
+		}
+		counts := map[interface{}]int64{}
+		var starts []models.Organization
+		for _, org := range matches {
+			start := org
+			switch field.Type {
+			case models.IntegerField:
+				if org.EmployeeCount != nil {
+					employeeCount := floorDiv(*org.EmployeeCount, histogram.Width) * histogram.Width
+					start.EmployeeCount = &employeeCount
+				}
+			case models.TimestampField:
+				if org.CreationDate != nil {
+					date := org.CreationDate.UTC()
+					month := date.Month()
+					if histogram.Interval == models.YearInterval {
+						month = time.January
+					}
+					creationDate := time.Date(date.Year(), month, 1, 0, 0, 0, 0, time.UTC)
+					start.CreationDate = &creationDate
+				}
+			}
+			if counts[start.ColumnValue(histogram.DBfield)] == 0 {
+				starts = append(starts, start)
+			}
+			counts[start.ColumnValue(histogram.DBfield)]++
+		}
+		// the bucket of NULL values is ordered last, like ORDER BY sorts NULL values
+		sort.Slice(starts, func(i, j int) bool {
+			isNullI := starts[i].ColumnValue(histogram.DBfield) == nil
+			isNullJ := starts[j].ColumnValue(histogram.DBfield) == nil
+			if isNullI || isNullJ {
+				return !isNullI
+			}
+			return compareOrganizationColumn(starts[i], starts[j], histogram.DBfield) < 0
+		})
+		buckets := make([]models.HistogramBucket, len(starts))
+		for i, start := range starts {
+			startValue := start.ColumnValue(histogram.DBfield)
+			buckets[i] = models.HistogramBucket{Start: startValue, End: histogram.BucketEnd(startValue),
+				Count: counts[startValue]}
+		}
+		result.Histograms[histogram.DBfield] = buckets
+	}
+	return &result, nil
+}
+
+// FindSimilar scores the name of every live organization with the same trigram similarity as the pg_trgm extension
+func (r *MemoryOrganizationRepository) FindSimilar(_ context.Context,
+	similarQuery models.SimilarNameQuery) ([]models.SimilarOrganization, error) {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.findSimilar(similarQuery), nil
+}
+
+func (r *MemoryOrganizationRepository) FindSimilarBatch(_ context.Context,
+	similarQuery models.SimilarNamesQuery) ([][]models.SimilarOrganization, error) {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([][]models.SimilarOrganization, len(similarQuery.Names))
+	for i, name := range similarQuery.Names {
+		result[i] = r.findSimilar(models.SimilarNameQuery{Name: name, Threshold: similarQuery.Threshold,
+			Limit: similarQuery.Limit})
+	}
+	return result, nil
+}
+
+// findSimilar returns the organizations with names similar to the query name, the caller must hold the lock
+func (r *MemoryOrganizationRepository) findSimilar(similarQuery models.SimilarNameQuery) []models.SimilarOrganization {
+	var result []models.SimilarOrganization
+	for _, org := range r.organizations {
+		if org.DeletedAt.Valid || org.ID == similarQuery.ExcludeID {
+			continue
+		}
+		similarity := trigramSimilarity(org.Name, similarQuery.Name)
+		if similarity >= similarQuery.Threshold {
+			result = append(result, models.SimilarOrganization{Organization: org, Similarity: similarity})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Similarity != result[j].Similarity {
+			return result[i].Similarity > result[j].Similarity
+		}
+		return result[i].Organization.ID.String() < result[j].Organization.ID.String()
+	})
+	if similarQuery.Limit > 0 && len(result) > similarQuery.Limit {
+		result = result[:similarQuery.Limit]
+	}
+	return result
+}
+
+func (r *MemoryOrganizationRepository) Update(ctx context.Context, org *models.Organization) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existingOrg, exists := r.organizations[org.ID]
+	if !exists || existingOrg.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	if existingOrg.Version != org.Version {
+		return ErrVersionMismatch
+	}
+	updatedOrg := *org
+	updatedOrg.Version++
+	updatedOrg.DeletedAt = existingOrg.DeletedAt
+	entry, err := newAuditEntry(ctx, models.UpdateAuditAction, &existingOrg, &updatedOrg)
+	if err != nil {
+		return err
+	}
+	org.Version = updatedOrg.Version
+	r.organizations[org.ID] = updatedOrg
+	r.recordAudit(entry)
+	r.recordVersion(updatedOrg, entry.ChangedAt)
+	return nil
+}
+
+func (r *MemoryOrganizationRepository) Delete(ctx context.Context, id uuid.UUID, version int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	org, exists := r.organizations[id]
+	if !exists || org.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	if version != 0 && org.Version != version {
+		return ErrVersionMismatch
+	}
+	deletedOrg := org
+	deletedOrg.DeletedAt = gorm.DeletedAt{Time: time.Now().UTC(), Valid: true}
+	deletedOrg.Version++
+	entry, err := newAuditEntry(ctx, models.DeleteAuditAction, &org, &deletedOrg)
+	if err != nil {
+		return err
+	}
+	r.organizations[id] = deletedOrg
+	r.recordAudit(entry)
+	r.recordVersion(deletedOrg, entry.ChangedAt)
+	return nil
+}
+
+func (r *MemoryOrganizationRepository) Restore(ctx context.Context, id uuid.UUID) (*models.Organization, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	org, exists := r.organizations[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	if !org.DeletedAt.Valid {
+		return nil, ErrNotDeleted
+	}
+	restoredOrg := org
+	restoredOrg.DeletedAt = gorm.DeletedAt{}
+	restoredOrg.Version++
+	entry, err := newAuditEntry(ctx, models.RestoreAuditAction, &org, &restoredOrg)
+	if err != nil {
+		return nil, err
+	}
+	r.organizations[id] = restoredOrg
+	r.recordAudit(entry)
+	r.recordVersion(restoredOrg, entry.ChangedAt)
+	return &restoredOrg, nil
+}
+
+func (r *MemoryOrganizationRepository) Merge(ctx context.Context, merge *models.OrganizationMerge,
+	mergeFunc models.MergeFunc) (*models.Organization, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	target, targetExists := r.organizations[merge.TargetID]
+	source, sourceExists := r.organizations[merge.SourceID]
+	if !targetExists || target.DeletedAt.Valid || !sourceExists || source.DeletedAt.Valid {
+		return nil, ErrNotFound
+	}
+
+	mergedOrg, err := mergeFunc(target, source)
+	if err != nil {
+		return nil, err
+	}
+	mergedOrg.ID = target.ID
+	mergedOrg.DeletedAt = target.DeletedAt
+	mergedOrg.Version = target.Version + 1
+
+	merge.Source, err = json.Marshal(source)
+	if err != nil {
+		return nil, err
+	}
+	merge.Target, err = json.Marshal(target)
+	if err != nil {
+		return nil, err
+	}
+	merge.MergedAt = time.Now().UTC()
+	targetEntry, err := newAuditEntry(ctx, models.MergeAuditAction, &target, &mergedOrg)
+	if err != nil {
+		return nil, err
+	}
+	sourceEntry, err := newAuditEntry(ctx, models.MergeAuditAction, &source, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	r.organizations[target.ID] = mergedOrg
+	delete(r.organizations, source.ID)
+	r.merges[source.ID] = *merge
+	r.recordAudit(targetEntry)
+	r.recordAudit(sourceEntry)
+	r.recordVersion(mergedOrg, merge.MergedAt)
+	r.closeVersion(source.ID, merge.MergedAt)
+	return &mergedOrg, nil
+}
+
+func (r *MemoryOrganizationRepository) ResolveMerge(_ context.Context, id uuid.UUID) (uuid.UUID, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	resolvedID := id
+	for {
+		merge, isMerged := r.merges[resolvedID]
+		if !isMerged {
+			break
+		}
+		resolvedID = merge.TargetID
+	}
+	if resolvedID == id {
+		return uuid.Nil, ErrNotFound
+	}
+	return resolvedID, nil
+}
+
+func (r *MemoryOrganizationRepository) Purge(ctx context.Context, id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	org, exists := r.organizations[id]
+	if !exists {
+		return ErrNotFound
+	}
+	entry, err := newAuditEntry(ctx, models.PurgeAuditAction, &org, nil)
+	if err != nil {
+		return err
+	}
+	delete(r.organizations, id)
+	r.recordAudit(entry)
+	versions := r.versions[:0]
+	for _, version := range r.versions {
+		if version.ID != id {
+			versions = append(versions, version)
+		}
+	}
+	r.versions = versions
+	return nil
+}
+
+func (r *MemoryOrganizationRepository) History(_ context.Context, query models.AuditQuery) (*models.AuditResult, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var entries []models.OrganizationAuditEntry
+	for i := len(r.audit) - 1; i >= 0; i-- {
+		if r.audit[i].OrganizationID == query.OrganizationID {
+			entries = append(entries, r.audit[i])
+		}
+	}
+	result := models.AuditResult{TotalCount: int64(len(entries))}
+	start := (query.Page - 1) * query.PageSize
+	if start < len(entries) {
+		end := start + query.PageSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		result.Entries = entries[start:end]
+	}
+	return &result, nil
+}
+
+// recordAudit assigns the next ID to an audit log entry and appends it to the audit log, the lock must be held
+func (r *MemoryOrganizationRepository) recordAudit(entry models.OrganizationAuditEntry) {
+	entry.ID = int64(len(r.audit) + 1)
+	r.audit = append(r.audit, entry)
+}
+
+// recordVersion makes an organization the current version of its organization from changedAt, the lock must be held
+func (r *MemoryOrganizationRepository) recordVersion(org models.Organization, changedAt time.Time) {
+	r.closeVersion(org.ID, changedAt)
+	r.versions = append(r.versions, organizationVersion{Organization: org, ValidFrom: changedAt})
+}
+
+// closeVersion ends the current version of an organization at changedAt, the lock must be held
+func (r *MemoryOrganizationRepository) closeVersion(id uuid.UUID, changedAt time.Time) {
+	for i := len(r.versions) - 1; i >= 0; i-- {
+		if r.versions[i].ID == id && r.versions[i].ValidTo.IsZero() {
+			r.versions[i].ValidTo = changedAt
+			return
+		}
+	}
+}
+
+// organizationsAsOf returns the organizations as they were at the asOf moment, or the current organizations when it
+// is nil. The caller must hold the lock
+func (r *MemoryOrganizationRepository) organizationsAsOf(asOf *time.Time) map[uuid.UUID]models.Organization {
+	if asOf == nil {
+		return r.organizations
+	}
+	orgs := map[uuid.UUID]models.Organization{}
+	for _, version := range r.versions {
+		if version.isValidAt(*asOf) {
+			orgs[version.ID] = version.Organization
+		}
+	}
+	return orgs
+}
+
+// checkHistoryAvailable returns ErrHistoryUnavailable when a version of the organization with the ID, or of any
+// organization when it is nil, has been backfilled after the asOf moment. The caller must hold the lock
+func (r *MemoryOrganizationRepository) checkHistoryAvailable(asOf time.Time, id *uuid.UUID) error {
+	for _, version := range r.versions {
+		if version.Backfilled && version.ValidFrom.After(asOf) && (id == nil || version.ID == *id) {
+			return ErrHistoryUnavailable
+		}
+	}
+	return nil
+}
+
+// sqlTruth is the value of a SQL condition, which is unknown rather than true or false when it compares a NULL value
+type sqlTruth int
+
+const (
+	sqlFalse sqlTruth = iota
+	sqlTrue
+	sqlUnknown
+)
+
+func truthOf(value bool) sqlTruth {
+	if value {
+		return sqlTrue
+	}
+	return sqlFalse
+}
+
+// not negates a condition, the negation of an unknown condition is unknown
+func (t sqlTruth) not() sqlTruth {
+	switch t {
+	case sqlTrue:
+		return sqlFalse
+	case sqlFalse:
+		return sqlTrue
+	}
+	return sqlUnknown
+}
+
+// matchesFilter determines if an organization satisfies a filter expression, a nil filter matches every organization.
+// Like a WHERE clause, only organizations for which the filter is true match, not those for which it is unknown
+func matchesFilter(org models.Organization, filter models.FilterExpression) (bool, error) {
+	truth, err := evaluateFilter(org, filter)
+	return truth == sqlTrue, err
+}
+
+// evaluateFilter evaluates a filter expression for an organization with the three valued logic of SQL, so negating a
+// filter does not match organizations whose column is NULL
+func evaluateFilter(org models.Organization, filter models.FilterExpression) (sqlTruth, error) {
+	switch expression := filter.(type) {
+	case nil:
+		return sqlTrue, nil
+	case models.CategoryQueryFilter:
+		return evaluateCategoryFilter(org, expression)
+	case models.RangeQueryFilter:
+		truth := sqlTrue
+		if expression.StartRange != models.OpenRangeDelimiter {
+			startTruth, err := compareColumn(org, expression.DBfield, expression.StartComparator, expression.StartRange)
+			if err != nil || startTruth == sqlFalse {
+				return sqlFalse, err
+			}
+			truth = startTruth
+		}
+		if expression.EndRange != models.OpenRangeDelimiter {
+			endTruth, err := compareColumn(org, expression.DBfield, expression.EndComparator, expression.EndRange)
+			if err != nil || endTruth != sqlTrue {
+				return endTruth, err
+			}
+		}
+		return truth, nil
+	case models.AndExpression:
+		truth := sqlTrue
+		for _, operand := range expression.Operands {
+			operandTruth, err := evaluateFilter(org, operand)
+			if err != nil || operandTruth == sqlFalse {
+				return sqlFalse, err
+			} else if operandTruth == sqlUnknown {
+				truth = sqlUnknown
+			}
+		}
+		return truth, nil
+	case models.OrExpression:
+		truth := sqlFalse
+		for _, operand := range expression.Operands {
+			operandTruth, err := evaluateFilter(org, operand)
+			if err != nil || operandTruth == sqlTrue {
+				return operandTruth, err
+			} else if operandTruth == sqlUnknown {
+				truth = sqlUnknown
+			}
+		}
+		return truth, nil
+	case models.NotExpression:
+		truth, err := evaluateFilter(org, expression.Operand)
+		return truth.not(), err
+	}
+	return sqlFalse, fmt.Errorf("unsupported filter expression %T", filter)
+}
+
+// evaluateCategoryFilter evaluates a categorical filter for an organization
+func evaluateCategoryFilter(org models.Organization, filter models.CategoryQueryFilter) (sqlTruth, error) {
+	switch {
+	case filter.NullFilter != "":
+		if _, isField := models.OrganizationFields[filter.DBfield]; !isField {
+			return sqlFalse, fmt.Errorf("column %q does not exist", filter.DBfield)
+		}
+		if filter.NullFilter != models.IsNull && filter.NullFilter != models.NotNull {
+			return sqlFalse, fmt.Errorf("unsupported null filter %q", filter.NullFilter)
+		}
+		isNull := org.ColumnValue(filter.DBfield) == nil
+		return truthOf(isNull == (filter.NullFilter == models.IsNull)), nil
+	case filter.InFilter != nil:
+		return matchesAny(org, filter.DBfield, filter.InFilter)
+	case filter.NotInFilter != nil:
+		truth, err := matchesAny(org, filter.DBfield, filter.NotInFilter)
+		return truth.not(), err
+	case filter.LikeFilter != "":
+		isMatch, err := matchesLike(org, filter.DBfield, filter.LikeFilter, false)
+		return truthOf(isMatch), err
+	case filter.ILikeFilter != "":
+		isMatch, err := matchesLike(org, filter.DBfield, filter.ILikeFilter, true)
+		return truthOf(isMatch), err
+	}
+	return compareColumn(org, filter.DBfield, "=", filter.ExactFilter)
+}
+
+// matchesAny determines if an organization column is equal to any of the values, like IN it is unknown when the
+// column is NULL
+func matchesAny(org models.Organization, column string, values []string) (sqlTruth, error) {
+	truth := sqlFalse
+	for _, value := range values {
+		valueTruth, err := compareColumn(org, column, "=", value)
+		if err != nil || valueTruth == sqlTrue {
+			return valueTruth, err
+		} else if valueTruth == sqlUnknown {
+			truth = sqlUnknown
+		}
+	}
+	return truth, nil
+}
+
+// matchesLike evaluates a SQL LIKE or ILIKE pattern against a text column, where % matches any sequence of
+// characters and _ matches a single character. Like Postgres, LIKE is case sensitive and both operators are only
+// defined for text columns
+func matchesLike(org models.Organization, column, pattern string, caseInsensitive bool) (bool, error) {
+	if column != "name" {
+		return false, fmt.Errorf("operator does not exist: %s LIKE text", column)
+	}
+
+	var expr strings.Builder
+	expr.WriteString(`(?s)^`)
+	if caseInsensitive {
+		expr.WriteString(`(?i)`)
+	}
+	escaped := false
+	for _, char := range pattern {
+		switch {
+		case escaped:
+			expr.WriteString(regexp.QuoteMeta(string(char)))
+			escaped = false
+		case char == '\\':
+			escaped = true
+		case char == '%':
+			expr.WriteString(".*")
+		case char == '_':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(char)))
+		}
+	}
+	expr.WriteString("$")
+
+	matcher, err := regexp.Compile(expr.String())
+	if err != nil {
+		return false, err
+	}
+	return matcher.MatchString(org.Name), nil
+}
+
+// compareColumn compares an organization column to a filter value using one of the SQL comparison operators. The
+// filter value is parsed according to the column type, the same way Postgres casts the query parameter. Comparing a
+// NULL column is unknown
+func compareColumn(org models.Organization, column, comparator, value string) (sqlTruth, error) {
+	field, isField := models.OrganizationFields[column]
+	if !isField {
+		return sqlFalse, fmt.Errorf("column %q does not exist", column)
+	}
+	filterValue, err := field.ParseValue(value)
+	if err != nil {
+		return sqlFalse, err
+	}
+
+	var comparison int
+	switch columnValue := org.ColumnValue(column).(type) {
+	case nil:
+		return sqlUnknown, nil
+	case string:
+		comparison = strings.Compare(columnValue, filterValue.(string))
+	case int:
+		comparison = compareInts(columnValue, filterValue.(int))
+	case time.Time:
+		comparison = compareTimes(columnValue, filterValue.(time.Time))
+	case bool:
+		comparison = compareBools(columnValue, filterValue.(bool))
+	}
+
+	switch comparator {
+	case "=":
+		return truthOf(comparison == 0), nil
+	case models.GT:
+		return truthOf(comparison > 0), nil
+	case models.GTE:
+		return truthOf(comparison >= 0), nil
+	case models.LT:
+		return truthOf(comparison < 0), nil
+	case models.LTE:
+		return truthOf(comparison <= 0), nil
+	}
+	return sqlFalse, fmt.Errorf("unsupported comparator %q", comparator)
+}
+
+// compareOrganizations orders two organizations by the sort fields followed by their IDs, matching the ORDER BY
+// clause built by the Postgres repository
+func compareOrganizations(a, b models.Organization, sortFields []models.SortField) int {
+	for _, sortField := range models.WithIDTiebreaker(sortFields) {
+		comparison := compareOrganizationColumn(a, b, sortField.DBfield)
+		if sortField.Descending {
+			comparison = -comparison
+		}
+		if comparison != 0 {
+			return comparison
+		}
+	}
+	return 0
+}
+
+// compareOrganizationColumn compares a single column of two organizations, names are compared by their lower
+// cased bytes and NULL values are compared as zero values to match the SortExpression of each field
+func compareOrganizationColumn(a, b models.Organization, column string) int {
+	switch column {
+	case "name":
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	case "employee_count":
+		var countA, countB int
+		if a.EmployeeCount != nil {
+			countA = *a.EmployeeCount
+		}
+		if b.EmployeeCount != nil {
+			countB = *b.EmployeeCount
+		}
+		return compareInts(countA, countB)
+	case "creation_date":
+		var dateA, dateB time.Time
+		if a.CreationDate != nil {
+			dateA = *a.CreationDate
+		}
+		if b.CreationDate != nil {
+			dateB = *b.CreationDate
+		}
+		return compareTimes(dateA, dateB)
+	case "is_public":
+		var isPublicA, isPublicB bool
+		if a.IsPublic != nil {
+			isPublicA = *a.IsPublic
+		}
+		if b.IsPublic != nil {
+			isPublicB = *b.IsPublic
+		}
+		return compareBools(isPublicA, isPublicB)
+	case models.IDField:
+		return strings.Compare(a.ID.String(), b.ID.String())
+	}
+	return 0
+}
+
+// searchWords splits text into the lower cased words compared by full text searches, approximating how the
+// Postgres simple text search configuration splits names into words
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), isWordSeparator)
+}
+
+func isWordSeparator(char rune) bool {
+	return !unicode.IsLetter(char) && !unicode.IsDigit(char)
+}
+
+// searchRank counts the words of a name that match the search terms, or returns 0 if the name does not contain
+// every term. Like ts_rank, names repeating the terms rank higher
+func searchRank(name string, searchTerms []string) int {
+	wordCounts := map[string]int{}
+	for _, word := range searchWords(name) {
+		wordCounts[word]++
+	}
+	rank := 0
+	for _, term := range searchTerms {
+		if wordCounts[term] == 0 {
+			return 0
+		}
+		rank += wordCounts[term]
+	}
+	return rank
+}
+
+// highlightWords wraps the words of a name that match the search terms in HighlightStart and HighlightStop,
+// matching the output of ts_headline
+func highlightWords(name string, searchTerms []string) string {
+	isTerm := map[string]bool{}
+	for _, term := range searchTerms {
+		isTerm[term] = true
+	}
+
+	var highlighted strings.Builder
+	wordStart := -1
+	for i, char := range name + " " {
+		switch {
+		case !isWordSeparator(char) && wordStart < 0:
+			wordStart = i
+		case isWordSeparator(char) && wordStart >= 0:
+			word := name[wordStart:i]
+			if isTerm[strings.ToLower(word)] {
+				word = models.HighlightStart + word + models.HighlightStop
+			}
+			highlighted.WriteString(word)
+			wordStart = -1
+		}
+		if isWordSeparator(char) && i < len(name) {
+			highlighted.WriteRune(char)
+		}
+	}
+	return highlighted.String()
+}
+
+// fieldStats computes the statistics of a continuous column over organizations, ignoring NULL values like the
+// Postgres aggregate functions. Timestamp averages are rounded to microseconds like Postgres timestamps
+func fieldStats(orgs []models.Organization, column string, field models.OrganizationField) models.FieldStats {
+	var values []interface{}
+	for _, org := range orgs {
+		if value := org.ColumnValue(column); value != nil {
+			values = append(values, value)
+		}
+	}
+	var stats models.FieldStats
+	if len(values) == 0 {
+		return stats
+	}
+	switch field.Type {
+	case models.IntegerField:
+		minValue, maxValue, sum := values[0].(int), values[0].(int), int64(0)
+		for _, value := range values {
+			value := value.(int)
+			if value < minValue {
+				minValue = value
+			}
+			if value > maxValue {
+				maxValue = value
+			}
+			sum += int64(value)
+		}
+		stats.Min, stats.Max, stats.Avg, stats.Sum = minValue, maxValue, float64(sum)/float64(len(values)), &sum
+	case models.TimestampField:
+		minValue, maxValue, sum := values[0].(time.Time), values[0].(time.Time), 0.0
+		for _, value := range values {
+			value := value.(time.Time)
+			if value.Before(minValue) {
+				minValue = value
+			}
+			if value.After(maxValue) {
+				maxValue = value
+			}
+			sum += float64(value.UnixNano()) / float64(time.Second)
+		}
+		avg := sum / float64(len(values))
+		seconds := math.Floor(avg)
+		stats.Min, stats.Max = minValue.UTC(), maxValue.UTC()
+		stats.Avg = time.Unix(int64(seconds), int64((avg-seconds)*float64(time.Second))).UTC().Round(time.Microsecond)
+	}
+	return stats
+}
+
+// floorDiv divides a by b rounding towards negative infinity, like floor(a::numeric / b) in Postgres
+func floorDiv(a, b int) int {
+	quotient := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		quotient--
+	}
+	return quotient
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareTimes(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+// compareBools orders false before true, matching Postgres
+func compareBools(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case b:
+		return -1
+	}
+	return 1
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"organization_manager/pkg/database/models"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when no organization exists with the requested ID
+	ErrNotFound = errors.New("organization not found")
+	// ErrNotDeleted is returned when restoring an organization that has not been soft deleted
+	ErrNotDeleted = errors.New("organization has not been deleted")
+	// ErrVersionMismatch is returned when writing an organization whose stored version differs from the expected
+	// version, as it has been updated since the expected version was read
+	ErrVersionMismatch = errors.New("organization version does not match")
+	// ErrSearchKeyset is returned when a full text search is combined with keyset pagination, as the order of search
+	// results depends on their relevance
+	ErrSearchKeyset = errors.New("keyset pagination is not supported for full text searches")
+	// ErrHistoryUnavailable is returned when reading organizations as of a moment before their history was recorded
+	ErrHistoryUnavailable = errors.New("organization history is not available")
+)
+
+// OrganizationRepository stores organizations. Soft deleted organizations are hidden from every method except
+// Restore, Purge and searches that set IncludeDeleted. Every change of an organization is recorded in its audit log
+// along with the auditor of the context, in the same transaction as the change, and every version of an organization
+// is kept so organizations can be read as they were at a past moment
+type OrganizationRepository interface {
+	// Create assigns a new ID and the first version to the organization and stores it
+	Create(ctx context.Context, org *models.Organization) error
+	// CreateBatch assigns new IDs and the first version to the organizations and stores them all, or none of them if
+	// any fails
+	CreateBatch(ctx context.Context, orgs []models.Organization) error
+	// Get returns the organization with the given ID, or ErrNotFound
+	Get(ctx context.Context, id uuid.UUID) (*models.Organization, error)
+	// GetAsOf returns the organization with the given ID as it was at the given moment, or ErrNotFound if it did not
+	// exist or was soft deleted at that moment. ErrHistoryUnavailable is returned when the moment is before the
+	// history of the organization was recorded
+	GetAsOf(ctx context.Context, id uuid.UUID, asOf time.Time) (*models.Organization, error)
+	// Search returns the page of organizations matching the query, ordered by its sort fields and then by ID. Queries
+	// as of a moment before the history of any organization was recorded return ErrHistoryUnavailable
+	Search(ctx context.Context, query models.OrganizationQuery) (*models.OrganizationSearchResult, error)
+	// Stream calls fn with each organization matching the query in the order of its sort fields, stopping at the
+	// first error fn returns. Pagination is ignored and full text searches only filter the organizations, they are
+	// not ordered by relevance
+	Stream(ctx context.Context, query models.OrganizationQuery, fn func(models.Organization) error) error
+	// Aggregate computes the facet counts, statistics and histograms of the organizations matching the query
+	Aggregate(ctx context.Context, query models.AggregateQuery) (*models.AggregateResult, error)
+	// FindSimilar returns the organizations with names similar to the query name, ordered from most to least similar
+	// and then by ID
+	FindSimilar(ctx context.Context, query models.SimilarNameQuery) ([]models.SimilarOrganization, error)
+	// FindSimilarBatch returns the organizations with names similar to each name of the query, in the order of the
+	// names and ordered like FindSimilar, with fewer round trips to the database than a FindSimilar for each name
+	FindSimilarBatch(ctx context.Context, query models.SimilarNamesQuery) ([][]models.SimilarOrganization, error)
+	// Update replaces every field of an existing organization whose stored version matches the version of org and
+	// increments the version of org, or returns ErrNotFound or ErrVersionMismatch
+	Update(ctx context.Context, org *models.Organization) error
+	// Delete soft deletes an organization and increments its version, or returns ErrNotFound. When version is not 0
+	// the organization is only deleted if its stored version matches, otherwise ErrVersionMismatch is returned
+	Delete(ctx context.Context, id uuid.UUID, version int) error
+	// Restore clears the soft delete of an organization, increments its version and returns it, or returns
+	// ErrNotFound or ErrNotDeleted
+	Restore(ctx context.Context, id uuid.UUID) (*models.Organization, error)
+	// Merge locks the target and source organizations of the merge and applies mergeFunc to them, then updates the
+	// target with its result and increments its version, removes the source and records the merge in a single
+	// transaction. It fills in the snapshots and time of the merge and returns the surviving organization, or returns
+	// ErrNotFound
+	Merge(ctx context.Context, merge *models.OrganizationMerge, mergeFunc models.MergeFunc) (*models.Organization, error)
+	// ResolveMerge returns the ID of the organization that the organization with the given ID was merged into,
+	// following later merges of that organization, or returns ErrNotFound if it was never merged
+	ResolveMerge(ctx context.Context, id uuid.UUID) (uuid.UUID, error)
+	// Purge permanently removes an organization whether or not it has been soft deleted, or returns ErrNotFound. Its
+	// versions are removed too, so it is not found as of any moment, but its audit log is kept
+	Purge(ctx context.Context, id uuid.UUID) error
+	// History returns the page of audit log entries of an organization, newest first. Entries are kept after the
+	// organization is merged or purged
+	History(ctx context.Context, query models.AuditQuery) (*models.AuditResult, error)
+}
+
+// checkColumns validates the columns selected by a query against the organization field registry
+func checkColumns(columns []string) error {
+	for _, column := range columns {
+		if _, isField := models.OrganizationFields[column]; !isField && column != models.IDField {
+			return fmt.Errorf("column %q does not exist", column)
+		}
+	}
+	return nil
+}
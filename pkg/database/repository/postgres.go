@@ -0,0 +1,986 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"organization_manager/pkg/database/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// textSearchConfig is the text search configuration of the name_tsv column. The simple configuration lower cases
+// words without stemming them or removing stop words, as organization names are mostly proper nouns
+const textSearchConfig = "'pg_catalog.simple'"
+
+// similarBatchSize is the number of names whose similar organizations are found by each statement of FindSimilarBatch
+const similarBatchSize = 1000
+
+// createBatchSize is the number of organizations inserted by each statement of CreateBatch, which keeps statements
+// well below the Postgres limit of 65535 parameters
+const createBatchSize = 500
+
+// organizationHistoryTable holds every version of each organization, with the period it was valid for. It is
+// maintained by a trigger on the organizations table, so every change of an organization is recorded
+const organizationHistoryTable = "organization_history"
+
+// validAsOfCondition selects the versions of organizations in organizationHistoryTable that were valid at a moment
+const validAsOfCondition = "valid_from <= ? AND (valid_to IS NULL OR valid_to > ?)"
+
+// unavailableAsOfCondition selects the versions of organizations in organizationHistoryTable that were backfilled
+// after a moment. The organizations are unknown before their backfilled versions, so their history is unavailable
+const unavailableAsOfCondition = "backfilled AND valid_from > ?"
+
+// highlightOptions configures ts_headline to return the whole name with every matching word highlighted
+var highlightOptions = fmt.Sprintf("StartSel=%s, StopSel=%s, HighlightAll=true", models.HighlightStart,
+	models.HighlightStop)
+
+// rankedOrganization is an organization returned by a full text search along with its highlighted name
+type rankedOrganization struct {
+	models.Organization
+	SearchHighlight string
+}
+
+// similarOrganization is an organization returned by a trigram similarity query along with its similarity
+type similarOrganization struct {
+	models.Organization
+	Similarity float64
+}
+
+// similarNameOrganization is an organization returned by a trigram similarity query of several names, along with the
+// position of the name it is similar to
+type similarNameOrganization struct {
+	models.Organization
+	Similarity   float64
+	NamePosition int
+}
+
+// PostgresOrganizationRepository is an OrganizationRepository backed by the organizations table
+type PostgresOrganizationRepository struct {
+	db *gorm.DB
+}
+
+func NewPostgresOrganizationRepository(db *gorm.DB) *PostgresOrganizationRepository {
+	return &PostgresOrganizationRepository{db: db}
+}
+
+func (r *PostgresOrganizationRepository) Create(ctx context.Context, org *models.Organization) error {
+	org.ID = uuid.New()
+	org.Version = 1
+	entry, err := newAuditEntry(ctx, models.CreateAuditAction, nil, org)
+	if err != nil {
+		return err
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(org).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&entry).Error
+	})
+}
+
+// CreateBatch inserts the organizations and their audit log entries createBatchSize rows at a time, in one
+// transaction
+func (r *PostgresOrganizationRepository) CreateBatch(ctx context.Context, orgs []models.Organization) error {
+	entries := make([]models.OrganizationAuditEntry, len(orgs))
+	for i := range orgs {
+		orgs[i].ID = uuid.New()
+		orgs[i].Version = 1
+		var err error
+		entries[i], err = newAuditEntry(ctx, models.CreateAuditAction, nil, &orgs[i])
+		if err != nil {
+			return err
+		}
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// the batches are already part of the transaction, they do not need a transaction of their own
+		tx = tx.Session(&gorm.Session{SkipDefaultTransaction: true})
+		err := tx.CreateInBatches(orgs, createBatchSize).Error
+		if err != nil {
+			return err
+		}
+		return tx.CreateInBatches(entries, createBatchSize).Error
+	})
+}
+
+func (r *PostgresOrganizationRepository) Get(ctx context.Context, id uuid.UUID) (*models.Organization, error) {
+	var org models.Organization
+	err := r.db.WithContext(ctx).Where("id = ?", id).First(&org).Error
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &org, nil
+}
+
+// GetAsOf reads the version of the organization that was valid at the given moment from the history table
+func (r *PostgresOrganizationRepository) GetAsOf(ctx context.Context, id uuid.UUID,
+	asOf time.Time) (*models.Organization, error) {
+
+	var org models.Organization
+	err := r.db.WithContext(ctx).Table(organizationHistoryTable).Where("id = ?", id).
+		Where(validAsOfCondition, asOf, asOf).First(&org).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		historyErr := checkHistoryAvailable(r.db.WithContext(ctx).Where("id = ?", id), asOf)
+		if historyErr != nil {
+			return nil, historyErr
+		}
+	}
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &org, nil
+}
+
+// checkHistoryAvailable returns ErrHistoryUnavailable when any organization selected by the query has been backfilled
+// after the asOf moment
+func checkHistoryAvailable(query *gorm.DB, asOf time.Time) error {
+	var backfilled []uuid.UUID
+	err := query.Table(organizationHistoryTable).Where(unavailableAsOfCondition, asOf).Limit(1).
+		Pluck("id", &backfilled).Error
+	if err != nil {
+		return err
+	}
+	if len(backfilled) > 0 {
+		return ErrHistoryUnavailable
+	}
+	return nil
+}
+
+// Search takes in categorical and range filters and creates and executes a query to the organizations database
+// table, or to the history table for queries as of a past moment. Soft deleted organizations are only returned if
+// the query includes them
+func (r *PostgresOrganizationRepository) Search(ctx context.Context,
+	orgQuery models.OrganizationQuery) (*models.OrganizationSearchResult, error) {
+
+	if orgQuery.Search != "" && orgQuery.After != nil {
+		return nil, ErrSearchKeyset
+	}
+	query, err := filteredQuery(r.db.WithContext(ctx), orgQuery.Filter, orgQuery.Search, orgQuery.IncludeDeleted,
+		orgQuery.AsOf)
+	if err != nil {
+		return nil, err
+	}
+
+	// The total count is taken before pagination is applied so it covers every page
+	var result models.OrganizationSearchResult
+	if !orgQuery.OmitTotalCount {
+		err := query.Count(&result.TotalCount).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Setting pagination parameters on query, keyset pagination continues after the given organization while
+	// page based pagination skips the previous pages
+	if orgQuery.After != nil {
+		condition, args := keysetCondition(orgQuery.Sort, *orgQuery.After)
+		query = query.Where(condition, args...)
+	} else {
+		offset := (orgQuery.Page - 1) * orgQuery.PageSize
+		query = query.Offset(offset)
+	}
+	// one extra organization is fetched to determine if there are more pages
+	query = query.Limit(orgQuery.PageSize + 1)
+
+	// the selected columns are validated against the field registry, as they cannot be passed as parameters
+	columns := orgQuery.SelectedColumns()
+	err = checkColumns(columns)
+	if err != nil {
+		return nil, err
+	}
+	selectedColumns := "*"
+	if columns != nil {
+		selectedColumns = strings.Join(columns, ", ")
+		query = query.Select(columns)
+	}
+	if orgQuery.Search != "" {
+		// searches are ordered by relevance, with the highlighted names selected alongside the organizations
+		query = query.Select(selectedColumns+", ts_rank(name_tsv, plainto_tsquery("+textSearchConfig+", ?)) AS search_rank, "+
+			"ts_headline("+textSearchConfig+", name, plainto_tsquery("+textSearchConfig+", ?), ?) AS search_highlight",
+			orgQuery.Search, orgQuery.Search, highlightOptions).Order("search_rank DESC")
+	}
+	for _, orderBy := range orderByExpressions(orgQuery.Sort) {
+		query = query.Order(orderBy)
+	}
+	if orgQuery.Search != "" {
+		var rankedOrgs []rankedOrganization
+		err := query.Find(&rankedOrgs).Error
+		if err != nil {
+			return nil, err
+		}
+		result.Highlights = make(map[uuid.UUID]string, len(rankedOrgs))
+		for _, rankedOrg := range rankedOrgs {
+			result.Organizations = append(result.Organizations, rankedOrg.Organization)
+			result.Highlights[rankedOrg.ID] = rankedOrg.SearchHighlight
+		}
+	} else {
+		err := query.Find(&result.Organizations).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(result.Organizations) > orgQuery.PageSize {
+		result.HasMore = true
+		result.Organizations = result.Organizations[:orgQuery.PageSize]
+	}
+	return &result, nil
+}
+
+// Stream reads the matching organizations from a cursor over the query's rows, so they are never all held in memory
+func (r *PostgresOrganizationRepository) Stream(ctx context.Context, orgQuery models.OrganizationQuery,
+	fn func(models.Organization) error) error {
+
+	query, err := filteredQuery(r.db.WithContext(ctx), orgQuery.Filter, orgQuery.Search, orgQuery.IncludeDeleted,
+		orgQuery.AsOf)
+	if err != nil {
+		return err
+	}
+	columns := orgQuery.SelectedColumns()
+	err = checkColumns(columns)
+	if err != nil {
+		return err
+	}
+	if columns != nil {
+		query = query.Select(columns)
+	}
+	for _, orderBy := range orderByExpressions(orgQuery.Sort) {
+		query = query.Order(orderBy)
+	}
+
+	rows, err := query.Rows()
+	if err != nil {
+		return err
+	}
+	return scanRows(rows, func() error {
+		var org models.Organization
+		err := query.ScanRows(rows, &org)
+		if err != nil {
+			return err
+		}
+		return fn(org)
+	})
+}
+
+// FindSimilar uses the pg_trgm % operator, which can use the trigram index on the name column, to find live
+// organizations whose names have a trigram similarity of at least the query threshold
+func (r *PostgresOrganizationRepository) FindSimilar(ctx context.Context,
+	similarQuery models.SimilarNameQuery) ([]models.SimilarOrganization, error) {
+
+	var similarOrgs []similarOrganization
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := setSimilarityThreshold(tx, similarQuery.Threshold)
+		if err != nil {
+			return err
+		}
+
+		query := tx.Model(&models.Organization{}).Select("*, similarity(name, ?) AS similarity", similarQuery.Name).
+			Where("name % ?", similarQuery.Name)
+		if similarQuery.ExcludeID != uuid.Nil {
+			query = query.Where("id <> ?", similarQuery.ExcludeID)
+		}
+		return query.Order("similarity DESC").Order("id").Limit(similarQuery.Limit).Find(&similarOrgs).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]models.SimilarOrganization, len(similarOrgs))
+	for i, similarOrg := range similarOrgs {
+		result[i] = models.SimilarOrganization{Organization: similarOrg.Organization, Similarity: similarOrg.Similarity}
+	}
+	return result, nil
+}
+
+// FindSimilarBatch joins the names, similarBatchSize at a time, laterally with the organizations similar to each of
+// them, so each statement can use the trigram index like FindSimilar
+func (r *PostgresOrganizationRepository) FindSimilarBatch(ctx context.Context,
+	similarQuery models.SimilarNamesQuery) ([][]models.SimilarOrganization, error) {
+
+	result := make([][]models.SimilarOrganization, len(similarQuery.Names))
+	if len(similarQuery.Names) == 0 {
+		return result, nil
+	}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := setSimilarityThreshold(tx, similarQuery.Threshold)
+		if err != nil {
+			return err
+		}
+
+		for start := 0; start < len(similarQuery.Names); start += similarBatchSize {
+			end := start + similarBatchSize
+			if end > len(similarQuery.Names) {
+				end = len(similarQuery.Names)
+			}
+			values := make([]string, end-start)
+			var args []interface{}
+			for i := start; i < end; i++ {
+				values[i-start] = "(?::integer, ?::text)"
+				args = append(args, i, similarQuery.Names[i])
+			}
+			// a NULL limit does not limit the organizations, like the zero limit of FindSimilar
+			var limit interface{}
+			if similarQuery.Limit > 0 {
+				limit = similarQuery.Limit
+			}
+			args = append(args, limit)
+
+			var similarOrgs []similarNameOrganization
+			err := tx.Raw("SELECT names.position AS name_position, similar_orgs.* FROM (VALUES "+
+				strings.Join(values, ", ")+") AS names (position, name) CROSS JOIN LATERAL ("+
+				"SELECT organizations.*, similarity(organizations.name, names.name) AS similarity FROM organizations "+
+				"WHERE organizations.deleted_at IS NULL AND organizations.name % names.name "+
+				"ORDER BY similarity DESC, organizations.id LIMIT ?) AS similar_orgs "+
+				"ORDER BY names.position, similar_orgs.similarity DESC, similar_orgs.id", args...).
+				Scan(&similarOrgs).Error
+			if err != nil {
+				return err
+			}
+			for _, similarOrg := range similarOrgs {
+				result[similarOrg.NamePosition] = append(result[similarOrg.NamePosition], models.SimilarOrganization{
+					Organization: similarOrg.Organization, Similarity: similarOrg.Similarity})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// setSimilarityThreshold sets the threshold of the pg_trgm % operator. It is a setting, which set_config scopes to
+// the transaction
+func setSimilarityThreshold(tx *gorm.DB, threshold float64) error {
+	return tx.Exec("SELECT set_config('pg_trgm.similarity_threshold', ?, true)",
+		strconv.FormatFloat(threshold, 'f', -1, 64)).Error
+}
+
+// filteredQuery creates a query of the organizations matching a filter expression and full text search, as they were
+// at the asOf moment when it is not nil. The history table has the same columns as the organizations table, so the
+// filters apply to either. ErrHistoryUnavailable is returned when the history is unavailable at the asOf moment
+func filteredQuery(db *gorm.DB, filter models.FilterExpression, search string, includeDeleted bool,
+	asOf *time.Time) (*gorm.DB, error) {
+
+	query := db.Model(&models.Organization{})
+	if asOf != nil {
+		err := checkHistoryAvailable(db, *asOf)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Table(organizationHistoryTable).Where(validAsOfCondition, *asOf, *asOf)
+	}
+	if includeDeleted {
+		query = query.Unscoped()
+	}
+	// the operands of a top level AND are added as separate conditions, which gorm joins with AND
+	filters := []models.FilterExpression{filter}
+	if andExpression, isAnd := filter.(models.AndExpression); isAnd {
+		filters = andExpression.Operands
+	}
+	for _, filter := range filters {
+		if filter == nil {
+			continue
+		}
+		condition, args, err := filterCondition(filter)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where(condition, args...)
+	}
+	if search != "" {
+		query = query.Where("name_tsv @@ plainto_tsquery("+textSearchConfig+", ?)", search)
+	}
+	return query, nil
+}
+
+// Aggregate runs every aggregation of the query in a read only transaction, so they all see the same snapshot of the
+// organizations table
+func (r *PostgresOrganizationRepository) Aggregate(ctx context.Context,
+	aggQuery models.AggregateQuery) (*models.AggregateResult, error) {
+
+	result := models.AggregateResult{
+		Facets:     map[string][]models.FacetBucket{},
+		Stats:      map[string]models.FieldStats{},
+		Histograms: map[string][]models.HistogramBucket{},
+	}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// every aggregation starts from a new query, as gorm conditions accumulate on the query they are added to
+		newQuery := func() (*gorm.DB, error) {
+			return filteredQuery(tx, aggQuery.Filter, aggQuery.Search, aggQuery.IncludeDeleted, nil)
+		}
+
+		query, err := newQuery()
+		if err != nil {
+			return err
+		}
+		err = query.Count(&result.Count).Error
+		if err != nil {
+			return err
+		}
+
+		for _, column := range aggQuery.Facets {
+			field, err := aggregateField(column)
+			if err != nil {
+				return err
+			}
+			query, err := newQuery()
+			if err != nil {
+				return err
+			}
+			rows, err := query.Select(column + " AS value, count(*) AS count").Group(column).
+				Order("count DESC").Order(sortExpression(column, column)).Limit(aggQuery.FacetLimit).Rows()
+			if err != nil {
+				return err
+			}
+			buckets := []models.FacetBucket{}
+			err = scanRows(rows, func() error {
+				var bucket models.FacetBucket
+				value, scannedValue := newColumnScanner(field.Type)
+				err := rows.Scan(value, &bucket.Count)
+				if err != nil {
+					return err
+				}
+				bucket.Value = scannedValue()
+				buckets = append(buckets, bucket)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			result.Facets[column] = buckets
+		}
+
+		for _, column := range aggQuery.Stats {
+			field, err := aggregateField(column)
+			if err != nil {
+				return err
+			}
+			query, err := newQuery()
+			if err != nil {
+				return err
+			}
+			var stats models.FieldStats
+			minValue, scannedMin := newColumnScanner(field.Type)
+			maxValue, scannedMax := newColumnScanner(field.Type)
+			switch field.Type {
+			case models.IntegerField:
+				var avg sql.NullFloat64
+				var sum sql.NullInt64
+				err = query.Select(fmt.Sprintf("min(%[1]s), max(%[1]s), avg(%[1]s)::float8, sum(%[1]s)", column)).
+					Row().Scan(minValue, maxValue, &avg, &sum)
+				if avg.Valid {
+					stats.Avg = avg.Float64
+				}
+				if sum.Valid {
+					stats.Sum = &sum.Int64
+				}
+			case models.TimestampField:
+				avg, scannedAvg := newColumnScanner(field.Type)
+				err = query.Select(fmt.Sprintf("min(%[1]s), max(%[1]s), "+
+					"to_timestamp(avg(extract(epoch FROM %[1]s))) AT TIME ZONE 'UTC'", column)).
+					Row().Scan(minValue, maxValue, avg)
+				stats.Avg = scannedAvg()
+			default:
+				return fmt.Errorf("cannot compute stats of categorical column %q", column)
+			}
+			if err != nil {
+				return err
+			}
+			stats.Min, stats.Max = scannedMin(), scannedMax()
+			result.Stats[column] = stats
+		}
+
+		for _, histogram := range aggQuery.Histograms {
+			field, err := aggregateField(histogram.DBfield)
+			if err != nil {
+				return err
+			}
+			query, err := newQuery()
+			if err != nil {
+				return err
+			}
+			switch field.Type {
+			case models.IntegerField:
+				query = query.Select(fmt.Sprintf("floor(%s::numeric / ?)::bigint * ? AS bucket, count(*) AS count",
+					histogram.DBfield), histogram.Width, histogram.Width)
+			case models.TimestampField:
+				query = query.Select(fmt.Sprintf("date_trunc(?, %s) AS bucket, count(*) AS count", histogram.DBfield),
+					histogram.Interval)
+			default:
+				return fmt.Errorf("cannot compute histogram of categorical column %q", histogram.DBfield)
+			}
+			rows, err := query.Group("bucket").Order("bucket").Rows()
+			if err != nil {
+				return err
+			}
+			buckets := []models.HistogramBucket{}
+			err = scanRows(rows, func() error {
+				var bucket models.HistogramBucket
+				start, scannedStart := newColumnScanner(field.Type)
+				err := rows.Scan(start, &bucket.Count)
+				if err != nil {
+					return err
+				}
+				bucket.Start = scannedStart()
+				bucket.End = histogram.BucketEnd(bucket.Start)
+				buckets = append(buckets, bucket)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			result.Histograms[histogram.DBfield] = buckets
+		}
+		return nil
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Update writes every field of the organization, including zero values, to its existing database row. The row is
+// locked while it is read for the audit log, and the version is also checked by the UPDATE statement itself
+func (r *PostgresOrganizationRepository) Update(ctx context.Context, org *models.Organization) error {
+	updatedOrg := *org
+	updatedOrg.Version++
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		existingOrg, err := lockOrganization(tx, org.ID)
+		if err != nil {
+			return err
+		}
+		if existingOrg.Version != org.Version {
+			return ErrVersionMismatch
+		}
+		result := tx.Model(&models.Organization{}).Where("id = ? AND version = ?", org.ID, org.Version).
+			Select("*").Omit("id", "deleted_at").Updates(&updatedOrg)
+		if errors.Is(checkRowsAffected(result), ErrNotFound) {
+			return ErrVersionMismatch
+		} else if result.Error != nil {
+			return result.Error
+		}
+		return createAuditEntry(ctx, tx, models.UpdateAuditAction, existingOrg, &updatedOrg)
+	})
+	if err != nil {
+		return err
+	}
+	org.Version = updatedOrg.Version
+	return nil
+}
+
+// Delete soft deletes an organization by setting its deleted_at column
+func (r *PostgresOrganizationRepository) Delete(ctx context.Context, id uuid.UUID, version int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		org, err := lockOrganization(tx, id)
+		if err != nil {
+			return err
+		}
+		if version != 0 && org.Version != version {
+			return ErrVersionMismatch
+		}
+		deletedOrg := *org
+		deletedOrg.DeletedAt = gorm.DeletedAt{Time: time.Now().UTC(), Valid: true}
+		deletedOrg.Version++
+		err = tx.Model(&models.Organization{}).Where("id = ?", id).
+			Updates(map[string]interface{}{"deleted_at": deletedOrg.DeletedAt, "version": deletedOrg.Version}).Error
+		if err != nil {
+			return err
+		}
+		return createAuditEntry(ctx, tx, models.DeleteAuditAction, org, &deletedOrg)
+	})
+}
+
+// Restore clears the deleted_at column of a soft deleted organization and increments its version
+func (r *PostgresOrganizationRepository) Restore(ctx context.Context, id uuid.UUID) (*models.Organization, error) {
+	var restoredOrg models.Organization
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		org, err := lockOrganization(tx.Unscoped(), id)
+		if err != nil {
+			return err
+		}
+		if !org.DeletedAt.Valid {
+			return ErrNotDeleted
+		}
+
+		restoredOrg = *org
+		restoredOrg.DeletedAt = gorm.DeletedAt{}
+		restoredOrg.Version++
+		err = tx.Unscoped().Model(&models.Organization{}).Where("id = ?", id).
+			Updates(map[string]interface{}{"deleted_at": nil, "version": restoredOrg.Version}).Error
+		if err != nil {
+			return err
+		}
+		return createAuditEntry(ctx, tx, models.RestoreAuditAction, org, &restoredOrg)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &restoredOrg, nil
+}
+
+// lockOrganization reads an organization row and locks it until the end of the transaction, or returns ErrNotFound
+func lockOrganization(tx *gorm.DB, id uuid.UUID) (*models.Organization, error) {
+	var org models.Organization
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).First(&org).Error
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &org, nil
+}
+
+// createAuditEntry records a change of an organization in its audit log as part of the transaction making the change
+func createAuditEntry(ctx context.Context, tx *gorm.DB, action string, before, after *models.Organization) error {
+	entry, err := newAuditEntry(ctx, action, before, after)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&entry).Error
+}
+
+// Merge locks both organization rows for the duration of the transaction, so neither can change between being read
+// and being merged
+func (r *PostgresOrganizationRepository) Merge(ctx context.Context, merge *models.OrganizationMerge,
+	mergeFunc models.MergeFunc) (*models.Organization, error) {
+
+	var mergedOrg models.Organization
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var orgs []models.Organization
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN ?", []uuid.UUID{merge.TargetID, merge.SourceID}).Find(&orgs).Error
+		if err != nil {
+			return err
+		}
+		if len(orgs) != 2 {
+			return ErrNotFound
+		}
+		target, source := orgs[0], orgs[1]
+		if target.ID != merge.TargetID {
+			target, source = source, target
+		}
+
+		mergedOrg, err = mergeFunc(target, source)
+		if err != nil {
+			return err
+		}
+		mergedOrg.ID = target.ID
+		mergedOrg.Version = target.Version + 1
+		err = tx.Model(&models.Organization{}).Where("id = ?", target.ID).
+			Select("*").Omit("id", "deleted_at").Updates(&mergedOrg).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Unscoped().Where("id = ?", source.ID).Delete(&models.Organization{}).Error
+		if err != nil {
+			return err
+		}
+
+		merge.Source, err = json.Marshal(source)
+		if err != nil {
+			return err
+		}
+		merge.Target, err = json.Marshal(target)
+		if err != nil {
+			return err
+		}
+		merge.MergedAt = time.Now().UTC()
+		err = tx.Create(merge).Error
+		if err != nil {
+			return err
+		}
+		err = createAuditEntry(ctx, tx, models.MergeAuditAction, &target, &mergedOrg)
+		if err != nil {
+			return err
+		}
+		return createAuditEntry(ctx, tx, models.MergeAuditAction, &source, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &mergedOrg, nil
+}
+
+// ResolveMerge follows the chain of merges starting at the given ID, each organization can only be merged once as
+// merging removes it
+func (r *PostgresOrganizationRepository) ResolveMerge(ctx context.Context, id uuid.UUID) (uuid.UUID, error) {
+	resolvedID := id
+	for {
+		var merge models.OrganizationMerge
+		err := r.db.WithContext(ctx).Where("source_id = ?", resolvedID).First(&merge).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			break
+		} else if err != nil {
+			return uuid.Nil, err
+		}
+		resolvedID = merge.TargetID
+	}
+	if resolvedID == id {
+		return uuid.Nil, ErrNotFound
+	}
+	return resolvedID, nil
+}
+
+// Purge permanently removes an organization row and its history, its audit log entries are kept
+func (r *PostgresOrganizationRepository) Purge(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		org, err := lockOrganization(tx.Unscoped(), id)
+		if err != nil {
+			return err
+		}
+		err = tx.Unscoped().Where("id = ?", id).Delete(&models.Organization{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Exec("DELETE FROM "+organizationHistoryTable+" WHERE id = ?", id).Error
+		if err != nil {
+			return err
+		}
+		return createAuditEntry(ctx, tx, models.PurgeAuditAction, org, nil)
+	})
+}
+
+// History counts the audit log entries of the organization and reads the requested page of them, newest first
+func (r *PostgresOrganizationRepository) History(ctx context.Context,
+	query models.AuditQuery) (*models.AuditResult, error) {
+
+	var result models.AuditResult
+	db := r.db.WithContext(ctx).Model(&models.OrganizationAuditEntry{}).Where("organization_id = ?", query.OrganizationID)
+	err := db.Count(&result.TotalCount).Error
+	if err != nil {
+		return nil, err
+	}
+	err = db.Order("id DESC").Limit(query.PageSize).Offset((query.Page - 1) * query.PageSize).Find(&result.Entries).Error
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// filterCondition compiles a filter expression into a parameterized SQL condition, column names are checked against
+// the organization field registry as they cannot be passed as parameters
+func filterCondition(filter models.FilterExpression) (string, []interface{}, error) {
+	switch expression := filter.(type) {
+	case models.CategoryQueryFilter:
+		if _, isField := models.OrganizationFields[expression.DBfield]; !isField {
+			return "", nil, fmt.Errorf("column %q does not exist", expression.DBfield)
+		}
+		switch {
+		case expression.NullFilter == models.IsNull:
+			return fmt.Sprintf("%s IS NULL", expression.DBfield), nil, nil
+		case expression.NullFilter == models.NotNull:
+			return fmt.Sprintf("%s IS NOT NULL", expression.DBfield), nil, nil
+		case expression.NullFilter != "":
+			return "", nil, fmt.Errorf("unsupported null filter %q", expression.NullFilter)
+		case expression.InFilter != nil:
+			return fmt.Sprintf("%s IN ?", expression.DBfield), []interface{}{expression.InFilter}, nil
+		case expression.NotInFilter != nil:
+			return fmt.Sprintf("%s NOT IN ?", expression.DBfield), []interface{}{expression.NotInFilter}, nil
+		case expression.LikeFilter != "":
+			return fmt.Sprintf("%s LIKE ?", expression.DBfield), []interface{}{expression.LikeFilter}, nil
+		case expression.ILikeFilter != "":
+			return fmt.Sprintf("%s ILIKE ?", expression.DBfield), []interface{}{expression.ILikeFilter}, nil
+		}
+		return fmt.Sprintf("%s = ?", expression.DBfield), []interface{}{expression.ExactFilter}, nil
+	case models.RangeQueryFilter:
+		if _, isField := models.OrganizationFields[expression.DBfield]; !isField {
+			return "", nil, fmt.Errorf("column %q does not exist", expression.DBfield)
+		}
+		var conditions []string
+		var args []interface{}
+		if expression.StartRange != models.OpenRangeDelimiter {
+			conditions = append(conditions, fmt.Sprintf("%s %s ?", expression.DBfield, expression.StartComparator))
+			args = append(args, expression.StartRange)
+		}
+		if expression.EndRange != models.OpenRangeDelimiter {
+			conditions = append(conditions, fmt.Sprintf("%s %s ?", expression.DBfield, expression.EndComparator))
+			args = append(args, expression.EndRange)
+		}
+		return joinConditions(conditions, " AND ", "TRUE"), args, nil
+	case models.AndExpression:
+		return joinFilterConditions(expression.Operands, " AND ", "TRUE")
+	case models.OrExpression:
+		return joinFilterConditions(expression.Operands, " OR ", "FALSE")
+	case models.NotExpression:
+		condition, args, err := filterCondition(expression.Operand)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("NOT (%s)", condition), args, nil
+	}
+	return "", nil, fmt.Errorf("unsupported filter expression %T", filter)
+}
+
+// joinFilterConditions compiles each operand of an AND or OR expression and joins them with the operator, operands
+// made up of several conditions are wrapped in parentheses
+func joinFilterConditions(operands []models.FilterExpression, operator, empty string) (string, []interface{}, error) {
+	var conditions []string
+	var args []interface{}
+	for _, operand := range operands {
+		condition, operandArgs, err := filterCondition(operand)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(operands) > 1 && isCompoundFilter(operand) {
+			condition = "(" + condition + ")"
+		}
+		conditions = append(conditions, condition)
+		args = append(args, operandArgs...)
+	}
+	return joinConditions(conditions, operator, empty), args, nil
+}
+
+// joinConditions joins SQL conditions with an operator, or returns the empty condition if there are none
+func joinConditions(conditions []string, operator, empty string) string {
+	if len(conditions) == 0 {
+		return empty
+	}
+	return strings.Join(conditions, operator)
+}
+
+// isCompoundFilter determines if a filter compiles to several conditions joined by AND or OR, which need to be
+// wrapped in parentheses when they are nested in another expression. Top level conditions are wrapped by gorm
+func isCompoundFilter(filter models.FilterExpression) bool {
+	switch expression := filter.(type) {
+	case models.RangeQueryFilter:
+		return expression.StartRange != models.OpenRangeDelimiter && expression.EndRange != models.OpenRangeDelimiter
+	case models.AndExpression:
+		return len(expression.Operands) > 1 || len(expression.Operands) == 1 && isCompoundFilter(expression.Operands[0])
+	case models.OrExpression:
+		return len(expression.Operands) > 1 || len(expression.Operands) == 1 && isCompoundFilter(expression.Operands[0])
+	}
+	return false
+}
+
+// orderByExpressions converts the sort fields of a query into ORDER BY expressions, id is appended as the final
+// tiebreaker so the order is stable across pages
+func orderByExpressions(sortFields []models.SortField) []string {
+	var expressions []string
+	for _, sortField := range models.WithIDTiebreaker(sortFields) {
+		expression := sortExpression(sortField.DBfield, sortField.DBfield)
+		if sortField.Descending {
+			expression += " DESC"
+		}
+		expressions = append(expressions, expression)
+	}
+	return expressions
+}
+
+// keysetCondition builds the condition selecting the organizations ordered after the given organization. For sort
+// keys k1, k2 and id it expands to (k1 > v1) OR (k1 = v1 AND k2 > v2) OR (k1 = v1 AND k2 = v2 AND id > v3), with
+// the comparison reversed for descending keys
+func keysetCondition(sortFields []models.SortField, after models.Organization) (string, []interface{}) {
+	keys := models.WithIDTiebreaker(sortFields)
+	var disjuncts []string
+	var args []interface{}
+	for i, key := range keys {
+		var conjuncts []string
+		for _, previousKey := range keys[:i] {
+			conjuncts = append(conjuncts, fmt.Sprintf("%s = %s",
+				sortExpression(previousKey.DBfield, previousKey.DBfield), sortExpression(previousKey.DBfield, "?")))
+			args = append(args, after.ColumnValue(previousKey.DBfield))
+		}
+
+		comparator := models.GT
+		if key.Descending {
+			comparator = models.LT
+		}
+		conjuncts = append(conjuncts, fmt.Sprintf("%s %s %s",
+			sortExpression(key.DBfield, key.DBfield), comparator, sortExpression(key.DBfield, "?")))
+		args = append(args, after.ColumnValue(key.DBfield))
+		disjuncts = append(disjuncts, "("+strings.Join(conjuncts, " AND ")+")")
+	}
+	return "(" + strings.Join(disjuncts, " OR ") + ")", args
+}
+
+// sortExpression applies a field's sort expression to an operand, which is either the column itself or a
+// placeholder for a value being compared against it
+func sortExpression(column, operand string) string {
+	if field, isField := models.OrganizationFields[column]; isField {
+		return fmt.Sprintf(field.SortExpression, operand)
+	}
+	return operand
+}
+
+// aggregateField looks up a column in the field registry, as aggregated columns cannot be passed as parameters
+func aggregateField(column string) (models.OrganizationField, error) {
+	field, isField := models.OrganizationFields[column]
+	if !isField {
+		return models.OrganizationField{}, fmt.Errorf("column %q does not exist", column)
+	}
+	return field, nil
+}
+
+// newColumnScanner returns a scan destination for a column of the given type, along with a function returning the
+// scanned value as the type used by Organization fields, or nil for NULL values
+func newColumnScanner(fieldType models.FieldType) (interface{}, func() interface{}) {
+	switch fieldType {
+	case models.IntegerField:
+		var value sql.NullInt64
+		return &value, func() interface{} {
+			if !value.Valid {
+				return nil
+			}
+			return int(value.Int64)
+		}
+	case models.TimestampField:
+		var value sql.NullTime
+		return &value, func() interface{} {
+			if !value.Valid {
+				return nil
+			}
+			return value.Time.UTC()
+		}
+	case models.BooleanField:
+		var value sql.NullBool
+		return &value, func() interface{} {
+			if !value.Valid {
+				return nil
+			}
+			return value.Bool
+		}
+	}
+	var value sql.NullString
+	return &value, func() interface{} {
+		if !value.Valid {
+			return nil
+		}
+		return value.String
+	}
+}
+
+// scanRows calls scan for each row and closes the rows
+func scanRows(rows *sql.Rows, scan func() error) error {
+	defer rows.Close()
+	for rows.Next() {
+		err := scan()
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// checkRowsAffected returns ErrNotFound if a write statement did not match any organization rows
+func checkRowsAffected(result *gorm.DB) error {
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// translateError converts gorm's record not found error into the repository's ErrNotFound
+func translateError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,243 @@
+package repository
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"organization_manager/pkg/database"
+	"organization_manager/pkg/database/models"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// The conformance suite only runs against Postgres when TEST_DATABASE_URL is set, these tests check the Postgres
+// specific SQL the repository generates without a database
+
+var organizationColumns = []string{"id", "name", "creation_date", "employee_count", "is_public", "deleted_at", "version"}
+
+func Test_keysetCondition(t *testing.T) {
+	id := uuid.New()
+	var testCases = []struct {
+		name              string
+		sortFields        []models.SortField
+		after             models.Organization
+		expectedCondition string
+		expectedArgs      []interface{}
+	}{
+		{
+			name:              "id",
+			after:             models.Organization{ID: id},
+			expectedCondition: "((id > ?))",
+			expectedArgs:      []interface{}{id},
+		},
+		{
+			name:       "nullable_descending",
+			sortFields: []models.SortField{{DBfield: "employee_count", Descending: true}},
+			after:      models.Organization{ID: id, EmployeeCount: intPointer(100)},
+			expectedCondition: "((COALESCE(employee_count, 0) < COALESCE(?, 0)) OR " +
+				"(COALESCE(employee_count, 0) = COALESCE(?, 0) AND id > ?))",
+			expectedArgs: []interface{}{100, 100, id},
+		},
+		{
+			name:       "several_keys",
+			sortFields: []models.SortField{{DBfield: "name"}, {DBfield: "is_public", Descending: true}},
+			after:      models.Organization{ID: id, Name: "CLEAR", IsPublic: boolPointer(true)},
+			expectedCondition: `((lower(name) COLLATE "C" > lower(?) COLLATE "C") OR ` +
+				`(lower(name) COLLATE "C" = lower(?) COLLATE "C" AND COALESCE(is_public, false) < COALESCE(?, false)) OR ` +
+				`(lower(name) COLLATE "C" = lower(?) COLLATE "C" AND COALESCE(is_public, false) = COALESCE(?, false) AND id > ?))`,
+			expectedArgs: []interface{}{"CLEAR", "CLEAR", true, "CLEAR", true, id},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			condition, args := keysetCondition(test.sortFields, test.after)
+			assert.Equal(t, test.expectedCondition, condition)
+			assert.Equal(t, test.expectedArgs, args)
+		})
+	}
+}
+
+func TestPostgresOrganizationRepository_Search_keyset(t *testing.T) {
+	repo, mock := newTestPostgresRepository(t)
+	after := models.Organization{ID: uuid.New(), EmployeeCount: intPointer(100)}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organizations" WHERE `+
+		`(((COALESCE(employee_count, 0) < COALESCE($1, 0)) OR (COALESCE(employee_count, 0) = COALESCE($2, 0) AND id > $3))) `+
+		`AND "organizations"."deleted_at" IS NULL ORDER BY COALESCE(employee_count, 0) DESC,id LIMIT 3`)).
+		WithArgs(100, 100, after.ID).
+		WillReturnRows(sqlmock.NewRows(organizationColumns).
+			AddRow(uuid.New(), "CLEAR", nil, nil, nil, nil, 1).
+			AddRow(uuid.New(), "Acme", nil, nil, nil, nil, 1).
+			AddRow(uuid.New(), "Globex", nil, nil, nil, nil, 1))
+
+	result, err := repo.Search(context.Background(), models.OrganizationQuery{
+		Sort:           []models.SortField{{DBfield: "employee_count", Descending: true}},
+		PageSize:       2,
+		After:          &after,
+		OmitTotalCount: true,
+	})
+	require.NoError(t, err)
+	assert.Len(t, result.Organizations, 2)
+	assert.True(t, result.HasMore)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresOrganizationRepository_Search_textSearch(t *testing.T) {
+	repo, mock := newTestPostgresRepository(t)
+	id := uuid.New()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "organizations" ` +
+		`WHERE name_tsv @@ plainto_tsquery('pg_catalog.simple', $1) AND "organizations"."deleted_at" IS NULL`)).
+		WithArgs("clear").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT *, ts_rank(name_tsv, plainto_tsquery('pg_catalog.simple', $1)) AS search_rank, `+
+		`ts_headline('pg_catalog.simple', name, plainto_tsquery('pg_catalog.simple', $2), $3) AS search_highlight `+
+		`FROM "organizations" WHERE name_tsv @@ plainto_tsquery('pg_catalog.simple', $4) `+
+		`AND "organizations"."deleted_at" IS NULL ORDER BY search_rank DESC,id LIMIT 21`)).
+		WithArgs("clear", "clear", highlightOptions, "clear").
+		WillReturnRows(sqlmock.NewRows(append(organizationColumns, "search_rank", "search_highlight")).
+			AddRow(id, "CLEAR", nil, nil, nil, nil, 1, 0.1, models.HighlightStart+"CLEAR"+models.HighlightStop))
+
+	result, err := repo.Search(context.Background(), models.OrganizationQuery{Search: "clear", Page: 1, PageSize: 20})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.TotalCount)
+	require.Len(t, result.Organizations, 1)
+	assert.Equal(t, models.HighlightStart+"CLEAR"+models.HighlightStop, result.Highlights[id])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresOrganizationRepository_FindSimilarBatch(t *testing.T) {
+	repo, mock := newTestPostgresRepository(t)
+	firstID, secondID := uuid.New(), uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT set_config('pg_trgm.similarity_threshold', $1, true)`)).
+		WithArgs("0.6").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT names.position AS name_position, similar_orgs.* `+
+		`FROM (VALUES ($1::integer, $2::text), ($3::integer, $4::text)) AS names (position, name) CROSS JOIN LATERAL (`+
+		`SELECT organizations.*, similarity(organizations.name, names.name) AS similarity FROM organizations `+
+		`WHERE organizations.deleted_at IS NULL AND organizations.name % names.name `+
+		`ORDER BY similarity DESC, organizations.id LIMIT $5) AS similar_orgs `+
+		`ORDER BY names.position, similar_orgs.similarity DESC, similar_orgs.id`)).
+		WithArgs(0, "CLEAR", 1, "Acme", 5).
+		WillReturnRows(sqlmock.NewRows(append([]string{"name_position"}, append(organizationColumns, "similarity")...)).
+			AddRow(1, firstID, "Acme Corp", nil, nil, nil, nil, 1, 0.8).
+			AddRow(1, secondID, "Acme", nil, nil, nil, nil, 1, 0.7))
+	mock.ExpectCommit()
+
+	result, err := repo.FindSimilarBatch(context.Background(), models.SimilarNamesQuery{
+		Names: []string{"CLEAR", "Acme"}, Threshold: 0.6, Limit: 5})
+	require.NoError(t, err)
+	require.Len(t, result, 2)
+	assert.Empty(t, result[0])
+	require.Len(t, result[1], 2)
+	assert.Equal(t, firstID, result[1][0].Organization.ID)
+	assert.Equal(t, 0.8, result[1][0].Similarity)
+	assert.Equal(t, secondID, result[1][1].Organization.ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresOrganizationRepository_GetAsOf(t *testing.T) {
+	repo, mock := newTestPostgresRepository(t)
+	id := uuid.New()
+	asOf := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organization_history" WHERE id = $1 AND `+
+		`(valid_from <= $2 AND (valid_to IS NULL OR valid_to > $3)) AND "organization_history"."deleted_at" IS NULL `+
+		`ORDER BY "organization_history"."id" LIMIT 1`)).
+		WithArgs(id, asOf, asOf).WillReturnRows(sqlmock.NewRows(organizationColumns))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id" FROM "organization_history" WHERE id = $1 AND `+
+		`(backfilled AND valid_from > $2) LIMIT 1`)).
+		WithArgs(id, asOf).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(id))
+
+	_, err := repo.GetAsOf(context.Background(), id, asOf)
+	assert.Equal(t, ErrHistoryUnavailable, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresOrganizationRepository_Purge(t *testing.T) {
+	repo, mock := newTestPostgresRepository(t)
+	id := uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "organizations" WHERE id = $1 ORDER BY "organizations"."id" LIMIT 1 FOR UPDATE`)).
+		WithArgs(id).WillReturnRows(sqlmock.NewRows(organizationColumns).AddRow(id, "CLEAR", nil, nil, nil, time.Now(), 2))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "organizations" WHERE id = $1`)).
+		WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 1))
+	// the versions recorded by the history trigger are removed along with the organization
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM organization_history WHERE id = $1`)).
+		WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "organization_audit" ("organization_id","action","actor","claimed_actor","request_id","before","after","changed_at") `+
+		`VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`)).
+		WithArgs(id, models.PurgeAuditAction, models.SystemActor, "", "", sqlmock.AnyArg(), nil, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	require.NoError(t, repo.Purge(context.Background(), id))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresIdempotencyKeyRepository_Reserve(t *testing.T) {
+	db, mock, err := database.InitializeTest()
+	require.NoError(t, err)
+	repo := NewPostgresIdempotencyKeyRepository(db)
+	key := newTestIdempotencyKey("key-1", time.Hour)
+
+	expectExpiredKeysSweep(mock)
+	expectIdempotencyKeyUpsert(mock, key, 0)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "idempotency_keys" WHERE key = $1 ORDER BY "idempotency_keys"."key" LIMIT 1`)).
+		WithArgs("key-1").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "request_hash", "status_code"}).AddRow("key-1", "other", 201))
+
+	existing, err := repo.Reserve(context.Background(), &key)
+	require.NoError(t, err)
+	require.NotNil(t, existing)
+	assert.Equal(t, "other", existing.RequestHash)
+	assert.True(t, existing.IsCompleted())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresIdempotencyKeyRepository_Reserve_removedBeforeRead(t *testing.T) {
+	db, mock, err := database.InitializeTest()
+	require.NoError(t, err)
+	repo := NewPostgresIdempotencyKeyRepository(db)
+	key := newTestIdempotencyKey("key-1", time.Hour)
+
+	// the existing key is released by its request between the insert and the read, so the insert is retried
+	expectExpiredKeysSweep(mock)
+	expectIdempotencyKeyUpsert(mock, key, 0)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "idempotency_keys" WHERE key = $1 ORDER BY "idempotency_keys"."key" LIMIT 1`)).
+		WithArgs("key-1").WillReturnRows(sqlmock.NewRows([]string{"key", "request_hash", "status_code"}))
+	expectIdempotencyKeyUpsert(mock, key, 1)
+
+	existing, err := repo.Reserve(context.Background(), &key)
+	require.NoError(t, err)
+	assert.Nil(t, existing)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func expectExpiredKeysSweep(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "idempotency_keys" WHERE expires_at <= $1`)).
+		WithArgs(sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+}
+
+// expectIdempotencyKeyUpsert expects the reservation of key, which only replaces a key whose request has not completed
+// and whose lease has ended
+func expectIdempotencyKeyUpsert(mock sqlmock.Sqlmock, key models.IdempotencyKey, rowsAffected int64) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "idempotency_keys" `+
+		`("key","request_hash","status_code","headers","body","created_at","locked_until","expires_at") `+
+		`VALUES ($1,$2,$3,$4,$5,$6,$7,$8) ON CONFLICT ("key") DO UPDATE SET "request_hash"="excluded"."request_hash",`+
+		`"created_at"="excluded"."created_at","locked_until"="excluded"."locked_until","expires_at"="excluded"."expires_at" `+
+		`WHERE idempotency_keys.status_code = 0 AND idempotency_keys.locked_until <= $9`)).
+		WithArgs(key.Key, key.RequestHash, 0, nil, sqlmock.AnyArg(), key.CreatedAt, key.LockedUntil, key.ExpiresAt,
+			sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, rowsAffected))
+	mock.ExpectCommit()
+}
+
+func newTestPostgresRepository(t *testing.T) (*PostgresOrganizationRepository, sqlmock.Sqlmock) {
+	db, mock, err := database.InitializeTest()
+	require.NoError(t, err)
+	return NewPostgresOrganizationRepository(db), mock
+}
@@ -0,0 +1,71 @@
+package repository
+
+import "sort"
+
+// trigramSimilarity computes the similarity of two strings the way the Postgres pg_trgm extension does. Both strings
+// are lower cased and split into words of letters and digits, each word is padded with two spaces before it and one
+// after it and the similarity is the number of distinct trigrams the strings share divided by the number of distinct
+// trigrams in either string
+func trigramSimilarity(a, b string) float64 {
+	trigramsA := trigrams(a)
+	trigramsB := trigrams(b)
+	if len(trigramsA) == 0 || len(trigramsB) == 0 {
+		return 0
+	}
+
+	common := 0
+	for trigram := range trigramsA {
+		if trigramsB[trigram] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(trigramsA)+len(trigramsB)-common)
+}
+
+// trigrams returns the set of distinct trigrams of the padded words of a string
+func trigrams(text string) map[string]bool {
+	result := map[string]bool{}
+	for _, word := range searchWords(text) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			result[string(padded[i:i+3])] = true
+		}
+	}
+	return result
+}
+
+// NameMatch is an earlier name of a list that is similar to a later name of the list
+type NameMatch struct {
+	Index      int
+	Similarity float64
+}
+
+// MatchSimilarNames compares each name with the names before it in the list, using the trigram similarity of the
+// pg_trgm extension, and returns the earlier names with a similarity of at least the threshold for each name. Only
+// names sharing a trigram are compared, so lists of names that are mostly dissimilar are compared quickly
+func MatchSimilarNames(names []string, threshold float64) [][]NameMatch {
+	matches := make([][]NameMatch, len(names))
+	nameTrigrams := make([]map[string]bool, len(names))
+	// trigramNames indexes the earlier names by each of their trigrams
+	trigramNames := map[string][]int{}
+	for i, name := range names {
+		nameTrigrams[i] = trigrams(name)
+		common := map[int]int{}
+		for trigram := range nameTrigrams[i] {
+			for _, earlier := range trigramNames[trigram] {
+				common[earlier]++
+			}
+			trigramNames[trigram] = append(trigramNames[trigram], i)
+		}
+		for earlier, count := range common {
+			similarity := float64(count) / float64(len(nameTrigrams[i])+len(nameTrigrams[earlier])-count)
+			if similarity >= threshold {
+				matches[i] = append(matches[i], NameMatch{Index: earlier, Similarity: similarity})
+			}
+		}
+		sort.Slice(matches[i], func(a, b int) bool {
+			return matches[i][a].Index < matches[i][b].Index
+		})
+	}
+	return matches
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_trigramSimilarity(t *testing.T) {
+	// expected similarities are the results of the pg_trgm similarity function
+	var testCases = []struct {
+		a, b               string
+		expectedSimilarity float64
+	}{
+		{a: "word", b: "two words", expectedSimilarity: 4.0 / 11},
+		{a: "Acme Corp", b: "ACME corp.", expectedSimilarity: 1},
+		{a: "Acme Corp", b: "Acme Corporation", expectedSimilarity: 0.5},
+		{a: "Acme", b: "Globex", expectedSimilarity: 0},
+		{a: "Café", b: "cafe", expectedSimilarity: 3.0 / 7},
+		{a: "", b: "Acme", expectedSimilarity: 0},
+		{a: "!!", b: "??", expectedSimilarity: 0},
+	}
+
+	for i, test := range testCases {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			assert.InDelta(t, test.expectedSimilarity, trigramSimilarity(test.a, test.b), 0.000001)
+			assert.InDelta(t, test.expectedSimilarity, trigramSimilarity(test.b, test.a), 0.000001)
+		})
+	}
+}
+
+func TestMatchSimilarNames(t *testing.T) {
+	names := []string{"Acme Corp", "Globex", "ACME corp.", "", "Acme Corporation", "acme corp"}
+	matches := MatchSimilarNames(names, 0.5)
+	assert.Equal(t, 6, len(matches))
+	assert.Empty(t, matches[0])
+	assert.Empty(t, matches[1])
+	assert.Equal(t, []NameMatch{{Index: 0, Similarity: 1}}, matches[2])
+	assert.Empty(t, matches[3])
+	assert.Equal(t, []NameMatch{{Index: 0, Similarity: 0.5}, {Index: 2, Similarity: 0.5}}, matches[4])
+	assert.Equal(t, []int{0, 2, 4}, []int{matches[5][0].Index, matches[5][1].Index, matches[5][2].Index})
+
+	// the matches agree with the similarity of each pair of names
+	for i := range names {
+		for _, match := range matches[i] {
+			assert.InDelta(t, trigramSimilarity(names[i], names[match.Index]), match.Similarity, 0.000001)
+		}
+	}
+}